@@ -13,6 +13,10 @@ list(APPEND NGINX_CONF_ARGS
   "--with-compat"
 )
 
+if (NGINX_DATADOG_STREAM_ENABLED)
+  list(APPEND NGINX_CONF_ARGS "--with-stream")
+endif ()
+
 if (NGINX_DATADOG_ASM_ENABLED)
   list(APPEND NGINX_CONF_ARGS "--with-threads")
 endif ()
@@ -63,6 +67,7 @@ target_include_directories(nginx_module
   ${nginx_SOURCE_DIR}/src/event
   ${nginx_SOURCE_DIR}/src/http/modules
   ${nginx_SOURCE_DIR}/src/http
+  ${nginx_SOURCE_DIR}/src/stream
   ${nginx_SOURCE_DIR}/src/os/unix
   ${nginx_SOURCE_DIR}/objs
   ${nginx_SOURCE_DIR}/src/core
@@ -0,0 +1,49 @@
+#include "agent_headers_http_client.h"
+
+#include <datadog/dict_writer.h>
+
+#include <datadog/json.hpp>
+#include <utility>
+
+namespace datadog {
+namespace nginx {
+
+AgentHeadersHTTPClient::AgentHeadersHTTPClient(
+    std::shared_ptr<dd::HTTPClient> delegate,
+    std::vector<std::pair<std::string, std::string>> headers)
+    : delegate_(std::move(delegate)), headers_(std::move(headers)) {}
+
+dd::Expected<void> AgentHeadersHTTPClient::post(
+    const URL& url, HeadersSetter set_headers, std::string body,
+    ResponseHandler on_response, ErrorHandler on_error,
+    std::chrono::steady_clock::time_point deadline) {
+  auto with_agent_headers = [this, set_headers = std::move(set_headers)](
+                                dd::DictWriter& headers) {
+    set_headers(headers);
+    for (const auto& [name, value] : headers_) {
+      headers.set(name, value);
+    }
+  };
+
+  return delegate_->post(url, std::move(with_agent_headers), std::move(body),
+                         std::move(on_response), std::move(on_error),
+                         deadline);
+}
+
+void AgentHeadersHTTPClient::drain(
+    std::chrono::steady_clock::time_point deadline) {
+  delegate_->drain(deadline);
+}
+
+nlohmann::json AgentHeadersHTTPClient::config_json() const {
+  auto names = nlohmann::json::array();
+  for (const auto& [name, _] : headers_) {
+    names.push_back(name);
+  }
+  return nlohmann::json::object({{"type", "AgentHeadersHTTPClient"},
+                                 {"headers", std::move(names)},
+                                 {"delegate", delegate_->config_json()}});
+}
+
+}  // namespace nginx
+}  // namespace datadog
@@ -0,0 +1,46 @@
+#pragma once
+
+// This component provides a `class`, `AgentHeadersHTTPClient`, that decorates
+// another `dd::HTTPClient`. It adds a fixed set of headers, configured by the
+// `datadog_agent_header` directive, to every request sent to the Datadog
+// Agent. This allows the Agent to sit behind a proxy that requires, for
+// example, an authentication token in a request header.
+
+#include <datadog/http_client.h>
+
+#include <chrono>
+#include <memory>
+#include <string>
+#include <utility>
+#include <vector>
+
+#include "dd.h"
+
+namespace datadog {
+namespace nginx {
+
+class AgentHeadersHTTPClient : public dd::HTTPClient {
+  std::shared_ptr<dd::HTTPClient> delegate_;
+  std::vector<std::pair<std::string, std::string>> headers_;
+
+ public:
+  // Send requests using the specified `delegate`, adding the specified
+  // `headers` (name/value pairs) to each request.
+  AgentHeadersHTTPClient(
+      std::shared_ptr<dd::HTTPClient> delegate,
+      std::vector<std::pair<std::string, std::string>> headers);
+
+  dd::Expected<void> post(const URL& url, HeadersSetter set_headers,
+                          std::string body, ResponseHandler on_response,
+                          ErrorHandler on_error,
+                          std::chrono::steady_clock::time_point deadline)
+      override;
+
+  void drain(std::chrono::steady_clock::time_point deadline) override;
+
+  // The header values might be secret, so only their names are included.
+  nlohmann::json config_json() const override;
+};
+
+}  // namespace nginx
+}  // namespace datadog
@@ -0,0 +1,62 @@
+#include "agent_tls_http_client.h"
+
+#include <datadog/json.hpp>
+#include <utility>
+
+namespace datadog {
+namespace nginx {
+
+AgentTLSHTTPClient::Library::Library(AgentTLSSettings settings)
+    : settings_(std::move(settings)) {}
+
+CURL* AgentTLSHTTPClient::Library::easy_init() {
+  CURL* handle = dd::CurlLibrary::easy_init();
+  if (handle == nullptr) {
+    return handle;
+  }
+  if (!settings_.ca_file.empty()) {
+    curl_easy_setopt(handle, CURLOPT_CAINFO, settings_.ca_file.c_str());
+  }
+  if (!settings_.certificate.empty()) {
+    curl_easy_setopt(handle, CURLOPT_SSLCERT, settings_.certificate.c_str());
+  }
+  if (!settings_.certificate_key.empty()) {
+    curl_easy_setopt(handle, CURLOPT_SSLKEY, settings_.certificate_key.c_str());
+  }
+  if (!settings_.verify) {
+    curl_easy_setopt(handle, CURLOPT_SSL_VERIFYPEER, 0L);
+    curl_easy_setopt(handle, CURLOPT_SSL_VERIFYHOST, 0L);
+  }
+  return handle;
+}
+
+AgentTLSHTTPClient::AgentTLSHTTPClient(
+    const std::shared_ptr<dd::Logger>& logger, const dd::Clock& clock,
+    const AgentTLSSettings& settings)
+    : library_(settings), curl_(logger, clock, library_), settings_(settings) {}
+
+dd::Expected<void> AgentTLSHTTPClient::post(
+    const URL& url, HeadersSetter set_headers, std::string body,
+    ResponseHandler on_response, ErrorHandler on_error,
+    std::chrono::steady_clock::time_point deadline) {
+  return curl_.post(url, std::move(set_headers), std::move(body),
+                    std::move(on_response), std::move(on_error), deadline);
+}
+
+void AgentTLSHTTPClient::drain(
+    std::chrono::steady_clock::time_point deadline) {
+  curl_.drain(deadline);
+}
+
+nlohmann::json AgentTLSHTTPClient::config_json() const {
+  return nlohmann::json::object(
+      {{"type", "AgentTLSHTTPClient"},
+       {"ca_file", settings_.ca_file},
+       {"certificate", settings_.certificate},
+       {"certificate_key", settings_.certificate_key},
+       {"verify", settings_.verify},
+       {"delegate", curl_.config_json()}});
+}
+
+}  // namespace nginx
+}  // namespace datadog
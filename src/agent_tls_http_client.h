@@ -0,0 +1,70 @@
+#pragma once
+
+// This component provides a `class`, `AgentTLSHTTPClient`, that sends requests
+// to the Datadog Agent using libcurl, as the tracer's default HTTP client
+// does, but with configurable TLS settings. It is used when the Agent is
+// reachable only over HTTPS, e.g. behind a TLS-terminating proxy, and the
+// `datadog_agent_ca_file`, `datadog_agent_certificate`,
+// `datadog_agent_certificate_key`, or `datadog_agent_verify_certificate`
+// directives are used.
+//
+// The TLS settings are applied to each libcurl handle as it is created, via a
+// `dd::CurlLibrary` whose `easy_init` is overridden.
+
+#include <datadog/clock.h>
+#include <datadog/curl.h>
+#include <datadog/http_client.h>
+#include <datadog/logger.h>
+
+#include <chrono>
+#include <memory>
+#include <string>
+
+#include "dd.h"
+
+namespace datadog {
+namespace nginx {
+
+struct AgentTLSSettings {
+  // Path to a file of PEM certificates trusted to sign the Agent's (or
+  // proxy's) certificate. If empty, libcurl's default trust store is used.
+  std::string ca_file;
+  // Paths to a PEM client certificate and its private key, for mutual TLS.
+  // If empty, no client certificate is presented.
+  std::string certificate;
+  std::string certificate_key;
+  // Whether the server's certificate and host name are verified.
+  bool verify = true;
+};
+
+class AgentTLSHTTPClient : public dd::HTTPClient {
+  class Library : public dd::CurlLibrary {
+    AgentTLSSettings settings_;
+
+   public:
+    explicit Library(AgentTLSSettings settings);
+    CURL* easy_init() override;
+  };
+
+  // `library_` must outlive `curl_`, which refers to it.
+  Library library_;
+  dd::Curl curl_;
+  AgentTLSSettings settings_;
+
+ public:
+  AgentTLSHTTPClient(const std::shared_ptr<dd::Logger>& logger,
+                     const dd::Clock& clock, const AgentTLSSettings& settings);
+
+  dd::Expected<void> post(const URL& url, HeadersSetter set_headers,
+                          std::string body, ResponseHandler on_response,
+                          ErrorHandler on_error,
+                          std::chrono::steady_clock::time_point deadline)
+      override;
+
+  void drain(std::chrono::steady_clock::time_point deadline) override;
+
+  nlohmann::json config_json() const override;
+};
+
+}  // namespace nginx
+}  // namespace datadog
@@ -0,0 +1,42 @@
+#pragma once
+
+// This component implements the `datadog_config_dump` directive, which makes
+// a location respond with a JSON description of the module's effective
+// configuration: the configuration of the tracer, and the merged
+// configuration of each `location` block.
+//
+// Locations are recorded as their configurations are merged, so that the
+// handler can describe every location, not only the one serving the dump.
+// Values that might be secret, such as credentials embedded in URLs, are
+// masked.
+
+#include <datadog/json.hpp>
+
+#include "datadog_conf.h"
+
+extern "C" {
+#include <ngx_config.h>
+#include <ngx_core.h>
+#include <ngx_http.h>
+}
+
+namespace datadog {
+namespace nginx {
+
+// Record the specified `loc_conf`, whose merge is in progress in the specified
+// `cf`, for inclusion in configuration dumps. Return `false` if an error
+// occurs.
+bool record_config_dump_location(ngx_conf_t *cf,
+                                 const datadog_loc_conf_t *loc_conf) noexcept;
+
+// Respond to the specified `request` with the configuration dump. This is the
+// content handler of locations containing `datadog_config_dump`.
+ngx_int_t config_dump_handler(ngx_http_request_t *request) noexcept;
+
+// Return a copy of the specified `config` in which secret values are masked.
+// Values of object members whose names suggest a secret, e.g. "api_key", are
+// replaced by "****", as are credentials in strings that look like URLs.
+nlohmann::json mask_secrets(const nlohmann::json &config);
+
+}  // namespace nginx
+}  // namespace datadog
@@ -13,7 +13,9 @@ extern "C" {
 #include <datadog/propagation_style.h>
 #include <datadog/trace_sampler_config.h>
 
+#include <optional>
 #include <string>
+#include <utility>
 #include <vector>
 
 namespace datadog {
@@ -59,8 +61,74 @@ struct sampling_rule_t {
   dd::TraceSamplerConfig::Rule rule;
 };
 
+// `upstream_sample_rate_t` is the sample rate of traces whose requests are
+// proxied to a particular `upstream` block, as configured by a
+// `datadog_upstream_sample_rate` directive.
+struct upstream_sample_rate_t {
+  // `upstream` is the name of the `upstream` block.
+  std::string upstream;
+  double rate;
+};
+
+// `openapi_route_t` is one of the paths of the OpenAPI spec named by the
+// `datadog_openapi_spec` directive.
+struct openapi_route_t {
+  // `path` is the path template, e.g. "/pets/{petId}".
+  std::string path;
+  // `methods` are the upper case HTTP methods of the path's operations, e.g.
+  // "GET". If `methods` is empty, then the path matches any method.
+  std::vector<std::string> methods;
+};
+
+struct datadog_loc_conf_t;
+
+// `config_dump_location_t` identifies a `location` block whose configuration
+// is described by the `datadog_config_dump` directive.
+struct config_dump_location_t {
+  // `server` is the primary name of the enclosing `server` block.
+  std::string server;
+  // `location` is the name of the location, e.g. "/api" or "@fallback".
+  std::string location;
+  const datadog_loc_conf_t *conf;
+};
+
+// `span_timing_e` enumerates the values of the `datadog_span_timing`
+// directive.
+enum span_timing_e : ngx_uint_t {
+  // Request span durations are measured with a monotonic clock.
+  SPAN_TIMING_MONOTONIC,
+  // Request span durations are measured with the system clock.
+  SPAN_TIMING_WALL,
+};
+
+// `propagate_dropped_e` enumerates the values of the
+// `datadog_propagate_dropped` directive.
+enum propagate_dropped_e : ngx_uint_t {
+  // Dropped traces are propagated like any other.
+  PROPAGATE_DROPPED_ON,
+  // Trace context is not injected for dropped traces.
+  PROPAGATE_DROPPED_OFF,
+  // Only the headers carrying the sampling decision are injected for dropped
+  // traces.
+  PROPAGATE_DROPPED_FLAG_ONLY,
+};
+
+// `span_start_e` enumerates the values of the `datadog_span_start` directive.
+enum span_start_e : ngx_uint_t {
+  // Request spans begin when nginx begins receiving the request.
+  SPAN_START_HEADERS,
+  // Request spans of requests that have a body begin once nginx has received
+  // the whole body.
+  SPAN_START_AFTER_BODY,
+};
+
 struct datadog_main_conf_t {
   ngx_array_t *tags;
+  // `cookie_redact_allowlist` contains the names of cookies whose values are
+  // kept when a `Cookie` or `Set-Cookie` header is captured as a tag, as set
+  // by the `datadog_cookie_redact_allowlist` directive. The values of all
+  // other cookies are redacted.
+  std::vector<std::string> cookie_redact_allowlist;
   // `are_propagation_styles_locked` is whether the tracer's propagation styles
   // have been set, either by an explicit `datadog_propagation_styles`
   // directive, or implicitly to a default configuration by another directive.
@@ -97,17 +165,93 @@ struct datadog_main_conf_t {
   // `propagation_styles` is populated by the "datadog_propagation_styles"
   // configuration directive.
   std::vector<dd::PropagationStyle> propagation_styles;
+  // `tags_header_max_size` is the maximum size, in bytes, of the
+  // "X-Datadog-Tags" header extracted from or injected into requests, as set
+  // by the `datadog_tags_header_max_size` directive. If unset, the tracer's
+  // default, 512 bytes, applies.
+  size_t tags_header_max_size{NGX_CONF_UNSET_SIZE};
+  // `max_active_spans` is the maximum number of request spans, per worker
+  // process, that may be in progress at once and still be sent to the Datadog
+  // Agent, as set by the `datadog_max_active_spans` directive. If unset or
+  // zero, there is no limit.
+  ngx_int_t max_active_spans{NGX_CONF_UNSET};
   // `sampling_rules` contains one sampling rule per `datadog_sample_rate` in
   // the nginx configuration. Each rule is associated with its "depth" in the
   // configuration, so that the rules can be sorted before use by the tracer
   // config.
   std::vector<sampling_rule_t> sampling_rules;
+  // `upstream_sample_rates` contains one entry per
+  // `datadog_upstream_sample_rate` directive. Unlike `sampling_rules`, these
+  // are applied when the request finishes, once its upstream is known.
+  std::vector<upstream_sample_rate_t> upstream_sample_rates;
   // `service_name` is set by the `datadog_service_name` directive.
   std::optional<configured_value_t> service_name;
   // `environment` is set by the `datadog_environment` directive.
   std::optional<configured_value_t> environment;
+  // `version` is the contents, trimmed of surrounding whitespace, of the file
+  // named by the `datadog_version_file` directive. It is read when the
+  // configuration is loaded. If the directive is absent or the file cannot be
+  // read, then `version` is null. `version_file_set` is whether the directive
+  // has been seen, so that duplicates can be rejected.
+  std::optional<std::string> version;
+  bool version_file_set = false;
+  // `openapi_routes` are the paths of the OpenAPI spec named by the
+  // `datadog_openapi_spec` directive, ordered so that the first route that
+  // matches a request is the most specific. `openapi_spec_set` is whether the
+  // directive has been seen, so that duplicates can be rejected.
+  std::vector<openapi_route_t> openapi_routes;
+  bool openapi_spec_set = false;
   // `agent_url` is set by the `datadog_agent_url` directive.
   std::optional<configured_value_t> agent_url;
+  // `zipkin_endpoint` is the URL of a Zipkin collector to which traces are
+  // sent instead of to the Datadog Agent, as set by the
+  // `datadog_zipkin_endpoint` directive.
+  std::optional<configured_value_t> zipkin_endpoint;
+  // `agent_headers` contains the name and value of each header added to
+  // requests sent to the Datadog Agent, as configured by the
+  // `datadog_agent_header` directive. References to environment variables in
+  // the values have already been substituted.
+  std::vector<std::pair<std::string, std::string>> agent_headers;
+  // `agent_ca_file`, `agent_certificate`, `agent_certificate_key`, and
+  // `agent_verify_certificate` are the TLS settings used for "https" agent
+  // URLs, as configured by the directives of the same names (prefixed by
+  // "datadog_"). If all are unset, then the tracer's default HTTP client is
+  // used.
+  ngx_str_t agent_ca_file = ngx_null_string;
+  ngx_str_t agent_certificate = ngx_null_string;
+  ngx_str_t agent_certificate_key = ngx_null_string;
+  ngx_flag_t agent_verify_certificate{NGX_CONF_UNSET};
+  // `shutdown_flush_timeout_ms` is how long an exiting worker process waits
+  // for its final flush of traces to the agent to complete, as set by the
+  // `datadog_shutdown_flush_timeout` directive. If unset, the tracer's default
+  // applies.
+  ngx_msec_t shutdown_flush_timeout_ms{NGX_CONF_UNSET_MSEC};
+  // `flush_jitter_ms` is the most by which the time between two flushes of
+  // traces to the agent may randomly differ from the tracer's flush interval,
+  // as set by the `datadog_flush_jitter` directive. If unset, there is no
+  // jitter.
+  ngx_msec_t flush_jitter_ms{NGX_CONF_UNSET_MSEC};
+  // `log_rate_limit` is the minimum number of seconds between two logs of the
+  // same error message by the tracer, as set by the `datadog_log_rate_limit`
+  // directive. If unset or zero, errors are not rate limited.
+  time_t log_rate_limit{NGX_CONF_UNSET};
+  // `span_timing` is how the durations of request spans are measured, as set by
+  // the `datadog_span_timing` directive. It is one of the `span_timing_e`
+  // values. If unset, `SPAN_TIMING_MONOTONIC` applies.
+  ngx_uint_t span_timing{NGX_CONF_UNSET_UINT};
+  // `trace_api_version` is the version of the Datadog Agent's trace API to
+  // which traces are sent, e.g. "v0.3", as set by the
+  // `datadog_trace_api_version` directive. If empty, the tracer's default,
+  // "v0.4", is used.
+  ngx_str_t trace_api_version = ngx_null_string;
+  // `client_computed_top_level` is whether trace submissions tell the Datadog
+  // Agent that the tracer has already marked top-level spans, as configured by
+  // the `datadog_client_computed_top_level` directive. If unset, it is on.
+  ngx_flag_t client_computed_top_level{NGX_CONF_UNSET};
+  // `config_dump_locations` contains every named `location` block, in the
+  // order in which their configurations were merged, for use by the
+  // `datadog_config_dump` directive.
+  std::vector<config_dump_location_t> config_dump_locations;
 
 #ifdef WITH_WAF
   // DD_APPSEC_ENABLED
@@ -133,12 +277,39 @@ struct datadog_main_conf_t {
   // settings in nginx (e.g. 100ms)
   ngx_msec_t appsec_waf_timeout_ms{NGX_CONF_UNSET_MSEC};
 
+  // DD_APPSEC_WAF_OVERLOAD_THRESHOLD (in microseconds, default: 0, i.e.
+  // disabled)
+  // When the average WAF run time exceeds this, WAF evaluation is suspended
+  // for `appsec_waf_overload_cooldown_ms`. Like the environment variable, the
+  // directive's value is in microseconds, because WAF runs often take less
+  // than a millisecond.
+  ngx_int_t appsec_waf_overload_threshold_us{NGX_CONF_UNSET};
+  // Default: 10s
+  ngx_msec_t appsec_waf_overload_cooldown_ms{NGX_CONF_UNSET_MSEC};
+
   // DD_APPSEC_OBFUSCATION_PARAMETER_KEY_REGEXP
   ngx_str_t appsec_obfuscation_key_regex = ngx_null_string;
 
   // DD_APPSEC_OBFUSCATION_PARAMETER_VALUE_REGEXP
   ngx_str_t appsec_obfuscation_value_regex = ngx_null_string;
 
+  // DD_API_SECURITY_REQUEST_SAMPLE_RATE (default: 0, i.e. disabled)
+  // The fraction of requests to each endpoint whose JSON body schema is
+  // reported. Kept as a string, and parsed when the security library is
+  // initialized.
+  ngx_str_t appsec_api_security_sample_rate = ngx_null_string;
+
+  // `appsec_body_redact_keys` is set by the `datadog_appsec_body_redact_keys`
+  // directive. It contains lowercased JSON keys whose values are redacted from
+  // request bodies before they are examined.
+  std::vector<std::string> appsec_body_redact_keys;
+
+  // `appsec_named_rulesets` contains the name and file of each ruleset defined
+  // by a `datadog_appsec_named_ruleset_file` directive, in the order in which
+  // they were defined. Locations select one of them by name using the
+  // `datadog_appsec_ruleset` directive.
+  std::vector<std::pair<std::string, std::string>> appsec_named_rulesets;
+
   // TODO: missing settings and their functionality
   // DD_TRACE_CLIENT_IP_RESOLVER_ENABLED (whether to collect headers and run the
   // client ip resolution. Also requires AppSec to be enabled or
@@ -179,6 +350,24 @@ struct datadog_sample_rate_condition_t {
   std::string tag_value() const;
 };
 
+// `status_range_t` is an inclusive range of HTTP response status codes, as
+// specified by the `datadog_error_statuses` directive, e.g. "500-599" or
+// "404" (where `first == last`).
+struct status_range_t {
+  ngx_uint_t first;
+  ngx_uint_t last;
+};
+
+// `resource_pattern_t` is a regular expression and the text that replaces its
+// matches in resource names, as specified by the
+// `datadog_resource_normalize_pattern` directive.
+struct resource_pattern_t {
+#if (NGX_PCRE)
+  ngx_regex_t *regex;
+#endif
+  std::string replacement;
+};
+
 struct datadog_loc_conf_t {
   ngx_flag_t enable = NGX_CONF_UNSET;
   ngx_flag_t enable_locations = NGX_CONF_UNSET;
@@ -186,7 +375,32 @@ struct datadog_loc_conf_t {
   NgxScript loc_operation_name_script;
   NgxScript resource_name_script;
   NgxScript loc_resource_name_script;
+  // `service_name_script` is set by the `datadog_service_name_override`
+  // directive.  If it evaluates to a non-empty string, that string is the
+  // service name of the request span.
+  NgxScript service_name_script;
+  // `peer_service_script` is set by the `datadog_peer_service` directive.  If
+  // it evaluates to a non-empty string, that string is the "peer.service" of
+  // request spans, instead of the name of the upstream.
+  NgxScript peer_service_script;
   ngx_flag_t trust_incoming_span = NGX_CONF_UNSET;
+  // `sampling_priority_override_script` evaluates to one of "on" or "off". If
+  // "on", and if `trust_incoming_span` is also on, then a request having the
+  // "x-datadog-sampling-priority: 2" (manual keep) header causes its trace to
+  // be kept, even if the trace sampler would have dropped it.
+  NgxScript sampling_priority_override_script;
+  // `drop_trace_script` evaluates to one of "on" or "off". If "on", then a
+  // request that begins a trace (i.e. is not a subrequest) has its trace
+  // created by a tracer that never sends traces to the Datadog Agent. Trace
+  // context is still propagated to upstream services.
+  NgxScript drop_trace_script;
+  // `jwt_token_script`, `jwt_key`, and `jwt_claim` are set by the
+  // `datadog_trace_context_jwt` directive. If `jwt_token_script` evaluates to
+  // a JSON Web Token signed with `jwt_key`, then trace context is extracted
+  // from the token's `jwt_claim` instead of from the request headers.
+  NgxScript jwt_token_script;
+  std::string jwt_key;
+  std::string jwt_claim;
   ngx_array_t *tags;
   // `proxy_directive` is the name of the configuration directive used to proxy
   // requests at this location, i.e. `proxy_pass`, `grpc_pass`, or
@@ -229,9 +443,106 @@ struct datadog_loc_conf_t {
   // applies this location, if any.
   conf_directive_source_location_t
       allow_sampling_delegation_in_subrequests_directive;
+  // If "on", then responses carry debugging information about the trace, e.g.
+  // an "X-Trace-Id" trailer in responses that support trailers. If "off", then
+  // responses are not modified.
+  ngx_flag_t debug_headers = NGX_CONF_UNSET;
+  // If "on", then responses carry an "X-Datadog-Trace" header whose value is a
+  // JSON object describing the trace context, and the header is exposed to
+  // cross-origin scripts. If "off", then the header is not added.
+  ngx_flag_t trace_context_header = NGX_CONF_UNSET;
+  // If "on", then responses carry a "Server-Timing" header whose "traceparent"
+  // metric describes the W3C trace context of the request span, for use by
+  // browser monitoring. If "off", then the header is not added.
+  ngx_flag_t server_timing = NGX_CONF_UNSET;
+  // `propagate_dropped` is which trace context headers are injected into
+  // upstream requests when the trace is dropped, as set by the
+  // `datadog_propagate_dropped` directive. It is one of the
+  // `propagate_dropped_e` values.
+  ngx_uint_t propagate_dropped = NGX_CONF_UNSET_UINT;
+  // `span_start` is when request spans begin, as set by the
+  // `datadog_span_start` directive. It is one of the `span_start_e` values.
+  ngx_uint_t span_start = NGX_CONF_UNSET_UINT;
+  // `version_header` is the name of a response header whose value is the
+  // versions of the module and of the tracer, as configured by the
+  // `datadog_emit_version_header` directive. If empty, then no such header is
+  // added.
+  ngx_str_t version_header = ngx_null_string;
+  // If "off", then the query string, if any, is removed from the "http.url"
+  // tag and from the resource name of spans. If "on", then they are left as
+  // configured.
+  ngx_flag_t url_include_query = NGX_CONF_UNSET;
+  // `resource_max_length` is the maximum length of span resource names, and
+  // `url_max_length` is the maximum length of the "http.url" tag. Longer
+  // values are truncated.
+  ngx_int_t resource_max_length = NGX_CONF_UNSET;
+  ngx_int_t url_max_length = NGX_CONF_UNSET;
+  // `resource_patterns` are applied, in order, to span resource names, each
+  // replacing all of its matches, as configured by the
+  // `datadog_resource_normalize_pattern` directive. If `resource_patterns` is
+  // null, then resource names are not rewritten.
+  std::optional<std::vector<resource_pattern_t>> resource_patterns;
+  // If "on", then request spans are tagged with the upstream's time to first
+  // byte and total response time (if the request was proxied), and with the
+  // number of response body bytes sent to the client.
+  ngx_flag_t timing_tags = NGX_CONF_UNSET;
+  // If "on", then request spans are tagged with the client's IP address, as
+  // "http.client_ip".
+  ngx_flag_t client_ip_tag = NGX_CONF_UNSET;
+  // Traces of requests that take less than `min_trace_duration` milliseconds
+  // are dropped. Zero means that no trace is dropped for its duration.
+  ngx_msec_t min_trace_duration = NGX_CONF_UNSET_MSEC;
+  // If "on", then request spans are tagged with the browser family and
+  // operating system indicated by the request's "User-Agent" header.
+  ngx_flag_t user_agent_tags = NGX_CONF_UNSET;
+  // If "on", then the resource name of request spans is the type and name of
+  // the GraphQL operation in the request body, if any, e.g. "query GetUser".
+  ngx_flag_t graphql_resource_name = NGX_CONF_UNSET;
+  // `error_statuses` contains the response status codes that cause a span to
+  // be marked as an error, as configured by the `datadog_error_statuses`
+  // directive. If `error_statuses` is null, then the default applies: any 5xx
+  // status is an error.
+  std::optional<std::vector<status_range_t>> error_statuses;
+  // If "on", then a 404 response status causes a span to be marked as an
+  // error, in addition to the statuses in `error_statuses`.
+  ngx_flag_t error_on_404 = NGX_CONF_UNSET;
+  // If "off", then CORS preflight requests are not traced, as configured by
+  // the `datadog_trace_cors_preflight` directive.
+  ngx_flag_t trace_cors_preflight = NGX_CONF_UNSET;
+  // `error_on_header` is the lower case name of a response header that, if
+  // present with a non-empty value, causes a span to be marked as an error, as
+  // configured by the `datadog_error_on_header` directive. If
+  // `error_on_header` is empty, then no response header is consulted.
+  ngx_str_t error_on_header = ngx_null_string;
+  // `log_correlation_header` is the name of an additional request header that
+  // carries the trace ID, in hexadecimal, to upstream services, as configured
+  // by the `datadog_log_correlation_header` directive. The header is intended
+  // for correlating upstream logs, and is not a trace context propagation
+  // style. If `log_correlation_header` is empty, then no such header is added.
+  ngx_str_t log_correlation_header = ngx_null_string;
+  // `default_host` is the host used for requests that have none, i.e. whose
+  // "Host" header is absent and whose request line has no absolute URI, as
+  // configured by the `datadog_default_host` directive. See the
+  // `$datadog_host` variable.
+  ngx_str_t default_host = ngx_null_string;
 
 #ifdef WITH_WAF
   ngx_thread_pool_t *waf_pool{nullptr};
+  // `appsec_route` is a route template, e.g. "/users/{id}", as configured by
+  // the `datadog_appsec_route` directive. Path segments of requests matching
+  // the template are passed to the WAF, by name, in the
+  // "server.request.path_params" address. If `appsec_route` is empty, then no
+  // path parameters are extracted.
+  ngx_str_t appsec_route = ngx_null_string;
+  // `appsec_blocking` is whether requests matching a blocking rule are
+  // blocked, as configured by the `datadog_appsec_blocking` directive. If off,
+  // such requests are reported but allowed to proceed. It is on by default.
+  ngx_flag_t appsec_blocking = NGX_CONF_UNSET;
+  // `appsec_ruleset` is the name of the ruleset, defined by a
+  // `datadog_appsec_named_ruleset_file` directive, that is evaluated for
+  // requests in this location, as configured by the `datadog_appsec_ruleset`
+  // directive. If empty, then the default ruleset is evaluated.
+  ngx_str_t appsec_ruleset = ngx_null_string;
 #endif
 };
 
@@ -19,12 +19,14 @@ namespace nginx {
 
 DatadogContext::DatadogContext(ngx_http_request_t *request,
                                ngx_http_core_loc_conf_t *core_loc_conf,
-                               datadog_loc_conf_t *loc_conf)
+                               datadog_loc_conf_t *loc_conf,
+                               bool after_body)
 #ifdef WITH_WAF
-    : sec_ctx_{security::Context::maybe_create()}
+    : sec_ctx_{
+          security::Context::maybe_create(str(loc_conf->appsec_ruleset))}
 #endif
 {
-  traces_.emplace_back(request, core_loc_conf, loc_conf);
+  traces_.emplace_back(request, core_loc_conf, loc_conf, nullptr, after_body);
 }
 
 void DatadogContext::on_change_block(ngx_http_request_t *request,
@@ -71,6 +73,27 @@ ngx_int_t DatadogContext::main_output_body_filter(ngx_http_request_t *request,
 }
 #endif
 
+void DatadogContext::on_header_filter(ngx_http_request_t *request) {
+  auto trace = find_trace(request);
+  if (trace == nullptr) {
+    // The response belongs to a request that isn't traced, e.g. a subrequest
+    // when `log_subrequest` is off.
+    return;
+  }
+  trace->on_header_filter();
+}
+
+void DatadogContext::on_request_body(ngx_http_request_t *request,
+                                     const ngx_chain_t *chain) {
+  // The request body belongs to the main request, even if it is read on
+  // behalf of a subrequest.
+  auto trace = find_trace(request->main);
+  if (trace == nullptr) {
+    return;
+  }
+  trace->on_request_body(chain);
+}
+
 void DatadogContext::on_log_request(ngx_http_request_t *request) {
   auto trace = find_trace(request);
   if (trace == nullptr) {
@@ -23,9 +23,11 @@ namespace nginx {
 
 class DatadogContext {
  public:
+  // Begin tracing the specified `request`. `after_body` is as for
+  // `RequestTracing`.
   DatadogContext(ngx_http_request_t* request,
                  ngx_http_core_loc_conf_t* core_loc_conf,
-                 datadog_loc_conf_t* loc_conf);
+                 datadog_loc_conf_t* loc_conf, bool after_body = false);
 
   void on_change_block(ngx_http_request_t* request,
                        ngx_http_core_loc_conf_t* core_loc_conf,
@@ -38,6 +40,10 @@ class DatadogContext {
                                     ngx_chain_t* chain);
 #endif
 
+  void on_header_filter(ngx_http_request_t* request);
+
+  void on_request_body(ngx_http_request_t* request, const ngx_chain_t* chain);
+
   void on_log_request(ngx_http_request_t* request);
 
   ngx_str_t lookup_span_variable_value(ngx_http_request_t* request,
@@ -1,22 +1,31 @@
 #include "datadog_directive.h"
 
+#include <datadog/datadog_agent_config.h>
+
 #include <algorithm>
 #include <cctype>
+#include <cstdlib>
 #include <datadog/json.hpp>
+#include <fstream>
 #include <istream>
+#include <iterator>
 #include <stdexcept>
 #include <string>
 #include <string_view>
 
+#include "config_dump.h"
 #include "datadog_conf.h"
 #include "datadog_conf_handler.h"
 #include "datadog_variable.h"
 #include "dd.h"
 #include "defer.h"
+#include "jwt.h"
 #include "log_conf.h"
 #include "ngx_http_datadog_module.h"
 #include "ngx_logger.h"
 #include "ngx_script.h"
+#include "openapi.h"
+#include "self_test.h"
 #include "string_util.h"
 #include "tracing_library.h"
 
@@ -71,8 +80,15 @@ static char *set_script(ngx_conf_t *cf, ngx_command_t *command,
   auto value = static_cast<ngx_str_t *>(cf->args->elts);
   auto pattern = &value[1];
 
-  if (script.compile(cf, *pattern) != NGX_OK)
+  if (script.compile(cf, *pattern) != NGX_OK) {
+    const auto location = command_source_location(command, cf);
+    ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                  "Invalid argument \"%V\" to %V directive at %V:%d.  Expected "
+                  "a string that may contain $-variables.",
+                  pattern, &location.directive_name, &location.file_name,
+                  location.line);
     return static_cast<char *>(NGX_CONF_ERROR);
+  }
 
   return static_cast<char *>(NGX_CONF_OK);
 }
@@ -208,10 +224,21 @@ char *add_datadog_tag(ngx_conf_t *cf, ngx_array_t *tags, ngx_str_t key,
   if (!tag) return static_cast<char *>(NGX_CONF_ERROR);
 
   ngx_memzero(tag, sizeof(datadog_tag_t));
-  if (tag->key_script.compile(cf, key) != NGX_OK)
+  if (tag->key_script.compile(cf, key) != NGX_OK) {
+    ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                  "Invalid tag name \"%V\" at %V:%d.  Expected a string that "
+                  "may contain $-variables.",
+                  &key, &cf->conf_file->file.name, cf->conf_file->line);
     return static_cast<char *>(NGX_CONF_ERROR);
-  if (tag->value_script.compile(cf, value) != NGX_OK)
+  }
+  if (tag->value_script.compile(cf, value) != NGX_OK) {
+    ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                  "Invalid value \"%V\" for tag \"%V\" at %V:%d.  Expected a "
+                  "string that may contain $-variables.",
+                  &value, &key, &cf->conf_file->file.name,
+                  cf->conf_file->line);
     return static_cast<char *>(NGX_CONF_ERROR);
+  }
 
   return static_cast<char *>(NGX_CONF_OK);
 }
@@ -237,17 +264,37 @@ char *json_config_deprecated(ngx_conf_t *cf, ngx_command_t *command,
   return static_cast<char *>(NGX_CONF_ERROR);
 }
 
+// Like `set_script`, but additionally reject an empty operation name, since a
+// span must have a name.
+static char *set_operation_name_script(ngx_conf_t *cf, ngx_command_t *command,
+                                       NgxScript &script) noexcept {
+  const auto values = static_cast<ngx_str_t *>(cf->args->elts);
+  if (values[1].len == 0) {
+    const auto location = command_source_location(command, cf);
+    ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                  "Invalid argument \"\" to %V directive at %V:%d.  Expected "
+                  "a non-empty operation name.",
+                  &location.directive_name, &location.file_name,
+                  location.line);
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+
+  return set_script(cf, command, script);
+}
+
 char *set_datadog_operation_name(ngx_conf_t *cf, ngx_command_t *command,
                                  void *conf) noexcept {
   auto loc_conf = static_cast<datadog_loc_conf_t *>(conf);
-  return set_script(cf, command, loc_conf->operation_name_script);
+  return set_operation_name_script(cf, command,
+                                   loc_conf->operation_name_script);
 }
 
 char *set_datadog_location_operation_name(ngx_conf_t *cf,
                                           ngx_command_t *command,
                                           void *conf) noexcept {
   auto loc_conf = static_cast<datadog_loc_conf_t *>(conf);
-  return set_script(cf, command, loc_conf->loc_operation_name_script);
+  return set_operation_name_script(cf, command,
+                                   loc_conf->loc_operation_name_script);
 }
 
 char *set_datadog_resource_name(ngx_conf_t *cf, ngx_command_t *command,
@@ -256,12 +303,99 @@ char *set_datadog_resource_name(ngx_conf_t *cf, ngx_command_t *command,
   return set_script(cf, command, loc_conf->resource_name_script);
 }
 
+char *set_datadog_service_name_override(ngx_conf_t *cf, ngx_command_t *command,
+                                        void *conf) noexcept {
+  auto loc_conf = static_cast<datadog_loc_conf_t *>(conf);
+  return set_script(cf, command, loc_conf->service_name_script);
+}
+
+char *set_datadog_peer_service(ngx_conf_t *cf, ngx_command_t *command,
+                               void *conf) noexcept {
+  auto loc_conf = static_cast<datadog_loc_conf_t *>(conf);
+  return set_script(cf, command, loc_conf->peer_service_script);
+}
+
 char *set_datadog_location_resource_name(ngx_conf_t *cf, ngx_command_t *command,
                                          void *conf) noexcept {
   auto loc_conf = static_cast<datadog_loc_conf_t *>(conf);
   return set_script(cf, command, loc_conf->loc_resource_name_script);
 }
 
+char *set_datadog_sampling_priority_override(ngx_conf_t *cf,
+                                             ngx_command_t *command,
+                                             void *conf) noexcept {
+  auto loc_conf = static_cast<datadog_loc_conf_t *>(conf);
+  return set_script(cf, command, loc_conf->sampling_priority_override_script);
+}
+
+char *set_datadog_drop_trace_if(ngx_conf_t *cf, ngx_command_t *command,
+                                void *conf) noexcept {
+  auto loc_conf = static_cast<datadog_loc_conf_t *>(conf);
+  return set_script(cf, command, loc_conf->drop_trace_script);
+}
+
+char *set_datadog_trace_context_jwt(ngx_conf_t *cf, ngx_command_t *command,
+                                    void *conf) noexcept try {
+  auto loc_conf = static_cast<datadog_loc_conf_t *>(conf);
+  if (loc_conf->jwt_token_script.is_valid()) {
+    return const_cast<char *>("is duplicate");
+  }
+
+  const auto values = static_cast<ngx_str_t *>(cf->args->elts);
+  // values[0] is the command name, while values[1], values[2], and optionally
+  // values[3] are the arguments:
+  //
+  //     datadog_trace_context_jwt <token> <key file> [<claim>];
+  const auto location = command_source_location(command, cf);
+  if (!jwt_verification_supported()) {
+    ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                  "The %V directive at %V:%d requires nginx to be built with "
+                  "OpenSSL.",
+                  &location.directive_name, &location.file_name,
+                  location.line);
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+
+  if (loc_conf->jwt_token_script.compile(cf, values[1]) != NGX_OK) {
+    ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                  "Invalid argument \"%V\" to %V directive at %V:%d.  Expected "
+                  "a string that may contain $-variables.",
+                  &values[1], &location.directive_name, &location.file_name,
+                  location.line);
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+
+  // Relative paths are relative to nginx's prefix, as with other directives
+  // that name files.
+  ngx_str_t path = values[2];
+  if (ngx_conf_full_name(cf->cycle, &path, 0) != NGX_OK) {
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+  std::ifstream file{to_string(path)};
+  std::string key{std::istreambuf_iterator<char>(file),
+                  std::istreambuf_iterator<char>()};
+  // A trailing newline, as left by most editors, is not part of the key.
+  while (!key.empty() && (key.back() == '\n' || key.back() == '\r')) {
+    key.pop_back();
+  }
+  if (!file.is_open() || key.empty()) {
+    ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                  "Unable to read a key from \"%V\" for %V directive at "
+                  "%V:%d.",
+                  &path, &location.directive_name, &location.file_name,
+                  location.line);
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+  loc_conf->jwt_key = std::move(key);
+
+  loc_conf->jwt_claim =
+      cf->args->nelts > 3 ? to_string(values[3]) : std::string{"dd_trace"};
+  return static_cast<char *>(NGX_CONF_OK);
+} catch (const std::exception &e) {
+  ngx_conf_log_error(NGX_LOG_ERR, cf, 0, "%s", e.what());
+  return static_cast<char *>(NGX_CONF_ERROR);
+}
+
 char *toggle_opentracing(ngx_conf_t *cf, ngx_command_t *command,
                          void *conf) noexcept {
   const auto loc_conf = static_cast<datadog_loc_conf_t *>(conf);
@@ -276,10 +410,12 @@ char *toggle_opentracing(ngx_conf_t *cf, ngx_command_t *command,
     loc_conf->enable = false;
     preferred = "datadog_disable";
   } else {
-    ngx_log_error(
-        NGX_LOG_ERR, cf->log, 0,
-        "Invalid argument \"%V\" to %V directive.  Use \"on\" or \"off\". ",
-        &values[1], &command->name);
+    const auto location = command_source_location(command, cf);
+    ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                  "Invalid argument \"%V\" to %V directive at %V:%d.  Use "
+                  "\"on\" or \"off\".",
+                  &values[1], &location.directive_name, &location.file_name,
+                  location.line);
     return static_cast<char *>(NGX_CONF_ERROR);
   }
 
@@ -429,6 +565,69 @@ char *set_datadog_sample_rate(ngx_conf_t *cf, ngx_command_t *command,
   return static_cast<char *>(NGX_CONF_OK);
 }
 
+char *set_datadog_upstream_sample_rate(ngx_conf_t *cf, ngx_command_t *command,
+                                       void *conf) noexcept try {
+  auto *main_conf = static_cast<datadog_main_conf_t *>(conf);
+  const auto values = static_cast<ngx_str_t *>(cf->args->elts);
+  // values[0] is the command name, while values[1] and values[2] are the
+  // arguments:
+  //
+  //     datadog_upstream_sample_rate <upstream> <rate>;
+  const auto location = command_source_location(command, cf);
+  std::string upstream = to_string(values[1]);
+  for (const upstream_sample_rate_t &existing :
+       main_conf->upstream_sample_rates) {
+    if (existing.upstream == upstream) {
+      ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                    "Duplicate upstream \"%V\" in %V directive at %V:%d.",
+                    &values[1], &location.directive_name, &location.file_name,
+                    location.line);
+      return static_cast<char *>(NGX_CONF_ERROR);
+    }
+  }
+
+  const std::string rate_str = to_string(values[2]);
+  char *end = nullptr;
+  const double rate = std::strtod(rate_str.c_str(), &end);
+  if (rate_str.empty() || *end != '\0' || !(rate >= 0.0 && rate <= 1.0)) {
+    ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                  "Invalid argument \"%V\" to %V directive at %V:%d.  "
+                  "Expected a real number between 0.0 and 1.0.",
+                  &values[2], &location.directive_name, &location.file_name,
+                  location.line);
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+
+  main_conf->upstream_sample_rates.push_back({std::move(upstream), rate});
+  return static_cast<char *>(NGX_CONF_OK);
+} catch (const std::exception &e) {
+  ngx_conf_log_error(NGX_LOG_ERR, cf, 0, "%s", e.what());
+  return static_cast<char *>(NGX_CONF_ERROR);
+}
+
+char *set_datadog_cookie_redact_allowlist(ngx_conf_t *cf,
+                                          ngx_command_t *command,
+                                          void *conf) noexcept {
+  const auto main_conf = static_cast<datadog_main_conf_t *>(conf);
+  const auto values = static_cast<ngx_str_t *>(cf->args->elts);
+  // values[0] is the command name, "datadog_cookie_redact_allowlist".
+  //
+  //     datadog_cookie_redact_allowlist <name> [<name> ...];
+  for (ngx_uint_t i = 1; i < cf->args->nelts; ++i) {
+    if (values[i].len == 0) {
+      const auto location = command_source_location(command, cf);
+      ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                    "Invalid argument \"\" to %V directive at %V:%d.  "
+                    "Expected a non-empty cookie name.",
+                    &location.directive_name, &location.file_name,
+                    location.line);
+      return static_cast<char *>(NGX_CONF_ERROR);
+    }
+    main_conf->cookie_redact_allowlist.push_back(to_string(values[i]));
+  }
+  return static_cast<char *>(NGX_CONF_OK);
+}
+
 char *set_datadog_propagation_styles(ngx_conf_t *cf, ngx_command_t *command,
                                      void *conf) noexcept {
   const auto main_conf = static_cast<datadog_main_conf_t *>(conf);
@@ -468,9 +667,9 @@ char *set_datadog_propagation_styles(ngx_conf_t *cf, ngx_command_t *command,
     if (!maybe_style) {
       const auto location = command_source_location(command, cf);
       ngx_log_error(NGX_LOG_ERR, cf->log, 0,
-                    "Invalid propagation style \"%V\". Acceptable values are "
-                    "\"Datadog\", \"B3\", "
-                    "and \"tracecontext\". Error occurred at \"%V\" in %V:%d",
+                    "Invalid propagation style \"%V\" to %V directive at "
+                    "%V:%d.  Acceptable values are \"Datadog\", \"B3\", and "
+                    "\"tracecontext\" (case-insensitive).",
                     arg, &location.directive_name, &location.file_name,
                     location.line);
       return static_cast<char *>(NGX_CONF_ERROR);
@@ -524,8 +723,10 @@ static char *set_configured_value(
   auto finalized_config = dd::finalize_config(minimal_config);
   if (auto *error = finalized_config.if_error()) {
     ngx_log_error(NGX_LOG_ERR, cf->log, 0,
-                  "Unable to check %V %V; [error code %d]: %s", &values[0],
-                  &values[1], int(error->code), error->message.c_str());
+                  "Invalid argument \"%V\" to %V directive at %V:%d.  [error "
+                  "code %d]: %s",
+                  &values[1], &location.directive_name, &location.file_name,
+                  location.line, int(error->code), error->message.c_str());
     return static_cast<char *>(NGX_CONF_ERROR);
   }
 
@@ -533,7 +734,7 @@ static char *set_configured_value(
   const std::string &final_value = get_from_final_dd_config(*finalized_config);
   if (final_value != arg) {
     ngx_log_error(NGX_LOG_ERR, cf->log, 0,
-                  "\"%V %V;\" directive at  %V:%d is overriden to \"%s\" by an "
+                  "\"%V %V;\" directive at %V:%d is overriden to \"%s\" by an "
                   "environment variable",
                   &values[0], &values[1], &location.file_name, location.line,
                   final_value.c_str());
@@ -586,6 +787,360 @@ char *set_datadog_agent_url(ngx_conf_t *cf, ngx_command_t *command,
       });
 }
 
+char *set_datadog_zipkin_endpoint(ngx_conf_t *cf, ngx_command_t *command,
+                                  void *conf) noexcept try {
+  auto *main_conf = static_cast<datadog_main_conf_t *>(conf);
+  if (main_conf->zipkin_endpoint) {
+    return const_cast<char *>("is duplicate");
+  }
+
+  const auto values = static_cast<ngx_str_t *>(cf->args->elts);
+  auto location = command_source_location(command, cf);
+  auto url = dd::DatadogAgentConfig::parse_url(str(values[1]));
+  if (auto *error = url.if_error()) {
+    ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                  "Invalid argument \"%V\" to %V directive at %V:%d.  [error "
+                  "code %d]: %s",
+                  &values[1], &location.directive_name, &location.file_name,
+                  location.line, int(error->code), error->message.c_str());
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+
+  main_conf->zipkin_endpoint.emplace();
+  main_conf->zipkin_endpoint->value = to_string(values[1]);
+  main_conf->zipkin_endpoint->location = std::move(location);
+  return static_cast<char *>(NGX_CONF_OK);
+} catch (const std::exception &e) {
+  ngx_conf_log_error(NGX_LOG_ERR, cf, 0, "%s", e.what());
+  return static_cast<char *>(NGX_CONF_ERROR);
+}
+
+char *set_datadog_agent_header(ngx_conf_t *cf, ngx_command_t *command,
+                               void *conf) noexcept try {
+  auto *main_conf = static_cast<datadog_main_conf_t *>(conf);
+  const auto values = static_cast<ngx_str_t *>(cf->args->elts);
+  // values[0] is the command name, while values[1] and values[2] are the
+  // arguments:
+  //
+  //     datadog_agent_header <name> <value>;
+  const auto location = command_source_location(command, cf);
+  const std::string_view name = str(values[1]);
+  if (name.empty() || name.find_first_of(":\r\n") != std::string_view::npos) {
+    ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                  "Invalid header name \"%V\" in %V directive at %V:%d.",
+                  &values[1], &location.directive_name, &location.file_name,
+                  location.line);
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+
+  // Substitute environment variables, so that secrets need not appear in the
+  // configuration file. The configuration is loaded by the master process,
+  // which has the full environment.
+  const std::string_view raw = str(values[2]);
+  std::string value;
+  std::size_t pos = 0;
+  while (pos < raw.size()) {
+    const auto begin = raw.find("${", pos);
+    if (begin == std::string_view::npos) {
+      value.append(raw.substr(pos));
+      break;
+    }
+    const auto end = raw.find('}', begin);
+    if (end == std::string_view::npos) {
+      ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                    "Unterminated \"${\" in value of %V directive at %V:%d.",
+                    &location.directive_name, &location.file_name,
+                    location.line);
+      return static_cast<char *>(NGX_CONF_ERROR);
+    }
+    value.append(raw.substr(pos, begin - pos));
+    const std::string variable{raw.substr(begin + 2, end - begin - 2)};
+    const char *variable_value = std::getenv(variable.c_str());
+    if (variable_value == nullptr) {
+      ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                    "Environment variable \"%s\" referenced by %V directive "
+                    "at %V:%d is not set.",
+                    variable.c_str(), &location.directive_name,
+                    &location.file_name, location.line);
+      return static_cast<char *>(NGX_CONF_ERROR);
+    }
+    value.append(variable_value);
+    pos = end + 1;
+  }
+  if (value.find_first_of("\r\n") != std::string::npos) {
+    ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                  "Value of %V directive at %V:%d contains a line break.",
+                  &location.directive_name, &location.file_name,
+                  location.line);
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+
+  main_conf->agent_headers.emplace_back(std::string{name}, std::move(value));
+  return static_cast<char *>(NGX_CONF_OK);
+} catch (const std::exception &e) {
+  ngx_conf_log_error(NGX_LOG_ERR, cf, 0, "%s", e.what());
+  return static_cast<char *>(NGX_CONF_ERROR);
+}
+
+char *set_datadog_version_file(ngx_conf_t *cf, ngx_command_t *command,
+                               void *conf) noexcept try {
+  auto *main_conf = static_cast<datadog_main_conf_t *>(conf);
+  if (main_conf->version_file_set) {
+    return const_cast<char *>("is duplicate");
+  }
+  main_conf->version_file_set = true;
+
+  const auto values = static_cast<ngx_str_t *>(cf->args->elts);
+  ngx_str_t path = values[1];
+  // Relative paths are relative to nginx's prefix, as with other directives
+  // that name files.
+  if (ngx_conf_full_name(cf->cycle, &path, 0) != NGX_OK) {
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+
+  std::ifstream file{to_string(path)};
+  std::string contents{std::istreambuf_iterator<char>(file),
+                       std::istreambuf_iterator<char>()};
+  if (!file.is_open()) {
+    ngx_conf_log_error(NGX_LOG_WARN, cf, 0,
+                       "unable to read Datadog version file \"%V\"; the "
+                       "version will be taken from DD_VERSION, if set",
+                       &path);
+    return static_cast<char *>(NGX_CONF_OK);
+  }
+
+  const auto is_space = [](unsigned char c) { return std::isspace(c); };
+  const auto begin =
+      std::find_if_not(contents.begin(), contents.end(), is_space);
+  const auto end =
+      std::find_if_not(contents.rbegin(), contents.rend(), is_space).base();
+  if (begin >= end) {
+    ngx_conf_log_error(NGX_LOG_WARN, cf, 0,
+                       "Datadog version file \"%V\" is empty", &path);
+    return static_cast<char *>(NGX_CONF_OK);
+  }
+
+  main_conf->version.emplace(begin, end);
+  return static_cast<char *>(NGX_CONF_OK);
+} catch (const std::exception &e) {
+  ngx_conf_log_error(NGX_LOG_ERR, cf, 0, "%s", e.what());
+  return static_cast<char *>(NGX_CONF_ERROR);
+}
+
+char *set_datadog_openapi_spec(ngx_conf_t *cf, ngx_command_t * /*command*/,
+                               void *conf) noexcept try {
+  auto *main_conf = static_cast<datadog_main_conf_t *>(conf);
+  if (main_conf->openapi_spec_set) {
+    return const_cast<char *>("is duplicate");
+  }
+  main_conf->openapi_spec_set = true;
+
+  const auto values = static_cast<ngx_str_t *>(cf->args->elts);
+  ngx_str_t path = values[1];
+  // Relative paths are relative to nginx's prefix, as with other directives
+  // that name files.
+  if (ngx_conf_full_name(cf->cycle, &path, 0) != NGX_OK) {
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+
+  std::ifstream file{to_string(path)};
+  if (!file.is_open()) {
+    ngx_conf_log_error(NGX_LOG_ERR, cf, 0,
+                       "unable to read OpenAPI spec \"%V\"", &path);
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+  const std::string contents{std::istreambuf_iterator<char>(file),
+                             std::istreambuf_iterator<char>()};
+
+  auto routes = parse_openapi_routes(contents);
+  if (const auto *error = routes.if_error()) {
+    ngx_conf_log_error(NGX_LOG_ERR, cf, 0, "invalid OpenAPI spec \"%V\": %s",
+                       &path, error->message.c_str());
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+  if (routes->empty()) {
+    ngx_conf_log_error(NGX_LOG_WARN, cf, 0,
+                       "OpenAPI spec \"%V\" has no supported paths", &path);
+  }
+
+  main_conf->openapi_routes = std::move(*routes);
+  return static_cast<char *>(NGX_CONF_OK);
+} catch (const std::exception &e) {
+  ngx_conf_log_error(NGX_LOG_ERR, cf, 0, "%s", e.what());
+  return static_cast<char *>(NGX_CONF_ERROR);
+}
+
+char *set_datadog_trace_api_version(ngx_conf_t *cf, ngx_command_t *command,
+                                    void *conf) noexcept {
+  auto *main_conf = static_cast<datadog_main_conf_t *>(conf);
+  if (main_conf->trace_api_version.data) {
+    return const_cast<char *>("is duplicate");
+  }
+
+  const auto values = static_cast<ngx_str_t *>(cf->args->elts);
+  const auto version = str(values[1]);
+  if (version != "v0.3" && version != "v0.4") {
+    const auto location = command_source_location(command, cf);
+    ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                  "Invalid argument \"%V\" to %V directive at %V:%d.  "
+                  "Acceptable values are \"v0.3\" and \"v0.4\".",
+                  &values[1], &location.directive_name, &location.file_name,
+                  location.line);
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+
+  main_conf->trace_api_version = values[1];
+  return static_cast<char *>(NGX_CONF_OK);
+}
+
+char *set_datadog_config_dump(ngx_conf_t *cf, ngx_command_t * /*command*/,
+                              void * /*conf*/) noexcept {
+  auto *core_loc_conf = static_cast<ngx_http_core_loc_conf_t *>(
+      ngx_http_conf_get_module_loc_conf(cf, ngx_http_core_module));
+  core_loc_conf->handler = config_dump_handler;
+  return static_cast<char *>(NGX_CONF_OK);
+}
+
+char *run_datadog_self_test(ngx_conf_t *cf, ngx_command_t *command,
+                            void *conf) noexcept try {
+  const auto result = run_self_test(std::make_shared<NgxLogger>());
+  if (const auto *error = result.if_error()) {
+    ngx_conf_log_error(NGX_LOG_EMERG, cf, 0, "datadog self-test: FAIL: %s",
+                       error->message.c_str());
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+
+  ngx_conf_log_error(NGX_LOG_NOTICE, cf, 0, "datadog self-test: PASS");
+  return static_cast<char *>(NGX_CONF_OK);
+} catch (const std::exception &e) {
+  ngx_conf_log_error(NGX_LOG_EMERG, cf, 0, "datadog self-test: FAIL: %s",
+                     e.what());
+  return static_cast<char *>(NGX_CONF_ERROR);
+}
+
+char *set_datadog_resource_normalize_pattern(ngx_conf_t *cf,
+                                             ngx_command_t *command,
+                                             void *conf) noexcept try {
+  const auto loc_conf = static_cast<datadog_loc_conf_t *>(conf);
+  const auto values = static_cast<ngx_str_t *>(cf->args->elts);
+  // values[0] is the command name, while values[1] and values[2] are the
+  // arguments:
+  //
+  //     datadog_resource_normalize_pattern <regex> <replacement>;
+#if (NGX_PCRE)
+  u_char errstr[NGX_MAX_CONF_ERRSTR];
+  ngx_regex_compile_t rc;
+  ngx_memzero(&rc, sizeof(rc));
+  rc.pattern = values[1];
+  rc.pool = cf->pool;
+  rc.err.len = NGX_MAX_CONF_ERRSTR;
+  rc.err.data = errstr;
+  if (ngx_regex_compile(&rc) != NGX_OK) {
+    const auto location = command_source_location(command, cf);
+    ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                  "Invalid regular expression \"%V\" in %V directive at "
+                  "%V:%d: %V",
+                  &values[1], &location.directive_name, &location.file_name,
+                  location.line, &rc.err);
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+
+  if (!loc_conf->resource_patterns) {
+    loc_conf->resource_patterns.emplace();
+  }
+  loc_conf->resource_patterns->push_back(resource_pattern_t{
+      .regex = rc.regex, .replacement = to_string(values[2])});
+  return static_cast<char *>(NGX_CONF_OK);
+#else
+  (void)loc_conf;
+  (void)values;
+  return const_cast<char *>("requires nginx to be built with PCRE");
+#endif
+} catch (const std::exception &e) {
+  ngx_conf_log_error(NGX_LOG_ERR, cf, 0, "%s", e.what());
+  return static_cast<char *>(NGX_CONF_ERROR);
+}
+
+char *set_datadog_error_statuses(ngx_conf_t *cf, ngx_command_t *command,
+                                 void *conf) noexcept {
+  const auto loc_conf = static_cast<datadog_loc_conf_t *>(conf);
+  if (loc_conf->error_statuses) {
+    return const_cast<char *>("is duplicate");
+  }
+
+  const auto location = command_source_location(command, cf);
+  const auto values = static_cast<ngx_str_t *>(cf->args->elts);
+  // values[0] is the command name, "datadog_error_statuses".
+  // The other elements are the arguments: status codes or ranges of them.
+  //
+  //     datadog_error_statuses <status>|<first>-<last> ...;
+  const auto parse_status = [](std::string_view text) -> ngx_int_t {
+    const ngx_int_t status =
+        ngx_atoi(reinterpret_cast<u_char *>(const_cast<char *>(text.data())),
+                 text.size());
+    if (status < 100 || status > 599) {
+      return NGX_ERROR;
+    }
+    return status;
+  };
+
+  std::vector<status_range_t> ranges;
+  for (ngx_uint_t i = 1; i < cf->args->nelts; ++i) {
+    const std::string_view arg = str(values[i]);
+    const auto hyphen = arg.find('-');
+    const ngx_int_t first = parse_status(arg.substr(0, hyphen));
+    const ngx_int_t last = hyphen == std::string_view::npos
+                               ? first
+                               : parse_status(arg.substr(hyphen + 1));
+    if (first == NGX_ERROR || last == NGX_ERROR || first > last) {
+      ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                    "Invalid argument \"%V\" to %V directive at %V:%d.  "
+                    "Expected an HTTP status code between 100 and 599, e.g. "
+                    "\"500\", or an ascending range of such codes, e.g. "
+                    "\"500-599\".",
+                    &values[i], &location.directive_name, &location.file_name,
+                    location.line);
+      return static_cast<char *>(NGX_CONF_ERROR);
+    }
+    ranges.push_back(status_range_t{.first = ngx_uint_t(first),
+                                    .last = ngx_uint_t(last)});
+  }
+
+  loc_conf->error_statuses = std::move(ranges);
+  return static_cast<char *>(NGX_CONF_OK);
+}
+
+char *set_datadog_error_on_header(ngx_conf_t *cf, ngx_command_t *command,
+                                  void *conf) noexcept {
+  const auto loc_conf = static_cast<datadog_loc_conf_t *>(conf);
+  if (loc_conf->error_on_header.data) {
+    return const_cast<char *>("is duplicate");
+  }
+
+  const auto values = static_cast<ngx_str_t *>(cf->args->elts);
+  if (values[1].len == 0) {
+    const auto location = command_source_location(command, cf);
+    ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                  "Invalid argument \"\" to %V directive at %V:%d.  Expected "
+                  "the name of a response header.",
+                  &location.directive_name, &location.file_name,
+                  location.line);
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+
+  // Response header names are compared case-insensitively, so store the name
+  // in lower case.
+  ngx_str_t &name = loc_conf->error_on_header;
+  name.len = values[1].len;
+  name.data = static_cast<u_char *>(ngx_pnalloc(cf->pool, name.len));
+  if (name.data == nullptr) {
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+  ngx_strlow(name.data, values[1].data, name.len);
+
+  return static_cast<char *>(NGX_CONF_OK);
+}
+
 char *hijack_auth_request(ngx_conf_t *cf, ngx_command_t *command,
                           void *conf) noexcept try {
   // Call the underlying directive handler, and then insert the following:
@@ -665,6 +1220,119 @@ char *waf_thread_pool_name(ngx_conf_t *cf, ngx_command_t *command,
 
   return NGX_CONF_OK;
 }
+
+// Return whether the specified `route` is a valid route template: it begins
+// with a slash, and each of its segments either contains no braces, or is a
+// parameter of the form "{name}" where "name" is not empty.
+static bool is_valid_route_template(std::string_view route) {
+  if (route.empty() || route.front() != '/') {
+    return false;
+  }
+
+  std::size_t begin = 1;
+  while (begin <= route.size()) {
+    const std::size_t end = std::min(route.find('/', begin), route.size());
+    const std::string_view segment = route.substr(begin, end - begin);
+    const std::size_t braces = std::count(segment.begin(), segment.end(), '{') +
+                               std::count(segment.begin(), segment.end(), '}');
+    const bool is_param = segment.size() > 2 && segment.front() == '{' &&
+                          segment.back() == '}' && braces == 2;
+    if (braces != 0 && !is_param) {
+      return false;
+    }
+    begin = end + 1;
+  }
+
+  return true;
+}
+
+char *set_datadog_appsec_route(ngx_conf_t *cf, ngx_command_t *command,
+                               void *conf) noexcept {
+  auto *loc_conf = static_cast<datadog_loc_conf_t *>(conf);
+  if (loc_conf->appsec_route.data) {
+    return const_cast<char *>("is duplicate");
+  }
+
+  const auto values = static_cast<ngx_str_t *>(cf->args->elts);
+  if (!is_valid_route_template(str(values[1]))) {
+    const auto location = command_source_location(command, cf);
+    ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                  "Invalid argument \"%V\" to %V directive at %V:%d.  Expected "
+                  "a route template beginning with \"/\" whose parameters are "
+                  "whole path segments, e.g. \"/users/{id}/orders/{order}\".",
+                  &values[1], &location.directive_name, &location.file_name,
+                  location.line);
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+
+  loc_conf->appsec_route = values[1];
+  return static_cast<char *>(NGX_CONF_OK);
+}
+
+char *set_datadog_appsec_body_redact_keys(ngx_conf_t *cf,
+                                          ngx_command_t *command,
+                                          void *conf) noexcept {
+  const auto main_conf = static_cast<datadog_main_conf_t *>(conf);
+  const auto values = static_cast<ngx_str_t *>(cf->args->elts);
+  // values[0] is the command name, "datadog_appsec_body_redact_keys".
+  //
+  //     datadog_appsec_body_redact_keys <key> [<key> ...];
+  for (ngx_uint_t i = 1; i < cf->args->nelts; ++i) {
+    if (values[i].len == 0) {
+      const auto location = command_source_location(command, cf);
+      ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                    "Invalid argument \"\" to %V directive at %V:%d.  "
+                    "Expected a non-empty JSON key.",
+                    &location.directive_name, &location.file_name,
+                    location.line);
+      return static_cast<char *>(NGX_CONF_ERROR);
+    }
+    std::string key = to_string(values[i]);
+    for (char &c : key) {
+      c = to_lower(c);
+    }
+    main_conf->appsec_body_redact_keys.push_back(std::move(key));
+  }
+  return static_cast<char *>(NGX_CONF_OK);
+}
+
+char *set_datadog_appsec_named_ruleset_file(ngx_conf_t *cf,
+                                            ngx_command_t *command,
+                                            void *conf) noexcept try {
+  auto *main_conf = static_cast<datadog_main_conf_t *>(conf);
+  const auto values = static_cast<ngx_str_t *>(cf->args->elts);
+  // values[0] is the command name, while values[1] and values[2] are the
+  // arguments:
+  //
+  //     datadog_appsec_named_ruleset_file <name> <file>;
+  const auto location = command_source_location(command, cf);
+  if (values[1].len == 0 || values[2].len == 0) {
+    ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                  "Invalid arguments to %V directive at %V:%d.  Expected a "
+                  "non-empty ruleset name and file.",
+                  &location.directive_name, &location.file_name,
+                  location.line);
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+
+  const std::string name = to_string(values[1]);
+  for (const auto &[existing, file] : main_conf->appsec_named_rulesets) {
+    if (existing == name) {
+      ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                    "Duplicate AppSec ruleset name \"%V\" in %V directive at "
+                    "%V:%d.",
+                    &values[1], &location.directive_name, &location.file_name,
+                    location.line);
+      return static_cast<char *>(NGX_CONF_ERROR);
+    }
+  }
+
+  main_conf->appsec_named_rulesets.emplace_back(name, to_string(values[2]));
+  return static_cast<char *>(NGX_CONF_OK);
+} catch (const std::exception &e) {
+  ngx_conf_log_error(NGX_LOG_ERR, cf, 0, "%s", e.what());
+  return static_cast<char *>(NGX_CONF_ERROR);
+}
 #endif
 
 }  // namespace nginx
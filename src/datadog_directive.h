@@ -39,9 +39,25 @@ char *set_datadog_location_operation_name(ngx_conf_t *cf,
 char *set_datadog_resource_name(ngx_conf_t *cf, ngx_command_t *command,
                                 void *conf) noexcept;
 
+char *set_datadog_service_name_override(ngx_conf_t *cf, ngx_command_t *command,
+                                        void *conf) noexcept;
+
+char *set_datadog_peer_service(ngx_conf_t *cf, ngx_command_t *command,
+                               void *conf) noexcept;
+
 char *set_datadog_location_resource_name(ngx_conf_t *cf, ngx_command_t *command,
                                          void *conf) noexcept;
 
+char *set_datadog_sampling_priority_override(ngx_conf_t *cf,
+                                             ngx_command_t *command,
+                                             void *conf) noexcept;
+
+char *set_datadog_trace_context_jwt(ngx_conf_t *cf, ngx_command_t *command,
+                                    void *conf) noexcept;
+
+char *set_datadog_drop_trace_if(ngx_conf_t *cf, ngx_command_t *command,
+                                void *conf) noexcept;
+
 char *toggle_opentracing(ngx_conf_t *cf, ngx_command_t *command,
                          void *conf) noexcept;
 
@@ -57,6 +73,13 @@ char *plugin_loading_deprecated(ngx_conf_t *cf, ngx_command_t *command,
 char *set_datadog_sample_rate(ngx_conf_t *cf, ngx_command_t *command,
                               void *conf) noexcept;
 
+char *set_datadog_upstream_sample_rate(ngx_conf_t *cf, ngx_command_t *command,
+                                       void *conf) noexcept;
+
+char *set_datadog_cookie_redact_allowlist(ngx_conf_t *cf,
+                                          ngx_command_t *command,
+                                          void *conf) noexcept;
+
 char *set_datadog_propagation_styles(ngx_conf_t *cf, ngx_command_t *command,
                                      void *conf) noexcept;
 
@@ -68,6 +91,51 @@ char *set_datadog_environment(ngx_conf_t *, ngx_command_t *,
 
 char *set_datadog_agent_url(ngx_conf_t *, ngx_command_t *, void *conf) noexcept;
 
+// Read the version of the service from the file named by the directive's
+// argument, and store it in the main configuration.  If the file cannot be
+// read, log a warning and leave the version unset.
+char *set_datadog_version_file(ngx_conf_t *cf, ngx_command_t *command,
+                               void *conf) noexcept;
+
+// Add a header, named by the directive's first argument, to requests sent to
+// the Datadog Agent. References of the form "${NAME}" in the header value, the
+// second argument, are replaced by the value of the environment variable
+// "NAME".
+char *set_datadog_zipkin_endpoint(ngx_conf_t *cf, ngx_command_t *command,
+                                  void *conf) noexcept;
+
+char *set_datadog_agent_header(ngx_conf_t *cf, ngx_command_t *command,
+                               void *conf) noexcept;
+
+char *set_datadog_openapi_spec(ngx_conf_t *cf, ngx_command_t *command,
+                               void *conf) noexcept;
+
+char *set_datadog_trace_api_version(ngx_conf_t *cf, ngx_command_t *command,
+                                    void *conf) noexcept;
+
+// Make the current location respond with a JSON description of the module's
+// effective configuration. See `config_dump.h`.
+char *set_datadog_config_dump(ngx_conf_t *cf, ngx_command_t *command,
+                              void *conf) noexcept;
+
+// Run the module's self-test (see `self_test.h`), and log "PASS" or "FAIL"
+// with a reason. Fail the configuration if the self-test fails.
+char *run_datadog_self_test(ngx_conf_t *cf, ngx_command_t *command,
+                            void *conf) noexcept;
+
+// Compile the regular expression that is the directive's first argument, and
+// append it, with its replacement text, to the location's resource name
+// patterns.
+char *set_datadog_resource_normalize_pattern(ngx_conf_t *cf,
+                                             ngx_command_t *command,
+                                             void *conf) noexcept;
+
+char *set_datadog_error_statuses(ngx_conf_t *cf, ngx_command_t *command,
+                                 void *conf) noexcept;
+
+char *set_datadog_error_on_header(ngx_conf_t *cf, ngx_command_t *command,
+                                  void *conf) noexcept;
+
 char *hijack_auth_request(ngx_conf_t *cf, ngx_command_t *command,
                           void *conf) noexcept;
 
@@ -77,6 +145,17 @@ char *warn_deprecated_command(ngx_conf_t *cf, ngx_command_t * /*command*/,
 #ifdef WITH_WAF
 char *waf_thread_pool_name(ngx_conf_t *cf, ngx_command_t *command,
                            void *conf) noexcept;
+
+char *set_datadog_appsec_route(ngx_conf_t *cf, ngx_command_t *command,
+                               void *conf) noexcept;
+
+char *set_datadog_appsec_body_redact_keys(ngx_conf_t *cf,
+                                          ngx_command_t *command,
+                                          void *conf) noexcept;
+
+char *set_datadog_appsec_named_ruleset_file(ngx_conf_t *cf,
+                                            ngx_command_t *command,
+                                            void *conf) noexcept;
 #endif
 
 }  // namespace nginx
@@ -7,6 +7,9 @@ extern "C" {
 }
 
 #include "datadog_context.h"
+#ifdef WITH_WAF
+#include "security/library.h"
+#endif
 
 extern "C" {
 extern ngx_module_t ngx_http_datadog_module;
@@ -28,19 +31,84 @@ static bool is_datadog_enabled(const ngx_http_request_t *request,
   }
 }
 
+// Return whether the specified `request` must be handled even though tracing
+// is disabled for it, because it is a main request and AppSec is active. Such
+// requests are protected by AppSec, but their traces are not sent.
+static bool is_appsec_only(const ngx_http_request_t *request) noexcept {
+#ifdef WITH_WAF
+  return request == request->main && security::Library::active();
+#else
+  (void)request;
+  return false;
+#endif
+}
+
+// Return whether the span of the specified `request` waits until nginx has
+// received the request body, as configured by `datadog_span_start after-body`
+// in the specified `loc_conf`. Only main requests that have a body wait, and
+// only when AppSec is not active, because AppSec must inspect the request in
+// the access phase, before the body is read.
+static bool defers_span_to_body(const ngx_http_request_t *request,
+                                const datadog_loc_conf_t *loc_conf) noexcept {
+#ifdef WITH_WAF
+  if (security::Library::active()) return false;
+#endif
+  return request == request->main &&
+         loc_conf->span_start == SPAN_START_AFTER_BODY &&
+         (request->headers_in.content_length_n > 0 ||
+          request->headers_in.chunked);
+}
+
+// Begin tracing the specified `request` once nginx has received its body, and
+// then resume the request's phases. This calls `on_precontent` again, which
+// now has nothing to do, even if tracing the request failed.
+static void on_request_body_received(ngx_http_request_t *request) noexcept {
+#if nginx_version >= 1021001
+  // Keep the body for any internal redirect, as the `mirror` module does.
+  request->preserve_body = 1;
+#endif
+  auto core_loc_conf = static_cast<ngx_http_core_loc_conf_t *>(
+      ngx_http_get_module_loc_conf(request, ngx_http_core_module));
+  auto loc_conf = static_cast<datadog_loc_conf_t *>(
+      ngx_http_get_module_loc_conf(request, ngx_http_datadog_module));
+  try {
+    auto context = new DatadogContext{request, core_loc_conf, loc_conf, true};
+    set_datadog_context(request, context);
+    if (request->request_body != nullptr) {
+      // The body passed through the request body filters before the request
+      // was traced, so count it now.
+      context->on_request_body(request, request->request_body->bufs);
+    }
+  } catch (const std::exception &e) {
+    ngx_log_error(NGX_LOG_ERR, request->connection->log, 0,
+                  "Datadog instrumentation failed for request %p: %s", request,
+                  e.what());
+  }
+  request->write_event_handler = ngx_http_core_run_phases;
+  ngx_http_core_run_phases(request);
+}
+
 ngx_int_t on_enter_block(ngx_http_request_t *request) noexcept try {
   auto core_loc_conf = static_cast<ngx_http_core_loc_conf_t *>(
       ngx_http_get_module_loc_conf(request, ngx_http_core_module));
   auto loc_conf = static_cast<datadog_loc_conf_t *>(
       ngx_http_get_module_loc_conf(request, ngx_http_datadog_module));
-  if (!is_datadog_enabled(request, core_loc_conf, loc_conf))
-    return NGX_DECLINED;
+  const bool enabled = is_datadog_enabled(request, core_loc_conf, loc_conf);
 
   auto context = get_datadog_context(request);
   if (context == nullptr) {
+    // An untraced CORS preflight request is handled like a request for which
+    // tracing is disabled: only if AppSec is active, so that it is still
+    // protected.
+    const bool traced =
+        enabled && !is_untraced_cors_preflight(request, loc_conf);
+    if (!traced && !is_appsec_only(request)) return NGX_DECLINED;
+    // A request whose span waits for its body is traced in `on_precontent`.
+    if (traced && defers_span_to_body(request, loc_conf)) return NGX_DECLINED;
     context = new DatadogContext{request, core_loc_conf, loc_conf};
     set_datadog_context(request, context);
   } else {
+    if (!enabled) return NGX_DECLINED;
     try {
       context->on_change_block(request, core_loc_conf, loc_conf);
     } catch (...) {
@@ -58,6 +126,46 @@ ngx_int_t on_enter_block(ngx_http_request_t *request) noexcept try {
   return NGX_DECLINED;
 }
 
+ngx_int_t on_precontent(ngx_http_request_t *request) noexcept try {
+  // Once the body has been requested, whether by this module or by another,
+  // the phases are already past waiting for it. A context might not exist
+  // even then, e.g. if creating it failed, so the context alone does not tell.
+  if (request != request->main || request->request_body != nullptr ||
+      get_datadog_context(request) != nullptr) {
+    return NGX_DECLINED;
+  }
+  auto core_loc_conf = static_cast<ngx_http_core_loc_conf_t *>(
+      ngx_http_get_module_loc_conf(request, ngx_http_core_module));
+  auto loc_conf = static_cast<datadog_loc_conf_t *>(
+      ngx_http_get_module_loc_conf(request, ngx_http_datadog_module));
+  if (!is_datadog_enabled(request, core_loc_conf, loc_conf) ||
+      is_untraced_cors_preflight(request, loc_conf) ||
+      !defers_span_to_body(request, loc_conf)) {
+    return NGX_DECLINED;
+  }
+  if (request->discard_body) {
+    // The body will not be read, so there is nothing to wait for.
+    set_datadog_context(request,
+                        new DatadogContext{request, core_loc_conf, loc_conf});
+    return NGX_DECLINED;
+  }
+
+  // This is what the `mirror` module does with `mirror_request_body`. If
+  // reading the body fails, then the request is traced in `on_log_request`.
+  const ngx_int_t rc =
+      ngx_http_read_client_request_body(request, on_request_body_received);
+  if (rc >= NGX_HTTP_SPECIAL_RESPONSE) {
+    return rc;
+  }
+  ngx_http_finalize_request(request, NGX_DONE);
+  return NGX_DONE;
+} catch (const std::exception &e) {
+  ngx_log_error(NGX_LOG_ERR, request->connection->log, 0,
+                "Datadog instrumentation failed for request %p: %s", request,
+                e.what());
+  return NGX_DECLINED;
+}
+
 #ifdef WITH_WAF
 ngx_int_t on_access(ngx_http_request_t *request) noexcept try {
   if (request->main != request) {
@@ -83,8 +191,23 @@ ngx_int_t on_access(ngx_http_request_t *request) noexcept try {
 
 ngx_int_t on_log_request(ngx_http_request_t *request) noexcept {
   auto context = get_datadog_context(request);
-  if (context == nullptr) return NGX_DECLINED;
   try {
+    if (context == nullptr) {
+      // A request whose span waits for its body, but that finished before
+      // nginx received the body (e.g. it was rejected in the access phase, or
+      // reading the body failed), is still traced, from its usual start.
+      auto core_loc_conf = static_cast<ngx_http_core_loc_conf_t *>(
+          ngx_http_get_module_loc_conf(request, ngx_http_core_module));
+      auto loc_conf = static_cast<datadog_loc_conf_t *>(
+          ngx_http_get_module_loc_conf(request, ngx_http_datadog_module));
+      if (!is_datadog_enabled(request, core_loc_conf, loc_conf) ||
+          is_untraced_cors_preflight(request, loc_conf) ||
+          !defers_span_to_body(request, loc_conf)) {
+        return NGX_DECLINED;
+      }
+      context = new DatadogContext{request, core_loc_conf, loc_conf};
+      set_datadog_context(request, context);
+    }
     context->on_log_request(request);
   } catch (const std::exception &e) {
     ngx_log_error(NGX_LOG_ERR, request->connection->log, 0,
@@ -94,6 +217,41 @@ ngx_int_t on_log_request(ngx_http_request_t *request) noexcept {
   return NGX_DECLINED;
 }
 
+ngx_http_request_body_filter_pt ngx_http_next_request_body_filter;
+ngx_int_t request_body_filter(ngx_http_request_t *request,
+                              ngx_chain_t *chain) noexcept {
+  DatadogContext *context = get_datadog_context(request->main);
+  if (!context) {
+    return ngx_http_next_request_body_filter(request, chain);
+  }
+
+  try {
+    context->on_request_body(request, chain);
+  } catch (const std::exception &e) {
+    ngx_log_error(NGX_LOG_ERR, request->connection->log, 0,
+                  "Datadog instrumentation failed for request %p: %s", request,
+                  e.what());
+  }
+  return ngx_http_next_request_body_filter(request, chain);
+}
+
+ngx_http_output_header_filter_pt ngx_http_next_output_header_filter;
+ngx_int_t output_header_filter(ngx_http_request_t *request) noexcept {
+  DatadogContext *context = get_datadog_context(request);
+  if (!context) {
+    return ngx_http_next_output_header_filter(request);
+  }
+
+  try {
+    context->on_header_filter(request);
+  } catch (const std::exception &e) {
+    ngx_log_error(NGX_LOG_ERR, request->connection->log, 0,
+                  "Datadog instrumentation failed for request %p: %s", request,
+                  e.what());
+  }
+  return ngx_http_next_output_header_filter(request);
+}
+
 #ifdef WITH_WAF
 ngx_http_output_body_filter_pt ngx_http_next_output_body_filter;
 ngx_int_t output_body_filter(ngx_http_request_t *request,
@@ -12,6 +12,7 @@
 #include "dd.h"
 #include "global_tracer.h"
 #include "ngx_http_datadog_module.h"
+#include "rejected_spans_http_client.h"
 #include "string_util.h"
 #include "tracing_library.h"
 
@@ -237,6 +238,72 @@ static ngx_int_t expand_proxy_directive_variable(
   return NGX_OK;
 }
 
+// Load into the specified `variable_value` the result of looking up the value
+// of the variable whose name is determined by
+// `TracingLibrary::host_variable_name()`.  The variable evaluates to the first
+// non-empty of:
+//
+// - the "Host" header of `request`, as sent (including any port),
+// - the host from the request line, if it is an absolute URI,
+// - the `server_name` of the server handling `request`,
+// - the `datadog_default_host` of the location associated with `request`.
+//
+// If all of those are empty, then the variable evaluates to an empty string.
+// It is never "not found", so that scripts and maps keyed on it do not have to
+// account for a missing value.
+static ngx_int_t expand_host_variable(ngx_http_request_t* request,
+                                      ngx_http_variable_value_t* variable_value,
+                                      uintptr_t /*data*/) noexcept {
+  ngx_str_t value_str = ngx_null_string;
+  if (request->headers_in.host != nullptr) {
+    value_str = request->headers_in.host->value;
+  }
+  if (value_str.len == 0) {
+    value_str = request->headers_in.server;
+  }
+  if (value_str.len == 0) {
+    const auto core_srv_conf = static_cast<ngx_http_core_srv_conf_t*>(
+        ngx_http_get_module_srv_conf(request, ngx_http_core_module));
+    if (core_srv_conf != nullptr) {
+      value_str = core_srv_conf->server_name;
+    }
+  }
+  if (value_str.len == 0) {
+    const auto loc_conf = static_cast<datadog_loc_conf_t*>(
+        ngx_http_get_module_loc_conf(request, ngx_http_datadog_module));
+    if (loc_conf != nullptr) {
+      value_str = loc_conf->default_host;
+    }
+  }
+
+  variable_value->len = value_str.len;
+  variable_value->valid = true;
+  variable_value->no_cacheable = true;
+  variable_value->not_found = false;
+  variable_value->data = value_str.data;
+
+  return NGX_OK;
+}
+
+// Load into the specified `variable_value` the result of looking up the value
+// of the variable whose name is determined by
+// `TracingLibrary::agent_rejected_spans_variable_name()`.  The variable
+// evaluates to a JSON object of the number of spans that the Datadog Agent
+// rejected in the current worker process, keyed by response status, e.g.
+// `{"413":2}`.  Return `NGX_OK` on success or another value if an error occurs.
+static ngx_int_t expand_agent_rejected_spans_variable(
+    ngx_http_request_t* request, ngx_http_variable_value_t* variable_value,
+    uintptr_t /*data*/) noexcept {
+  variable_value->valid = true;
+  variable_value->no_cacheable = true;
+  variable_value->not_found = false;
+
+  const ngx_str_t json_str = to_ngx_str(request->pool, rejected_spans_json());
+  variable_value->len = json_str.len;
+  variable_value->data = json_str.data;
+  return NGX_OK;
+}
+
 ngx_int_t add_variables(ngx_conf_t* cf) noexcept {
   ngx_str_t prefix;
   ngx_http_variable_t* variable;
@@ -277,6 +344,20 @@ ngx_int_t add_variables(ngx_conf_t* cf) noexcept {
   variable = ngx_http_add_variable(cf, &name, NGX_HTTP_VAR_NOHASH);
   variable->get_handler = expand_proxy_directive_variable;
   variable->data = 0;
+
+  // Register the variable name for getting a request's host, with a fallback
+  // for requests that have none.
+  name = to_ngx_str(TracingLibrary::host_variable_name());
+  variable = ngx_http_add_variable(cf, &name, NGX_HTTP_VAR_NOHASH);
+  variable->get_handler = expand_host_variable;
+  variable->data = 0;
+
+  // Register the variable name for getting the number of spans rejected by
+  // the Datadog Agent.
+  name = to_ngx_str(TracingLibrary::agent_rejected_spans_variable_name());
+  variable = ngx_http_add_variable(cf, &name, NGX_HTTP_VAR_NOHASH);
+  variable->get_handler = expand_agent_rejected_spans_variable;
+  variable->data = 0;
   return NGX_OK;
 }
 }  // namespace nginx
@@ -9,6 +9,7 @@ namespace datadog {
 namespace nginx {
 namespace {
 std::optional<dd::Tracer> instance;
+std::optional<dd::Tracer> unreported_instance;
 }  // namespace
 
 dd::Tracer* global_tracer() {
@@ -22,5 +23,18 @@ void reset_global_tracer() { instance.reset(); }
 
 void reset_global_tracer(dd::Tracer&& tracer) { instance = std::move(tracer); }
 
+dd::Tracer* global_unreported_tracer() {
+  if (unreported_instance) {
+    return &*unreported_instance;
+  }
+  return nullptr;
+}
+
+void reset_global_unreported_tracer() { unreported_instance.reset(); }
+
+void reset_global_unreported_tracer(dd::Tracer&& tracer) {
+  unreported_instance = std::move(tracer);
+}
+
 }  // namespace nginx
 }  // namespace datadog
@@ -13,5 +13,14 @@ void reset_global_tracer();
 
 void reset_global_tracer(dd::Tracer&&);
 
+// The "unreported" tracer is configured like the global tracer, but never
+// sends traces to the Datadog Agent.  It is used for requests whose traces
+// are dropped by the `datadog_drop_trace_if` directive.
+dd::Tracer* global_unreported_tracer();
+
+void reset_global_unreported_tracer();
+
+void reset_global_unreported_tracer(dd::Tracer&&);
+
 }  // namespace nginx
 }  // namespace datadog
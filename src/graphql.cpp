@@ -0,0 +1,167 @@
+#include "graphql.h"
+
+#include <algorithm>
+#include <datadog/json.hpp>
+#include <utility>
+#include <vector>
+
+namespace datadog {
+namespace nginx {
+namespace {
+
+bool is_name_start(char ch) {
+  return ch == '_' || (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z');
+}
+
+bool is_name_continue(char ch) {
+  return is_name_start(ch) || (ch >= '0' && ch <= '9');
+}
+
+// `Scanner` produces the tokens of a GraphQL document that matter for finding
+// its operations: names and punctuation. Whitespace, commas, comments, and
+// string values are skipped.
+class Scanner {
+  std::string_view text_;
+  std::size_t pos_ = 0;
+
+  void skip_ignored() {
+    while (pos_ < text_.size()) {
+      const char ch = text_[pos_];
+      if (ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == ',') {
+        ++pos_;
+      } else if (ch == '#') {
+        while (pos_ < text_.size() && text_[pos_] != '\n' &&
+               text_[pos_] != '\r') {
+          ++pos_;
+        }
+      } else if (ch == '"') {
+        skip_string();
+      } else {
+        return;
+      }
+    }
+  }
+
+  void skip_string() {
+    if (text_.substr(pos_, 3) == R"(""")") {
+      // A block string ends at the next unescaped triple quote.
+      pos_ += 3;
+      while (pos_ < text_.size() && text_.substr(pos_, 3) != R"(""")") {
+        pos_ += text_.substr(pos_, 4) == R"(\""")" ? 4 : 1;
+      }
+      pos_ = std::min(pos_ + 3, text_.size());
+      return;
+    }
+    ++pos_;
+    while (pos_ < text_.size() && text_[pos_] != '"' && text_[pos_] != '\n') {
+      pos_ += text_[pos_] == '\\' ? 2 : 1;
+    }
+    pos_ = std::min(pos_ + 1, text_.size());
+  }
+
+ public:
+  explicit Scanner(std::string_view text) : text_(text) {}
+
+  // Return the next token, or an empty token at the end of the document. A
+  // token is either a name or a single punctuation character.
+  std::string_view next() {
+    skip_ignored();
+    if (pos_ == text_.size()) {
+      return {};
+    }
+    const std::size_t begin = pos_++;
+    if (is_name_start(text_[begin])) {
+      while (pos_ < text_.size() && is_name_continue(text_[pos_])) {
+        ++pos_;
+      }
+    }
+    return text_.substr(begin, pos_ - begin);
+  }
+};
+
+bool is_operation_type(std::string_view token) {
+  return token == "query" || token == "mutation" || token == "subscription";
+}
+
+// Return the operations defined at the top level of the specified GraphQL
+// `document`, in order. Fragment definitions are not operations.
+std::vector<GraphQLOperation> find_operations(std::string_view document) {
+  std::vector<GraphQLOperation> operations;
+  Scanner scanner{document};
+  // `depth` is how deeply nested the scanner is within braces, parentheses,
+  // and brackets. Definitions begin at depth zero.
+  int depth = 0;
+  // `in_definition` is whether a definition has begun at depth zero, but its
+  // selection set has not.
+  bool in_definition = false;
+  std::string_view pending;
+  for (std::string_view token = scanner.next(); !token.empty();
+       token = pending.empty() ? scanner.next() : std::exchange(pending, {})) {
+    if (token == "{" || token == "(" || token == "[") {
+      if (depth == 0 && token == "{") {
+        if (!in_definition) {
+          // A selection set by itself is an anonymous query.
+          operations.push_back(GraphQLOperation{"query", ""});
+        }
+        in_definition = false;
+      }
+      ++depth;
+    } else if (token == "}" || token == ")" || token == "]") {
+      if (depth > 0) {
+        --depth;
+      }
+    } else if (depth == 0 && !in_definition && is_name_start(token[0])) {
+      in_definition = true;
+      if (!is_operation_type(token)) {
+        // e.g. a fragment definition
+        continue;
+      }
+      GraphQLOperation operation{std::string{token}, ""};
+      std::string_view next = scanner.next();
+      if (!next.empty() && is_name_start(next[0])) {
+        operation.name = next;
+      } else {
+        pending = next;
+      }
+      operations.push_back(std::move(operation));
+    }
+  }
+  return operations;
+}
+
+}  // namespace
+
+std::optional<GraphQLOperation> parse_graphql_request(std::string_view body) {
+  const auto request = nlohmann::json::parse(body, nullptr,
+                                             /*allow_exceptions=*/false);
+  if (!request.is_object()) {
+    return std::nullopt;
+  }
+  const auto query = request.find("query");
+  if (query == request.end() || !query->is_string()) {
+    return std::nullopt;
+  }
+
+  std::string operation_name;
+  const auto name = request.find("operationName");
+  if (name != request.end() && name->is_string()) {
+    operation_name = name->get<std::string>();
+  }
+
+  auto operations = find_operations(query->get_ref<const std::string &>());
+  if (operation_name.empty()) {
+    if (operations.size() != 1) {
+      return std::nullopt;
+    }
+    return std::move(operations.front());
+  }
+  for (GraphQLOperation &operation : operations) {
+    if (operation.name == operation_name) {
+      return std::move(operation);
+    }
+  }
+  return std::nullopt;
+}
+
+}  // namespace nginx
+}  // namespace datadog
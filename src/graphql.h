@@ -0,0 +1,36 @@
+#pragma once
+
+// This component provides a function, `parse_graphql_request`, that finds the
+// operation requested by a GraphQL-over-HTTP request body, e.g.
+//
+//     {"query": "query GetUser($id: ID!) { user(id: $id) { name } }"}
+//
+// requests the "query" operation named "GetUser". It is used by the
+// `datadog_graphql_resource_name` directive to name request spans' resources.
+//
+// The query document is scanned only as far as is needed to find its
+// top-level operations. It is not validated.
+
+#include <optional>
+#include <string>
+#include <string_view>
+
+namespace datadog {
+namespace nginx {
+
+struct GraphQLOperation {
+  // The operation type, i.e. one of "query", "mutation", or "subscription".
+  std::string type;
+  // The operation name, or empty if the operation is anonymous.
+  std::string name;
+};
+
+// Return the operation requested by the specified JSON request `body`, whose
+// "query" property is a GraphQL document. If the document defines more than
+// one operation, then the body's "operationName" property selects one of
+// them. Return `std::nullopt` if `body` is not such a request, or if it does
+// not identify exactly one operation.
+std::optional<GraphQLOperation> parse_graphql_request(std::string_view body);
+
+}  // namespace nginx
+}  // namespace datadog
@@ -0,0 +1,134 @@
+#include "jwt.h"
+
+#include <string>
+
+extern "C" {
+#include <ngx_config.h>
+#include <ngx_core.h>
+}
+
+#if (NGX_OPENSSL)
+#include <openssl/crypto.h>
+#include <openssl/evp.h>
+#include <openssl/hmac.h>
+#endif
+
+namespace datadog {
+namespace nginx {
+namespace {
+
+// Return the decoding of the specified unpadded base64url `encoded` text, or
+// return `std::nullopt` if `encoded` is not valid base64url.
+std::optional<std::string> decode_base64url(std::string_view encoded) {
+  std::string decoded(ngx_base64_decoded_length(encoded.size()), '\0');
+  ngx_str_t src;
+  src.data = reinterpret_cast<u_char *>(const_cast<char *>(encoded.data()));
+  src.len = encoded.size();
+  ngx_str_t dst;
+  dst.data = reinterpret_cast<u_char *>(decoded.data());
+  if (ngx_decode_base64url(&dst, &src) != NGX_OK) {
+    return std::nullopt;
+  }
+  decoded.resize(dst.len);
+  return decoded;
+}
+
+std::optional<nlohmann::json> decode_json_part(std::string_view encoded) {
+  auto decoded = decode_base64url(encoded);
+  if (!decoded) {
+    return std::nullopt;
+  }
+  auto parsed = nlohmann::json::parse(*decoded, nullptr,
+                                      /*allow_exceptions=*/false);
+  if (!parsed.is_object()) {
+    return std::nullopt;
+  }
+  return parsed;
+}
+
+// Return whether the specified `signature` is the HMAC-SHA256 of the specified
+// `signed_part` using the specified `key`.
+bool is_hs256_signature(std::string_view signed_part, std::string_view key,
+                        std::string_view signature) {
+#if (NGX_OPENSSL)
+  unsigned char digest[EVP_MAX_MD_SIZE];
+  unsigned int digest_length = 0;
+  if (HMAC(EVP_sha256(), key.data(), int(key.size()),
+           reinterpret_cast<const unsigned char *>(signed_part.data()),
+           signed_part.size(), digest, &digest_length) == nullptr) {
+    return false;
+  }
+  return signature.size() == digest_length &&
+         CRYPTO_memcmp(digest, signature.data(), digest_length) == 0;
+#else
+  (void)signed_part;
+  (void)key;
+  (void)signature;
+  return false;
+#endif
+}
+
+}  // namespace
+
+bool jwt_verification_supported() {
+#if (NGX_OPENSSL)
+  return true;
+#else
+  return false;
+#endif
+}
+
+std::optional<nlohmann::json> verify_jwt(std::string_view token,
+                                         std::string_view key,
+                                         std::time_t now) {
+  // header.payload.signature
+  const auto first_dot = token.find('.');
+  if (first_dot == std::string_view::npos) {
+    return std::nullopt;
+  }
+  const auto second_dot = token.find('.', first_dot + 1);
+  if (second_dot == std::string_view::npos ||
+      token.find('.', second_dot + 1) != std::string_view::npos) {
+    return std::nullopt;
+  }
+
+  // The header is checked before the signature, so it may come from anyone.
+  // `value("alg", "")` would throw if "alg" were not a string.
+  const auto header = decode_json_part(token.substr(0, first_dot));
+  if (!header) {
+    return std::nullopt;
+  }
+  const auto alg = header->find("alg");
+  if (alg == header->end() || !alg->is_string() || *alg != "HS256") {
+    return std::nullopt;
+  }
+
+  const auto signature = decode_base64url(token.substr(second_dot + 1));
+  if (!signature ||
+      !is_hs256_signature(token.substr(0, second_dot), key, *signature)) {
+    return std::nullopt;
+  }
+
+  auto claims = decode_json_part(
+      token.substr(first_dot + 1, second_dot - first_dot - 1));
+  if (!claims) {
+    return std::nullopt;
+  }
+
+  // "exp" and "nbf" are in seconds since the Unix epoch.
+  const auto expires = claims->find("exp");
+  if (expires != claims->end() &&
+      (!expires->is_number() || double(now) >= expires->get<double>())) {
+    return std::nullopt;
+  }
+  const auto not_before = claims->find("nbf");
+  if (not_before != claims->end() &&
+      (!not_before->is_number() || double(now) < not_before->get<double>())) {
+    return std::nullopt;
+  }
+
+  return claims;
+}
+
+}  // namespace nginx
+}  // namespace datadog
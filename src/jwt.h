@@ -0,0 +1,31 @@
+#pragma once
+
+// This component provides a function, `verify_jwt`, that checks a JSON Web
+// Token (RFC 7519) signed with HMAC-SHA256 ("HS256"), and returns its claims.
+// It is used by the `datadog_trace_context_jwt` directive.
+//
+// Signatures are computed with the OpenSSL library that nginx is linked
+// against. If nginx was built without OpenSSL, then no token is valid.
+
+#include <ctime>
+#include <datadog/json.hpp>
+#include <optional>
+#include <string_view>
+
+namespace datadog {
+namespace nginx {
+
+// Return whether `verify_jwt` is able to verify any token, i.e. whether nginx
+// was built with OpenSSL.
+bool jwt_verification_supported();
+
+// Return the claims of the specified JSON Web `token` if it is signed with
+// HMAC-SHA256 using the specified `key`, and is neither expired nor not yet
+// valid at the specified time `now`. Otherwise, return `std::nullopt`. Tokens
+// that use any other algorithm, including "none", are rejected.
+std::optional<nlohmann::json> verify_jwt(std::string_view token,
+                                         std::string_view key,
+                                         std::time_t now);
+
+}  // namespace nginx
+}  // namespace datadog
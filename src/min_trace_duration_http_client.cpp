@@ -0,0 +1,129 @@
+#include "min_trace_duration_http_client.h"
+
+#include <datadog/dict_writer.h>
+
+#include <algorithm>
+#include <cstddef>
+#include <datadog/json.hpp>
+#include <string>
+#include <utility>
+
+#include "string_util.h"
+
+namespace datadog {
+namespace nginx {
+
+const std::string_view below_min_trace_duration_metric =
+    "_dd.nginx.below_min_duration";
+
+namespace {
+
+// Trace submissions are sent to a path ending with "/traces", e.g.
+// "/v0.4/traces", or "/v0.3/traces" if the `datadog_trace_api_version`
+// directive is used.
+constexpr std::string_view traces_path_suffix = "/traces";
+
+// Return whether the specified trace `chunk` (an array of spans) is to be
+// removed from its trace submission: whether one of its spans is marked with
+// `below_min_trace_duration_metric` and the trace was not kept by AppSec or by
+// a sampling rule.
+bool is_withheld(const nlohmann::json& chunk) {
+  bool marked = false;
+  double priority = 0;
+  for (const auto& span : chunk) {
+    const auto metrics = span.value("metrics", nlohmann::json::object());
+    if (metrics.contains(std::string{below_min_trace_duration_metric})) {
+      marked = true;
+    }
+    const auto found = metrics.find("_sampling_priority_v1");
+    if (found != metrics.end() && found->is_number()) {
+      priority = std::max(priority, found->get<double>());
+    }
+  }
+  return marked && priority < 2;  // USER-KEEP
+}
+
+// `TraceCountHeaders` passes through to another `dd::DictWriter` the headers
+// that the tracer sets on trace submissions, except that the
+// "X-Datadog-Trace-Count" header is given the number of traces that remain.
+class TraceCountHeaders : public dd::DictWriter {
+  dd::DictWriter& headers_;
+  std::string count_;
+
+ public:
+  TraceCountHeaders(dd::DictWriter& headers, std::size_t count)
+      : headers_(headers), count_(std::to_string(count)) {}
+
+  void set(std::string_view key, std::string_view value) override {
+    std::string name{key};
+    std::transform(name.begin(), name.end(), name.begin(), to_lower);
+    if (name == "x-datadog-trace-count") {
+      value = count_;
+    }
+    headers_.set(key, value);
+  }
+};
+
+}  // namespace
+
+MinTraceDurationHTTPClient::MinTraceDurationHTTPClient(
+    std::shared_ptr<dd::HTTPClient> delegate)
+    : delegate_(std::move(delegate)) {}
+
+dd::Expected<void> MinTraceDurationHTTPClient::post(
+    const URL& url, HeadersSetter set_headers, std::string body,
+    ResponseHandler on_response, ErrorHandler on_error,
+    std::chrono::steady_clock::time_point deadline) {
+  // Requests other than trace submissions, e.g. remote configuration, are
+  // passed through unmodified, as are submissions without marked spans, which
+  // are the usual case and need not be decoded.
+  if (!ends_with(url.path, traces_path_suffix) ||
+      body.find(below_min_trace_duration_metric) == std::string::npos) {
+    return delegate_->post(url, std::move(set_headers), std::move(body),
+                           std::move(on_response), std::move(on_error),
+                           deadline);
+  }
+
+  auto chunks = nlohmann::json::from_msgpack(body, /*strict=*/true,
+                                             /*allow_exceptions=*/false);
+  if (chunks.is_discarded() || !chunks.is_array()) {
+    return delegate_->post(url, std::move(set_headers), std::move(body),
+                           std::move(on_response), std::move(on_error),
+                           deadline);
+  }
+
+  auto remaining = nlohmann::json::array();
+  for (auto& chunk : chunks) {
+    if (!is_withheld(chunk)) {
+      remaining.push_back(std::move(chunk));
+    }
+  }
+
+  // The submission is sent even if no traces remain, so that the tracer still
+  // receives the agent's sample rates.
+  const std::size_t count = remaining.size();
+  const auto encoded = nlohmann::json::to_msgpack(remaining);
+  auto counted_headers = [set_headers = std::move(set_headers),
+                          count](dd::DictWriter& headers) {
+    TraceCountHeaders writer{headers, count};
+    set_headers(writer);
+  };
+
+  return delegate_->post(url, std::move(counted_headers),
+                         std::string(encoded.begin(), encoded.end()),
+                         std::move(on_response), std::move(on_error),
+                         deadline);
+}
+
+void MinTraceDurationHTTPClient::drain(
+    std::chrono::steady_clock::time_point deadline) {
+  delegate_->drain(deadline);
+}
+
+nlohmann::json MinTraceDurationHTTPClient::config_json() const {
+  return nlohmann::json::object({{"type", "MinTraceDurationHTTPClient"},
+                                 {"delegate", delegate_->config_json()}});
+}
+
+}  // namespace nginx
+}  // namespace datadog
@@ -0,0 +1,47 @@
+#pragma once
+
+// This component provides a `class`, `MinTraceDurationHTTPClient`, that
+// decorates another `dd::HTTPClient`. The `datadog_min_trace_duration`
+// directive marks the request spans of requests that completed too quickly
+// with the metric named by `below_min_trace_duration_metric`. The tracer has
+// no way to withhold a trace once its spans exist, so
+// `MinTraceDurationHTTPClient` removes marked traces from trace submissions
+// instead, unless the trace was kept by AppSec or by a sampling rule (that is,
+// its sampling priority is 2 or more).
+
+#include <datadog/http_client.h>
+
+#include <chrono>
+#include <memory>
+#include <string_view>
+
+#include "dd.h"
+
+namespace datadog {
+namespace nginx {
+
+// This is the name of the metric that marks a request span whose trace is not
+// to be sent, because of `datadog_min_trace_duration`.
+extern const std::string_view below_min_trace_duration_metric;
+
+class MinTraceDurationHTTPClient : public dd::HTTPClient {
+  std::shared_ptr<dd::HTTPClient> delegate_;
+
+ public:
+  // Send requests using the specified `delegate`, removing marked traces from
+  // trace submissions.
+  explicit MinTraceDurationHTTPClient(std::shared_ptr<dd::HTTPClient> delegate);
+
+  dd::Expected<void> post(const URL& url, HeadersSetter set_headers,
+                          std::string body, ResponseHandler on_response,
+                          ErrorHandler on_error,
+                          std::chrono::steady_clock::time_point deadline)
+      override;
+
+  void drain(std::chrono::steady_clock::time_point deadline) override;
+
+  nlohmann::json config_json() const override;
+};
+
+}  // namespace nginx
+}  // namespace datadog
@@ -1,7 +1,10 @@
 #include "ngx_event_scheduler.h"
 
+#include <algorithm>
 #include <chrono>
 #include <datadog/json.hpp>
+#include <optional>
+#include <random>
 
 namespace datadog {
 namespace nginx {
@@ -15,7 +18,7 @@ ngx_msec_t to_milliseconds(std::chrono::steady_clock::duration interval) {
 extern "C" void handle_event(ngx_event_t *ev) {
   auto *event = static_cast<NgxEventScheduler::Event *>(ev->data);
   // Schedule the next round.
-  ngx_add_timer(ev, to_milliseconds(event->interval));
+  ngx_add_timer(ev, event->scheduler->next_delay(*event));
 
   event->callback();
 }
@@ -23,20 +26,46 @@ extern "C" void handle_event(ngx_event_t *ev) {
 }  // namespace
 
 NgxEventScheduler::Event::Event(std::function<void()> callback,
-                                std::chrono::steady_clock::duration interval)
-    : interval(interval), callback(callback), event() {
+                                std::chrono::steady_clock::duration interval,
+                                NgxEventScheduler *scheduler)
+    : interval(interval), callback(callback), event(), scheduler(scheduler) {
   event.data = this;
   event.log = ngx_cycle->log;
   event.handler = &handle_event;
   event.cancelable = true;  // otherwise a pending event will prevent shutdown
 }
 
+NgxEventScheduler::NgxEventScheduler(std::chrono::milliseconds jitter)
+    : jitter_(jitter), random_(std::random_device{}()) {}
+
+void NgxEventScheduler::jitter_interval(
+    std::chrono::steady_clock::duration interval) {
+  jittered_interval_ = interval;
+}
+
+ngx_msec_t NgxEventScheduler::next_delay(const Event &event) {
+  const ngx_msec_t interval = to_milliseconds(event.interval);
+  if (!jittered_interval_ || event.interval != *jittered_interval_) {
+    return interval;
+  }
+  // The jitter is at most the interval, so that the delay is never negative.
+  const ngx_msec_t jitter = std::min(ngx_msec_t(jitter_.count()), interval);
+  if (jitter == 0) {
+    return interval;
+  }
+  std::uniform_int_distribution<ngx_msec_t> delay{interval - jitter,
+                                                  interval + jitter};
+  // A delay of zero would run the event again in the same event loop
+  // iteration.
+  return std::max(delay(random_), ngx_msec_t(1));
+}
+
 dd::EventScheduler::Cancel NgxEventScheduler::schedule_recurring_event(
     std::chrono::steady_clock::duration interval,
     std::function<void()> callback) {
-  auto event = std::make_unique<Event>(std::move(callback), interval);
+  auto event = std::make_unique<Event>(std::move(callback), interval, this);
   events_.insert(event.get());
-  ngx_add_timer(&event->event, to_milliseconds(event->interval));
+  ngx_add_timer(&event->event, next_delay(*event));
 
   // Return a cancellation function.
   return [this, event = event.release()]() {
@@ -55,7 +84,8 @@ NgxEventScheduler::~NgxEventScheduler() {
 
 nlohmann::json NgxEventScheduler::config_json() const {
   return nlohmann::json::object(
-      {{"type", "datadog::nginx::NgxEventScheduler"}});
+      {{"type", "datadog::nginx::NgxEventScheduler"},
+       {"jitter_milliseconds", jitter_.count()}});
 }
 
 }  // namespace nginx
@@ -2,7 +2,10 @@
 
 #include <datadog/event_scheduler.h>
 
+#include <chrono>
 #include <memory>
+#include <optional>
+#include <random>
 #include <unordered_set>
 
 #include "dd.h"
@@ -21,9 +24,13 @@ class NgxEventScheduler : public dd::EventScheduler {
     std::chrono::steady_clock::duration interval;
     std::function<void()> callback;
     ngx_event_t event;
+    // `scheduler` is the `NgxEventScheduler` that owns this event, and that
+    // determines the delay before each of its rounds.
+    NgxEventScheduler* scheduler;
 
     Event(std::function<void()> callback,
-          std::chrono::steady_clock::duration interval);
+          std::chrono::steady_clock::duration interval,
+          NgxEventScheduler* scheduler);
 
     Event(const Event&) = delete;
     Event& operator=(const Event&) = delete;
@@ -31,8 +38,33 @@ class NgxEventScheduler : public dd::EventScheduler {
 
  private:
   std::unordered_set<Event*> events_;
+  // `jitter_` is the most by which the delay before a round of a jittered
+  // event may differ from the event's interval.
+  std::chrono::milliseconds jitter_;
+  // `jittered_interval_` is the interval of the events that are jittered. If
+  // it is null, then no event is jittered.
+  std::optional<std::chrono::steady_clock::duration> jittered_interval_;
+  std::minstd_rand random_;
 
  public:
+  // Create a scheduler whose events recur at their intervals. Events whose
+  // interval is the one later given to `jitter_interval` recur instead at
+  // their interval plus or minus a random amount up to the specified `jitter`,
+  // chosen anew for each round.
+  explicit NgxEventScheduler(
+      std::chrono::milliseconds jitter = std::chrono::milliseconds::zero());
+
+  // Apply jitter to events that are subsequently scheduled with the specified
+  // `interval`. This is meant for the flushing of traces to the Datadog Agent,
+  // so that the flushes of different worker processes do not recur in
+  // lockstep. The scheduler cannot tell which event is which, so another event
+  // that happens to have the same interval is jittered too.
+  void jitter_interval(std::chrono::steady_clock::duration interval);
+
+  // Return the delay, in milliseconds, before the next round of the specified
+  // `event`.
+  ngx_msec_t next_delay(const Event& event);
+
   Cancel schedule_recurring_event(std::chrono::steady_clock::duration interval,
                                   std::function<void()> callback) override;
 
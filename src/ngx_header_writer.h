@@ -2,6 +2,12 @@
 
 #include <datadog/dict_writer.h>
 
+#include <algorithm>
+#include <cstddef>
+#include <iterator>
+#include <utility>
+#include <vector>
+
 #include "string_util.h"
 
 extern "C" {
@@ -17,51 +23,119 @@ namespace nginx {
 class NgxHeaderWriter : public datadog::tracing::DictWriter {
   ngx_http_request_t *request_;
   ngx_pool_t *pool_;
+  // `written_` contains the headers set by this object, in the order in which
+  // they appear in the request's header list, which is also the order in which
+  // they were set. Each header is identified by its position in the list.
+  std::vector<std::pair<std::size_t, ngx_table_elt_t *>> written_;
 
  public:
   explicit NgxHeaderWriter(ngx_http_request_t *request)
       : request_(request), pool_(request_->pool) {}
 
+  // Set the request header having the specified `key` to the specified
+  // `value`. Headers are forwarded upstream in the order in which they are
+  // set, even if some of them were already present in the request, e.g.
+  // because the client sent them. Some proxies consider only the first trace
+  // context header that they find, so the order of the configured propagation
+  // styles must be preserved.
   void set(std::string_view key, std::string_view value) override {
-    const auto key_size = key.size();
+    const auto [position, h] = search_header(key);
+    if (h == nullptr) {
+      push_header(key, value);
+      return;
+    }
 
-    ngx_table_elt_t *h = search_header(key);
-    if (h != nullptr) {
+    if (written_.empty() || position > written_.back().first) {
+      // Overwriting the existing header in place preserves the order.
       h->value = to_ngx_str(pool_, value);
-    } else {
-      h = static_cast<ngx_table_elt_t *>(
-          ngx_list_push(&request_->headers_in.headers));
-      if (h == nullptr) {
-        return;
-      }
-
-      // This trick tells ngx_http_header_module to reflect the header value
-      // in the actual response. Otherwise the header will be ignored and client
-      // will never see it. To date the value must be just non zero.
-      // Source:
-      // <https://web.archive.org/web/20240409072840/https://www.nginx.com/resources/wiki/start/topics/examples/headers_management/>
-      h->hash = 1;
-
-      // HTTP proxy module expects the header to has a lowercased key value
-      // Instead of allocating twice the same key, `h->key` and `h->lowcase_key`
-      // use the same data.
-      h->key.len = key_size;
-      h->key.data = (u_char *)ngx_pnalloc(pool_, sizeof(char) * key_size);
-      for (std::size_t i = 0; i < key_size; ++i) {
-        h->key.data[i] = to_lower(key[i]);
-      }
-      h->lowcase_key = h->key.data;
+      written_.emplace_back(position, h);
+      return;
+    }
 
+    // The existing header precedes headers that we've already set. Rotate the
+    // contents of those headers into the existing header's slot, so that the
+    // header being set comes last.
+    auto first = std::lower_bound(
+        written_.begin(), written_.end(), position,
+        [](const auto &entry, std::size_t pos) { return entry.first < pos; });
+    if (first->first != position) {
+      first = written_.insert(first, {position, h});
+    }
+    for (auto slot = first; std::next(slot) != written_.end(); ++slot) {
+      const ngx_table_elt_t *source = std::next(slot)->second;
+      ngx_table_elt_t *destination = slot->second;
+      destination->hash = source->hash;
+      destination->key = source->key;
+      destination->lowcase_key = source->lowcase_key;
+      destination->value = source->value;
+    }
+    ngx_table_elt_t *last = written_.back().second;
+    if (last == h) {
+      // `h` is the only slot after `first`; it keeps its key.
       h->value = to_ngx_str(pool_, value);
+      return;
     }
+    set_key(last, key);
+    last->value = to_ngx_str(pool_, value);
   }
 
  private:
-  ngx_table_elt_t *search_header(std::string_view key) {
+  void push_header(std::string_view key, std::string_view value) {
+    auto *h = static_cast<ngx_table_elt_t *>(
+        ngx_list_push(&request_->headers_in.headers));
+    if (h == nullptr) {
+      return;
+    }
+    // Fields that we don't set below, e.g. `next`, which links duplicate
+    // headers in newer versions of nginx, must not contain garbage.
+    // Otherwise modules that walk the header table, such as the proxy
+    // module, can follow a dangling link and lose neighboring headers.
+    ngx_memzero(h, sizeof(ngx_table_elt_t));
+    set_key(h, key);
+    h->value = to_ngx_str(pool_, value);
+    written_.emplace_back(count_headers() - 1, h);
+  }
+
+  void set_key(ngx_table_elt_t *h, std::string_view key) {
+    const auto key_size = key.size();
+
+    // This trick tells ngx_http_header_module to reflect the header value
+    // in the actual response. Otherwise the header will be ignored and client
+    // will never see it. To date the value must be just non zero.
+    // Source:
+    // <https://web.archive.org/web/20240409072840/https://www.nginx.com/resources/wiki/start/topics/examples/headers_management/>
+    h->hash = 1;
+
+    // HTTP proxy module expects the header to has a lowercased key value
+    // Instead of allocating twice the same key, `h->key` and `h->lowcase_key`
+    // use the same data.
+    h->key.len = key_size;
+    h->key.data = (u_char *)ngx_pnalloc(pool_, sizeof(char) * key_size);
+    for (std::size_t i = 0; i < key_size; ++i) {
+      h->key.data[i] = to_lower(key[i]);
+    }
+    h->lowcase_key = h->key.data;
+  }
+
+  std::size_t count_headers() const {
+    std::size_t count = 0;
+    for (const ngx_list_part_t *part = &request_->headers_in.headers.part;
+         part != nullptr; part = part->next) {
+      count += part->nelts;
+    }
+    return count;
+  }
+
+  // Return the position in the request's header list of the first header
+  // whose name is `key` (case-insensitive), and a pointer to that header. If
+  // there is no such header, then the returned pointer is null.
+  std::pair<std::size_t, ngx_table_elt_t *> search_header(
+      std::string_view key) {
     ngx_list_part_t *part = &request_->headers_in.headers.part;
     auto *h = static_cast<ngx_table_elt_t *>(part->elts);
+    std::size_t position = 0;
 
-    for (std::size_t i = 0;; i++) {
+    for (std::size_t i = 0;; i++, position++) {
       if (i >= part->nelts) {
         if (part->next == nullptr) {
           break;
@@ -72,15 +146,18 @@ class NgxHeaderWriter : public datadog::tracing::DictWriter {
         i = 0;
       }
 
+      // Neither `key` nor the header's key need be null-terminated, so
+      // compare exactly `key.size()` characters.
       if (key.size() != h[i].key.len ||
-          ngx_strcasecmp((u_char *)key.data(), h[i].key.data) != 0) {
+          ngx_strncasecmp((u_char *)key.data(), h[i].key.data, key.size()) !=
+              0) {
         continue;
       }
 
-      return &h[i];
+      return {position, &h[i]};
     }
 
-    return nullptr;
+    return {position, nullptr};
   }
 };
 
@@ -1,14 +1,18 @@
 #include "ngx_http_datadog_module.h"
 
+#include <algorithm>
 #include <cassert>
+#include <chrono>
 #include <cstdlib>
 #include <exception>
 #include <iterator>
 #include <memory>
 #include <new>
+#include <string>
 #include <string_view>
 #include <utility>
 
+#include "config_dump.h"
 #include "datadog_conf.h"
 #include "datadog_conf_handler.h"
 #include "datadog_directive.h"
@@ -85,6 +89,31 @@ static ngx_uint_t anywhere =
     anywhere_but_main
   | NGX_HTTP_MAIN_CONF;  // the toplevel configuration, e.g. where modules are loaded
 
+static ngx_conf_enum_t datadog_span_timings[] = {
+    { ngx_string("monotonic"), SPAN_TIMING_MONOTONIC },
+    { ngx_string("wall"), SPAN_TIMING_WALL },
+    { ngx_null_string, 0 }
+};
+
+static ngx_conf_enum_t datadog_span_starts[] = {
+    { ngx_string("headers"), SPAN_START_HEADERS },
+    { ngx_string("after-body"), SPAN_START_AFTER_BODY },
+    { ngx_null_string, 0 }
+};
+
+static ngx_conf_enum_t datadog_propagate_dropped_modes[] = {
+    { ngx_string("on"), PROPAGATE_DROPPED_ON },
+    { ngx_string("off"), PROPAGATE_DROPPED_OFF },
+    { ngx_string("flag-only"), PROPAGATE_DROPPED_FLAG_ONLY },
+    { ngx_null_string, 0 }
+};
+
+// A truncated value ends with "...", so a maximum length must leave room for
+// it.
+static ngx_conf_num_bounds_t datadog_max_length_bounds = {
+    ngx_conf_check_num_bounds, 3, -1
+};
+
 static ngx_command_t datadog_commands[] = {
     { ngx_string("opentracing"),
       anywhere | NGX_CONF_TAKE1,
@@ -107,6 +136,13 @@ static ngx_command_t datadog_commands[] = {
       0,
       nullptr},
 
+    { ngx_string("datadog_tracing"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_flag_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, enable),
+      nullptr},
+
     DEFINE_COMMAND_WITH_OLD_ALIAS(
       "datadog_trace_locations",
       "opentracing_trace_locations",
@@ -203,6 +239,20 @@ static ngx_command_t datadog_commands[] = {
       0,
       nullptr},
 
+    { ngx_string("datadog_service_name_override"),
+      anywhere | NGX_CONF_TAKE1,
+      set_datadog_service_name_override,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      0,
+      nullptr},
+
+    { ngx_string("datadog_peer_service"),
+      anywhere | NGX_CONF_TAKE1,
+      set_datadog_peer_service,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      0,
+      nullptr},
+
     { ngx_string("datadog_location_resource_name"),
       anywhere | NGX_CONF_TAKE1,
       set_datadog_location_resource_name,
@@ -210,6 +260,13 @@ static ngx_command_t datadog_commands[] = {
       0,
       nullptr},
 
+    { ngx_string("datadog_resource_normalize_pattern"),
+      anywhere | NGX_CONF_TAKE2,
+      set_datadog_resource_normalize_pattern,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      0,
+      nullptr},
+
     DEFINE_COMMAND_WITH_OLD_ALIAS(
       "datadog_trust_incoming_span",
       "opentracing_trust_incoming_span",
@@ -219,6 +276,27 @@ static ngx_command_t datadog_commands[] = {
       offsetof(datadog_loc_conf_t, trust_incoming_span),
       nullptr),
 
+    { ngx_string("datadog_sampling_priority_override"),
+      anywhere | NGX_CONF_TAKE1,
+      set_datadog_sampling_priority_override,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      0,
+      nullptr},
+
+    { ngx_string("datadog_drop_trace_if"),
+      anywhere | NGX_CONF_TAKE1,
+      set_datadog_drop_trace_if,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      0,
+      nullptr},
+
+    { ngx_string("datadog_trace_context_jwt"),
+      anywhere | NGX_CONF_TAKE23,
+      set_datadog_trace_context_jwt,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      0,
+      nullptr},
+
     DEFINE_COMMAND_WITH_OLD_ALIAS(
       "datadog_tag",
       "opentracing_tag",
@@ -228,6 +306,13 @@ static ngx_command_t datadog_commands[] = {
       0,
       nullptr),
 
+    { ngx_string("datadog_cookie_redact_allowlist"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_1MORE,
+      set_datadog_cookie_redact_allowlist,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      0,
+      nullptr},
+
     { ngx_string("datadog_load_tracer"),
        NGX_HTTP_MAIN_CONF | NGX_HTTP_SRV_CONF | NGX_CONF_TAKE2,
        plugin_loading_deprecated,
@@ -257,6 +342,13 @@ static ngx_command_t datadog_commands[] = {
       0,
       nullptr},
 
+    { ngx_string("datadog_upstream_sample_rate"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE2,
+      set_datadog_upstream_sample_rate,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      0,
+      nullptr},
+
     { ngx_string("datadog_propagation_styles"),
       NGX_HTTP_MAIN_CONF | NGX_CONF_1MORE,
       set_datadog_propagation_styles,
@@ -264,6 +356,20 @@ static ngx_command_t datadog_commands[] = {
       0,
       nullptr},
 
+    { ngx_string("datadog_tags_header_max_size"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE1,
+      ngx_conf_set_size_slot,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      offsetof(datadog_main_conf_t, tags_header_max_size),
+      nullptr},
+
+    { ngx_string("datadog_max_active_spans"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE1,
+      ngx_conf_set_num_slot,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      offsetof(datadog_main_conf_t, max_active_spans),
+      nullptr},
+
     { ngx_string("datadog_service_name"),
       NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE1,
       set_datadog_service_name,
@@ -285,6 +391,118 @@ static ngx_command_t datadog_commands[] = {
       0,
       nullptr},
 
+    { ngx_string("datadog_zipkin_endpoint"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE1,
+      set_datadog_zipkin_endpoint,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      0,
+      nullptr},
+
+    { ngx_string("datadog_agent_ca_file"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE1,
+      ngx_conf_set_str_slot,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      offsetof(datadog_main_conf_t, agent_ca_file),
+      nullptr},
+
+    { ngx_string("datadog_agent_certificate"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE1,
+      ngx_conf_set_str_slot,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      offsetof(datadog_main_conf_t, agent_certificate),
+      nullptr},
+
+    { ngx_string("datadog_agent_certificate_key"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE1,
+      ngx_conf_set_str_slot,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      offsetof(datadog_main_conf_t, agent_certificate_key),
+      nullptr},
+
+    { ngx_string("datadog_agent_verify_certificate"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE1,
+      ngx_conf_set_flag_slot,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      offsetof(datadog_main_conf_t, agent_verify_certificate),
+      nullptr},
+
+    { ngx_string("datadog_agent_header"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE2,
+      set_datadog_agent_header,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      0,
+      nullptr},
+
+    { ngx_string("datadog_version_file"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE1,
+      set_datadog_version_file,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      0,
+      nullptr},
+
+    { ngx_string("datadog_openapi_spec"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE1,
+      set_datadog_openapi_spec,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      0,
+      nullptr},
+
+    { ngx_string("datadog_trace_api_version"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE1,
+      set_datadog_trace_api_version,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      0,
+      nullptr},
+
+    { ngx_string("datadog_client_computed_top_level"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE1,
+      ngx_conf_set_flag_slot,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      offsetof(datadog_main_conf_t, client_computed_top_level),
+      nullptr},
+
+    { ngx_string("datadog_config_dump"),
+      NGX_HTTP_LOC_CONF | NGX_CONF_NOARGS,
+      set_datadog_config_dump,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      0,
+      nullptr},
+
+    { ngx_string("datadog_self_test"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_NOARGS,
+      run_datadog_self_test,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      0,
+      nullptr},
+
+    { ngx_string("datadog_log_rate_limit"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE1,
+      ngx_conf_set_sec_slot,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      offsetof(datadog_main_conf_t, log_rate_limit),
+      nullptr},
+
+    { ngx_string("datadog_span_timing"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE1,
+      ngx_conf_set_enum_slot,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      offsetof(datadog_main_conf_t, span_timing),
+      datadog_span_timings},
+
+    { ngx_string("datadog_shutdown_flush_timeout"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE1,
+      ngx_conf_set_msec_slot,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      offsetof(datadog_main_conf_t, shutdown_flush_timeout_ms),
+      nullptr},
+
+    { ngx_string("datadog_flush_jitter"),
+      NGX_HTTP_MAIN_CONF | NGX_CONF_TAKE1,
+      ngx_conf_set_msec_slot,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      offsetof(datadog_main_conf_t, flush_jitter_ms),
+      nullptr},
+
     { ngx_string("datadog_delegate_sampling"),
       NGX_HTTP_MAIN_CONF | NGX_HTTP_SRV_CONF | NGX_HTTP_LOC_CONF | NGX_CONF_TAKE1 | NGX_CONF_NOARGS,
       ngx_conf_set_flag_slot,
@@ -299,6 +517,146 @@ static ngx_command_t datadog_commands[] = {
     offsetof(datadog_loc_conf_t, allow_sampling_delegation_in_subrequests),
       nullptr},
 
+    { ngx_string("datadog_error_statuses"),
+      anywhere | NGX_CONF_1MORE,
+      set_datadog_error_statuses,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      0,
+      nullptr},
+
+    { ngx_string("datadog_error_on_404"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_flag_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, error_on_404),
+      nullptr},
+
+    { ngx_string("datadog_trace_cors_preflight"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_flag_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, trace_cors_preflight),
+      nullptr},
+
+    { ngx_string("datadog_error_on_header"),
+      anywhere | NGX_CONF_TAKE1,
+      set_datadog_error_on_header,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      0,
+      nullptr},
+
+    { ngx_string("datadog_debug_headers"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_flag_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, debug_headers),
+      nullptr},
+
+    { ngx_string("datadog_log_correlation_header"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_str_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, log_correlation_header),
+      nullptr},
+
+    { ngx_string("datadog_default_host"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_str_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, default_host),
+      nullptr},
+
+    { ngx_string("datadog_trace_context_header"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_flag_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, trace_context_header),
+      nullptr},
+
+    { ngx_string("datadog_server_timing"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_flag_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, server_timing),
+      nullptr},
+
+    { ngx_string("datadog_emit_version_header"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_str_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, version_header),
+      nullptr},
+
+    { ngx_string("datadog_propagate_dropped"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_enum_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, propagate_dropped),
+      datadog_propagate_dropped_modes},
+
+    { ngx_string("datadog_span_start"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_enum_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, span_start),
+      datadog_span_starts},
+
+    { ngx_string("datadog_url_include_query"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_flag_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, url_include_query),
+      nullptr},
+
+    { ngx_string("datadog_timing_tags"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_flag_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, timing_tags),
+      nullptr},
+
+    { ngx_string("datadog_client_ip_tag"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_flag_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, client_ip_tag),
+      nullptr},
+
+    { ngx_string("datadog_user_agent_tags"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_flag_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, user_agent_tags),
+      nullptr},
+
+    { ngx_string("datadog_graphql_resource_name"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_flag_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, graphql_resource_name),
+      nullptr},
+
+    { ngx_string("datadog_min_trace_duration"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_msec_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, min_trace_duration),
+      nullptr},
+
+    { ngx_string("datadog_resource_max_length"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_num_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, resource_max_length),
+      &datadog_max_length_bounds},
+
+    { ngx_string("datadog_url_max_length"),
+      anywhere | NGX_CONF_TAKE1,
+      ngx_conf_set_num_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, url_max_length),
+      &datadog_max_length_bounds},
+
     // based on ngx_http_auth_request_module.c
     { ngx_string("auth_request"),
       NGX_HTTP_MAIN_CONF|NGX_HTTP_SRV_CONF|NGX_HTTP_LOC_CONF|NGX_CONF_TAKE1,
@@ -317,6 +675,33 @@ static ngx_command_t datadog_commands[] = {
       NULL
     },
 
+    {
+      ngx_string("datadog_appsec_route"),
+      NGX_HTTP_MAIN_CONF|NGX_HTTP_SRV_CONF|NGX_HTTP_LOC_CONF|NGX_CONF_TAKE1,
+      set_datadog_appsec_route,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      0,
+      nullptr,
+    },
+
+    {
+      ngx_string("datadog_appsec_blocking"),
+      NGX_HTTP_MAIN_CONF|NGX_HTTP_SRV_CONF|NGX_HTTP_LOC_CONF|NGX_CONF_TAKE1,
+      ngx_conf_set_flag_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, appsec_blocking),
+      nullptr,
+    },
+
+    {
+      ngx_string("datadog_appsec_ruleset"),
+      NGX_HTTP_MAIN_CONF|NGX_HTTP_SRV_CONF|NGX_HTTP_LOC_CONF|NGX_CONF_TAKE1,
+      ngx_conf_set_str_slot,
+      NGX_HTTP_LOC_CONF_OFFSET,
+      offsetof(datadog_loc_conf_t, appsec_ruleset),
+      nullptr,
+    },
+
     {
       ngx_string("datadog_appsec_enabled"),
       NGX_HTTP_MAIN_CONF|NGX_CONF_TAKE1,
@@ -335,6 +720,15 @@ static ngx_command_t datadog_commands[] = {
       nullptr,
     },
 
+    {
+      ngx_string("datadog_appsec_named_ruleset_file"),
+      NGX_HTTP_MAIN_CONF|NGX_CONF_TAKE2,
+      set_datadog_appsec_named_ruleset_file,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      0,
+      nullptr,
+    },
+
     {
       ngx_string("datadog_appsec_http_blocked_template_json"),
       NGX_HTTP_MAIN_CONF|NGX_CONF_TAKE1,
@@ -371,6 +765,24 @@ static ngx_command_t datadog_commands[] = {
       nullptr,
     },
 
+    {
+      ngx_string("datadog_appsec_waf_overload_threshold"),
+      NGX_HTTP_MAIN_CONF|NGX_CONF_TAKE1,
+      ngx_conf_set_num_slot,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      offsetof(datadog_main_conf_t, appsec_waf_overload_threshold_us),
+      nullptr,
+    },
+
+    {
+      ngx_string("datadog_appsec_waf_overload_cooldown"),
+      NGX_HTTP_MAIN_CONF|NGX_CONF_TAKE1,
+      ngx_conf_set_msec_slot,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      offsetof(datadog_main_conf_t, appsec_waf_overload_cooldown_ms),
+      nullptr,
+    },
+
     {
       ngx_string("datadog_appsec_obfuscation_key_regex"),
       NGX_HTTP_MAIN_CONF|NGX_CONF_TAKE1,
@@ -388,6 +800,24 @@ static ngx_command_t datadog_commands[] = {
       offsetof(datadog_main_conf_t, appsec_obfuscation_value_regex),
       nullptr,
     },
+
+    {
+      ngx_string("datadog_appsec_body_redact_keys"),
+      NGX_HTTP_MAIN_CONF|NGX_CONF_1MORE,
+      set_datadog_appsec_body_redact_keys,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      0,
+      nullptr,
+    },
+
+    {
+      ngx_string("datadog_appsec_api_security_sample_rate"),
+      NGX_HTTP_MAIN_CONF|NGX_CONF_TAKE1,
+      ngx_conf_set_str_slot,
+      NGX_HTTP_MAIN_CONF_OFFSET,
+      offsetof(datadog_main_conf_t, appsec_api_security_sample_rate),
+      nullptr,
+    },
 #endif
 
     ngx_null_command
@@ -484,6 +914,12 @@ static ngx_int_t datadog_master_process_post_config(
     return NGX_OK;
   }
 
+  ngx_http_next_output_header_filter = ngx_http_top_header_filter;
+  ngx_http_top_header_filter = output_header_filter;
+
+  ngx_http_next_request_body_filter = ngx_http_top_request_body_filter;
+  ngx_http_top_request_body_filter = request_body_filter;
+
 #ifdef WITH_WAF
   ngx_http_next_output_body_filter = ngx_http_top_body_filter;
   ngx_http_top_body_filter = output_body_filter;
@@ -510,6 +946,46 @@ static ngx_int_t datadog_master_process_post_config(
   return NGX_OK;
 }
 
+// Traces are sent either to the Datadog Agent or to a Zipkin collector, but
+// not both. If the specified `main_conf` has a `datadog_zipkin_endpoint` and
+// also configures the connection to the Datadog Agent, then log an error and
+// return `NGX_ERROR`. Otherwise, return `NGX_OK`.
+static ngx_int_t check_zipkin_endpoint(ngx_conf_t *cf,
+                                       const datadog_main_conf_t &main_conf) {
+  if (!main_conf.zipkin_endpoint) {
+    return NGX_OK;
+  }
+
+  const char *conflict = nullptr;
+  if (main_conf.agent_url) {
+    conflict = "datadog_agent_url";
+  } else if (!main_conf.agent_headers.empty()) {
+    conflict = "datadog_agent_header";
+  } else if (main_conf.trace_api_version.len != 0) {
+    conflict = "datadog_trace_api_version";
+  } else if (main_conf.agent_ca_file.len != 0) {
+    conflict = "datadog_agent_ca_file";
+  } else if (main_conf.agent_certificate.len != 0) {
+    conflict = "datadog_agent_certificate";
+  } else if (main_conf.agent_certificate_key.len != 0) {
+    conflict = "datadog_agent_certificate_key";
+  } else if (main_conf.agent_verify_certificate != NGX_CONF_UNSET) {
+    conflict = "datadog_agent_verify_certificate";
+  }
+  if (conflict == nullptr) {
+    return NGX_OK;
+  }
+
+  const auto &location = main_conf.zipkin_endpoint->location;
+  ngx_log_error(NGX_LOG_ERR, cf->log, 0,
+                "The %V directive at %V:%d sends traces to a Zipkin collector "
+                "instead of the Datadog Agent, and cannot be combined with the "
+                "%s directive.",
+                &location.directive_name, &location.file_name, location.line,
+                conflict);
+  return NGX_ERROR;
+}
+
 static ngx_int_t datadog_module_init(ngx_conf_t *cf) noexcept {
   auto core_main_config = static_cast<ngx_http_core_main_conf_t *>(
       ngx_http_conf_get_module_main_conf(cf, ngx_http_core_module));
@@ -521,6 +997,10 @@ static ngx_int_t datadog_module_init(ngx_conf_t *cf) noexcept {
     return NGX_OK;
   }
 
+  if (check_zipkin_endpoint(cf, *main_conf) != NGX_OK) {
+    return NGX_ERROR;
+  }
+
   // Add handlers to create tracing data.
   auto handler = static_cast<ngx_http_handler_pt *>(ngx_array_push(
       &core_main_config->phases[NGX_HTTP_REWRITE_PHASE].handlers));
@@ -534,6 +1014,11 @@ static ngx_int_t datadog_module_init(ngx_conf_t *cf) noexcept {
   *handler = on_access;
 #endif
 
+  handler = static_cast<ngx_http_handler_pt *>(ngx_array_push(
+      &core_main_config->phases[NGX_HTTP_PRECONTENT_PHASE].handlers));
+  if (handler == nullptr) return NGX_ERROR;
+  *handler = on_precontent;
+
   handler = static_cast<ngx_http_handler_pt *>(
       ngx_array_push(&core_main_config->phases[NGX_HTTP_LOG_PHASE].handlers));
   if (handler == nullptr) return NGX_ERROR;
@@ -555,6 +1040,23 @@ static ngx_int_t datadog_module_init(ngx_conf_t *cf) noexcept {
   return NGX_OK;
 }
 
+// `worker_logger` is the logger of this worker process's tracers. Messages that
+// the tracers log from other threads are written to nginx's error log by
+// `pending_log_event`, which recurs every `pending_log_interval_ms`, so that
+// they are never written while nginx is reopening its log files. The same
+// event logs the summaries of rate limited messages.
+static std::shared_ptr<NgxLogger> worker_logger;
+static ngx_event_t pending_log_event;
+static constexpr ngx_msec_t pending_log_interval_ms = 1000;
+
+static void write_pending_log(ngx_event_t *event) noexcept {
+  if (worker_logger) {
+    worker_logger->write_pending();
+    worker_logger->write_summaries();
+  }
+  ngx_add_timer(event, pending_log_interval_ms);
+}
+
 static ngx_int_t datadog_init_worker(ngx_cycle_t *cycle) noexcept try {
   auto main_conf = static_cast<datadog_main_conf_t *>(
       ngx_http_cycle_get_module_main_conf(cycle, ngx_http_datadog_module));
@@ -562,7 +1064,12 @@ static ngx_int_t datadog_init_worker(ngx_cycle_t *cycle) noexcept try {
     return NGX_OK;
   }
 
-  std::shared_ptr<dd::Logger> logger = std::make_shared<NgxLogger>();
+  std::chrono::seconds log_rate_limit{0};
+  if (main_conf->log_rate_limit != NGX_CONF_UNSET) {
+    log_rate_limit = std::chrono::seconds(main_conf->log_rate_limit);
+  }
+  worker_logger = std::make_shared<NgxLogger>(log_rate_limit);
+  std::shared_ptr<dd::Logger> logger = worker_logger;
 #ifdef WITH_WAF
   try {
     security::Library::initialize_security_library(*main_conf);
@@ -581,7 +1088,26 @@ static ngx_int_t datadog_init_worker(ngx_cycle_t *cycle) noexcept try {
     return NGX_ERROR;
   }
 
+  // Requests matching a `datadog_drop_trace_if` condition use a second tracer
+  // that is configured identically, except that it never sends traces.
+  auto maybe_unreported_tracer = TracingLibrary::make_tracer(
+      *main_conf, logger, /*report_traces=*/false);
+  if (auto *error = maybe_unreported_tracer.if_error()) {
+    ngx_log_error(NGX_LOG_ERR, cycle->log, 0,
+                  "Failed to construct non-reporting tracer: [error code %d] %s",
+                  int(error->code), error->message.c_str());
+    return NGX_ERROR;
+  }
+
   reset_global_tracer(std::move(*maybe_tracer));
+  reset_global_unreported_tracer(std::move(*maybe_unreported_tracer));
+
+  ngx_memzero(&pending_log_event, sizeof(pending_log_event));
+  pending_log_event.handler = write_pending_log;
+  pending_log_event.log = cycle->log;
+  // otherwise a pending event will prevent shutdown
+  pending_log_event.cancelable = 1;
+  ngx_add_timer(&pending_log_event, pending_log_interval_ms);
   return NGX_OK;
 } catch (const std::exception &e) {
   ngx_log_error(NGX_LOG_ERR, cycle->log, 0, "failed to initialize tracer: %s",
@@ -591,8 +1117,23 @@ static ngx_int_t datadog_init_worker(ngx_cycle_t *cycle) noexcept try {
 
 static void datadog_exit_worker(ngx_cycle_t *cycle) noexcept {
   // If the `dd::Tracer` singleton has been set (in `datadog_init_worker`),
-  // destroy it.
+  // destroy it. Destroying the tracer sends any traces that have not yet been
+  // flushed to the agent, and then waits at most the configured
+  // `datadog_shutdown_flush_timeout` for the request to complete, so that
+  // reloading or stopping nginx does not lose the most recent traces.
+  if (global_tracer()) {
+    ngx_log_debug0(NGX_LOG_DEBUG_HTTP, cycle->log, 0,
+                   "flushing pending Datadog traces before worker exit");
+  }
   reset_global_tracer();
+  reset_global_unreported_tracer();
+
+  // The tracers' threads have finished, so anything that they logged while
+  // flushing is written now, when the logger is destroyed.
+  if (pending_log_event.timer_set) {
+    ngx_del_timer(&pending_log_event);
+  }
+  worker_logger.reset();
 }
 
 // `register_destructor` allows us to have C++-allocated objects in the
@@ -622,7 +1163,63 @@ static int register_destructor(ngx_pool_t *pool, Config *config) {
 //------------------------------------------------------------------------------
 // create_datadog_main_conf
 //------------------------------------------------------------------------------
+// The tracer refuses to start if the `DD_TAGS` environment variable contains
+// an entry that is not of the form "key:value".  Rather than have a typo in
+// the environment prevent tracing altogether, remove any such entries from
+// `DD_TAGS`, logging a warning for each, so that the remaining tags still
+// apply to every span.  This modifies the environment of the current process,
+// which is then inherited by the worker processes.
+static void sanitize_dd_tags_environment_variable(ngx_log_t *log) {
+  const char *const raw = std::getenv("DD_TAGS");
+  if (raw == nullptr) {
+    return;
+  }
+
+  const std::string_view tags = raw;
+  std::string sanitized;
+  bool modified = false;
+  std::size_t begin = 0;
+  while (begin <= tags.size()) {
+    std::size_t end = tags.find_first_of(", ", begin);
+    if (end == std::string_view::npos) {
+      end = tags.size();
+    }
+    const std::string_view entry = tags.substr(begin, end - begin);
+    begin = end + 1;
+    if (entry.empty()) {
+      continue;
+    }
+
+    const auto colon = entry.find(':');
+    if (colon == std::string_view::npos || colon == 0) {
+      ngx_str_t entry_str = to_ngx_str(entry);
+      ngx_log_error(NGX_LOG_WARN, log, 0,
+                    "Ignoring malformed entry \"%V\" in the DD_TAGS "
+                    "environment variable. Expected \"key:value\".",
+                    &entry_str);
+      modified = true;
+      continue;
+    }
+
+    if (!sanitized.empty()) {
+      sanitized += ',';
+    }
+    sanitized += entry;
+  }
+
+  if (!modified) {
+    return;
+  }
+  if (sanitized.empty()) {
+    ::unsetenv("DD_TAGS");
+  } else {
+    ::setenv("DD_TAGS", sanitized.c_str(), 1);
+  }
+}
+
 static void *create_datadog_main_conf(ngx_conf_t *conf) noexcept {
+  sanitize_dd_tags_environment_variable(conf->log);
+
   void *memory = ngx_pcalloc(conf->pool, sizeof(datadog_main_conf_t));
   if (memory == nullptr) {
     return nullptr;  // error
@@ -746,8 +1343,30 @@ static char *merge_datadog_loc_conf(ngx_conf_t *cf, void *parent,
           TracingLibrary::default_resource_name_pattern())) {
     return rc;
   }
+  if (const auto rc = merge_script(cf, prev->service_name_script,
+                                   conf->service_name_script, "")) {
+    return rc;
+  }
+  if (const auto rc = merge_script(cf, prev->peer_service_script,
+                                   conf->peer_service_script, "")) {
+    return rc;
+  }
 
   ngx_conf_merge_value(conf->trust_incoming_span, prev->trust_incoming_span, 1);
+  if (const auto rc = merge_script(cf, prev->sampling_priority_override_script,
+                                   conf->sampling_priority_override_script,
+                                   "off")) {
+    return rc;
+  }
+  if (!conf->jwt_token_script.is_valid()) {
+    conf->jwt_token_script = prev->jwt_token_script;
+    conf->jwt_key = prev->jwt_key;
+    conf->jwt_claim = prev->jwt_claim;
+  }
+  if (const auto rc = merge_script(cf, prev->drop_trace_script,
+                                   conf->drop_trace_script, "off")) {
+    return rc;
+  }
 
   // Create a new array that joins `prev->tags` and `conf->tags`. Since tags
   // are set consecutively and setting a tag with the same key as a previous
@@ -801,12 +1420,76 @@ static char *merge_datadog_loc_conf(ngx_conf_t *cf, void *parent,
   conf->sampling_delegation_directive = prev->sampling_delegation_directive;
   conf->allow_sampling_delegation_in_subrequests_directive =
       prev->allow_sampling_delegation_in_subrequests_directive;
+  ngx_conf_merge_value(conf->debug_headers, prev->debug_headers, 0);
+  ngx_conf_merge_value(conf->trace_context_header, prev->trace_context_header,
+                       0);
+  ngx_conf_merge_value(conf->server_timing, prev->server_timing, 0);
+  ngx_conf_merge_uint_value(conf->propagate_dropped, prev->propagate_dropped,
+                            PROPAGATE_DROPPED_ON);
+  ngx_conf_merge_uint_value(conf->span_start, prev->span_start,
+                            SPAN_START_HEADERS);
+  ngx_conf_merge_str_value(conf->version_header, prev->version_header, "");
+  ngx_conf_merge_value(conf->url_include_query, prev->url_include_query, 1);
+  ngx_conf_merge_value(conf->resource_max_length, prev->resource_max_length,
+                       5000);
+  ngx_conf_merge_value(conf->url_max_length, prev->url_max_length, 8192);
+  ngx_conf_merge_value(conf->timing_tags, prev->timing_tags, 0);
+  ngx_conf_merge_value(conf->client_ip_tag, prev->client_ip_tag, 0);
+  ngx_conf_merge_msec_value(conf->min_trace_duration, prev->min_trace_duration,
+                            0);
+  ngx_conf_merge_value(conf->user_agent_tags, prev->user_agent_tags, 0);
+  ngx_conf_merge_value(conf->graphql_resource_name,
+                       prev->graphql_resource_name, 0);
+  ngx_conf_merge_str_value(conf->log_correlation_header,
+                           prev->log_correlation_header, "");
+  ngx_conf_merge_str_value(conf->default_host, prev->default_host, "");
+  if (!conf->resource_patterns) {
+    conf->resource_patterns = prev->resource_patterns;
+  }
+  if (!conf->error_statuses) {
+    conf->error_statuses = prev->error_statuses;
+  }
+  ngx_conf_merge_value(conf->error_on_404, prev->error_on_404, 0);
+  ngx_conf_merge_value(conf->trace_cors_preflight, prev->trace_cors_preflight,
+                       1);
+  if (conf->error_on_header.data == nullptr) {
+    conf->error_on_header = prev->error_on_header;
+  }
 
 #ifdef WITH_WAF
   if (conf->waf_pool == nullptr) {
     conf->waf_pool = prev->waf_pool;
   }
+  if (conf->appsec_route.data == nullptr) {
+    conf->appsec_route = prev->appsec_route;
+  }
+  ngx_conf_merge_value(conf->appsec_blocking, prev->appsec_blocking, 1);
+  if (conf->appsec_ruleset.data == nullptr) {
+    conf->appsec_ruleset = prev->appsec_ruleset;
+  }
+  if (conf->appsec_ruleset.len != 0) {
+    // Named rulesets are defined in the `http` block, which has been parsed
+    // in full by the time locations are merged.
+    auto *main_conf = static_cast<datadog_main_conf_t *>(
+        ngx_http_conf_get_module_main_conf(cf, ngx_http_datadog_module));
+    const auto &rulesets = main_conf->appsec_named_rulesets;
+    const bool found =
+        std::any_of(rulesets.begin(), rulesets.end(), [&](const auto &entry) {
+          return entry.first == str(conf->appsec_ruleset);
+        });
+    if (!found) {
+      ngx_conf_log_error(NGX_LOG_EMERG, cf, 0,
+                         "datadog_appsec_ruleset: no ruleset named \"%V\" "
+                         "is defined by datadog_appsec_named_ruleset_file",
+                         &conf->appsec_ruleset);
+      return static_cast<char *>(NGX_CONF_ERROR);
+    }
+  }
 #endif
 
+  if (!record_config_dump_location(cf, conf)) {
+    return static_cast<char *>(NGX_CONF_ERROR);
+  }
+
   return NGX_CONF_OK;
 }
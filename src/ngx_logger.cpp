@@ -1,6 +1,8 @@
 #include "ngx_logger.h"
 
 #include <sstream>
+#include <string>
+#include <utility>
 
 #include "string_util.h"
 
@@ -10,6 +12,111 @@ extern "C" {
 }
 
 namespace datadog::nginx {
+namespace {
+
+// At most this many distinct messages are tracked. When the table is full, its
+// pending summaries are logged, and then it is cleared.
+constexpr std::size_t kMaxTrackedMessages = 1024;
+
+// At most this many messages from other threads are kept until they can be
+// written. Further messages are counted, and then discarded.
+constexpr std::size_t kMaxPendingMessages = 1024;
+
+}  // namespace
+
+NgxLogger::NgxLogger(std::chrono::seconds rate_limit_interval)
+    : rate_limit_interval_(rate_limit_interval),
+      owner_(std::this_thread::get_id()) {}
+
+NgxLogger::~NgxLogger() { write_pending(); }
+
+void NgxLogger::emit(std::string line) {
+  if (std::this_thread::get_id() != owner_) {
+    if (pending_.size() < kMaxPendingMessages) {
+      pending_.push_back(std::move(line));
+    } else {
+      ++dropped_;
+    }
+    return;
+  }
+
+  write_pending_locked();
+  const ngx_str_t ngx_line = to_ngx_str(line);
+  ngx_log_error(NGX_LOG_ERR, ngx_cycle->log, 0, "%V", &ngx_line);
+}
+
+void NgxLogger::write_pending_locked() {
+  for (const std::string& line : pending_) {
+    const ngx_str_t ngx_line = to_ngx_str(line);
+    ngx_log_error(NGX_LOG_ERR, ngx_cycle->log, 0, "%V", &ngx_line);
+  }
+  pending_.clear();
+  if (dropped_ != 0) {
+    ngx_log_error(NGX_LOG_ERR, ngx_cycle->log, 0,
+                  "datadog: dropped %uz messages logged by other threads",
+                  dropped_);
+    dropped_ = 0;
+  }
+}
+
+void NgxLogger::write_pending() {
+  std::lock_guard<std::mutex> lock(mutex_);
+  write_pending_locked();
+}
+
+bool NgxLogger::admit(const std::string& message) {
+  if (rate_limit_interval_ == std::chrono::seconds::zero()) {
+    return true;
+  }
+
+  const auto now = std::chrono::steady_clock::now();
+  auto found = occurrences_.find(message);
+  if (found == occurrences_.end()) {
+    if (occurrences_.size() >= kMaxTrackedMessages) {
+      for (auto& [tracked, occurrences] : occurrences_) {
+        summarize(tracked, occurrences, now);
+      }
+      occurrences_.clear();
+    }
+    occurrences_.emplace(message, Occurrences{now, 0});
+    return true;
+  }
+
+  Occurrences& occurrences = found->second;
+  if (now - occurrences.last_logged < rate_limit_interval_) {
+    ++occurrences.suppressed;
+    return false;
+  }
+
+  summarize(message, occurrences, now);
+  return true;
+}
+
+void NgxLogger::summarize(const std::string& message, Occurrences& occurrences,
+                          std::chrono::steady_clock::time_point now) {
+  if (occurrences.suppressed != 0) {
+    emit("datadog: suppressed " + std::to_string(occurrences.suppressed) +
+         " repetitions of \"" + message + '"');
+  }
+  occurrences.last_logged = now;
+  occurrences.suppressed = 0;
+}
+
+void NgxLogger::write_summaries() {
+  std::lock_guard<std::mutex> lock(mutex_);
+  const auto now = std::chrono::steady_clock::now();
+  for (auto it = occurrences_.begin(); it != occurrences_.end();) {
+    auto& [message, occurrences] = *it;
+    if (now - occurrences.last_logged < rate_limit_interval_) {
+      ++it;
+    } else if (occurrences.suppressed == 0) {
+      it = occurrences_.erase(it);
+    } else {
+      summarize(message, occurrences, now);
+      ++it;
+    }
+  }
+}
 
 void NgxLogger::log_error(const LogFunc& write) {
   std::ostringstream stream;
@@ -28,17 +135,19 @@ void NgxLogger::log_startup(const LogFunc& write) {
 }
 
 void NgxLogger::log_error(const dd::Error& error) {
-  const ngx_str_t ngx_message = to_ngx_str(error.message);
-
   std::lock_guard<std::mutex> lock(mutex_);
-  ngx_log_error(NGX_LOG_ERR, ngx_cycle->log, 0, "datadog: [error code %d] %V",
-                int(error.code), &ngx_message);
+  if (!admit(std::to_string(int(error.code)) + ' ' + error.message)) {
+    return;
+  }
+  emit("datadog: [error code " + std::to_string(int(error.code)) + "] " +
+        error.message);
 }
 
 void NgxLogger::log_error(std::string_view message) {
-  const ngx_str_t ngx_message = to_ngx_str(message);
-
   std::lock_guard<std::mutex> lock(mutex_);
-  ngx_log_error(NGX_LOG_ERR, ngx_cycle->log, 0, "datadog: %V", &ngx_message);
+  if (!admit(std::string{message})) {
+    return;
+  }
+  emit("datadog: " + std::string{message});
 }
 }  // namespace datadog::nginx
@@ -3,7 +3,12 @@
 #include <datadog/error.h>
 #include <datadog/logger.h>
 
+#include <chrono>
 #include <mutex>
+#include <string>
+#include <thread>
+#include <unordered_map>
+#include <vector>
 
 #include "dd.h"
 
@@ -11,11 +16,74 @@ namespace datadog {
 namespace nginx {
 
 class NgxLogger : public dd::Logger {
+  // `Occurrences` tracks, for one distinct error message, when it was last
+  // logged and how many times it has been suppressed since.
+  struct Occurrences {
+    std::chrono::steady_clock::time_point last_logged;
+    std::size_t suppressed = 0;
+  };
+
   std::mutex mutex_;
+  // If nonzero, an error message that was logged less than
+  // `rate_limit_interval_` ago is not logged again.
+  std::chrono::seconds rate_limit_interval_;
+  std::unordered_map<std::string, Occurrences> occurrences_;
+  // `owner_` is the thread that created this logger, i.e. the worker process's
+  // main thread. Only that thread writes to nginx's error log, because nginx
+  // reopens its log files (e.g. on `nginx -s reopen`) on that thread without
+  // any locking. Messages logged by other threads, such as the tracer's HTTP
+  // client thread, are kept in `pending_` until `write_pending` is called.
+  std::thread::id owner_;
+  std::vector<std::string> pending_;
+  // `dropped_` is the number of messages discarded because `pending_` was
+  // full.
+  std::size_t dropped_ = 0;
 
   using dd::Logger::LogFunc;
 
+  // Return whether the specified `message` should be logged now. If it should,
+  // and earlier occurrences were suppressed, then first log how many. The
+  // caller must hold `mutex_`.
+  bool admit(const std::string& message);
+
+  // Log how many repetitions of `message` were suppressed, if any, as of the
+  // specified `now`, and begin a new interval for the message. The caller must
+  // hold `mutex_`.
+  void summarize(const std::string& message, Occurrences& occurrences,
+                 std::chrono::steady_clock::time_point now);
+
+  // Write the specified `line` to nginx's error log if the calling thread is
+  // `owner_`, or otherwise append it to `pending_`. The caller must hold
+  // `mutex_`.
+  void emit(std::string line);
+
+  // Write `pending_` to nginx's error log. The caller must hold `mutex_` and
+  // be running on `owner_`.
+  void write_pending_locked();
+
  public:
+  // Log errors to nginx's error log. If the specified
+  // `rate_limit_interval` is nonzero, then log each distinct error message at
+  // most once per interval, followed by a count of suppressed repetitions
+  // when the message is next logged, or by `write_summaries`.
+  explicit NgxLogger(
+      std::chrono::seconds rate_limit_interval = std::chrono::seconds::zero());
+
+  // Write any pending messages.
+  ~NgxLogger();
+
+  // Write to nginx's error log any messages that were logged by threads other
+  // than the one that created this logger. This must be called from the thread
+  // that created this logger.
+  void write_pending();
+
+  // Log a count of the suppressed repetitions of each error message that was
+  // last logged at least one rate limit interval ago, so that the count is
+  // reported even if the message is not logged again. Forget messages that
+  // have nothing to report. This must be called periodically from the thread
+  // that created this logger.
+  void write_summaries();
+
   void log_error(const LogFunc& write) override;
 
   void log_startup(const LogFunc& write) override;
@@ -0,0 +1,254 @@
+#include "ngx_stream_datadog_module.h"
+
+#include <datadog/span.h>
+#include <datadog/span_config.h>
+#include <datadog/tracer.h>
+
+#include <chrono>
+#include <exception>
+#include <new>
+#include <optional>
+#include <string>
+#include <string_view>
+
+#include "dd.h"
+#include "global_tracer.h"
+#include "ngx_http_datadog_module.h"
+#include "string_util.h"
+
+extern "C" {
+#include <ngx_config.h>
+#include <ngx_core.h>
+#include <ngx_http.h>
+#include <ngx_stream.h>
+}
+
+using namespace datadog::nginx;
+
+namespace {
+
+struct datadog_stream_srv_conf_t {
+  ngx_flag_t enable = NGX_CONF_UNSET;
+};
+
+// The span of a stream session, attached to the session as its module context
+// and destroyed along with the session's connection pool.
+struct StreamTracing {
+  std::optional<dd::Span> span;
+};
+
+}  // namespace
+
+// clang-format off
+static ngx_int_t datadog_stream_init(ngx_conf_t *cf) noexcept;
+static ngx_int_t datadog_stream_init_module(ngx_cycle_t *cycle) noexcept;
+static void *create_datadog_stream_srv_conf(ngx_conf_t *conf) noexcept;
+static char *merge_datadog_stream_srv_conf(ngx_conf_t *, void *parent, void *child) noexcept;
+
+static ngx_command_t datadog_stream_commands[] = {
+    { ngx_string("datadog_stream_tracing"),
+      NGX_STREAM_MAIN_CONF | NGX_STREAM_SRV_CONF | NGX_CONF_TAKE1,
+      ngx_conf_set_flag_slot,
+      NGX_STREAM_SRV_CONF_OFFSET,
+      offsetof(datadog_stream_srv_conf_t, enable),
+      nullptr},
+
+    ngx_null_command
+};
+
+static ngx_stream_module_t datadog_stream_module_ctx = {
+    nullptr,                         /* preconfiguration */
+    datadog_stream_init,             /* postconfiguration */
+    nullptr,                         /* create main configuration */
+    nullptr,                         /* init main configuration */
+    create_datadog_stream_srv_conf,  /* create server configuration */
+    merge_datadog_stream_srv_conf    /* merge server configuration */
+};
+
+//------------------------------------------------------------------------------
+// ngx_stream_datadog_module
+//------------------------------------------------------------------------------
+ngx_module_t ngx_stream_datadog_module = {
+    NGX_MODULE_V1,
+    &datadog_stream_module_ctx, /* module context */
+    datadog_stream_commands,    /* module directives */
+    NGX_STREAM_MODULE,          /* module type */
+    nullptr,                    /* init master */
+    datadog_stream_init_module, /* init module */
+    nullptr,                    /* init process */
+    nullptr,                    /* init thread */
+    nullptr,                    /* exit thread */
+    nullptr,                    /* exit process */
+    nullptr,                    /* exit master */
+    NGX_MODULE_V1_PADDING
+};
+// clang-format on
+
+static void cleanup_stream_tracing(void *data) noexcept {
+  delete static_cast<StreamTracing *>(data);
+}
+
+static std::string_view protocol(const ngx_connection_t *connection) {
+  return connection->type == SOCK_DGRAM ? "udp" : "tcp";
+}
+
+// Return the "address:port" on which the specified `connection` was accepted,
+// or return an empty string if it cannot be determined.
+static std::string local_address(ngx_connection_t *connection) {
+  u_char buffer[NGX_SOCKADDR_STRLEN];
+  ngx_str_t address{sizeof(buffer), buffer};
+  if (ngx_connection_local_sockaddr(connection, &address, 1) != NGX_OK) {
+    return "";
+  }
+  return to_string(address);
+}
+
+// Start a span for the specified `session`, if stream tracing is enabled for
+// the session's `server` block.  The span begins when the connection is
+// accepted, which is when handlers of the post-accept phase run.
+static ngx_int_t on_session_start(ngx_stream_session_t *session) noexcept try {
+  auto *srv_conf = static_cast<datadog_stream_srv_conf_t *>(
+      ngx_stream_get_module_srv_conf(session, ngx_stream_datadog_module));
+  if (!srv_conf->enable ||
+      ngx_stream_get_module_ctx(session, ngx_stream_datadog_module)) {
+    return NGX_DECLINED;
+  }
+
+  dd::Tracer *tracer = global_tracer();
+  if (tracer == nullptr) {
+    return NGX_DECLINED;
+  }
+
+  ngx_connection_t *connection = session->connection;
+  auto cleanup = ngx_pool_cleanup_add(connection->pool, 0);
+  if (cleanup == nullptr) {
+    ngx_log_error(NGX_LOG_ERR, connection->log, 0,
+                  "failed to allocate cleanup handler for Datadog stream span");
+    return NGX_DECLINED;
+  }
+
+  const std::string local = local_address(connection);
+  dd::SpanConfig config;
+  config.name = "nginx.stream";
+  config.resource = std::string{protocol(connection)} + " " + local;
+
+  auto *tracing = new StreamTracing;
+  tracing->span.emplace(tracer->create_span(config));
+  cleanup->data = tracing;
+  cleanup->handler = cleanup_stream_tracing;
+  ngx_stream_set_ctx(session, tracing, ngx_stream_datadog_module);
+
+  dd::Span &span = *tracing->span;
+  span.set_tag("network.protocol", protocol(connection));
+  span.set_tag("network.client.ip", str(connection->addr_text));
+  span.set_tag("network.client.port",
+               std::to_string(ngx_inet_get_port(connection->sockaddr)));
+  if (!local.empty()) {
+    span.set_tag("network.destination.address", local);
+  }
+  return NGX_DECLINED;
+} catch (const std::exception &e) {
+  ngx_log_error(NGX_LOG_ERR, session->connection->log, 0,
+                "failed to start Datadog stream span: %s", e.what());
+  return NGX_DECLINED;
+}
+
+// Finish the span of the specified `session`, if there is one, tagging it with
+// the session's status, the number of bytes transferred, and the upstream
+// address, if the session was proxied.
+static ngx_int_t on_session_end(ngx_stream_session_t *session) noexcept try {
+  auto *tracing = static_cast<StreamTracing *>(
+      ngx_stream_get_module_ctx(session, ngx_stream_datadog_module));
+  if (tracing == nullptr || !tracing->span) {
+    return NGX_OK;
+  }
+
+  dd::Span &span = *tracing->span;
+  span.set_tag("nginx.stream.status", std::to_string(session->status));
+  // Bytes received from and sent to the client.
+  span.set_tag("nginx.stream.bytes_received",
+               std::to_string(session->received));
+  span.set_tag("nginx.stream.bytes_sent",
+               std::to_string(session->connection->sent));
+  if (session->upstream != nullptr && session->upstream->peer.name != nullptr) {
+    span.set_tag("nginx.upstream.address",
+                 str(*session->upstream->peer.name));
+  }
+  if (session->status >= NGX_STREAM_INTERNAL_SERVER_ERROR) {
+    span.set_error(true);
+  }
+
+  span.set_end_time(std::chrono::steady_clock::now());
+  // Destroying the span finishes it.
+  tracing->span.reset();
+  return NGX_OK;
+} catch (const std::exception &e) {
+  ngx_log_error(NGX_LOG_ERR, session->connection->log, 0,
+                "failed to finish Datadog stream span: %s", e.what());
+  return NGX_OK;
+}
+
+static ngx_int_t datadog_stream_init(ngx_conf_t *cf) noexcept {
+  auto *core_main_conf = static_cast<ngx_stream_core_main_conf_t *>(
+      ngx_stream_conf_get_module_main_conf(cf, ngx_stream_core_module));
+
+  auto handler = static_cast<ngx_stream_handler_pt *>(ngx_array_push(
+      &core_main_conf->phases[NGX_STREAM_POST_ACCEPT_PHASE].handlers));
+  if (handler == nullptr) return NGX_ERROR;
+  *handler = on_session_start;
+
+  handler = static_cast<ngx_stream_handler_pt *>(
+      ngx_array_push(&core_main_conf->phases[NGX_STREAM_LOG_PHASE].handlers));
+  if (handler == nullptr) return NGX_ERROR;
+  *handler = on_session_end;
+
+  return NGX_OK;
+}
+
+// The tracer is configured by, and created for, the `http` block.  Warn if
+// stream tracing is enabled in the specified `cycle` but there is no `http`
+// block, since then there is no tracer and sessions are not traced.
+static ngx_int_t datadog_stream_init_module(ngx_cycle_t *cycle) noexcept {
+  if (ngx_http_cycle_get_module_main_conf(cycle, ngx_http_datadog_module)) {
+    return NGX_OK;
+  }
+
+  auto *core_main_conf = static_cast<ngx_stream_core_main_conf_t *>(
+      ngx_stream_cycle_get_module_main_conf(cycle, ngx_stream_core_module));
+  if (core_main_conf == nullptr) {
+    return NGX_OK;
+  }
+
+  auto **servers =
+      static_cast<ngx_stream_core_srv_conf_t **>(core_main_conf->servers.elts);
+  for (ngx_uint_t i = 0; i < core_main_conf->servers.nelts; ++i) {
+    auto *srv_conf = static_cast<datadog_stream_srv_conf_t *>(
+        servers[i]->ctx->srv_conf[ngx_stream_datadog_module.ctx_index]);
+    if (srv_conf->enable == 1) {
+      ngx_log_error(NGX_LOG_WARN, cycle->log, 0,
+                    "datadog_stream_tracing is on, but there is no \"http\" "
+                    "block to configure the Datadog tracer. Stream sessions "
+                    "will not be traced.");
+      break;
+    }
+  }
+  return NGX_OK;
+}
+
+static void *create_datadog_stream_srv_conf(ngx_conf_t *conf) noexcept {
+  void *memory = ngx_pcalloc(conf->pool, sizeof(datadog_stream_srv_conf_t));
+  if (memory == nullptr) {
+    return nullptr;  // error
+  }
+  return new (memory) datadog_stream_srv_conf_t{};
+}
+
+static char *merge_datadog_stream_srv_conf(ngx_conf_t *, void *parent,
+                                           void *child) noexcept {
+  auto prev = static_cast<datadog_stream_srv_conf_t *>(parent);
+  auto conf = static_cast<datadog_stream_srv_conf_t *>(child);
+
+  ngx_conf_merge_value(conf->enable, prev->enable, 0);
+
+  return NGX_CONF_OK;
+}
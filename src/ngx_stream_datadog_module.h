@@ -0,0 +1,18 @@
+#pragma once
+
+// The nginx stream module object, `ngx_module_t ngx_stream_datadog_module`, is
+// defined in this translation unit.  It creates a span for each TCP or UDP
+// session proxied by nginx's `stream` module, in `server` blocks where the
+// `datadog_stream_tracing` directive is `on`.
+//
+// The spans are created using the same tracer as the HTTP module, and so
+// tracing is configured by the `datadog_*` directives in the `http` block.
+//
+// This header file exists to `extern` declare the module.  Other files can
+// refer to the `ngx_stream_datadog_module` variable by including this header.
+
+extern "C" {
+#include <ngx_core.h>
+
+extern ngx_module_t ngx_stream_datadog_module;
+}
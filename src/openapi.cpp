@@ -0,0 +1,143 @@
+#include "openapi.h"
+
+#include <algorithm>
+#include <cctype>
+#include <datadog/json.hpp>
+#include <string>
+#include <utility>
+
+namespace datadog {
+namespace nginx {
+namespace {
+
+// These are the fields of an OpenAPI "Path Item Object" that describe
+// operations.
+constexpr std::string_view operation_methods[] = {
+    "get", "put", "post", "delete", "options", "head", "patch", "trace"};
+
+// Return the segments of the specified `path`, e.g. {"pets", "{petId}"} for
+// "/pets/{petId}".
+std::vector<std::string_view> split_path(std::string_view path) {
+  std::vector<std::string_view> segments;
+  std::size_t begin = path.empty() || path.front() != '/' ? 0 : 1;
+  while (begin <= path.size()) {
+    const std::size_t end = std::min(path.find('/', begin), path.size());
+    segments.push_back(path.substr(begin, end - begin));
+    begin = end + 1;
+  }
+  return segments;
+}
+
+bool is_param(std::string_view segment) {
+  return segment.size() > 2 && segment.front() == '{' &&
+         segment.back() == '}' &&
+         segment.find_first_of("{}", 1) == segment.size() - 1;
+}
+
+// Return whether the specified `path` is a route template whose segments are
+// each either literal, with no braces, or a parameter, e.g. "{petId}".
+bool is_supported_template(std::string_view path) {
+  if (path.empty() || path.front() != '/') {
+    return false;
+  }
+  const auto segments = split_path(path);
+  return std::all_of(segments.begin(), segments.end(),
+                     [](std::string_view segment) {
+                       return is_param(segment) ||
+                              segment.find_first_of("{}") ==
+                                  std::string_view::npos;
+                     });
+}
+
+// Return the kind of each segment of the specified route template `path`: zero
+// for a literal segment, and one for a parameter.
+std::vector<int> segment_kinds(std::string_view path) {
+  std::vector<int> kinds;
+  for (const std::string_view segment : split_path(path)) {
+    kinds.push_back(is_param(segment));
+  }
+  return kinds;
+}
+
+// Return whether the specified route `left` is more specific than the
+// specified route `right`, i.e. whether, at the first segment in which they
+// differ in kind, `left` has a literal and `right` a parameter. Routes that
+// have different numbers of segments never match the same path, and so are
+// ordered only to make the ordering total.
+bool is_more_specific(const openapi_route_t &left,
+                      const openapi_route_t &right) {
+  return segment_kinds(left.path) < segment_kinds(right.path);
+}
+
+bool matches(std::string_view route, std::string_view path) {
+  const auto route_segments = split_path(route);
+  const auto path_segments = split_path(path);
+  if (route_segments.size() != path_segments.size()) {
+    return false;
+  }
+  for (std::size_t i = 0; i < route_segments.size(); ++i) {
+    if (is_param(route_segments[i]) ? path_segments[i].empty()
+                                    : route_segments[i] != path_segments[i]) {
+      return false;
+    }
+  }
+  return true;
+}
+
+}  // namespace
+
+dd::Expected<std::vector<openapi_route_t>> parse_openapi_routes(
+    std::string_view spec) {
+  const auto document = nlohmann::json::parse(spec, nullptr,
+                                              /*allow_exceptions=*/false);
+  if (document.is_discarded()) {
+    return dd::Error{dd::Error::OTHER, "The OpenAPI spec is not valid JSON."};
+  }
+  if (!document.is_object() || !document.contains("paths") ||
+      !document["paths"].is_object()) {
+    return dd::Error{dd::Error::OTHER,
+                     "The OpenAPI spec does not have a \"paths\" object."};
+  }
+
+  std::vector<openapi_route_t> routes;
+  for (const auto &[path, item] : document["paths"].items()) {
+    if (!is_supported_template(path)) {
+      continue;
+    }
+    openapi_route_t route;
+    route.path = path;
+    if (item.is_object()) {
+      for (const std::string_view method : operation_methods) {
+        if (item.contains(std::string{method})) {
+          std::string upper{method};
+          std::transform(upper.begin(), upper.end(), upper.begin(),
+                         [](unsigned char ch) { return std::toupper(ch); });
+          route.methods.push_back(std::move(upper));
+        }
+      }
+    }
+    routes.push_back(std::move(route));
+  }
+
+  std::stable_sort(routes.begin(), routes.end(), is_more_specific);
+  return routes;
+}
+
+const openapi_route_t *match_openapi_route(
+    const std::vector<openapi_route_t> &routes, std::string_view method,
+    std::string_view path) {
+  for (const openapi_route_t &route : routes) {
+    if (!route.methods.empty() &&
+        std::find(route.methods.begin(), route.methods.end(), method) ==
+            route.methods.end()) {
+      continue;
+    }
+    if (matches(route.path, path)) {
+      return &route;
+    }
+  }
+  return nullptr;
+}
+
+}  // namespace nginx
+}  // namespace datadog
@@ -0,0 +1,38 @@
+#pragma once
+
+// This component provides functions that read the paths of an OpenAPI spec,
+// and that match request paths against them. They are used by the
+// `datadog_openapi_spec` directive to name request spans' resources after the
+// spec's path templates, e.g. "GET /pets/{petId}".
+//
+// Only specs in JSON are supported. A path template's parameters must be
+// whole path segments, as in "/pets/{petId}"; paths with other templates,
+// e.g. "/files/{name}.json", are ignored.
+
+#include <datadog/expected.h>
+
+#include <string_view>
+#include <vector>
+
+#include "datadog_conf.h"
+#include "dd.h"
+
+namespace datadog {
+namespace nginx {
+
+// Return the routes of the specified OpenAPI `spec`, which is a JSON document
+// that has a "paths" object, or return an error if `spec` is not such a
+// document. The routes are ordered by specificity: when two routes match the
+// same path, the one whose first differing segment is literal comes first,
+// e.g. "/pets/mine" before "/pets/{petId}".
+dd::Expected<std::vector<openapi_route_t>> parse_openapi_routes(
+    std::string_view spec);
+
+// Return the first of the specified `routes` that matches the specified HTTP
+// `method` and request `path`, or return `nullptr` if none matches.
+const openapi_route_t *match_openapi_route(
+    const std::vector<openapi_route_t> &routes, std::string_view method,
+    std::string_view path);
+
+}  // namespace nginx
+}  // namespace datadog
@@ -0,0 +1,128 @@
+#include "rejected_spans_http_client.h"
+
+#include <cstdint>
+#include <datadog/json.hpp>
+#include <map>
+#include <optional>
+#include <ostream>
+#include <string_view>
+#include <utility>
+
+#include "string_util.h"
+
+namespace datadog {
+namespace nginx {
+namespace {
+
+// Trace submissions are sent to a path ending with "/traces", e.g.
+// "/v0.4/traces", or "/v0.3/traces" if the `datadog_trace_api_version`
+// directive is used.
+constexpr std::string_view traces_path_suffix = "/traces";
+
+// Rejections are logged at most this often.
+constexpr auto log_interval = std::chrono::minutes(1);
+
+// Response callbacks run on the HTTP client's thread, while the counts are
+// read on the worker's main thread, so they are guarded by a mutex.
+std::mutex mutex;
+std::map<int, std::uint64_t> rejected_spans_by_status;
+std::optional<std::chrono::steady_clock::time_point> last_logged;
+// `unlogged_spans` is the number of spans rejected since the last log.
+std::uint64_t unlogged_spans = 0;
+
+// Return the number of spans in the specified trace submission `body`, which
+// is a MessagePack encoded array of trace chunks, each an array of spans.
+// Return zero if `body` cannot be decoded.
+std::uint64_t count_spans(const std::string& body) {
+  const auto chunks = nlohmann::json::from_msgpack(body, /*strict=*/true,
+                                                   /*allow_exceptions=*/false);
+  if (chunks.is_discarded() || !chunks.is_array()) {
+    return 0;
+  }
+  std::uint64_t spans = 0;
+  for (const auto& chunk : chunks) {
+    if (chunk.is_array()) {
+      spans += chunk.size();
+    }
+  }
+  return spans;
+}
+
+}  // namespace
+
+std::string rejected_spans_json() {
+  auto result = nlohmann::json::object();
+  std::lock_guard<std::mutex> lock{mutex};
+  for (const auto& [status, spans] : rejected_spans_by_status) {
+    result[std::to_string(status)] = spans;
+  }
+  return result.dump();
+}
+
+RejectedSpansHTTPClient::RejectedSpansHTTPClient(
+    std::shared_ptr<dd::HTTPClient> delegate,
+    std::shared_ptr<dd::Logger> logger)
+    : delegate_(std::move(delegate)), logger_(std::move(logger)) {}
+
+dd::Expected<void> RejectedSpansHTTPClient::post(
+    const URL& url, HeadersSetter set_headers, std::string body,
+    ResponseHandler on_response, ErrorHandler on_error,
+    std::chrono::steady_clock::time_point deadline) {
+  // Requests other than trace submissions, e.g. remote configuration, are
+  // passed through unmodified.
+  if (!ends_with(url.path, traces_path_suffix)) {
+    return delegate_->post(url, std::move(set_headers), std::move(body),
+                           std::move(on_response), std::move(on_error),
+                           deadline);
+  }
+
+  // The submission's spans are counted now, because the body is handed to the
+  // delegate, but they are recorded only if the agent rejects them.
+  auto counting_on_response = [on_response = std::move(on_response),
+                               logger = logger_, spans = count_spans(body)](
+                                  int status, const dd::DictReader& headers,
+                                  std::string response_body) {
+    if (status >= 200 && status < 300) {
+      on_response(status, headers, std::move(response_body));
+      return;
+    }
+
+    std::unique_lock<std::mutex> lock{mutex};
+    rejected_spans_by_status[status] += spans;
+    unlogged_spans += spans;
+    const auto now = std::chrono::steady_clock::now();
+    if (!last_logged || now - *last_logged >= log_interval) {
+      const std::uint64_t rejected = unlogged_spans;
+      last_logged = now;
+      unlogged_spans = 0;
+      lock.unlock();
+      logger->log_error([&](std::ostream& log) {
+        log << "The Datadog Agent rejected " << rejected
+            << " span(s). The latest rejection had status " << status << ": "
+            << response_body
+            << " Further rejections are logged at most once per minute.";
+      });
+    }
+
+    // The rejection has been counted and logged. The tracer would log every
+    // rejection, so give it an empty response instead.
+    on_response(200, headers, "{}");
+  };
+
+  return delegate_->post(url, std::move(set_headers), std::move(body),
+                         std::move(counting_on_response), std::move(on_error),
+                         deadline);
+}
+
+void RejectedSpansHTTPClient::drain(
+    std::chrono::steady_clock::time_point deadline) {
+  delegate_->drain(deadline);
+}
+
+nlohmann::json RejectedSpansHTTPClient::config_json() const {
+  return nlohmann::json::object({{"type", "RejectedSpansHTTPClient"},
+                                 {"delegate", delegate_->config_json()}});
+}
+
+}  // namespace nginx
+}  // namespace datadog
@@ -0,0 +1,53 @@
+#pragma once
+
+// This component provides a `class`, `RejectedSpansHTTPClient`, that
+// decorates another `dd::HTTPClient`. When the Datadog Agent responds to a
+// trace submission with a status other than 2xx, the spans in the submission
+// are lost. `RejectedSpansHTTPClient` counts those spans by response status,
+// and logs the agent's response, at most once per minute. Counting requires
+// decoding each trace submission.
+//
+// The counts are those of the current worker process. They are available as
+// JSON from `rejected_spans_json`, which is the value of the variable named
+// by `TracingLibrary::agent_rejected_spans_variable_name()`.
+
+#include <datadog/http_client.h>
+#include <datadog/logger.h>
+
+#include <chrono>
+#include <memory>
+#include <mutex>
+#include <string>
+
+#include "dd.h"
+
+namespace datadog {
+namespace nginx {
+
+// Return a JSON object of the number of spans that the Datadog Agent has
+// rejected in this process, keyed by response status, e.g. `{"413":2}`.
+std::string rejected_spans_json();
+
+class RejectedSpansHTTPClient : public dd::HTTPClient {
+  std::shared_ptr<dd::HTTPClient> delegate_;
+  std::shared_ptr<dd::Logger> logger_;
+
+ public:
+  // Send requests using the specified `delegate`, counting spans rejected by
+  // the agent. Log rejections to the specified `logger`.
+  RejectedSpansHTTPClient(std::shared_ptr<dd::HTTPClient> delegate,
+                          std::shared_ptr<dd::Logger> logger);
+
+  dd::Expected<void> post(const URL& url, HeadersSetter set_headers,
+                          std::string body, ResponseHandler on_response,
+                          ErrorHandler on_error,
+                          std::chrono::steady_clock::time_point deadline)
+      override;
+
+  void drain(std::chrono::steady_clock::time_point deadline) override;
+
+  nlohmann::json config_json() const override;
+};
+
+}  // namespace nginx
+}  // namespace datadog
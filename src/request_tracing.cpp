@@ -1,32 +1,55 @@
 #include "request_tracing.h"
 
+#include <datadog/dict_reader.h>
 #include <datadog/dict_writer.h>
 #include <datadog/injection_options.h>
+#include <datadog/sampling_decision.h>
+#include <datadog/sampling_mechanism.h>
 #include <datadog/span.h>
 #include <datadog/span_config.h>
 #include <datadog/trace_segment.h>
+#include <datadog/version.h>
 
+#include <algorithm>
 #include <cassert>
 #include <chrono>
+#include <cinttypes>
+#include <cstdint>
+#include <cstdio>
 #include <ctime>
+#include <datadog/json.hpp>
+#include <functional>
+#include <limits>
 #include <sstream>
 #include <stdexcept>
 #include <string>
+#include <unordered_map>
 #include <utility>
+#include <vector>
 
 #include "array_util.h"
 #include "dd.h"
 #include "global_tracer.h"
+#include "graphql.h"
+#include "jwt.h"
+#include "min_trace_duration_http_client.h"
 #include "ngx_header_reader.h"
 #include "ngx_header_writer.h"
 #include "ngx_http_datadog_module.h"
+#include "openapi.h"
 #include "string_util.h"
 #include "tracing_library.h"
+#include "user_agent.h"
+#include "version.h"
 
 namespace datadog {
 namespace nginx {
 namespace {
 
+// `datadog_graphql_resource_name` examines at most this many bytes of request
+// body. Larger requests keep their usual resource name.
+constexpr std::size_t graphql_max_body_size = 64 * 1024;
+
 bool should_delegate(ngx_http_request_t *request,
                      datadog_loc_conf_t *loc_conf) {
   if (request->parent != nullptr) {
@@ -38,24 +61,96 @@ bool should_delegate(ngx_http_request_t *request,
   return loc_conf->sampling_delegation_enabled &&
          loc_conf->allow_sampling_delegation_in_subrequests;
 }
+
+// Return whether `request` is a background subrequest that updates a cache
+// entry, as made by `proxy_cache_background_update` and similar directives.
+bool is_cache_background_update(const ngx_http_request_t *request) {
+#if (NGX_HTTP_CACHE)
+  return request->background && request->cache_updater;
+#else
+  (void)request;
+  return false;
+#endif
+}
+
+// Return whether `request` is a subrequest made by the `mirror` directive.
+bool is_mirror(const ngx_http_request_t *request) {
+  return request->background && !is_cache_background_update(request);
+}
+
+// Return a name for the kind of subrequest that `request` is, suitable as the
+// value of the "nginx.subrequest" span tag. nginx does not record which module
+// created a subrequest, so infer it from the flags that each module sets:
+// `mirror` and cache background updates create background subrequests, and
+// only the latter mark theirs as cache updaters, while `auth_request` creates
+// subrequests that discard their response body. Anything else (e.g. SSI
+// includes) is reported as "subrequest".
+std::string_view subrequest_kind(const ngx_http_request_t *request) {
+  if (is_cache_background_update(request)) {
+    return "cache_background_update";
+  }
+  if (is_mirror(request)) {
+    return "mirror";
+  }
+  if (request->header_only) {
+    return "auth_request";
+  }
+  return "subrequest";
+}
+
+// nginx initializes a subrequest's `headers_in` as a shallow copy of its
+// parent's, so that the subrequest's header list shares storage with the
+// parent's. Injecting trace context into such a list would overwrite the
+// parent's propagation headers with those of the subrequest. Give `request`
+// its own copy of the list, so that each request carries its own context.
+// Return `NGX_OK` on success, or another value otherwise.
+ngx_int_t copy_headers_in(ngx_http_request_t *request) {
+  const ngx_list_t original = request->headers_in.headers;
+  if (ngx_list_init(&request->headers_in.headers, request->pool, 20,
+                    sizeof(ngx_table_elt_t)) != NGX_OK) {
+    return NGX_ERROR;
+  }
+
+  for (const ngx_list_part_t *part = &original.part; part != nullptr;
+       part = part->next) {
+    const auto *elts = static_cast<const ngx_table_elt_t *>(part->elts);
+    for (ngx_uint_t i = 0; i < part->nelts; ++i) {
+      auto *header = static_cast<ngx_table_elt_t *>(
+          ngx_list_push(&request->headers_in.headers));
+      if (header == nullptr) {
+        return NGX_ERROR;
+      }
+      *header = elts[i];
+    }
+  }
+
+  return NGX_OK;
+}
 }  // namespace
 
+// The operation name patterns are checked to be non-empty when the
+// configuration is loaded, but a pattern's variables might still evaluate to
+// an empty string. A span must have a name, so in that case fall back to the
+// name of the location.
 static std::string get_loc_operation_name(
     ngx_http_request_t *request, const ngx_http_core_loc_conf_t *core_loc_conf,
     const datadog_loc_conf_t *loc_conf) {
-  if (loc_conf->loc_operation_name_script.is_valid())
-    return to_string(loc_conf->loc_operation_name_script.run(request));
-  else
-    return to_string(core_loc_conf->name);
+  if (loc_conf->loc_operation_name_script.is_valid()) {
+    std::string name =
+        to_string(loc_conf->loc_operation_name_script.run(request));
+    if (!name.empty()) return name;
+  }
+  return to_string(core_loc_conf->name);
 }
 
 static std::string get_request_operation_name(
     ngx_http_request_t *request, const ngx_http_core_loc_conf_t *core_loc_conf,
     const datadog_loc_conf_t *loc_conf) {
-  if (loc_conf->operation_name_script.is_valid())
-    return to_string(loc_conf->operation_name_script.run(request));
-  else
-    return to_string(core_loc_conf->name);
+  if (loc_conf->operation_name_script.is_valid()) {
+    std::string name = to_string(loc_conf->operation_name_script.run(request));
+    if (!name.empty()) return name;
+  }
+  return to_string(core_loc_conf->name);
 }
 
 static std::string get_loc_resource_name(ngx_http_request_t *request,
@@ -76,25 +171,281 @@ static std::string get_request_resource_name(
   }
 }
 
-static void add_script_tags(ngx_array_t *tags, ngx_http_request_t *request,
-                            dd::Span &span) {
+// If `datadog_service_name_override` evaluates to a service name other than
+// the tracer's, then make it the service name of the specified `span`, and tag
+// the span with the tracer's service name as `_dd.base_service`, so that the
+// Datadog backend can relate the overridden service to the base service.
+static void set_service_name_override(ngx_http_request_t *request,
+                                      const datadog_loc_conf_t *loc_conf,
+                                      const datadog_main_conf_t &main_conf,
+                                      dd::Span &span) {
+  if (!loc_conf->service_name_script.is_valid()) {
+    return;
+  }
+  std::string service = to_string(loc_conf->service_name_script.run(request));
+  if (service.empty()) {
+    return;
+  }
+  std::string base_service = TracingLibrary::default_service_name(main_conf);
+  if (service == base_service) {
+    return;
+  }
+  span.set_service_name(service);
+  span.set_tag("_dd.base_service", base_service);
+}
+
+// Return the specified `text` up to, but not including, its first "?".
+static std::string_view without_query(std::string_view text) {
+  return text.substr(0, text.find('?'));
+}
+
+// If the specified `text` is longer than the specified `max_length`, then
+// return a prefix of `text` followed by "...", whose total length is at most
+// `max_length`. Otherwise, return `text` unmodified. Note that `text` is not
+// split within a UTF-8 encoded character. The behavior is undefined unless
+// `max_length` is at least the length of "...".
+static std::string truncate(std::string_view text, std::size_t max_length) {
+  constexpr std::string_view marker = "...";
+  if (text.size() <= max_length) {
+    return std::string(text);
+  }
+  std::size_t size = max_length - marker.size();
+  while (size > 0 && (static_cast<unsigned char>(text[size]) & 0xC0) == 0x80) {
+    --size;  // `text[size]` is a UTF-8 continuation byte
+  }
+  std::string result{text.substr(0, size)};
+  result += marker;
+  return result;
+}
+
+#if (NGX_PCRE)
+// Return a copy of the specified `text` in which each match of the specified
+// `regex` is replaced by the specified `replacement`.
+static std::string replace_all(std::string_view text, ngx_regex_t *regex,
+                               std::string_view replacement) {
+  std::string result;
+  std::size_t offset = 0;
+  while (offset <= text.size()) {
+    ngx_str_t subject = to_ngx_str(text.substr(offset));
+    int captures[3];
+    if (ngx_regex_exec(regex, &subject, captures, 3) < 0) {
+      break;
+    }
+    const std::size_t begin = offset + std::size_t(captures[0]);
+    const std::size_t end = offset + std::size_t(captures[1]);
+    result.append(text.substr(offset, begin - offset));
+    result.append(replacement);
+    if (end == begin) {
+      // Step past an empty match, so that it isn't matched again.
+      if (end < text.size()) {
+        result += text[end];
+      }
+      offset = end + 1;
+    } else {
+      offset = end;
+    }
+  }
+  if (offset < text.size()) {
+    result.append(text.substr(offset));
+  }
+  return result;
+}
+#endif
+
+// Set the specified `resource_name` as the resource name of the specified
+// `span`, and adjust the span's "http.url" tag, according to the specified
+// `loc_conf`:
+//
+// - If the `datadog_url_include_query` directive is "off", then remove the
+//   query string from both.
+// - Rewrite the resource name using the patterns of any
+//   `datadog_resource_normalize_pattern` directives, in order.
+// - Truncate both to the lengths configured by the
+//   `datadog_resource_max_length` and `datadog_url_max_length` directives.
+//   If the resource name is truncated, then tag the span with
+//   "_dd.resource.truncated".
+static void set_resource_name_and_url(const datadog_loc_conf_t *loc_conf,
+                                      dd::Span &span,
+                                      std::string_view resource_name) {
+  if (!loc_conf->url_include_query) {
+    resource_name = without_query(resource_name);
+  }
+#if (NGX_PCRE)
+  std::string normalized;
+  if (loc_conf->resource_patterns) {
+    normalized = resource_name;
+    for (const resource_pattern_t &pattern : *loc_conf->resource_patterns) {
+      normalized = replace_all(normalized, pattern.regex, pattern.replacement);
+    }
+    resource_name = normalized;
+  }
+#endif
+  const auto max_resource_length = std::size_t(loc_conf->resource_max_length);
+  if (resource_name.size() > max_resource_length) {
+    span.set_resource_name(truncate(resource_name, max_resource_length));
+    span.set_tag("_dd.resource.truncated", "true");
+  } else {
+    span.set_resource_name(resource_name);
+  }
+
+  if (const auto url = span.lookup_tag("http.url")) {
+    std::string_view value = *url;
+    if (!loc_conf->url_include_query) {
+      value = without_query(value);
+    }
+    // Copy, because `set_tag` replaces the storage that `url` refers to.
+    span.set_tag("http.url",
+                 truncate(value, std::size_t(loc_conf->url_max_length)));
+  }
+}
+
+// Return the specified `header`, which is the value of a "Cookie" header, or
+// of "Set-Cookie" headers if `set_cookie` is true, with the value of each
+// cookie replaced by "<redacted>", except for cookies named in the specified
+// `allowlist`. Cookie names and "Set-Cookie" attributes are kept.
+static std::string redact_cookies(std::string_view header, bool set_cookie,
+                                  const std::vector<std::string> &allowlist) {
+  // Redact the value of the "name=value" pair at the beginning of `pair`.
+  const auto redact_pair = [&](std::string_view pair, std::string &out) {
+    const auto equals = pair.find('=');
+    if (equals == std::string_view::npos) {
+      out += pair;
+      return;
+    }
+    const auto name = trim(pair.substr(0, equals));
+    out += pair.substr(0, equals + 1);
+    if (std::find(allowlist.begin(), allowlist.end(), name) !=
+        allowlist.end()) {
+      out += pair.substr(equals + 1);
+    } else {
+      out += "<redacted>";
+    }
+  };
+
+  std::string result;
+  if (!set_cookie) {
+    // Cookie: a=1; b=2
+    for (std::size_t begin = 0; begin <= header.size();) {
+      auto end = header.find(';', begin);
+      if (end == std::string_view::npos) end = header.size();
+      redact_pair(header.substr(begin, end - begin), result);
+      if (end != header.size()) result += ';';
+      begin = end + 1;
+    }
+    return result;
+  }
+
+  // Set-Cookie: a=1; Path=/; Expires=Wed, 21 Oct 2026 07:28:00 GMT
+  //
+  // nginx joins multiple "Set-Cookie" headers with commas, which also appear
+  // within "Expires" attributes. A comma begins a new cookie only if the text
+  // that follows it, up to the next semicolon, is a "name=value" pair.
+  bool at_cookie = true;
+  for (std::size_t begin = 0; begin <= header.size();) {
+    auto end = header.find_first_of(";,", begin);
+    if (end == std::string_view::npos) end = header.size();
+    const auto part = header.substr(begin, end - begin);
+    if (at_cookie) {
+      redact_pair(part, result);
+    } else {
+      result += part;
+    }
+    if (end == header.size()) break;
+    result += header[end];
+    at_cookie = false;
+    if (header[end] == ',') {
+      auto next = header.substr(end + 1);
+      next = next.substr(0, next.find(';'));
+      const auto equals = next.find('=');
+      at_cookie = equals != std::string_view::npos &&
+                  trim(next.substr(0, equals)).find(' ') ==
+                      std::string_view::npos;
+    }
+    begin = end + 1;
+  }
+  return result;
+}
+
+// Set on the specified `span` the tags configured by `datadog_tag` directives
+// in the specified `tags`.  The values of tags named after captured "Cookie"
+// and "Set-Cookie" headers have their cookie values redacted, except for those
+// in the specified `main_conf`'s `datadog_cookie_redact_allowlist`.
+static void add_script_tags(ngx_array_t *tags,
+                            const datadog_main_conf_t &main_conf,
+                            ngx_http_request_t *request, dd::Span &span) {
   if (!tags) return;
   auto add_tag = [&](const datadog_tag_t &tag) {
     auto key = tag.key_script.run(request);
     auto value = tag.value_script.run(request);
-    if (key.data && value.data) span.set_tag(to_string(key), to_string(value));
+    if (!key.data || !value.data) return;
+    std::string name = to_string(key);
+    std::transform(name.begin(), name.end(), name.begin(), to_lower);
+    if (name == "http.request.headers.cookie") {
+      span.set_tag(to_string(key),
+                   redact_cookies(str(value), /*set_cookie=*/false,
+                                  main_conf.cookie_redact_allowlist));
+    } else if (name == "http.response.headers.set-cookie") {
+      span.set_tag(to_string(key),
+                   redact_cookies(str(value), /*set_cookie=*/true,
+                                  main_conf.cookie_redact_allowlist));
+    } else {
+      span.set_tag(to_string(key), to_string(value));
+    }
   };
   for_each<datadog_tag_t>(*tags, add_tag);
 }
 
-static void add_status_tags(const ngx_http_request_t *request, dd::Span &span) {
+// Return whether the specified response `status` indicates an error according
+// to the specified `loc_conf`. Unless configured otherwise by the
+// `datadog_error_statuses` directive, any 5xx status is an error. A 404 status
+// is also an error if `datadog_error_on_404` is "on".
+static bool is_error_status(ngx_uint_t status,
+                            const datadog_loc_conf_t *loc_conf) {
+  if (status == NGX_HTTP_NOT_FOUND && loc_conf->error_on_404) {
+    return true;
+  }
+  if (!loc_conf->error_statuses) {
+    return status >= 500;
+  }
+  const auto &ranges = *loc_conf->error_statuses;
+  return std::any_of(ranges.begin(), ranges.end(),
+                     [&](const status_range_t &range) {
+                       return status >= range.first && status <= range.last;
+                     });
+}
+
+// Return whether the response to `request` has the error header configured by
+// the `datadog_error_on_header` directive in `loc_conf`, with a non-empty
+// value.
+static bool has_error_header(const ngx_http_request_t *request,
+                             const datadog_loc_conf_t *loc_conf) {
+  const std::string_view name = str(loc_conf->error_on_header);
+  if (name.empty()) {
+    return false;
+  }
+  bool found = false;
+  for_each<ngx_table_elt_t>(
+      request->headers_out.headers, [&](const ngx_table_elt_t &header) {
+        if (header.hash != 0 && header.value.len != 0 &&
+            header.key.len == name.size() &&
+            ngx_strncasecmp(header.key.data, loc_conf->error_on_header.data,
+                            name.size()) == 0) {
+          found = true;
+        }
+      });
+  return found;
+}
+
+static void add_status_tags(const ngx_http_request_t *request,
+                            const datadog_loc_conf_t *loc_conf,
+                            dd::Span &span) {
   // Check for errors.
   auto status = request->headers_out.status;
   auto status_line = to_string(request->headers_out.status_line);
   if (status != 0) span.set_tag("http.status_code", std::to_string(status));
   if (status_line.data()) span.set_tag("http.status_line", status_line);
-  // Treat any 5xx code as an error.
-  if (status >= 500) {
+  if ((status != 0 && is_error_status(status, loc_conf)) ||
+      has_error_header(request, loc_conf)) {
     span.set_error(true);
   }
 }
@@ -109,6 +460,460 @@ static void add_upstream_name(const ngx_http_request_t *request,
   span.set_tag("upstream.name", host_str);
 }
 
+// Tag the specified `span` with "peer.service", the service that the specified
+// `request` was proxied to. If the `datadog_peer_service` directive in the
+// specified `loc_conf` evaluates to a non-empty string, then that is the peer
+// service. Otherwise, the peer service is the name of the upstream, as in the
+// "upstream.name" tag. "_dd.peer.service.source" records which of the two
+// was used.
+static void add_peer_service(ngx_http_request_t *request,
+                             const datadog_loc_conf_t *loc_conf,
+                             dd::Span &span) {
+  if (loc_conf->peer_service_script.is_valid()) {
+    std::string peer_service =
+        to_string(loc_conf->peer_service_script.run(request));
+    if (!peer_service.empty()) {
+      span.set_tag("peer.service", peer_service);
+      span.set_tag("_dd.peer.service.source", "peer.service");
+      return;
+    }
+  }
+  if (!request->upstream || !request->upstream->upstream ||
+      !request->upstream->upstream->host.data) {
+    return;
+  }
+  span.set_tag("peer.service", str(request->upstream->upstream->host));
+  span.set_tag("_dd.peer.service.source", "upstream.name");
+}
+
+// If the specified `request` was proxied to an upstream for which the
+// specified `main_conf` has a `datadog_upstream_sample_rate`, then keep or drop
+// the trace of the specified `span` according to that rate. The decision is a
+// function of the trace ID, as it is for the tracer's own sampling, and it
+// overrides the tracer's decision, unless the trace is kept by AppSec or by a
+// manual keep (e.g. a trusted "X-Datadog-Sampling-Priority: 2"). Note that
+// the upstream has already received the earlier decision in the trace context
+// headers of the proxied request, and so may have decided differently.
+static void apply_upstream_sample_rate(const ngx_http_request_t *request,
+                                       const datadog_main_conf_t &main_conf,
+                                       dd::Span &span) {
+  if (main_conf.upstream_sample_rates.empty() || !request->upstream ||
+      !request->upstream->upstream ||
+      !request->upstream->upstream->host.data) {
+    return;
+  }
+
+  if (const auto decision = span.trace_segment().sampling_decision()) {
+    const bool kept = decision->priority >= 2;  // USER-KEEP
+    const bool manual =
+        decision->mechanism == int(dd::SamplingMechanism::MANUAL) ||
+        decision->mechanism == int(dd::SamplingMechanism::APP_SEC);
+    if (kept && manual) {
+      return;
+    }
+  }
+
+  const std::string_view upstream = str(request->upstream->upstream->host);
+  for (const upstream_sample_rate_t &entry : main_conf.upstream_sample_rates) {
+    if (entry.upstream != upstream) {
+      continue;
+    }
+    // Knuth's multiplicative hash, which is also used by the tracer.
+    const std::uint64_t hashed =
+        span.trace_id().low * UINT64_C(1111111111111111111);
+    const bool keep =
+        entry.rate >= 1.0 ||
+        double(hashed) <
+            entry.rate * double(std::numeric_limits<std::uint64_t>::max());
+    span.set_tag("nginx.upstream_sample_rate", std::to_string(entry.rate));
+    span.trace_segment().override_sampling_priority(keep ? 2    // USER-KEEP
+                                                         : -1);  // USER-REJECT
+    return;
+  }
+}
+
+// If the last upstream server that nginx tried for the specified `request`
+// could not be connected to, e.g. because the upstream is down, then tag the
+// specified `span` with "nginx.upstream.error: connect".  Such a request
+// typically ends with a 502 generated by nginx itself.
+static void add_upstream_error_tags(const ngx_http_request_t *request,
+                                    dd::Span &span) {
+  if (request->upstream_states == nullptr) {
+    return;
+  }
+
+  const auto *states = static_cast<const ngx_http_upstream_state_t *>(
+      request->upstream_states->elts);
+  for (ngx_uint_t i = request->upstream_states->nelts; i != 0; --i) {
+    const ngx_http_upstream_state_t &state = states[i - 1];
+    if (state.peer == nullptr) {
+      continue;
+    }
+    // nginx sets the connect time only once the connection is established.
+    if (state.connect_time == ngx_msec_t(-1)) {
+      span.set_tag("nginx.upstream.error", "connect");
+    }
+    return;
+  }
+}
+
+// If nginx looked up the specified `request` in a cache, e.g. one configured
+// by `proxy_cache`, then tag the specified `span` with "nginx.cache.status",
+// whose value is that of nginx's `$upstream_cache_status` variable.
+static void add_cache_status_tag(const ngx_http_request_t *request,
+                                 dd::Span &span) {
+#if (NGX_HTTP_CACHE)
+  if (!request->upstream || request->upstream->cache_status == 0) {
+    return;
+  }
+  span.set_tag("nginx.cache.status",
+               to_string_view(
+                   ngx_http_cache_status[request->upstream->cache_status - 1]));
+#else
+  (void)request;
+  (void)span;
+#endif
+}
+
+// Tag the specified `span` with the number of response body bytes sent for the
+// specified `request`, and, if the request was proxied, with the upstream's
+// time to first byte and total response time. If nginx tried more than one
+// upstream server, then the time to first byte is that of the last attempt,
+// and the response time is the total over all attempts.
+static void add_timing_tags(const ngx_http_request_t *request,
+                            dd::Span &span) {
+  const off_t sent = request->connection->sent - off_t(request->header_size);
+  span.set_tag("nginx.response.bytes", std::to_string(sent > 0 ? sent : 0));
+
+  if (request->upstream_states == nullptr ||
+      request->upstream_states->nelts == 0) {
+    return;
+  }
+
+  const auto *states = static_cast<const ngx_http_upstream_state_t *>(
+      request->upstream_states->elts);
+  const auto count = request->upstream_states->nelts;
+  ngx_msec_t response_time = 0;
+  for (ngx_uint_t i = 0; i < count; ++i) {
+    // Entries without a peer separate the upstream groups of internal
+    // redirects; they have no timing.
+    const ngx_http_upstream_state_t &state = states[i];
+    if (state.peer == nullptr || state.response_time == ngx_msec_t(-1)) {
+      continue;
+    }
+    response_time += state.response_time;
+  }
+  span.set_tag("nginx.upstream.response_time_ms",
+               std::to_string(response_time));
+
+  const ngx_http_upstream_state_t &last = states[count - 1];
+  if (last.peer != nullptr && last.header_time != ngx_msec_t(-1)) {
+    span.set_tag("nginx.upstream.ttfb_ms", std::to_string(last.header_time));
+  }
+}
+
+// If the path of the specified `request` matches one of the routes of the
+// `datadog_openapi_spec` in the specified `main_conf`, then tag the specified
+// `span` with the route's path template as "http.route", and return a resource
+// name made of the request method followed by the template, e.g.
+// "GET /pets/{petId}". Return `std::nullopt` if no route matches.
+static std::optional<std::string> openapi_resource_name(
+    const ngx_http_request_t *request, const datadog_main_conf_t &main_conf,
+    dd::Span &span) {
+  if (main_conf.openapi_routes.empty()) {
+    return std::nullopt;
+  }
+  const std::string_view method = str(request->method_name);
+  const openapi_route_t *route =
+      match_openapi_route(main_conf.openapi_routes, method, str(request->uri));
+  if (route == nullptr) {
+    return std::nullopt;
+  }
+  span.set_tag("http.route", route->path);
+  return std::string{method} + ' ' + route->path;
+}
+
+// If the specified `body` is a GraphQL request for exactly one operation, then
+// tag the specified `span` with the operation's type and name, and return a
+// resource name made of them, e.g. "query GetUser". Anonymous operations are
+// named "anonymous". Return `std::nullopt` if `body` is not such a request.
+static std::optional<std::string> graphql_resource_name(std::string_view body,
+                                                        dd::Span &span) {
+  const auto operation = parse_graphql_request(body);
+  if (!operation) {
+    return std::nullopt;
+  }
+  span.set_tag("graphql.operation.type", operation->type);
+  if (!operation->name.empty()) {
+    span.set_tag("graphql.operation.name", operation->name);
+  }
+  return operation->type + ' ' +
+         (operation->name.empty() ? std::string{"anonymous"} : operation->name);
+}
+
+// Return whether the `datadog_resource_name` directive in effect for the
+// specified `loc_conf` was configured, rather than being the default.
+static bool has_configured_resource_name(const datadog_loc_conf_t *loc_conf) {
+  return str(loc_conf->resource_name_script.pattern_) !=
+         TracingLibrary::default_resource_name_pattern();
+}
+
+// Tag the specified `span` with the browser family and operating system
+// indicated by the "User-Agent" header of the specified `request`, if they are
+// recognized.
+static void add_user_agent_tags(const ngx_http_request_t *request,
+                                dd::Span &span) {
+  const ngx_table_elt_t *header = request->headers_in.user_agent;
+  if (header == nullptr) {
+    return;
+  }
+
+  const UserAgentInfo info = parse_user_agent(str(header->value));
+  if (!info.browser.empty()) {
+    span.set_tag("http.useragent.browser", info.browser);
+  }
+  if (!info.os.empty()) {
+    span.set_tag("http.useragent.os", info.os);
+  }
+}
+
+// If the connection of the specified `request` is TLS-terminated by nginx, then
+// tag the specified `span` with the negotiated protocol version, the cipher,
+// and the server name indicated by the client (if any). If the server
+// requests client certificates (`ssl_verify_client`) and the client sent one,
+// then also tag the span with the certificate's subject, issuer, and serial
+// number.
+static void add_tls_tags(ngx_http_request_t *request, dd::Span &span) {
+#if (NGX_HTTP_SSL)
+  ngx_connection_t *connection = request->connection;
+  if (connection->ssl == nullptr) {
+    return;
+  }
+
+  using Getter = ngx_int_t (*)(ngx_connection_t *, ngx_pool_t *, ngx_str_t *);
+  const auto set_tag = [&](std::string_view name, Getter get) {
+    ngx_str_t value = ngx_null_string;
+    if (get(connection, request->pool, &value) == NGX_OK && value.len != 0) {
+      span.set_tag(name, str(value));
+    }
+  };
+
+  set_tag("tls.version", ngx_ssl_get_protocol);
+  set_tag("tls.cipher", ngx_ssl_get_cipher_name);
+  set_tag("tls.sni", ngx_ssl_get_server_name);
+
+  const auto *ssl_srv_conf = static_cast<ngx_http_ssl_srv_conf_t *>(
+      ngx_http_get_module_srv_conf(request, ngx_http_ssl_module));
+  if (ssl_srv_conf == nullptr || ssl_srv_conf->verify == 0) {
+    return;
+  }
+  // Each of these is empty if the client did not send a certificate.
+  set_tag("tls.client.subject", ngx_ssl_get_subject_dn);
+  set_tag("tls.client.issuer", ngx_ssl_get_issuer_dn);
+  set_tag("tls.client.serial", ngx_ssl_get_serial_number);
+#else
+  (void)request;
+  (void)span;
+#endif
+}
+
+// Tag the specified `span` with the address of the client that sent the
+// specified `request`, as "http.client_ip".  When the connection began with a
+// PROXY protocol header, e.g. from a load balancer, the client is the source
+// address in the header (`$proxy_protocol_addr`); otherwise, the client is the
+// peer of the connection (`$remote_addr`).
+static void add_client_ip_tag(const ngx_http_request_t *request,
+                              dd::Span &span) {
+  const ngx_connection_t *connection = request->connection;
+  if (connection->proxy_protocol != nullptr &&
+      connection->proxy_protocol->src_addr.len != 0) {
+    span.set_tag("http.client_ip", str(connection->proxy_protocol->src_addr));
+  } else if (connection->addr_text.len != 0) {
+    span.set_tag("http.client_ip", str(connection->addr_text));
+  }
+}
+
+// Append to the specified `headers` (e.g. the response headers or trailers of
+// `request`) a header having the specified `key` and `value`. Return whether
+// the header was added.
+static bool push_header(ngx_http_request_t *request, ngx_list_t *headers,
+                        std::string_view key, std::string_view value) {
+  auto *header = static_cast<ngx_table_elt_t *>(ngx_list_push(headers));
+  if (header == nullptr) {
+    return false;
+  }
+  ngx_memzero(header, sizeof(ngx_table_elt_t));
+  header->hash = 1;
+  header->key = to_ngx_str(request->pool, key);
+  header->value = to_ngx_str(request->pool, value);
+  return true;
+}
+
+// If the response to `request` is to be delivered in a way that supports
+// trailers, i.e. chunked HTTP/1.1, HTTP/2, or HTTP/3, then announce and add an
+// "X-Trace-Id" trailer containing the ID of the trace to which `span` belongs.
+// If the response has a known length over HTTP/1.1, adding a trailer would
+// force nginx to switch to chunked encoding, so leave the response as is.
+static void add_trace_id_trailer(ngx_http_request_t *request,
+                                 const dd::Span &span) {
+  if (request->header_only) {
+    return;
+  }
+
+  // HTTP/2 and HTTP/3 frame the response body, and so always allow trailers.
+  bool is_framed = request->http_version == NGX_HTTP_VERSION_20;
+#ifdef NGX_HTTP_VERSION_30
+  is_framed = is_framed || request->http_version == NGX_HTTP_VERSION_30;
+#endif
+  if (!is_framed) {
+    const auto *core_loc_conf = static_cast<ngx_http_core_loc_conf_t *>(
+        ngx_http_get_module_loc_conf(request, ngx_http_core_module));
+    const bool is_chunked =
+        request->http_version == NGX_HTTP_VERSION_11 &&
+        request->headers_out.content_length_n == -1 &&
+        core_loc_conf->chunked_transfer_encoding;
+    if (!is_chunked) {
+      return;
+    }
+  }
+
+  if (!push_header(request, &request->headers_out.headers, "Trailer",
+                   "X-Trace-Id")) {
+    return;
+  }
+  if (!push_header(request, &request->headers_out.trailers, "X-Trace-Id",
+                   std::to_string(span.trace_id().low))) {
+    return;
+  }
+  request->expect_trailers = 1;
+}
+
+// Add to the response of `request` an "X-Datadog-Trace" header whose value is a
+// JSON object containing the trace ID and span ID of the specified `span`, and
+// whether the trace is sampled, e.g.
+//
+//     {"sampled":true,"span_id":"456","trace_id":"123"}
+//
+// Also add an "Access-Control-Expose-Headers" header, so that scripts served
+// from other origins are able to read "X-Datadog-Trace".
+static void add_trace_context_header(ngx_http_request_t *request,
+                                     const dd::Span &span) {
+  bool sampled = false;
+  if (auto decision = span.trace_segment().sampling_decision()) {
+    sampled = decision->priority > 0;
+  }
+  const nlohmann::json context = {
+      {"trace_id", std::to_string(span.trace_id().low)},
+      {"span_id", std::to_string(span.id())},
+      {"sampled", sampled}};
+
+  if (!push_header(request, &request->headers_out.headers, "X-Datadog-Trace",
+                   context.dump())) {
+    return;
+  }
+  push_header(request, &request->headers_out.headers,
+              "Access-Control-Expose-Headers", "X-Datadog-Trace");
+}
+
+// Add to the response of `request` a "Server-Timing" header whose "traceparent"
+// metric is the W3C trace context of the specified `span`, e.g.
+//
+//     Server-Timing: traceparent;desc="00-<trace ID>-<span ID>-01"
+//
+// The trace ID and span ID are lowercase hexadecimal, and the flags are "01" if
+// the trace is sampled and "00" otherwise. Browser monitoring reads the header
+// to connect a page load to the trace of the request that served it.
+static void add_server_timing_header(ngx_http_request_t *request,
+                                     const dd::Span &span) {
+  bool sampled = false;
+  if (auto decision = span.trace_segment().sampling_decision()) {
+    sampled = decision->priority > 0;
+  }
+  char span_id[17];
+  std::snprintf(span_id, sizeof span_id, "%016" PRIx64, span.id());
+
+  std::string value = "traceparent;desc=\"00-";
+  value += span.trace_id().hex_padded();
+  value += '-';
+  value += span_id;
+  value += sampled ? "-01\"" : "-00\"";
+  push_header(request, &request->headers_out.headers, "Server-Timing", value);
+}
+
+namespace {
+
+// `InjectedHeaders` collects the headers injected by the tracer, so that they
+// can be examined once the sampling decision is known.
+class InjectedHeaders : public dd::DictWriter {
+ public:
+  std::vector<std::pair<std::string, std::string>> headers;
+
+  void set(std::string_view key, std::string_view value) override {
+    headers.emplace_back(key, value);
+  }
+};
+
+}  // namespace
+
+// Inject the trace context of the specified `span` into the request headers
+// set by the specified `writer`, using the specified injection `options`. If
+// the trace is dropped, then the `datadog_propagate_dropped` directive of
+// `loc_conf` determines which of the headers, if any, are set.
+static void inject_trace_context(NgxHeaderWriter &writer,
+                                 const datadog_loc_conf_t *loc_conf,
+                                 dd::Span &span,
+                                 const dd::InjectionOptions &options) {
+  InjectedHeaders injected;
+  // Injection finalizes the sampling decision, so the decision is examined
+  // afterward.
+  span.inject(injected, options);
+
+  bool dropped = false;
+  if (auto decision = span.trace_segment().sampling_decision()) {
+    dropped = decision->priority <= 0;
+  }
+  if (dropped && loc_conf->propagate_dropped == PROPAGATE_DROPPED_OFF) {
+    return;
+  }
+
+  for (const auto &[key, value] : injected.headers) {
+    // These headers carry the sampling decision without any trace ID, and so
+    // don't cause the upstream to join the trace.
+    if (dropped &&
+        loc_conf->propagate_dropped == PROPAGATE_DROPPED_FLAG_ONLY &&
+        key != "x-datadog-sampling-priority" && key != "x-b3-sampled") {
+      continue;
+    }
+    writer.set(key, value);
+  }
+}
+
+// Add to the response of `request` a header having the specified `name`, whose
+// value is the versions of this module and of the tracer, e.g.
+//
+//     nginx-datadog/1.2.1 dd-trace-cpp/v0.2.1
+static void add_version_header(ngx_http_request_t *request,
+                               std::string_view name) {
+  std::string value = "nginx-datadog/";
+  value += datadog_nginx_mod_version;
+  value += " dd-trace-cpp/";
+  value += dd::tracer_version;
+  push_header(request, &request->headers_out.headers, name, value);
+}
+
+// If `loc_conf` configures a `datadog_log_correlation_header`, then use the
+// specified `writer` to set that header to the hexadecimal trace ID of the
+// specified `span`.
+static void set_log_correlation_header(NgxHeaderWriter &writer,
+                                       const datadog_loc_conf_t *loc_conf,
+                                       const dd::Span &span) {
+  if (loc_conf->log_correlation_header.len == 0) {
+    return;
+  }
+  writer.set(str(loc_conf->log_correlation_header),
+             span.trace_id().hex_padded());
+}
+
 // Convert the epoch denoted by epoch_seconds, epoch_milliseconds to an
 // std::chrono::system_clock::time_point duration from the epoch.
 static std::chrono::system_clock::time_point to_system_timestamp(
@@ -124,22 +929,53 @@ static std::chrono::system_clock::time_point to_system_timestamp(
 // `estimate_past_time_point` guesses the steady time corresponding to the
 // specified system time (`before`) by comparing `before` with the current
 // system time.
+// The estimated elapsed time is at least zero and at most the specified
+// `max_elapsed`. If it had to be clamped, then the system clock stepped since
+// `before`, and the direction of the step, "backward" or "forward", is
+// assigned to the specified `anomaly`.
 // Return a `dd::TimePoint` containing the specified system (wall) time
 // (`before`) and the calculated steady (tick) time.
 static dd::TimePoint estimate_past_time_point(
-    std::chrono::system_clock::time_point before) {
+    std::chrono::system_clock::time_point before,
+    std::chrono::steady_clock::duration max_elapsed,
+    std::optional<std::string_view> &anomaly) {
   dd::TimePoint now = dd::default_clock();
-  const auto elapsed = now.wall - before;
+  auto elapsed =
+      std::chrono::duration_cast<std::chrono::steady_clock::duration>(
+          now.wall - before);
+  if (elapsed < decltype(elapsed)::zero()) {
+    anomaly = "backward";
+    elapsed = decltype(elapsed)::zero();
+  } else if (elapsed > max_elapsed) {
+    anomaly = "forward";
+    elapsed = max_elapsed;
+  }
 
   dd::TimePoint result;
   result.wall = before;
-  result.tick = now.tick;
-  if (elapsed > decltype(elapsed)::zero()) {
-    result.tick -= elapsed;
-  }
+  result.tick = now.tick - elapsed;
   return result;
 }
 
+// Return an upper bound on the time elapsed since the specified `request`
+// began, measured with nginx's monotonic clock. A request cannot be older than
+// its connection. nginx before 1.19.10 does not record when a connection was
+// accepted, in which case there is no bound.
+static std::chrono::steady_clock::duration max_request_age(
+    const ngx_http_request_t *request) {
+#if nginx_version >= 1019010
+  const ngx_msec_t accepted = request->connection->start_time;
+  if (accepted != 0 && accepted <= ngx_current_msec) {
+    // nginx's clocks are cached, so allow for some imprecision.
+    return std::chrono::milliseconds(ngx_current_msec - accepted) +
+           std::chrono::seconds(1);
+  }
+#else
+  (void)request;
+#endif
+  return std::chrono::steady_clock::duration::max();
+}
+
 // Search through `conf` and its ancestors for the first `datadog_sample_rate`
 // directive whose condition is satisfied for the specified `request`. If there
 // is such a `datadog_sample_rate`, then on the specified `span` set the
@@ -171,14 +1007,175 @@ void set_sample_rate_tag(ngx_http_request_t *request, datadog_loc_conf_t *conf,
   } while (conf);
 }
 
+// If the `datadog_sampling_priority_override` directive in the specified
+// `loc_conf` evaluates to "on" for the specified `request`, and `request` has
+// the "x-datadog-sampling-priority: 2" (manual keep) header, then keep the
+// trace to which the specified `span` belongs, regardless of the decision that
+// the trace sampler would make.
+static void apply_sampling_priority_override(
+    ngx_http_request_t *request, const datadog_loc_conf_t *loc_conf,
+    dd::Span &span) {
+  const ngx_str_t enabled =
+      loc_conf->sampling_priority_override_script.run(request);
+  if (str(enabled) != "on") {
+    if (str(enabled) != "off") {
+      ngx_log_error(NGX_LOG_ERR, request->connection->log, 0,
+                    "Condition expression for datadog_sampling_priority_"
+                    "override directive evaluated to unexpected value "
+                    "\"%V\". Expected \"on\" or \"off\". Proceeding as if "
+                    "it were \"off\".",
+                    &enabled);
+    }
+    return;
+  }
+
+  NgxHeaderReader reader{&request->headers_in.headers};
+  const auto priority = reader.lookup("x-datadog-sampling-priority");
+  if (!priority || *priority != "2") {
+    return;
+  }
+  span.trace_segment().override_sampling_priority(2);  // USER-KEEP
+}
+
+bool is_cors_preflight(ngx_http_request_t *request) {
+  if (request->method != NGX_HTTP_OPTIONS) {
+    return false;
+  }
+  NgxHeaderReader reader{&request->headers_in.headers};
+  return reader.lookup("origin") &&
+         reader.lookup("access-control-request-method");
+}
+
+bool is_untraced_cors_preflight(ngx_http_request_t *request,
+                                const datadog_loc_conf_t *loc_conf) {
+  return request == request->main && !loc_conf->trace_cors_preflight &&
+         is_cors_preflight(request);
+}
+
+// Return whether the specified `request` has the "Expect: 100-continue"
+// header, i.e. whether the client waits for a "100 Continue" response before
+// sending the request body.
+static bool expects_continue(ngx_http_request_t *request) {
+  NgxHeaderReader reader{&request->headers_in.headers};
+  const auto expect = reader.lookup("expect");
+  if (!expect) {
+    return false;
+  }
+  std::string value{*expect};
+  std::transform(value.begin(), value.end(), value.begin(), to_lower);
+  return value == "100-continue";
+}
+
+// `active_request_spans` is the number of request spans in progress in this
+// worker process that will be sent to the Datadog Agent. `shed_request_spans`
+// is the number of requests, since the worker process started, whose spans
+// were not sent because `active_request_spans` had reached
+// `datadog_max_active_spans`. nginx handles requests on one thread per worker
+// process, so the counts need no synchronization.
+static std::size_t active_request_spans = 0;
+static std::uint64_t shed_request_spans = 0;
+
+namespace {
+
+// `ClaimReader` presents the members of a JSON Web Token claim, e.g.
+// `{"traceparent": "00-..."}`, as if they were request headers, so that trace
+// context can be extracted from them.
+class ClaimReader : public dd::DictReader {
+  std::unordered_map<std::string, std::string> members_;
+  mutable std::string buffer_;
+
+ public:
+  explicit ClaimReader(const nlohmann::json &claim) {
+    for (const auto &[key, value] : claim.items()) {
+      if (!value.is_string()) {
+        continue;
+      }
+      std::string name = key;
+      std::transform(name.begin(), name.end(), name.begin(), to_lower);
+      members_.emplace(std::move(name), value.get<std::string>());
+    }
+  }
+
+  std::optional<std::string_view> lookup(std::string_view key) const override {
+    buffer_.assign(key.data(), key.size());
+    std::transform(buffer_.begin(), buffer_.end(), buffer_.begin(), to_lower);
+    const auto found = members_.find(buffer_);
+    if (found != members_.end()) {
+      return found->second;
+    }
+    return std::nullopt;
+  }
+
+  void visit(
+      const std::function<void(std::string_view key, std::string_view value)>
+          &visitor) const override {
+    for (const auto &[key, value] : members_) {
+      visitor(key, value);
+    }
+  }
+};
+
+}  // namespace
+
+// If the specified `loc_conf` has a `datadog_trace_context_jwt` whose token
+// evaluates, for the specified `request`, to a JSON Web Token signed with the
+// configured key, then return a reader of the token's configured claim.
+// Otherwise, return `std::nullopt`. Tokens that fail verification are logged
+// and ignored.
+static std::optional<ClaimReader> jwt_trace_context(
+    ngx_http_request_t *request, const datadog_loc_conf_t *loc_conf) {
+  if (!loc_conf->jwt_token_script.is_valid()) {
+    return std::nullopt;
+  }
+  const ngx_str_t token = loc_conf->jwt_token_script.run(request);
+  if (token.len == 0) {
+    return std::nullopt;
+  }
+
+  const auto claims = verify_jwt(str(token), loc_conf->jwt_key, ngx_time());
+  if (!claims) {
+    ngx_log_error(NGX_LOG_INFO, request->connection->log, 0,
+                  "Ignoring the trace context JSON Web Token of request %p, "
+                  "because the token could not be verified.",
+                  request);
+    return std::nullopt;
+  }
+  const auto claim = claims->find(loc_conf->jwt_claim);
+  if (claim == claims->end() || !claim->is_object()) {
+    return std::nullopt;
+  }
+  return ClaimReader{*claim};
+}
+
+// Return whether the `datadog_drop_trace_if` directive in the specified
+// `loc_conf` evaluates to "on" for the specified `request`.
+static bool should_drop_trace(ngx_http_request_t *request,
+                              const datadog_loc_conf_t *loc_conf) {
+  const ngx_str_t drop = loc_conf->drop_trace_script.run(request);
+  if (str(drop) == "on") {
+    return true;
+  }
+  if (str(drop) != "off") {
+    ngx_log_error(NGX_LOG_ERR, request->connection->log, 0,
+                  "Condition expression for datadog_drop_trace_if directive "
+                  "evaluated to unexpected value \"%V\". Expected \"on\" or "
+                  "\"off\". Proceeding as if it were \"off\".",
+                  &drop);
+  }
+  return false;
+}
+
 RequestTracing::RequestTracing(ngx_http_request_t *request,
                                ngx_http_core_loc_conf_t *core_loc_conf,
-                               datadog_loc_conf_t *loc_conf, dd::Span *parent)
+                               datadog_loc_conf_t *loc_conf, dd::Span *parent,
+                               bool after_body)
     : request_{request},
       main_conf_{static_cast<datadog_main_conf_t *>(
           ngx_http_get_module_main_conf(request_, ngx_http_datadog_module))},
       core_loc_conf_{core_loc_conf},
-      loc_conf_{loc_conf} {
+      loc_conf_{loc_conf},
+      appsec_only_{!parent && (!loc_conf->enable ||
+                               is_untraced_cors_preflight(request, loc_conf))} {
   // `main_conf_` would be null when no `http` block appears in the nginx
   // config.  If that happens, then no handlers are installed by this module,
   // and so no `RequestTracing` objects are ever instantiated.
@@ -187,13 +1184,50 @@ RequestTracing::RequestTracing(ngx_http_request_t *request,
   auto *tracer = global_tracer();
   if (!tracer) throw std::runtime_error{"no global tracer set"};
 
+  // A dropped trace, like the span of a request that is handled only for
+  // AppSec, is created by a tracer that never sends it to the Datadog Agent.
+  // Subrequests belong to their parent's trace, and so are dropped if and only
+  // if the parent's trace is dropped.
+  const bool drop_trace =
+      !parent && !appsec_only_ && should_drop_trace(request_, loc_conf_);
+  // Once `datadog_max_active_spans` request spans are in progress, the traces
+  // of new requests are shed: they, too, are created by the tracer that never
+  // sends traces, but their trace context is propagated as usual.
+  const bool counted = !parent && !appsec_only_ && !drop_trace;
+  const bool shed = counted && main_conf_->max_active_spans > 0 &&
+                    active_request_spans >=
+                        std::size_t(main_conf_->max_active_spans);
+  if (drop_trace || appsec_only_ || shed) {
+    tracer = global_unreported_tracer();
+    if (!tracer) throw std::runtime_error{"no global unreported tracer set"};
+  }
+  if (shed) {
+    ++shed_request_spans;
+    ngx_log_debug1(NGX_LOG_DEBUG_HTTP, request_->connection->log, 0,
+                   "shedding Datadog request span for %p", request_);
+  } else if (counted) {
+    active_span_count_ = ActiveSpanCount{active_request_spans};
+  }
+
   ngx_log_debug1(NGX_LOG_DEBUG_HTTP, request_->connection->log, 0,
                  "starting Datadog request span for %p", request_);
 
   dd::SpanConfig config;
-  auto start_timestamp =
-      to_system_timestamp(request->start_sec, request->start_msec);
-  config.start = estimate_past_time_point(start_timestamp);
+  start_wall_ = to_system_timestamp(request->start_sec, request->start_msec);
+  std::optional<std::string_view> clock_anomaly;
+  config.start = estimate_past_time_point(
+      start_wall_, max_request_age(request_), clock_anomaly);
+  // With "datadog_span_start after-body", a request that has a body is not
+  // traced until nginx has received the whole body (see `on_precontent`).
+  // Its span begins now, and the time spent receiving is a tag instead.
+  std::optional<std::chrono::steady_clock::duration> body_receive_time;
+  if (after_body) {
+    const dd::TimePoint now = dd::default_clock();
+    body_receive_time = now.tick - config.start.tick;
+    config.start = now;
+    start_wall_ = now.wall;
+  }
+  start_ = config.start.tick;
   config.name = get_request_operation_name(request_, core_loc_conf_, loc_conf_);
 
   // By the end of this function, we will have a `request_span_`.
@@ -211,8 +1245,17 @@ RequestTracing::RequestTracing(ngx_http_request_t *request,
   // both cases, we fall back to creating a new trace for `request_span_`. If,
   // on the other hand, extracting trace context from the request headers
   // succeeds, then `request_span_` is part of the extracted trace.
-  if (!parent && loc_conf_->trust_incoming_span) {
-    NgxHeaderReader reader{&request->headers_in.headers};
+  //
+  // A verified `datadog_trace_context_jwt` token takes the place of the
+  // request headers, whether or not the headers are trusted.
+  std::optional<ClaimReader> claim;
+  if (!parent) {
+    claim = jwt_trace_context(request_, loc_conf_);
+  }
+  if (!parent && (claim || loc_conf_->trust_incoming_span)) {
+    NgxHeaderReader headers{&request->headers_in.headers};
+    const dd::DictReader &reader =
+        claim ? static_cast<const dd::DictReader &>(*claim) : headers;
     auto maybe_span = tracer->extract_or_create_span(reader, config);
     if (auto *error = maybe_span.if_error()) {
       ngx_log_error(
@@ -232,6 +1275,56 @@ RequestTracing::RequestTracing(ngx_http_request_t *request,
     }
   }
 
+  if (clock_anomaly) {
+    request_span_->set_tag("_dd.clock_anomaly", *clock_anomaly);
+  }
+  if (body_receive_time) {
+    request_span_->set_tag(
+        "nginx.request.body.receive_ms",
+        std::to_string(std::chrono::duration_cast<std::chrono::milliseconds>(
+                           *body_receive_time)
+                           .count()));
+  }
+  if (counted && !shed && shed_request_spans != 0) {
+    request_span_->set_metric("_dd.spans_shed", double(shed_request_spans));
+  }
+
+  if (appsec_only_) {
+    return;
+  }
+
+  if (request_ != request_->main) {
+    request_span_->set_tag("nginx.subrequest", subrequest_kind(request_));
+    if (is_mirror(request_)) {
+      // Mirrored traffic is tagged so that it can be told apart from the
+      // traffic that it shadows.  The upstream that receives it is tagged
+      // when the subrequest finishes, as for any other request.
+      request_span_->set_tag("nginx.mirror", "true");
+      request_span_->set_tag("nginx.mirror.uri", str(request_->uri));
+    }
+    if (copy_headers_in(request_) != NGX_OK) {
+      throw std::runtime_error{"failed to copy subrequest headers"};
+    }
+  } else {
+    // nginx sends "100 Continue" only once it begins reading the request
+    // body, on behalf of whichever module needs it (e.g. `proxy_pass`).  The
+    // client's time spent sending the body is then part of the request, as
+    // for any other request with a body, and the span's start remains the
+    // time at which nginx began receiving the request, unless
+    // `datadog_span_start after-body` defers it.  Tag such requests so that
+    // they can be identified.
+    if (expects_continue(request_)) {
+      request_span_->set_tag("http.request.expect_continue", "true");
+    }
+    if (is_cors_preflight(request_)) {
+      request_span_->set_tag("http.cors_preflight", "true");
+    }
+    add_tls_tags(request_, *request_span_);
+    if (loc_conf_->client_ip_tag) {
+      add_client_ip_tag(request_, *request_span_);
+    }
+  }
+
   if (loc_conf_->enable_locations) {
     ngx_log_debug3(
         NGX_LOG_DEBUG_HTTP, request_->connection->log, 0,
@@ -246,6 +1339,19 @@ RequestTracing::RequestTracing(ngx_http_request_t *request,
   // only span that could be the root span.
   set_sample_rate_tag(request_, loc_conf_, *request_span_);
 
+  // A manual keep must be applied before injecting trace context, because
+  // injection finalizes the sampling decision.
+  if (!parent && loc_conf_->trust_incoming_span) {
+    apply_sampling_priority_override(request_, loc_conf_, *request_span_);
+  }
+
+  // Upstream services should drop the trace too, so that they don't report
+  // spans whose local root was never sent.
+  if (drop_trace) {
+    request_span_->trace_segment().override_sampling_priority(
+        -1);  // USER-REJECT
+  }
+
   // Inject the active span
   dd::InjectionOptions injection_opts;
   injection_opts.delegate_sampling_decision =
@@ -253,11 +1359,16 @@ RequestTracing::RequestTracing(ngx_http_request_t *request,
 
   NgxHeaderWriter writer(request_);
   auto &span = active_span();
-  span.inject(writer, injection_opts);
+  inject_trace_context(writer, loc_conf_, span, injection_opts);
+  set_log_correlation_header(writer, loc_conf_, span);
 }
 
 void RequestTracing::on_change_block(ngx_http_core_loc_conf_t *core_loc_conf,
                                      datadog_loc_conf_t *loc_conf) {
+  if (appsec_only_) {
+    return;
+  }
+
   on_exit_block(std::chrono::steady_clock::now());
   core_loc_conf_ = core_loc_conf;
   loc_conf_ = loc_conf;
@@ -284,11 +1395,12 @@ void RequestTracing::on_change_block(ngx_http_core_loc_conf_t *core_loc_conf,
 
   NgxHeaderWriter writer(request_);
   auto &span = active_span();
-  span.inject(writer, injection_opts);
+  inject_trace_context(writer, loc_conf_, span, injection_opts);
+  set_log_correlation_header(writer, loc_conf_, span);
 }
 
 dd::Span &RequestTracing::active_span() {
-  if (loc_conf_->enable_locations) {
+  if (loc_conf_->enable_locations && !appsec_only_) {
     return *span_;
   } else {
     return *request_span_;
@@ -304,10 +1416,12 @@ void RequestTracing::on_exit_block(
     ngx_log_debug2(NGX_LOG_DEBUG_HTTP, request_->connection->log, 0,
                    "finishing Datadog location span for %p in request %p",
                    loc_conf_, request_);
-    add_script_tags(main_conf_->tags, request_, *span_);
-    add_script_tags(loc_conf_->tags, request_, *span_);
-    add_status_tags(request_, *span_);
+    add_script_tags(main_conf_->tags, *main_conf_, request_, *span_);
+    add_script_tags(loc_conf_->tags, *main_conf_, request_, *span_);
+    add_status_tags(request_, loc_conf_, *span_);
     add_upstream_name(request_, *span_);
+    add_peer_service(request_, loc_conf_, *span_);
+    add_upstream_error_tags(request_, *span_);
 
     // If the location operation name and/or resource name is dependent upon a
     // variable, it may not have been available when the span was first created,
@@ -316,10 +1430,11 @@ void RequestTracing::on_exit_block(
     // See on_log_request below
     span_->set_name(
         get_loc_operation_name(request_, core_loc_conf_, loc_conf_));
-    span_->set_resource_name(get_loc_resource_name(request_, loc_conf_));
+    set_resource_name_and_url(loc_conf_, *span_,
+                              get_loc_resource_name(request_, loc_conf_));
     span_->set_end_time(finish_timestamp);
   } else {
-    add_script_tags(loc_conf_->tags, request_, *request_span_);
+    add_script_tags(loc_conf_->tags, *main_conf_, request_, *request_span_);
   }
 
   // We care about sampling rules for the request span only, because it's the
@@ -327,15 +1442,78 @@ void RequestTracing::on_exit_block(
   set_sample_rate_tag(request_, loc_conf_, *request_span_);
 }
 
+void RequestTracing::on_header_filter() {
+  if (loc_conf_->version_header.len != 0 && request_ == request_->main) {
+    add_version_header(request_, str(loc_conf_->version_header));
+  }
+  if (appsec_only_) {
+    return;
+  }
+  if (loc_conf_->debug_headers) {
+    add_trace_id_trailer(request_, *request_span_);
+  }
+  if (loc_conf_->trace_context_header) {
+    add_trace_context_header(request_, active_span());
+  }
+  if (loc_conf_->server_timing) {
+    add_server_timing_header(request_, *request_span_);
+  }
+}
+
+void RequestTracing::on_request_body(const ngx_chain_t *chain) {
+  off_t bytes = request_body_bytes_.value_or(0);
+  for (const ngx_chain_t *link = chain; link != nullptr; link = link->next) {
+    bytes += ngx_buf_size(link->buf);
+  }
+  request_body_bytes_ = bytes;
+
+  if (!loc_conf_->graphql_resource_name || graphql_body_too_large_) {
+    return;
+  }
+  for (const ngx_chain_t *link = chain; link != nullptr; link = link->next) {
+    const ngx_buf_t *buf = link->buf;
+    if (!ngx_buf_in_memory(buf) ||
+        graphql_body_.size() + std::size_t(buf->last - buf->pos) >
+            graphql_max_body_size) {
+      graphql_body_too_large_ = true;
+      graphql_body_.clear();
+      return;
+    }
+    graphql_body_.append(reinterpret_cast<const char *>(buf->pos),
+                         buf->last - buf->pos);
+  }
+}
+
 void RequestTracing::on_log_request() {
   auto finish_timestamp = std::chrono::steady_clock::now();
+  if (appsec_only_) {
+    request_span_->set_end_time(finish_timestamp);
+    return;
+  }
+
   on_exit_block(finish_timestamp);
 
   ngx_log_debug1(NGX_LOG_DEBUG_HTTP, request_->connection->log, 0,
                  "finishing Datadog request span for %p", request_);
-  add_status_tags(request_, *request_span_);
-  add_script_tags(main_conf_->tags, request_, *request_span_);
+  add_status_tags(request_, loc_conf_, *request_span_);
+  add_script_tags(main_conf_->tags, *main_conf_, request_, *request_span_);
   add_upstream_name(request_, *request_span_);
+  add_peer_service(request_, loc_conf_, *request_span_);
+  add_upstream_error_tags(request_, *request_span_);
+  if (loc_conf_->timing_tags) {
+    add_timing_tags(request_, *request_span_);
+  }
+  add_cache_status_tag(request_, *request_span_);
+  // Requests without a body, e.g. most GET requests, are not tagged.
+  const bool has_body = request_->headers_in.content_length_n >= 0 ||
+                        request_->headers_in.chunked;
+  if (request_body_bytes_ && has_body) {
+    request_span_->set_tag("http.request.body.bytes",
+                           std::to_string(*request_body_bytes_));
+  }
+  if (loc_conf_->user_agent_tags && request_ == request_->main) {
+    add_user_agent_tags(request_, *request_span_);
+  }
 
   // When datadog_operation_name points to a variable, then it can be
   // initialized or modified at any phase of the request, so set the span
@@ -346,11 +1524,52 @@ void RequestTracing::on_log_request() {
       ngx_http_get_module_loc_conf(request_, ngx_http_core_module));
   request_span_->set_name(
       get_request_operation_name(request_, core_loc_conf, loc_conf_));
-  request_span_->set_resource_name(
-      get_request_resource_name(request_, loc_conf_));
+  // A GraphQL operation, or else an OpenAPI route, names the resource unless
+  // `datadog_resource_name` is configured. Like any other resource name, it is
+  // then normalized and truncated.
+  std::optional<std::string> resource;
+  if (loc_conf_->graphql_resource_name && !graphql_body_.empty()) {
+    resource = graphql_resource_name(graphql_body_, *request_span_);
+  }
+  if (!resource) {
+    resource = openapi_resource_name(request_, *main_conf_, *request_span_);
+  }
+  if (!resource || has_configured_resource_name(loc_conf_)) {
+    resource = get_request_resource_name(request_, loc_conf_);
+  }
+  set_resource_name_and_url(loc_conf_, *request_span_, *resource);
+  set_service_name_override(request_, loc_conf_, *main_conf_, *request_span_);
+
+  // With "datadog_span_timing wall", the request span lasts as long as the
+  // system clock says that the request did. A system clock that stepped
+  // backward would yield a negative duration, so the duration is clamped.
+  if (main_conf_->span_timing == SPAN_TIMING_WALL) {
+    auto elapsed =
+        std::chrono::duration_cast<std::chrono::steady_clock::duration>(
+            std::chrono::system_clock::now() - start_wall_);
+    if (elapsed < decltype(elapsed)::zero()) {
+      request_span_->set_tag("_dd.clock_anomaly", "backward");
+      elapsed = decltype(elapsed)::zero();
+    }
+    finish_timestamp = start_ + elapsed;
+  }
 
   request_span_->set_end_time(finish_timestamp);
 
+  if (request_ == request_->main) {
+    apply_upstream_sample_rate(request_, *main_conf_, *request_span_);
+  }
+
+  // A trace that is shorter than `datadog_min_trace_duration` is marked, and
+  // `MinTraceDurationHTTPClient` then withholds it from the Datadog Agent,
+  // unless the trace is kept by AppSec or by a sampling rule. The sampling
+  // decision is not changed.
+  if (request_ == request_->main && loc_conf_->min_trace_duration != 0 &&
+      finish_timestamp - start_ <
+          std::chrono::milliseconds(loc_conf_->min_trace_duration)) {
+    request_span_->set_metric(below_min_trace_duration_metric, 1);
+  }
+
   if (should_delegate(request_, loc_conf_)) {
     NgxHeaderReader reader(&request_->headers_out.headers);
     auto delegated = request_span_->read_sampling_delegation_response(reader);
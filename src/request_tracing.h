@@ -5,7 +5,9 @@
 #include <chrono>
 #include <memory>
 #include <optional>
+#include <string>
 #include <string_view>
+#include <utility>
 
 #include "datadog_conf.h"
 
@@ -21,13 +23,26 @@ namespace nginx {
 
 class RequestTracing {
  public:
+  // Begin tracing the specified `request`. If `parent` is not null, then the
+  // request is a subrequest whose span is a child of `parent`. If
+  // `after_body` is true, then the request span begins now, because nginx
+  // has just received the request body (see `datadog_span_start`), rather
+  // than when nginx began receiving the request.
   RequestTracing(ngx_http_request_t *request,
                  ngx_http_core_loc_conf_t *core_loc_conf,
-                 datadog_loc_conf_t *loc_conf, dd::Span *parent = nullptr);
+                 datadog_loc_conf_t *loc_conf, dd::Span *parent = nullptr,
+                 bool after_body = false);
 
   void on_change_block(ngx_http_core_loc_conf_t *core_loc_conf,
                        datadog_loc_conf_t *loc_conf);
 
+  void on_header_filter();
+
+  // Count the bytes of request body in the specified `chain`, which is being
+  // passed through nginx's request body filters. If GraphQL resource names are
+  // enabled, then also keep a copy of the body.
+  void on_request_body(const ngx_chain_t *chain);
+
   void on_log_request();
 
   ngx_str_t lookup_span_variable_value(std::string_view key);
@@ -37,15 +52,74 @@ class RequestTracing {
   dd::Span &active_span();
 
  private:
+  // `ActiveSpanCount` counts one request span toward a per-worker total for
+  // as long as it lives, so that the total can be compared with
+  // `datadog_max_active_spans`. `RequestTracing` objects are moved as the
+  // vector that holds them grows, so only the last owner uncounts the span.
+  class ActiveSpanCount {
+   public:
+    ActiveSpanCount() = default;
+    explicit ActiveSpanCount(std::size_t &total) : total_{&total} { ++total; }
+    ActiveSpanCount(ActiveSpanCount &&other) noexcept
+        : total_{std::exchange(other.total_, nullptr)} {}
+    ActiveSpanCount &operator=(ActiveSpanCount &&other) noexcept {
+      std::swap(total_, other.total_);
+      return *this;
+    }
+    ~ActiveSpanCount() {
+      if (total_) --*total_;
+    }
+
+   private:
+    std::size_t *total_ = nullptr;
+  };
+
   ngx_http_request_t *request_;
   datadog_main_conf_t *main_conf_;
   ngx_http_core_loc_conf_t *core_loc_conf_;
   datadog_loc_conf_t *loc_conf_;
+  // `appsec_only_` is whether tracing is disabled for the request, or it is an
+  // untraced CORS preflight request, which is nonetheless handled because
+  // AppSec is active. In that case, the request
+  // span exists only for AppSec's use: it is never sent to the Datadog Agent,
+  // trace context is not propagated, and no location spans are created.
+  bool appsec_only_;
+  // `start_` is when the request span began, used to measure the request's
+  // duration for the `datadog_min_trace_duration` directive.
+  std::chrono::steady_clock::time_point start_;
+  // `start_wall_` is the system time at which the request began. It is
+  // used to measure the request span's duration when `datadog_span_timing` is
+  // "wall".
+  std::chrono::system_clock::time_point start_wall_;
+  // `request_body_bytes_` is the number of request body bytes read so far, or
+  // null if no request body has been read. The count includes bodies sent
+  // with chunked transfer encoding, whose length is not known in advance.
+  std::optional<off_t> request_body_bytes_;
+  // `graphql_body_` is the request body read so far, if
+  // `datadog_graphql_resource_name` is "on". If the body is too large, or is
+  // buffered in a file, then `graphql_body_too_large_` is true instead.
+  std::string graphql_body_;
+  bool graphql_body_too_large_ = false;
+  // `active_span_count_` counts the request span toward
+  // `datadog_max_active_spans`, unless the request is a subrequest or its
+  // trace is not sent to the Datadog Agent.
+  ActiveSpanCount active_span_count_;
   std::optional<dd::Span> request_span_;
   std::optional<dd::Span> span_;
 
   void on_exit_block(std::chrono::steady_clock::time_point finish_timestamp);
 };
 
+// Return whether the specified `request` is a CORS preflight request, i.e. an
+// "OPTIONS" request that has both an "Origin" header and an
+// "Access-Control-Request-Method" header.
+bool is_cors_preflight(ngx_http_request_t *request);
+
+// Return whether the specified main `request` is a CORS preflight request that
+// is not traced, because `datadog_trace_cors_preflight` is "off" in the
+// specified `loc_conf`. Such a request is still handled for AppSec.
+bool is_untraced_cors_preflight(ngx_http_request_t *request,
+                                const datadog_loc_conf_t *loc_conf);
+
 }  // namespace nginx
 }  // namespace datadog
@@ -0,0 +1,380 @@
+#include "api_security.h"
+
+#include <rapidjson/document.h>
+#include <rapidjson/stringbuffer.h>
+#include <rapidjson/writer.h>
+#include <zlib.h>
+
+#include <cmath>
+#include <cstdint>
+#include <string>
+#include <string_view>
+#include <unordered_map>
+#include <unordered_set>
+#include <vector>
+
+#include "../string_util.h"
+#include "library.h"
+
+using namespace std::literals;
+
+namespace datadog::nginx::security {
+namespace {
+
+// Schema type codes, as used by the WAF's "extract_schema" processor.
+enum class SchemaType {
+  UNKNOWN = 0,
+  NULL_TYPE = 1,
+  BOOLEAN = 2,
+  INTEGER = 4,
+  STRING = 8,
+  FLOAT = 16,
+};
+
+// Limits on the size of an extracted schema, after those of the WAF's
+// "extract_schema" processor. Containers nested more deeply than
+// `kMaxSchemaDepth` have the unknown type, only the first `kMaxArrayElements`
+// elements of an array contribute to the array's schema, and only the first
+// `kMaxObjectKeys` keys of an object are included.
+constexpr int kMaxSchemaDepth = 18;
+constexpr rapidjson::SizeType kMaxArrayElements = 10;
+constexpr rapidjson::SizeType kMaxObjectKeys = 255;
+
+// Request and response bodies larger than this are not examined.
+constexpr std::size_t kMaxBodySize = 1 << 20;
+
+// Each worker process tracks the number of requests seen for at most this many
+// endpoints. When the table is full, it is cleared.
+constexpr std::size_t kMaxSampledEndpoints = 4096;
+
+void append_json_string(std::string &out, std::string_view value) {
+  rapidjson::StringBuffer buffer;
+  rapidjson::Writer<rapidjson::StringBuffer> writer(buffer);
+  writer.String(value.data(), static_cast<rapidjson::SizeType>(value.size()));
+  out.append(buffer.GetString(), buffer.GetSize());
+}
+
+void append_scalar(std::string &out, SchemaType type) {
+  out += '[';
+  out += std::to_string(static_cast<int>(type));
+  out += ']';
+}
+
+bool is_redacted(std::string_view key,
+                 const std::unordered_set<std::string> &redacted_keys) {
+  if (redacted_keys.empty()) {
+    return false;
+  }
+  std::string lowered{key};
+  for (char &c : lowered) {
+    c = to_lower(c);
+  }
+  return redacted_keys.count(lowered) != 0;
+}
+
+// NOLINTNEXTLINE(misc-no-recursion)
+void append_schema(std::string &out, const rapidjson::Value &value, int depth,
+                   const std::unordered_set<std::string> &redacted_keys) {
+  if ((value.IsObject() || value.IsArray()) && depth >= kMaxSchemaDepth) {
+    append_scalar(out, SchemaType::UNKNOWN);
+    return;
+  }
+
+  if (value.IsObject()) {
+    out += "[{";
+    std::unordered_set<std::string_view> seen_keys;
+    rapidjson::SizeType count = 0;
+    for (auto it = value.MemberBegin();
+         it != value.MemberEnd() && count < kMaxObjectKeys; ++it) {
+      std::string_view key{it->name.GetString(), it->name.GetStringLength()};
+      if (!seen_keys.insert(key).second) {
+        continue;  // duplicate key
+      }
+      if (count++ != 0) {
+        out += ',';
+      }
+      append_json_string(out, key);
+      out += ':';
+      if (is_redacted(key, redacted_keys)) {
+        append_scalar(out, SchemaType::STRING);
+      } else {
+        append_schema(out, it->value, depth + 1, redacted_keys);
+      }
+    }
+    out += "}]";
+  } else if (value.IsArray()) {
+    // The array's schema lists the distinct schemas of its elements.
+    std::vector<std::string> element_schemas;
+    std::unordered_set<std::string> seen;
+    for (rapidjson::SizeType i = 0;
+         i < value.Size() && i < kMaxArrayElements; ++i) {
+      std::string element_schema;
+      append_schema(element_schema, value[i], depth + 1, redacted_keys);
+      if (seen.insert(element_schema).second) {
+        element_schemas.push_back(std::move(element_schema));
+      }
+    }
+    out += "[[";
+    for (std::size_t i = 0; i < element_schemas.size(); ++i) {
+      if (i != 0) {
+        out += ',';
+      }
+      out += element_schemas[i];
+    }
+    out += "],{\"len\":";
+    out += std::to_string(value.Size());
+    out += "}]";
+  } else if (value.IsNull()) {
+    append_scalar(out, SchemaType::NULL_TYPE);
+  } else if (value.IsBool()) {
+    append_scalar(out, SchemaType::BOOLEAN);
+  } else if (value.IsInt64() || value.IsUint64()) {
+    append_scalar(out, SchemaType::INTEGER);
+  } else if (value.IsNumber()) {
+    append_scalar(out, SchemaType::FLOAT);
+  } else if (value.IsString()) {
+    append_scalar(out, SchemaType::STRING);
+  } else {
+    append_scalar(out, SchemaType::UNKNOWN);
+  }
+}
+
+bool is_json_content_type(const ngx_str_t &content_type) {
+  std::string value{to_string_view(content_type)};
+  for (char &c : value) {
+    c = to_lower(c);
+  }
+  return value.find("json"sv) != std::string::npos;
+}
+
+bool is_json_request(const ngx_http_request_t &request) {
+  const ngx_table_elt_t *header = request.headers_in.content_type;
+  return header != nullptr && is_json_content_type(header->value);
+}
+
+// Return the body of the specified `request`, or return `std::nullopt` if the
+// body was not read by nginx, or was buffered to a file, or is too large.
+std::optional<std::string> in_memory_body(const ngx_http_request_t &request) {
+  if (request.request_body == nullptr ||
+      request.request_body->bufs == nullptr) {
+    return std::nullopt;
+  }
+
+  std::string body;
+  for (const ngx_chain_t *link = request.request_body->bufs; link != nullptr;
+       link = link->next) {
+    const ngx_buf_t *buf = link->buf;
+    if (!ngx_buf_in_memory(buf)) {
+      return std::nullopt;
+    }
+    // Sending the body upstream advances `pos`, so read from `start`, as nginx
+    // does when it resends the body to another upstream server.
+    const auto size = static_cast<std::size_t>(buf->last - buf->start);
+    if (body.size() + size > kMaxBodySize) {
+      return std::nullopt;
+    }
+    body.append(reinterpret_cast<const char *>(buf->start), size);
+  }
+  return body;
+}
+
+// Return the schema of the headers of the response to the specified
+// `request`, excluding cookies. Each header's value is a string, or an array
+// of strings if the header occurs more than once.
+std::string response_headers_schema(const ngx_http_request_t &request) {
+  rapidjson::Document headers;
+  headers.SetObject();
+  auto &allocator = headers.GetAllocator();
+  const auto add = [&](std::string_view name, std::string_view value) {
+    std::string key{name};
+    for (char &c : key) {
+      c = to_lower(c);
+    }
+    if (key == "set-cookie"sv) {
+      return;
+    }
+    rapidjson::Value string_value(
+        value.data(), static_cast<rapidjson::SizeType>(value.size()),
+        allocator);
+    auto found = headers.FindMember(key.c_str());
+    if (found == headers.MemberEnd()) {
+      headers.AddMember(rapidjson::Value(key.c_str(), allocator),
+                        string_value, allocator);
+    } else {
+      if (!found->value.IsArray()) {
+        rapidjson::Value first(std::move(found->value));
+        found->value.SetArray();
+        found->value.PushBack(first, allocator);
+      }
+      found->value.PushBack(string_value, allocator);
+    }
+  };
+
+  // nginx keeps the "Content-Type" and "Content-Length" headers apart from the
+  // others until it writes the response header.
+  if (request.headers_out.content_type.len != 0) {
+    add("content-type"sv, to_string_view(request.headers_out.content_type));
+  }
+  if (request.headers_out.content_length_n >= 0) {
+    add("content-length"sv,
+        std::to_string(request.headers_out.content_length_n));
+  }
+  for (const ngx_list_part_t *part = &request.headers_out.headers.part;
+       part != nullptr; part = part->next) {
+    const auto *elts = static_cast<const ngx_table_elt_t *>(part->elts);
+    for (ngx_uint_t i = 0; i < part->nelts; ++i) {
+      // A header whose hash is zero has been deleted.
+      if (elts[i].hash != 0) {
+        add(to_string_view(elts[i].key), to_string_view(elts[i].value));
+      }
+    }
+  }
+
+  std::string schema;
+  append_schema(schema, headers, 0, {});
+  return schema;
+}
+
+// Return whether a request to the specified `endpoint` is sampled.  Of the
+// requests to a given endpoint, the first is sampled, and thereafter a
+// fraction equal to the specified `rate`.
+bool sample_endpoint(const std::string &endpoint, double rate) {
+  if (rate <= 0) {
+    return false;
+  }
+  // nginx handles requests on one thread per worker process, so the table
+  // needs no synchronization.
+  static std::unordered_map<std::string, std::uint64_t> seen_requests;
+  if (seen_requests.size() >= kMaxSampledEndpoints &&
+      seen_requests.find(endpoint) == seen_requests.end()) {
+    seen_requests.clear();
+  }
+
+  const std::uint64_t n = ++seen_requests[endpoint];
+  return std::ceil(double(n) * rate) > std::ceil(double(n - 1) * rate);
+}
+
+}  // namespace
+
+std::optional<std::string> extract_json_schema(
+    std::string_view document,
+    const std::unordered_set<std::string> &redacted_keys) {
+  rapidjson::Document parsed;
+  parsed.Parse(document.data(), document.size());
+  if (parsed.HasParseError()) {
+    return std::nullopt;
+  }
+
+  std::string schema;
+  append_schema(schema, parsed, 0, redacted_keys);
+  return schema;
+}
+
+std::string encode_schema(std::string_view schema) {
+  // A window of 15 bits plus 16 selects the gzip format.
+  z_stream stream{};
+  if (deflateInit2(&stream, Z_DEFAULT_COMPRESSION, Z_DEFLATED, 15 + 16, 8,
+                   Z_DEFAULT_STRATEGY) != Z_OK) {
+    return {};
+  }
+  std::string compressed(deflateBound(&stream, schema.size()), '\0');
+  stream.next_in =
+      reinterpret_cast<Bytef *>(const_cast<char *>(schema.data()));
+  stream.avail_in = static_cast<uInt>(schema.size());
+  stream.next_out = reinterpret_cast<Bytef *>(compressed.data());
+  stream.avail_out = static_cast<uInt>(compressed.size());
+  const int rc = deflate(&stream, Z_FINISH);
+  compressed.resize(stream.total_out);
+  deflateEnd(&stream);
+  if (rc != Z_STREAM_END) {
+    return {};
+  }
+
+  std::string encoded(ngx_base64_encoded_length(compressed.size()), '\0');
+  ngx_str_t src{compressed.size(),
+                reinterpret_cast<u_char *>(compressed.data())};
+  ngx_str_t dst{encoded.size(), reinterpret_cast<u_char *>(encoded.data())};
+  ngx_encode_base64(&dst, &src);
+  encoded.resize(dst.len);
+  return encoded;
+}
+
+std::optional<ApiSecuritySample> ApiSecuritySample::maybe_create(
+    const ngx_http_request_t &request) {
+  const double rate = Library::api_security_sample_rate();
+  if (rate <= 0) {
+    return std::nullopt;
+  }
+
+  std::string endpoint{to_string_view(request.method_name)};
+  endpoint += ' ';
+  endpoint += to_string_view(request.uri);
+  if (!sample_endpoint(endpoint, rate)) {
+    return std::nullopt;
+  }
+  return ApiSecuritySample{};
+}
+
+void ApiSecuritySample::on_response_body(const ngx_http_request_t &request,
+                                         const ngx_chain_t *chain) {
+  if (response_body_complete_ || response_body_skipped_) {
+    return;
+  }
+  if (!is_json_content_type(request.headers_out.content_type)) {
+    response_body_skipped_ = true;
+    return;
+  }
+
+  for (const ngx_chain_t *link = chain; link != nullptr; link = link->next) {
+    const ngx_buf_t *buf = link->buf;
+    if (ngx_buf_in_memory(buf)) {
+      const auto size = static_cast<std::size_t>(buf->last - buf->pos);
+      if (response_body_.size() + size > kMaxBodySize) {
+        response_body_skipped_ = true;
+        response_body_.clear();
+        return;
+      }
+      response_body_.append(reinterpret_cast<const char *>(buf->pos), size);
+    } else if (!ngx_buf_special(buf)) {
+      // The body is sent from a file, e.g. a static file or a cached
+      // response, and so is not examined.
+      response_body_skipped_ = true;
+      response_body_.clear();
+      return;
+    }
+    if (buf->last_buf) {
+      response_body_complete_ = true;
+      return;
+    }
+  }
+}
+
+void ApiSecuritySample::report(const ngx_http_request_t &request,
+                               ::datadog::tracing::Span &span) const {
+  const auto tag = [&](std::string_view name, std::string_view schema) {
+    std::string encoded = encode_schema(schema);
+    if (!encoded.empty()) {
+      span.set_tag(name, encoded);
+    }
+  };
+
+  if (is_json_request(request)) {
+    if (auto body = in_memory_body(request)) {
+      if (auto schema = extract_json_schema(
+              *body, Library::appsec_body_redact_keys())) {
+        tag("_dd.appsec.s.req.body"sv, *schema);
+      }
+    }
+  }
+
+  tag("_dd.appsec.s.res.headers"sv, response_headers_schema(request));
+
+  if (response_body_complete_ && !response_body_skipped_) {
+    if (auto schema = extract_json_schema(
+            response_body_, Library::appsec_body_redact_keys())) {
+      tag("_dd.appsec.s.res.body"sv, *schema);
+    }
+  }
+}
+
+}  // namespace datadog::nginx::security
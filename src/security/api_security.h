@@ -0,0 +1,73 @@
+#pragma once
+
+// API Security reports the structure of the requests that an endpoint
+// receives and of the responses that it sends, so that the endpoint's API can
+// be documented and monitored. This component extracts the schemas of JSON
+// request and response bodies, and of response headers: descriptions of the
+// types and keys in the document, never the values. The schemas of a sampled
+// request are added to its span as "_dd.appsec.s.*" tags.
+
+#include <datadog/span.h>
+
+#include <optional>
+#include <string>
+#include <string_view>
+#include <unordered_set>
+
+extern "C" {
+#include <ngx_http.h>
+}
+
+namespace datadog::nginx::security {
+
+// Return the schema of the specified JSON `document`, in the format of the
+// WAF's "extract_schema" processor, e.g. `[{"id":[4],"tags":[[[8]],{"len":2}]}]`
+// for `{"id": 1, "tags": ["a", "b"]}`. Return `std::nullopt` if `document` is
+// not valid JSON. The values of object members whose keys, lowercased, are in
+// the optionally specified `redacted_keys` are treated as if they had been
+// replaced by a string, at any depth, so that the schema does not reveal
+// their structure.
+std::optional<std::string> extract_json_schema(
+    std::string_view document,
+    const std::unordered_set<std::string> &redacted_keys = {});
+
+// Return the specified API Security `schema` encoded as the Datadog backend
+// expects the value of an "_dd.appsec.s.*" tag: the base64 encoding of the
+// gzip compression of the schema's JSON text.
+std::string encode_schema(std::string_view schema);
+
+// `ApiSecuritySample` gathers what is needed to report the schemas of a
+// request that API Security has sampled: the request's JSON body, the
+// response's headers, and the response's JSON body. The schemas are added to
+// the request span as the tags "_dd.appsec.s.req.body",
+// "_dd.appsec.s.res.headers", and "_dd.appsec.s.res.body".
+class ApiSecuritySample {
+ public:
+  // Return a sample for the specified `request` if the request's endpoint is
+  // sampled, or return `std::nullopt` otherwise. Endpoints are sampled
+  // independently of each other, at the configured API Security sample rate.
+  // Call this at most once per request.
+  static std::optional<ApiSecuritySample> maybe_create(
+      const ngx_http_request_t &request);
+
+  // Copy the response body data in the specified `chain`, if the response of
+  // the specified `request` is JSON, so that its schema can be reported.
+  void on_response_body(const ngx_http_request_t &request,
+                        const ngx_chain_t *chain);
+
+  // Tag the specified `span` with the schemas of the specified `request`.
+  void report(const ngx_http_request_t &request,
+              ::datadog::tracing::Span &span) const;
+
+ private:
+  ApiSecuritySample() = default;
+
+  std::string response_body_;
+  // `response_body_complete_` is whether all of the response body is in
+  // `response_body_`, and `response_body_skipped_` is whether the body cannot
+  // be examined, e.g. because it is not JSON or is too large.
+  bool response_body_complete_ = false;
+  bool response_body_skipped_ = false;
+};
+
+}  // namespace datadog::nginx::security
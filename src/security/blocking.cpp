@@ -396,6 +396,7 @@ void BlockingService::push_header(ngx_http_request_t &req,
   if (!header) {
     return;
   }
+  ngx_memzero(header, sizeof(ngx_table_elt_t));
   header->hash = 1;
   header->key = ngx_stringv(name);
   header->value = ngx_stringv(value);
@@ -25,6 +25,11 @@ struct BlockSpecification {
   int status;
   ContentType ct;
   std::string_view location;
+  // The WAF action that resulted in this specification (e.g.
+  // "block_request"), and the ID of the rule that triggered it. These refer
+  // to memory owned by the WAF result.
+  std::string_view action;
+  std::string_view rule_id;
 };
 
 class BlockingService {
@@ -1,5 +1,6 @@
 #include "client_ip.h"
 
+#include <algorithm>
 #include <array>
 #include <string_view>
 
@@ -52,8 +53,27 @@ struct IpAddr {
 
   static std::optional<IpAddr> from_string(std::string_view addr_sv,
                                            int af_hint = AF_UNSPEC);
+  // IPv4-mapped addresses (::ffff:a.b.c.d) are converted to IPv4, so that
+  // they are classified as the IPv4 addresses that they are.
+  static IpAddr from_in6_addr(const struct in6_addr &addr);
 };
 
+IpAddr IpAddr::from_in6_addr(const struct in6_addr &addr) {
+  IpAddr out{};
+  static constexpr uint8_t ip4_mapped_prefix[12] = {0, 0, 0, 0, 0,    0,
+                                                    0, 0, 0, 0, 0xFF, 0xFF};
+  if (std::memcmp(addr.s6_addr, ip4_mapped_prefix,
+                  sizeof(ip4_mapped_prefix)) == 0) {
+    // IPv4 mapped
+    std::memcpy(&out.u.v4.s_addr, addr.s6_addr + sizeof(ip4_mapped_prefix), 4);
+    out.af = AF_INET;
+  } else {
+    out.u.v6 = addr;
+    out.af = AF_INET6;
+  }
+  return out;
+}
+
 std::optional<IpAddr> IpAddr::from_string(std::string_view addr_sv,
                                           int af_hint) {
   IpAddr out;
@@ -74,26 +94,15 @@ std::optional<IpAddr> IpAddr::from_string(std::string_view addr_sv,
     }
   }
 
-  int ret = inet_pton(AF_INET6, addr_nult, &out.u.v6);
+  struct in6_addr v6;
+  int ret = inet_pton(AF_INET6, addr_nult, &v6);
   if (ret != 1) {
     // neither valid ipv4 nor ipv6
     return std::nullopt;
   }
 
   // if we got here, we have a valid formal ipv6 address
-
-  uint8_t *s6addr = out.u.v6.s6_addr;
-  static constexpr uint8_t ip4_mapped_prefix[12] = {0, 0, 0, 0, 0,    0,
-                                                    0, 0, 0, 0, 0xFF, 0xFF};
-  if (std::memcmp(s6addr, ip4_mapped_prefix, sizeof(ip4_mapped_prefix)) == 0) {
-    // IPv4 mapped
-    std::memcpy(&out.u.v4.s_addr, s6addr + sizeof(ip4_mapped_prefix), 4);
-    out.af = AF_INET;
-  } else {
-    out.af = AF_INET6;
-  }
-
-  return {out};
+  return {from_in6_addr(v6)};
 }
 
 inline constexpr auto ct_htonl(std::uint32_t n) {
@@ -175,7 +184,7 @@ bool IpAddr::is_private_v6() const {
       },
       {
           .base_i = {ct_htonll(0xFDULL << 56), 0},  // unique local address
-          .mask_i = {ct_htonll(0xFFULL << 56), 0}   // /10 mask
+          .mask_i = {ct_htonll(0xFFULL << 56), 0}   // /8 mask
       },
       {
           .base_i = {ct_htonll(0xFCULL << 56), 0},
@@ -496,15 +505,21 @@ std::optional<IpAddr> parse_ip_address_maybe_port_pair(
   if (addr_sv.empty()) {
     return std::nullopt;
   }
-  if (addr_sv[0] == '[') {  // ipv6
+  if (addr_sv[0] == '[') {  // ipv6, e.g. "[2001:db8::1]" or "[::1]:8080"
     std::size_t pos_close = addr_sv.find(']');
     if (pos_close == std::string_view::npos) {
       return std::nullopt;
     }
-    if (!pos_close) {
-      return std::nullopt;
+    // Whatever follows the closing bracket must be a port, if anything.
+    std::string_view after_brackets = addr_sv.substr(pos_close + 1);
+    if (!after_brackets.empty()) {
+      if (after_brackets.size() == 1 || after_brackets[0] != ':' ||
+          !std::all_of(after_brackets.begin() + 1, after_brackets.end(),
+                       [](char c) { return c >= '0' && c <= '9'; })) {
+        return std::nullopt;
+      }
     }
-    std::string_view between_brackets = addr_sv.substr(1, pos_close);
+    std::string_view between_brackets = addr_sv.substr(1, pos_close - 1);
     return IpAddr::from_string(between_brackets, AF_INET6);
   }
 
@@ -517,6 +532,34 @@ std::optional<IpAddr> parse_ip_address_maybe_port_pair(
 
   return IpAddr::from_string(addr_sv);
 }
+
+// Return the address of the client that connected to nginx, or an empty
+// address if it cannot be determined.  If the connection began with a PROXY
+// protocol header, e.g. from a load balancer, then the client is the source
+// address in the header; otherwise, it is the peer of the connection.
+IpAddr connection_client_address(const ngx_connection_t &connection) {
+  if (connection.proxy_protocol != nullptr &&
+      connection.proxy_protocol->src_addr.len > 0) {
+    auto maybe_addr = IpAddr::from_string(
+        to_string_view(connection.proxy_protocol->src_addr));
+    if (maybe_addr) {
+      return *maybe_addr;
+    }
+  }
+
+  IpAddr addr{};
+  struct sockaddr *sockaddr = connection.sockaddr;
+  if (sockaddr->sa_family == AF_INET) {
+    addr.af = AF_INET;
+    addr.u.v4 = reinterpret_cast<sockaddr_in *>(sockaddr)->sin_addr;
+  } else if (sockaddr->sa_family == AF_INET6) {
+    // A dual-stack listener (`listen [::]:80 ipv6only=off`) accepts IPv4
+    // clients as IPv4-mapped IPv6 addresses.
+    addr = IpAddr::from_in6_addr(
+        reinterpret_cast<sockaddr_in6 *>(sockaddr)->sin6_addr);
+  }
+  return addr;
+}
 }  // namespace
 
 namespace datadog::nginx::security {
@@ -573,24 +616,18 @@ std::optional<std::string> ClientIp::resolve() const {
   }
 
   // No public address found yet
-  // Try remote_addr. If it's public we'll use it
-  IpAddr remote_addr{};
-  struct sockaddr *sockaddr = request_.connection->sockaddr;
-  if (sockaddr->sa_family == AF_INET) {
-    remote_addr.af = AF_INET;
-    remote_addr.u.v4 = reinterpret_cast<sockaddr_in *>(sockaddr)->sin_addr;
-  } else if (sockaddr->sa_family == AF_INET6) {
-    remote_addr.af = AF_INET6;
-    remote_addr.u.v6 = reinterpret_cast<sockaddr_in6 *>(sockaddr)->sin6_addr;
-  }
+  // Try the connection's client address (the PROXY protocol source address,
+  // or else remote_addr). If it's public we'll use it
+  IpAddr remote_addr = connection_client_address(*request_.connection);
 
   if (!remote_addr.empty()) {
-    if (remote_addr.is_private()) {
-      if (cur_private.empty()) {
-        return {remote_addr.to_string()};
-      } else {
-        return {cur_private.to_string()};
-      }
+    if (!remote_addr.is_private()) {
+      return {remote_addr.to_string()};
+    }
+    if (cur_private.empty()) {
+      return {remote_addr.to_string()};
+    } else {
+      return {cur_private.to_string()};
     }
   }
 
@@ -7,8 +7,11 @@
 #include <charconv>
 #include <cstring>
 #include <functional>
+#include <optional>
 #include <string_view>
 #include <unordered_map>
+#include <utility>
+#include <vector>
 
 #include "../string_util.h"
 #include "client_ip.h"
@@ -46,6 +49,7 @@ class ReqSerializer {
   static constexpr std::string_view kHeadersNoCookies{
       "server.request.headers.no_cookies"};
   static constexpr std::string_view kCookies{"server.request.cookies"};
+  static constexpr std::string_view kPathParams{"server.request.path_params"};
   static constexpr std::string_view kStatus{"server.response.status"};
   static constexpr std::string_view kClientIp{"http.client_ip"};
   static constexpr std::string_view kRespHeadersNoCookies{
@@ -54,9 +58,13 @@ class ReqSerializer {
  public:
   explicit ReqSerializer(dnsec::DdwafMemres &memres) : memres_{memres} {}
 
-  ddwaf_object *serialize(const ngx_http_request_t &request) {
+  ddwaf_object *serialize(const ngx_http_request_t &request,
+                          std::string_view route) {
+    auto path_params = match_route(route, to_string_view(request.uri));
+
     dnsec::ddwaf_obj *root = memres_.allocate_objects<dnsec::ddwaf_obj>(1);
-    dnsec::ddwaf_map_obj &root_map = root->make_map(6, memres_);
+    dnsec::ddwaf_map_obj &root_map =
+        root->make_map(path_params ? 7 : 6, memres_);
 
     set_request_query(request, root_map.at_unchecked(0));
     set_request_uri_raw(request, root_map.at_unchecked(1));
@@ -64,6 +72,9 @@ class ReqSerializer {
     set_request_headers_nocookies(request, root_map.at_unchecked(3));
     set_request_cookie(request, root_map.at_unchecked(4));
     set_client_ip(request, root_map.at_unchecked(5));
+    if (path_params) {
+      set_path_params(*path_params, root_map.at_unchecked(6));
+    }
 
     return root;
   }
@@ -161,6 +172,64 @@ class ReqSerializer {
     }
   }
 
+  using PathParams = std::vector<std::pair<std::string_view, std::string_view>>;
+
+  // Match the specified `path`, e.g. "/users/42/orders/7", against the
+  // specified `route` template, e.g. "/users/{id}/orders/{order}". A segment of
+  // the form "{name}" in `route` matches any non-empty segment of `path`, while
+  // other segments must be equal. Return the name and value of each parameter
+  // if `path` matches, or return `std::nullopt` otherwise.
+  static std::optional<PathParams> match_route(std::string_view route,
+                                               std::string_view path) {
+    if (route.empty()) {
+      return std::nullopt;
+    }
+
+    PathParams params;
+    std::size_t route_pos = 0;
+    std::size_t path_pos = 0;
+    while (true) {
+      const std::size_t route_end =
+          std::min(route.find('/', route_pos), route.size());
+      const std::size_t path_end =
+          std::min(path.find('/', path_pos), path.size());
+      const auto route_segment = route.substr(route_pos, route_end - route_pos);
+      const auto path_segment = path.substr(path_pos, path_end - path_pos);
+
+      if (route_segment.size() > 2 && route_segment.front() == '{' &&
+          route_segment.back() == '}') {
+        if (path_segment.empty()) {
+          return std::nullopt;
+        }
+        params.emplace_back(route_segment.substr(1, route_segment.size() - 2),
+                            path_segment);
+      } else if (route_segment != path_segment) {
+        return std::nullopt;
+      }
+
+      const bool route_ended = route_end == route.size();
+      const bool path_ended = path_end == path.size();
+      if (route_ended || path_ended) {
+        if (route_ended && path_ended) {
+          return params;
+        }
+        return std::nullopt;
+      }
+      route_pos = route_end + 1;
+      path_pos = path_end + 1;
+    }
+  }
+
+  void set_path_params(const PathParams &params, dnsec::ddwaf_obj &slot) {
+    slot.set_key(kPathParams);
+    dnsec::ddwaf_map_obj &map = slot.make_map(params.size(), memres_);
+    for (std::size_t i = 0; i < params.size(); ++i) {
+      dnsec::ddwaf_obj &entry = map.at_unchecked(i);
+      entry.set_key(params[i].first);
+      entry.make_string(params[i].second);
+    }
+  }
+
   static void set_request_uri_raw(const ngx_http_request_t &request,
                                   dnsec::ddwaf_obj &slot) {
     set_map_entry_str(slot, kUriRaw, request.unparsed_uri);
@@ -381,9 +450,10 @@ class ReqSerializer {
 namespace datadog::nginx::security {
 
 ddwaf_object *collect_request_data(const ngx_http_request_t &request,
+                                   std::string_view route,
                                    DdwafMemres &memres) {
   ReqSerializer rs{memres};
-  return rs.serialize(request);
+  return rs.serialize(request, route);
 }
 
 ddwaf_object *collect_response_data(const ngx_http_request_t &request,
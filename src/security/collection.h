@@ -2,6 +2,8 @@
 
 #include <ddwaf.h>
 
+#include <string_view>
+
 #include "ddwaf_memres.h"
 
 extern "C" {
@@ -10,7 +12,11 @@ extern "C" {
 
 namespace datadog::nginx::security {
 
+// `route` is a route template, e.g. "/users/{id}", or empty. If the path of
+// `request` matches `route`, then the matched path parameters are included in
+// the collected data.
 ddwaf_object *collect_request_data(const ngx_http_request_t &request,
+                                   std::string_view route,
                                    DdwafMemres &memres);
 ddwaf_object *collect_response_data(const ngx_http_request_t &request,
                                     DdwafMemres &memres);
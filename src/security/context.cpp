@@ -6,6 +6,7 @@
 
 #include <atomic>
 #include <charconv>
+#include <chrono>
 #include <optional>
 #include <sstream>
 #include <stdexcept>
@@ -19,12 +20,15 @@
 #include "../datadog_context.h"
 #include "../datadog_handler.h"
 #include "../ngx_http_datadog_module.h"
+#include "../span_event.h"
 #include "../tracing_library.h"
+#include "api_security.h"
 #include "blocking.h"
 #include "collection.h"
 #include "ddwaf_obj.h"
 #include "header_tags.h"
 #include "library.h"
+#include "overload_breaker.h"
 #include "util.h"
 
 extern "C" {
@@ -177,8 +181,10 @@ Context::Context(std::shared_ptr<OwnedDdwafHandle> handle)
   stage_->store(stage::START, std::memory_order_relaxed);
 }
 
-std::unique_ptr<Context> Context::maybe_create() {
-  std::shared_ptr<OwnedDdwafHandle> handle = Library::get_handle();
+std::unique_ptr<Context> Context::maybe_create(
+    std::string_view ruleset_name) {
+  std::shared_ptr<OwnedDdwafHandle> handle =
+      Library::get_named_handle(ruleset_name);
   if (!handle) {
     return {};
   }
@@ -350,6 +356,13 @@ class Pol1stWafCtx : public PolTaskCtx<Pol1stWafCtx> {
     bool const ran = ran_on_thread_.load(std::memory_order_acquire);
     if (ran && block_spec_) {
       span_.set_tag("appsec.blocked"sv, "true"sv);
+      add_span_event(
+          span_, SpanEvent{.name = "appsec.blocked",
+                           .time = std::chrono::system_clock::now(),
+                           .attributes = {
+                               {"rule_id", std::string{block_spec_->rule_id}},
+                               {"action", std::string{block_spec_->action}},
+                           }});
 
       auto *service = BlockingService::get_instance();
       assert(service != nullptr);
@@ -369,6 +382,53 @@ class Pol1stWafCtx : public PolTaskCtx<Pol1stWafCtx> {
   friend PolTaskCtx;
 };
 
+namespace {
+
+// Return whether the WAF's overload circuit breaker, if enabled, allows the
+// specified `request` to be evaluated.  If not, tag the specified `span` to
+// indicate that the request was not evaluated.
+bool overload_breaker_allows(ngx_http_request_t &request, dd::Span &span) {
+  OverloadBreaker *breaker = Library::overload_breaker();
+  if (breaker == nullptr) {
+    return true;
+  }
+
+  switch (breaker->decide(OverloadBreaker::clock::now())) {
+    case OverloadBreaker::Decision::RUN:
+      return true;
+    case OverloadBreaker::Decision::RUN_AFTER_COOLDOWN:
+      ngx_log_error(NGX_LOG_WARN, request.connection->log, 0,
+                    "AppSec resumed WAF evaluation after overload; %uL "
+                    "requests were not evaluated",
+                    static_cast<uint64_t>(breaker->take_skipped_count()));
+      return true;
+    case OverloadBreaker::Decision::SKIP:
+    default:
+      span.set_tag("_dd.appsec.skipped"sv, "overload"sv);
+      return false;
+  }
+}
+
+// Record the run time of the WAF, as reported in the specified `result`, with
+// the WAF's overload circuit breaker, if it is enabled.
+void record_waf_runtime(const ddwaf_result &result, ngx_log_t &log) {
+  OverloadBreaker *breaker = Library::overload_breaker();
+  if (breaker == nullptr) {
+    return;
+  }
+
+  if (breaker->record_run(std::chrono::nanoseconds{result.total_runtime},
+                          OverloadBreaker::clock::now())) {
+    ngx_log_error(NGX_LOG_WARN, &log, 0,
+                  "AppSec WAF is overloaded (average run time %uLus); "
+                  "suspending WAF evaluation for %uLms",
+                  static_cast<uint64_t>(breaker->average_runtime().count()),
+                  static_cast<uint64_t>(breaker->cooldown().count()));
+  }
+}
+
+}  // namespace
+
 bool Context::on_request_start(ngx_http_request_t &request,
                                dd::Span &span) noexcept {
   return catch_exceptions("on_request_start"sv, request, [&]() {
@@ -406,6 +466,11 @@ bool Context::do_on_request_start(ngx_http_request_t &request, dd::Span &span) {
     return false;
   }
 
+  if (!overload_breaker_allows(request, span)) {
+    stage_->store(stage::DISABLED, std::memory_order_release);
+    return false;
+  }
+
   auto &task_ctx = Pol1stWafCtx::create(request, *this, span);
 
   if (task_ctx.submit(conf->waf_pool)) {
@@ -574,17 +639,39 @@ std::optional<BlockSpecification> resolve_block_spec(
       continue;
     }
 
-    if (type == Action::type::BLOCK_REQUEST) {
-      return {create_block_request_action(act)};
-    }
-
-    if (type == Action::type::REDIRECT_REQUEST) {
-      return {create_redirect_request_action(act)};
+    if (type == Action::type::BLOCK_REQUEST ||
+        type == Action::type::REDIRECT_REQUEST) {
+      BlockSpecification spec = type == Action::type::BLOCK_REQUEST
+                                    ? create_block_request_action(act)
+                                    : create_redirect_request_action(act);
+      spec.action = act.raw_type();
+      return spec;
     }
   }
 
   return std::nullopt;
 }
+
+// Return the ID of the rule that triggered the first event in the specified
+// WAF `result`, or return an empty string if there is no such rule.
+std::string_view first_rule_id(const ddwaf_result &result) {
+  if (result.events.type != DDWAF_OBJ_ARRAY || result.events.nbEntries == 0) {
+    return {};
+  }
+  const ddwaf_object &event = result.events.array[0];
+  if (event.type != DDWAF_OBJ_MAP) {
+    return {};
+  }
+  auto rule = dnsec::ddwaf_map_obj{event}.get_opt("rule"sv);
+  if (!rule || rule->type != DDWAF_OBJ_MAP) {
+    return {};
+  }
+  auto id = dnsec::ddwaf_map_obj{*rule}.get_opt("id"sv);
+  if (!id || !id->is_string()) {
+    return {};
+  }
+  return id->string_val_unchecked();
+}
 }  // namespace
 
 std::optional<BlockSpecification> Context::run_waf_start(
@@ -599,11 +686,15 @@ std::optional<BlockSpecification> Context::run_waf_start(
   static const std::string_view libddwaf_version{ddwaf_get_version()};
   span.set_tag("_dd.appsec.waf.version", libddwaf_version);
 
-  ddwaf_object *data = collect_request_data(req, memres_);
+  auto *conf = static_cast<datadog_loc_conf_t *>(
+      ngx_http_get_module_loc_conf(&req, ngx_http_datadog_module));
+  ddwaf_object *data =
+      collect_request_data(req, to_string_view(conf->appsec_route), memres_);
 
   ddwaf_result result;
   auto code =
       ddwaf_run(ctx_.resource, data, nullptr, &result, Library::waf_timeout());
+  record_waf_runtime(result, *req.connection->log);
   if (code == DDWAF_MATCH) {
     results_.emplace_back(result);
   } else {
@@ -615,6 +706,16 @@ std::optional<BlockSpecification> Context::run_waf_start(
   if (code == DDWAF_MATCH && !actions_arr.empty()) {
     ActionsResult actions_res{actions_arr};
     block_spec = resolve_block_spec(actions_arr, *req.connection->log);
+    if (block_spec) {
+      block_spec->rule_id = first_rule_id(result);
+    }
+  }
+
+  // In monitoring mode, the match is reported but the request proceeds.
+  if (block_spec && !conf->appsec_blocking) {
+    ngx_log_debug0(NGX_LOG_DEBUG_HTTP, req.connection->log, 0,
+                   "not blocking request: datadog_appsec_blocking is off");
+    block_spec.reset();
   }
 
   if (block_spec) {
@@ -652,6 +753,10 @@ class PolFinalWafCtx : public PolTaskCtx<PolFinalWafCtx> {
 
 ngx_int_t Context::do_output_body_filter(ngx_http_request_t &request,
                                          ngx_chain_t *chain, dd::Span &span) {
+  if (auto *sample = api_security_sample(request)) {
+    sample->on_response_body(request, chain);
+  }
+
   auto st = stage_->load(std::memory_order_acquire);
   if (st != stage::AFTER_BEGIN_WAF) {
     return ngx_http_next_output_body_filter(&request, chain);
@@ -701,6 +806,7 @@ std::optional<BlockSpecification> Context::run_waf_end(
   ddwaf_result result;
   DDWAF_RET_CODE const code = ddwaf_run(ctx_.resource, resp_data, nullptr,
                                         &result, Library::waf_timeout());
+  record_waf_runtime(result, *request.connection->log);
   if (code == DDWAF_MATCH) {
     results_.emplace_back(result);
   } else {
@@ -728,10 +834,22 @@ void Context::do_on_main_log_request(ngx_http_request_t &request,
 
   set_header_tags(has_matches(), request, span);
   report_matches(request, span);
+  if (auto *sample = api_security_sample(request)) {
+    sample->report(request, span);
+  }
 }
 
 bool Context::has_matches() const noexcept { return !results_.empty(); }
 
+ApiSecuritySample *Context::api_security_sample(
+    const ngx_http_request_t &request) {
+  if (!api_security_decided_) {
+    api_security_decided_ = true;
+    api_security_sample_ = ApiSecuritySample::maybe_create(request);
+  }
+  return api_security_sample_ ? &*api_security_sample_ : nullptr;
+}
+
 void Context::report_matches(ngx_http_request_t &request, dd::Span &span) {
   if (results_.empty()) {
     return;
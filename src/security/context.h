@@ -8,8 +8,10 @@
 #include <memory>
 #include <optional>
 #include <stdexcept>
+#include <string_view>
 
 #include "../dd.h"
+#include "api_security.h"
 #include "blocking.h"
 #include "collection.h"
 #include "library.h"
@@ -52,8 +54,10 @@ class Context {
   Context(std::shared_ptr<OwnedDdwafHandle> waf_handle);
 
  public:
-  // returns a new context or an empty unique_ptr if the waf is not active
-  static std::unique_ptr<Context> maybe_create();
+  // returns a new context evaluating the ruleset having the specified
+  // `ruleset_name` (the default ruleset if empty), or an empty unique_ptr if
+  // the waf is not active
+  static std::unique_ptr<Context> maybe_create(std::string_view ruleset_name);
 
   bool on_request_start(ngx_http_request_t &request, dd::Span &span) noexcept;
   ngx_int_t output_body_filter(ngx_http_request_t &request, ngx_chain_t *chain,
@@ -75,11 +79,16 @@ class Context {
 
   bool has_matches() const noexcept;
   void report_matches(ngx_http_request_t &request, dd::Span &span);
+  // Return the API Security sample of the specified `request`, or null if
+  // the request is not sampled. The sampling decision is made on first use.
+  ApiSecuritySample *api_security_sample(const ngx_http_request_t &request);
 
   std::shared_ptr<OwnedDdwafHandle> waf_handle_;
   std::vector<OwnedDdwafResult> results_;
   OwnedDdwafContext ctx_{nullptr};
   DdwafMemres memres_;
+  bool api_security_decided_ = false;
+  std::optional<ApiSecuritySample> api_security_sample_;
 
   enum class stage {
     DISABLED,
@@ -12,16 +12,20 @@ extern "C" {
 #include <ddwaf.h>
 #include <rapidjson/schema.h>
 
+#include <chrono>
 #include <fstream>
 #include <numeric>
 #include <optional>
 #include <stdexcept>
 #include <string_view>
+#include <unordered_map>
+#include <unordered_set>
 #include <utility>
 
 #include "blocking.h"
 #include "context.h"
 #include "ddwaf_obj.h"
+#include "overload_breaker.h"
 #include "util.h"
 
 extern "C" {
@@ -223,12 +227,44 @@ std::string ddwaf_subdiagnostics_to_str(const dnsec::ddwaf_map_obj &top,
   ret += ")}";
   return ret;
 }
+
+// Return a WAF handle for the specified `ruleset`, which was read from the
+// specified `source`, using the specified `config`. Throw an exception if the
+// WAF rejects the ruleset.
+dnsec::OwnedDdwafHandle init_waf(dnsec::ddwaf_owned_map &ruleset,
+                                 const ddwaf_config &config,
+                                 std::string_view source) {
+  dnsec::libddwaf_ddwaf_owned_obj<dnsec::ddwaf_map_obj> diag{{}};
+  dnsec::OwnedDdwafHandle h{
+      ddwaf_init(&ruleset.get(), &config, &diag.get())};
+  if (!h.get()) {
+    throw std::runtime_error{"call to ddwaf_init failed:" +
+                             ddwaf_diagnostics_to_str(diag.get())};
+  }
+
+  if (ngx_cycle->log->log_level >= NGX_LOG_INFO) {
+    std::size_t num_loaded_rules =
+        diag.get()
+            .get_opt<dnsec::ddwaf_map_obj>("rules")
+            .value_or(dnsec::ddwaf_map_obj{})
+            .get_opt<dnsec::ddwaf_arr_obj>("loaded"sv)
+            .value_or(dnsec::ddwaf_arr_obj{})
+            .size();
+    ngx_str_t source_ngxs = dnsec::ngx_stringv(source);
+    ngx_log_error(NGX_LOG_INFO, ngx_cycle->log, 0,
+                  "AppSec loaded %uz rules from file %V", num_loaded_rules,
+                  &source_ngxs);
+  }
+
+  return h;
+}
 }  // namespace
 
 namespace datadog::nginx::security {
 
 class FinalizedConfigSettings {
   static constexpr ngx_uint_t kDefaultWafTimeoutUsec = 1000000;  // 100 ms
+  static constexpr ngx_uint_t kDefaultWafOverloadCooldownMsec = 10000;
   static constexpr std::string_view kDefaultObfuscationKeyRegex =
       "(?i)(?:p(?:ass)?w(?:or)?d|pass(?:_?phrase)?|secret|(?:api_?|private_?|"
       "public_?)key)|token|consumer_?(?:id|key|secret)|sign(?:ed|ature)|bearer|"
@@ -277,6 +313,10 @@ class FinalizedConfigSettings {
 
   auto waf_timeout() const { return waf_timeout_usec_; }
 
+  auto waf_overload_threshold() const { return waf_overload_threshold_usec_; }
+
+  auto waf_overload_cooldown() const { return waf_overload_cooldown_msec_; }
+
   const std::string &obfuscation_key_regex() const {
     return obfuscation_key_regex_;
   };
@@ -285,6 +325,12 @@ class FinalizedConfigSettings {
     return obfuscation_value_regex_;
   };
 
+  double api_security_sample_rate() const { return api_security_sample_rate_; }
+
+  const std::unordered_set<std::string> &body_redact_keys() const {
+    return body_redact_keys_;
+  }
+
  private:
   // NOLINTNEXTLINE(readability-identifier-naming)
   using ev_t = std::vector<environment_variable_t>;
@@ -294,6 +340,7 @@ class FinalizedConfigSettings {
   static std::optional<std::string> get_env_str(const ev_t &evs, std::string_view name);
   static std::optional<std::string> get_env_str_maybe_empty(const ev_t &evs, std::string_view name);
   static std::optional<ngx_uint_t> get_env_unsigned(const ev_t &evs, std::string_view name);
+  static std::optional<double> parse_sample_rate(std::string_view value);
   static std::string normalize_configured_header(std::string_view value);
   // clang-format on
 
@@ -322,8 +369,12 @@ class FinalizedConfigSettings {
   std::string blocked_template_json_;
   std::string blocked_template_html_;
   ngx_uint_t waf_timeout_usec_;
+  ngx_uint_t waf_overload_threshold_usec_;
+  ngx_uint_t waf_overload_cooldown_msec_;
   std::string obfuscation_key_regex_;
   std::string obfuscation_value_regex_;
+  double api_security_sample_rate_;
+  std::unordered_set<std::string> body_redact_keys_;
 };
 
 FinalizedConfigSettings::FinalizedConfigSettings(
@@ -382,6 +433,20 @@ FinalizedConfigSettings::FinalizedConfigSettings(
     waf_timeout_usec_ = ngx_conf.appsec_waf_timeout_ms * 1000;
   }
 
+  if (ngx_conf.appsec_waf_overload_threshold_us == NGX_CONF_UNSET) {
+    waf_overload_threshold_usec_ =
+        get_env_unsigned(evs, "DD_APPSEC_WAF_OVERLOAD_THRESHOLD"sv)
+            .value_or(0);
+  } else {
+    waf_overload_threshold_usec_ = ngx_conf.appsec_waf_overload_threshold_us;
+  }
+
+  if (ngx_conf.appsec_waf_overload_cooldown_ms == NGX_CONF_UNSET_MSEC) {
+    waf_overload_cooldown_msec_ = kDefaultWafOverloadCooldownMsec;
+  } else {
+    waf_overload_cooldown_msec_ = ngx_conf.appsec_waf_overload_cooldown_ms;
+  }
+
   if (ngx_conf.appsec_obfuscation_key_regex.data != nullptr) {
     obfuscation_key_regex_ =
         to_string_view(ngx_conf.appsec_obfuscation_key_regex);
@@ -401,6 +466,24 @@ FinalizedConfigSettings::FinalizedConfigSettings(
             evs, "DD_APPSEC_OBFUSCATION_PARAMETER_VALUE_REGEXP"sv)
             .value_or(std::string{kDefaultObfuscationValueRegex});
   }
+
+  if (ngx_conf.appsec_api_security_sample_rate.len > 0) {
+    auto value = to_string_view(ngx_conf.appsec_api_security_sample_rate);
+    auto maybe_rate = parse_sample_rate(value);
+    if (!maybe_rate) {
+      throw std::invalid_argument{
+          "invalid value for datadog_appsec_api_security_sample_rate: \"" +
+          std::string{value} + "\" (expected a number between 0 and 1)"};
+    }
+    api_security_sample_rate_ = *maybe_rate;
+  } else {
+    auto value = get_env(evs, "DD_API_SECURITY_REQUEST_SAMPLE_RATE"sv);
+    api_security_sample_rate_ =
+        value ? parse_sample_rate(*value).value_or(0.0) : 0.0;
+  }
+
+  body_redact_keys_.insert(ngx_conf.appsec_body_redact_keys.begin(),
+                           ngx_conf.appsec_body_redact_keys.end());
 }
 
 std::optional<bool> FinalizedConfigSettings::get_env_bool(
@@ -452,6 +535,17 @@ std::optional<ngx_uint_t> FinalizedConfigSettings::get_env_unsigned(
   return static_cast<ngx_uint_t>(value_int);
 }
 
+std::optional<double> FinalizedConfigSettings::parse_sample_rate(
+    std::string_view value) {
+  std::string value_str{value};
+  char *end;
+  double rate = std::strtod(value_str.c_str(), &end);
+  if (value_str.empty() || *end != '\0' || !(rate >= 0.0 && rate <= 1.0)) {
+    return std::nullopt;
+  }
+  return rate;
+}
+
 std::string FinalizedConfigSettings::normalize_configured_header(
     std::string_view value) {
   // lowercase all the characters and replace _ with -
@@ -472,6 +566,9 @@ std::string FinalizedConfigSettings::normalize_configured_header(
 std::shared_ptr<OwnedDdwafHandle> Library::handle_{nullptr};
 std::atomic<bool> Library::active_{true};
 std::unique_ptr<FinalizedConfigSettings> Library::config_settings_;
+std::unique_ptr<OverloadBreaker> Library::overload_breaker_;
+std::unordered_map<std::string, std::shared_ptr<OwnedDdwafHandle>>
+    Library::named_handles_;
 
 std::optional<ddwaf_owned_map> Library::initialize_security_library(
     const datadog_main_conf_t &ngx_conf) {
@@ -495,38 +592,28 @@ std::optional<ddwaf_owned_map> Library::initialize_security_library(
       conf.appsec_obfuscation_value_regex().c_str();
 
   ddwaf_owned_map ruleset = read_ruleset(conf.ruleset_file());
-  libddwaf_ddwaf_owned_obj<ddwaf_map_obj> diag{{}};
-  OwnedDdwafHandle h =
-      OwnedDdwafHandle{ddwaf_init(&ruleset.get(), &waf_config, &diag.get())};
-  if (!h.get()) {
-    throw std::runtime_error{"call to ddwaf_init failed:" +
-                             ddwaf_diagnostics_to_str(diag.get())};
-  }
+  OwnedDdwafHandle h = init_waf(
+      ruleset, waf_config, conf.ruleset_file().value_or("embedded ruleset"sv));
+  Library::handle_ = std::make_shared<OwnedDdwafHandle>(std::move(h));
 
-  if (ngx_cycle->log->log_level >= NGX_LOG_INFO) {
-    std::size_t num_loaded_rules =
-        diag.get()
-            .get_opt<dnsec::ddwaf_map_obj>("rules")
-            .value_or(dnsec::ddwaf_map_obj{})
-            .get_opt<dnsec::ddwaf_arr_obj>("loaded"sv)
-            .value_or(dnsec::ddwaf_arr_obj{})
-            .size();
-    ngx_str_t source;
-    if (auto rsf = conf.ruleset_file()) {
-      source = ngx_stringv(*rsf);
-    } else {
-      source = ngx_stringv("embedded ruleset"sv);
-    }
-    ngx_log_error(NGX_LOG_INFO, ngx_cycle->log, 0,
-                  "AppSec loaded %uz rules from file %V", num_loaded_rules,
-                  &source);
+  // Each named ruleset is compiled once, and shared by all of the locations
+  // that select it.
+  named_handles_.clear();
+  for (const auto &[name, file] : ngx_conf.appsec_named_rulesets) {
+    ddwaf_owned_map named_ruleset = read_ruleset(file);
+    named_handles_.emplace(name, std::make_shared<OwnedDdwafHandle>(init_waf(
+                                     named_ruleset, waf_config, file)));
   }
 
-  Library::handle_ = std::make_shared<OwnedDdwafHandle>(std::move(h));
-
   BlockingService::initialize(conf.blocked_template_html(),
                               conf.blocked_template_json());
 
+  if (conf.waf_overload_threshold() != 0) {
+    Library::overload_breaker_ = std::make_unique<OverloadBreaker>(
+        std::chrono::microseconds{conf.waf_overload_threshold()},
+        std::chrono::milliseconds{conf.waf_overload_cooldown()});
+  }
+
   Library::set_active(conf.enable_status() ==
                       FinalizedConfigSettings::enable_status::ENABLED);
 
@@ -558,6 +645,21 @@ std::shared_ptr<OwnedDdwafHandle> Library::get_handle() {
   return {};
 }
 
+std::shared_ptr<OwnedDdwafHandle> Library::get_named_handle(
+    std::string_view ruleset_name) {
+  if (ruleset_name.empty()) {
+    return get_handle();
+  }
+  if (!active_.load(std::memory_order_relaxed)) {
+    return {};
+  }
+  auto it = named_handles_.find(std::string{ruleset_name});
+  if (it == named_handles_.end()) {
+    return {};
+  }
+  return it->second;
+}
+
 std::shared_ptr<OwnedDdwafHandle> Library::get_handle_uncond() {
   return std::atomic_load_explicit(&Library::handle_,
                                    std::memory_order_acquire);
@@ -571,6 +673,18 @@ std::uint64_t Library::waf_timeout() {
   return static_cast<std::uint64_t>(config_settings_->waf_timeout());
 }
 
+OverloadBreaker *Library::overload_breaker() {
+  return overload_breaker_.get();
+}
+
+const std::unordered_set<std::string> &Library::appsec_body_redact_keys() {
+  return config_settings_->body_redact_keys();
+}
+
+double Library::api_security_sample_rate() {
+  return config_settings_->api_security_sample_rate();
+}
+
 std::vector<std::string_view> Library::environment_variable_names() {
   return {"DD_APPSEC_ENABLED"sv,
           "DD_APPSEC_RULES"sv,
@@ -579,7 +693,9 @@ std::vector<std::string_view> Library::environment_variable_names() {
           "DD_TRACE_CLIENT_IP_HEADER"sv,
           "DD_APPSEC_WAF_TIMEOUT"sv,
           "DD_APPSEC_OBFUSCATION_PARAMETER_KEY_REGEXP"sv,
-          "DD_APPSEC_OBFUSCATION_PARAMETER_VALUE_REGEXP"sv};
+          "DD_APPSEC_OBFUSCATION_PARAMETER_VALUE_REGEXP"sv,
+          "DD_API_SECURITY_REQUEST_SAMPLE_RATE"sv,
+          "DD_APPSEC_WAF_OVERLOAD_THRESHOLD"sv};
 }
 
 }  // namespace datadog::nginx::security
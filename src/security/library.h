@@ -6,9 +6,12 @@
 #include <memory>
 #include <string>
 #include <string_view>
+#include <unordered_map>
+#include <unordered_set>
 
 #include "../datadog_conf.h"
 #include "ddwaf_obj.h"
+#include "overload_breaker.h"
 
 namespace datadog::nginx::security {
 
@@ -30,6 +33,12 @@ class Library {
   // returns the handle if active, otherwise an empty shared_ptr
   static std::shared_ptr<OwnedDdwafHandle> get_handle();
 
+  // returns the handle of the ruleset defined under the specified
+  // `ruleset_name` by `datadog_appsec_named_ruleset_file` if active, otherwise
+  // an empty shared_ptr. An empty `ruleset_name` denotes the default ruleset.
+  static std::shared_ptr<OwnedDdwafHandle> get_named_handle(
+      std::string_view ruleset_name);
+
   // returns the handle unconditionally. It can still be an empty shared_ptr
   static std::shared_ptr<OwnedDdwafHandle> get_handle_uncond();
 
@@ -38,6 +47,13 @@ class Library {
 
   static std::optional<HashedStringView> custom_ip_header();
   static std::uint64_t waf_timeout();
+  // The WAF's overload circuit breaker, or nullptr if it is disabled.
+  static OverloadBreaker *overload_breaker();
+  // The fraction of requests to each endpoint whose schema is reported by
+  // API Security. Zero disables API Security.
+  static double api_security_sample_rate();
+  // The lowercased JSON keys whose values are redacted from request bodies.
+  static const std::unordered_set<std::string> &appsec_body_redact_keys();
 
   static std::vector<std::string_view> environment_variable_names();
 
@@ -48,6 +64,10 @@ class Library {
   static std::shared_ptr<OwnedDdwafHandle> handle_;                  // NOLINT
   static std::atomic<bool> active_;                                  // NOLINT
   static std::unique_ptr<FinalizedConfigSettings> config_settings_;  // NOLINT
+  static std::unique_ptr<OverloadBreaker> overload_breaker_;         // NOLINT
+  // set once, when the library is initialized
+  static std::unordered_map<std::string, std::shared_ptr<OwnedDdwafHandle>>
+      named_handles_;  // NOLINT
 };
 
 struct DdwafHandleFreeFunctor {
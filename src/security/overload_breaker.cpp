@@ -0,0 +1,65 @@
+#include "overload_breaker.h"
+
+namespace datadog::nginx::security {
+namespace {
+
+// The weight of the most recent run time in the moving average.  Recent runs
+// dominate, so that the breaker reacts within a few slow runs, while a single
+// outlier does not trip it.
+constexpr double kSmoothingFactor = 0.125;
+
+}  // namespace
+
+OverloadBreaker::OverloadBreaker(std::chrono::microseconds threshold,
+                                 std::chrono::milliseconds cooldown)
+    : threshold_{threshold}, cooldown_{cooldown} {}
+
+OverloadBreaker::Decision OverloadBreaker::decide(clock::time_point now) {
+  if (threshold_.count() == 0) {
+    return Decision::RUN;
+  }
+
+  std::lock_guard<std::mutex> lock{mutex_};
+  if (!open_) {
+    return Decision::RUN;
+  }
+  if (now >= open_until_) {
+    // The cooldown has elapsed. Start over with a fresh average.
+    open_ = false;
+    average_ns_ = 0;
+    return Decision::RUN_AFTER_COOLDOWN;
+  }
+  ++skipped_;
+  return Decision::SKIP;
+}
+
+bool OverloadBreaker::record_run(std::chrono::nanoseconds runtime,
+                                 clock::time_point now) {
+  if (threshold_.count() == 0) {
+    return false;
+  }
+
+  std::lock_guard<std::mutex> lock{mutex_};
+  average_ns_ += kSmoothingFactor * (double(runtime.count()) - average_ns_);
+  if (open_ || average_ns_ <= double(threshold_.count())) {
+    return false;
+  }
+  open_ = true;
+  open_until_ = now + cooldown_;
+  return true;
+}
+
+std::chrono::microseconds OverloadBreaker::average_runtime() const {
+  std::lock_guard<std::mutex> lock{mutex_};
+  return std::chrono::duration_cast<std::chrono::microseconds>(
+      std::chrono::nanoseconds{static_cast<std::int64_t>(average_ns_)});
+}
+
+std::uint64_t OverloadBreaker::take_skipped_count() {
+  std::lock_guard<std::mutex> lock{mutex_};
+  const std::uint64_t skipped = skipped_;
+  skipped_ = 0;
+  return skipped;
+}
+
+}  // namespace datadog::nginx::security
@@ -0,0 +1,65 @@
+#pragma once
+
+#include <chrono>
+#include <cstdint>
+#include <mutex>
+
+namespace datadog::nginx::security {
+
+// `OverloadBreaker` is a latency-based circuit breaker for the WAF.  It keeps
+// a moving average of the WAF's run times.  When the average exceeds a
+// threshold, the breaker opens: requests are not evaluated by the WAF (they
+// "fail open") until a cooldown period has elapsed, after which the breaker
+// closes and the average starts over.
+//
+// WAF runs happen on thread pool threads, while requests start on the main
+// thread of the worker process, so the breaker's methods are thread-safe.
+class OverloadBreaker {
+ public:
+  using clock = std::chrono::steady_clock;
+
+  // Create a breaker that opens when the average WAF run time exceeds the
+  // specified `threshold`, and that stays open for the specified `cooldown`.
+  // A zero `threshold` disables the breaker.
+  OverloadBreaker(std::chrono::microseconds threshold,
+                  std::chrono::milliseconds cooldown);
+
+  enum class Decision {
+    // Evaluate the request.
+    RUN,
+    // Evaluate the request.  The breaker was open, and has just closed.
+    RUN_AFTER_COOLDOWN,
+    // Do not evaluate the request.  It is counted as skipped.
+    SKIP,
+  };
+
+  // Return whether the WAF should evaluate a request starting at the
+  // specified `now`.
+  Decision decide(clock::time_point now);
+
+  // Record that a WAF run finishing at the specified `now` took the specified
+  // `runtime`.  Return whether this opened the breaker.
+  bool record_run(std::chrono::nanoseconds runtime, clock::time_point now);
+
+  // Return the average WAF run time, as of the last recorded run.
+  std::chrono::microseconds average_runtime() const;
+
+  // Return the number of requests skipped since the count was last taken, and
+  // reset the count.
+  std::uint64_t take_skipped_count();
+
+  std::chrono::milliseconds cooldown() const { return cooldown_; }
+
+ private:
+  const std::chrono::nanoseconds threshold_;
+  const std::chrono::milliseconds cooldown_;
+
+  mutable std::mutex mutex_;
+  // Exponentially weighted moving average of the WAF's run times.
+  double average_ns_ = 0;
+  bool open_ = false;
+  clock::time_point open_until_;
+  std::uint64_t skipped_ = 0;
+};
+
+}  // namespace datadog::nginx::security
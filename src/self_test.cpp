@@ -0,0 +1,130 @@
+#include "self_test.h"
+
+#include <datadog/dict_reader.h>
+#include <datadog/dict_writer.h>
+#include <datadog/error.h>
+#include <datadog/null_collector.h>
+#include <datadog/propagation_style.h>
+#include <datadog/span.h>
+#include <datadog/span_config.h>
+#include <datadog/tracer.h>
+#include <datadog/tracer_config.h>
+
+#include <functional>
+#include <optional>
+#include <string>
+#include <string_view>
+#include <unordered_map>
+#include <utility>
+
+namespace datadog {
+namespace nginx {
+namespace {
+
+using Headers = std::unordered_map<std::string, std::string>;
+
+class HeadersReader : public dd::DictReader {
+  const Headers &headers_;
+
+ public:
+  explicit HeadersReader(const Headers &headers) : headers_(headers) {}
+
+  std::optional<std::string_view> lookup(std::string_view key) const override {
+    const auto found = headers_.find(std::string(key));
+    if (found == headers_.end()) {
+      return std::nullopt;
+    }
+    return found->second;
+  }
+
+  void visit(
+      const std::function<void(std::string_view key, std::string_view value)>
+          &visitor) const override {
+    for (const auto &[key, value] : headers_) {
+      visitor(key, value);
+    }
+  }
+};
+
+class HeadersWriter : public dd::DictWriter {
+  Headers &headers_;
+
+ public:
+  explicit HeadersWriter(Headers &headers) : headers_(headers) {}
+
+  void set(std::string_view key, std::string_view value) override {
+    headers_.insert_or_assign(std::string(key), std::string(value));
+  }
+};
+
+dd::Error self_test_error(std::string message) {
+  return dd::Error{dd::Error::OTHER, std::move(message)};
+}
+
+}  // namespace
+
+dd::Expected<void> run_self_test(std::shared_ptr<dd::Logger> logger) {
+  dd::TracerConfig config;
+  config.logger = std::move(logger);
+  config.service = "nginx";
+  config.report_traces = false;
+  config.report_telemetry = false;
+  config.injection_styles = config.extraction_styles = {
+      dd::PropagationStyle::DATADOG, dd::PropagationStyle::W3C};
+
+  auto final_config = dd::finalize_config(config);
+  if (auto *error = final_config.if_error()) {
+    return self_test_error("unable to configure the tracer: " +
+                           error->message);
+  }
+  // Never send anything to the Datadog Agent, regardless of the environment.
+  final_config->collector = std::make_shared<dd::NullCollector>();
+  dd::Tracer tracer{*final_config};
+
+  // This is the trace context that a client might send to nginx.
+  const Headers request_headers{{"x-datadog-trace-id", "1234567890"},
+                                {"x-datadog-parent-id", "987654321"},
+                                {"x-datadog-sampling-priority", "1"}};
+  HeadersReader reader{request_headers};
+  dd::SpanConfig span_config;
+  span_config.name = "nginx.self_test";
+  auto maybe_span = tracer.extract_span(reader, span_config);
+  if (auto *error = maybe_span.if_error()) {
+    return self_test_error("unable to extract trace context: " +
+                           error->message);
+  }
+  dd::Span &span = *maybe_span;
+  if (span.trace_id().low != 1234567890) {
+    return self_test_error(
+        "extracted span does not belong to the incoming trace");
+  }
+  if (span.parent_id() != 987654321) {
+    return self_test_error(
+        "extracted span is not a child of the incoming span");
+  }
+
+  // This is the trace context that nginx would send to the upstream.
+  Headers upstream_headers;
+  HeadersWriter writer{upstream_headers};
+  span.inject(writer);
+
+  const auto trace_id = reader.lookup("x-datadog-trace-id");
+  if (HeadersReader{upstream_headers}.lookup("x-datadog-trace-id") !=
+      trace_id) {
+    return self_test_error(
+        "injected x-datadog-trace-id does not match the incoming trace");
+  }
+  if (HeadersReader{upstream_headers}.lookup("x-datadog-parent-id") !=
+      std::to_string(span.id())) {
+    return self_test_error(
+        "injected x-datadog-parent-id does not match the nginx span");
+  }
+  if (!upstream_headers.count("traceparent")) {
+    return self_test_error("traceparent header was not injected");
+  }
+
+  return {};
+}
+
+}  // namespace nginx
+}  // namespace datadog
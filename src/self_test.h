@@ -0,0 +1,27 @@
+#pragma once
+
+// This component provides a function, `run_self_test`, that exercises the
+// tracing library the way that a request would, but without nginx handling any
+// request and without sending anything to the Datadog Agent. It is used by the
+// `datadog_self_test` configuration directive, so that operators can verify
+// that the module is installed and functional by running `nginx -t`.
+
+#include <datadog/expected.h>
+#include <datadog/logger.h>
+
+#include <memory>
+
+#include "dd.h"
+
+namespace datadog {
+namespace nginx {
+
+// Extract trace context from a canned request, create a span in that trace,
+// and inject the span's context into the headers of a would-be upstream
+// request. Return an error describing the first step that did not behave as
+// expected, or return a non-error value if all steps succeeded. Use the
+// specified `logger` for any diagnostics produced by the tracer.
+dd::Expected<void> run_self_test(std::shared_ptr<dd::Logger> logger);
+
+}  // namespace nginx
+}  // namespace datadog
@@ -0,0 +1,42 @@
+#include "span_event.h"
+
+#include <datadog/json.hpp>
+#include <datadog/span.h>
+
+#include <string_view>
+
+namespace datadog {
+namespace nginx {
+namespace {
+
+constexpr std::string_view events_tag = "events";
+
+}  // namespace
+
+void add_span_event(dd::Span& span, const SpanEvent& event) {
+  auto events = nlohmann::json::array();
+  if (auto existing = span.lookup_tag(events_tag)) {
+    auto parsed = nlohmann::json::parse(*existing, nullptr, false);
+    if (parsed.is_array()) {
+      events = std::move(parsed);
+    }
+  }
+
+  auto attributes = nlohmann::json::object();
+  for (const auto& [key, value] : event.attributes) {
+    attributes[key] = value;
+  }
+
+  const auto since_epoch = event.time.time_since_epoch();
+  events.push_back(nlohmann::json::object(
+      {{"name", event.name},
+       {"time_unix_nano",
+        std::chrono::duration_cast<std::chrono::nanoseconds>(since_epoch)
+            .count()},
+       {"attributes", std::move(attributes)}}));
+
+  span.set_tag(events_tag, events.dump());
+}
+
+}  // namespace nginx
+}  // namespace datadog
@@ -0,0 +1,33 @@
+#pragma once
+
+// This component provides a `struct`, `SpanEvent`, and a function,
+// `add_span_event`, for attaching timestamped events to a span. A span event
+// marks something that happened at a particular moment during the span, such
+// as the WAF blocking a request, so that it appears on the trace timeline.
+//
+// The tracing library does not have a native notion of span events, so the
+// events are encoded as a JSON array in the span's "events" tag, which is the
+// representation understood by the Datadog Agent.
+
+#include <chrono>
+#include <string>
+#include <utility>
+#include <vector>
+
+#include "dd.h"
+
+namespace datadog {
+namespace nginx {
+
+struct SpanEvent {
+  std::string name;
+  std::chrono::system_clock::time_point time;
+  std::vector<std::pair<std::string, std::string>> attributes;
+};
+
+// Append the specified `event` to the events of the specified `span`. Events
+// previously added to `span` are preserved.
+void add_span_event(dd::Span& span, const SpanEvent& event);
+
+}  // namespace nginx
+}  // namespace datadog
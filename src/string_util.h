@@ -65,6 +65,15 @@ inline bool starts_with(const std::string_view& subject,
          prefix.end();
 }
 
+inline bool ends_with(const std::string_view& subject,
+                      const std::string_view& suffix) {
+  if (suffix.size() > subject.size()) {
+    return false;
+  }
+
+  return subject.substr(subject.size() - suffix.size()) == suffix;
+}
+
 inline std::string_view slice(const std::string_view& text, int begin,
                               int end) {
   if (begin < 0) {
@@ -80,5 +89,15 @@ inline std::string_view slice(const std::string_view& text, int begin) {
   return slice(text, begin, text.size());
 }
 
+// Return the specified `text` without leading and trailing spaces and tabs.
+inline std::string_view trim(std::string_view text) {
+  const auto begin = text.find_first_not_of(" \t");
+  if (begin == std::string_view::npos) {
+    return {};
+  }
+  const auto end = text.find_last_not_of(" \t");
+  return text.substr(begin, end - begin + 1);
+}
+
 }  // namespace nginx
 }  // namespace datadog
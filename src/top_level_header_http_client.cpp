@@ -0,0 +1,60 @@
+#include "top_level_header_http_client.h"
+
+#include <datadog/dict_writer.h>
+
+#include <datadog/json.hpp>
+#include <string_view>
+#include <utility>
+
+#include "string_util.h"
+
+namespace datadog {
+namespace nginx {
+namespace {
+
+// Trace submissions are sent to a path ending with "/traces", e.g.
+// "/v0.4/traces", or "/v0.3/traces" if the `datadog_trace_api_version`
+// directive is used.
+constexpr std::string_view traces_path_suffix = "/traces";
+
+}  // namespace
+
+TopLevelHeaderHTTPClient::TopLevelHeaderHTTPClient(
+    std::shared_ptr<dd::HTTPClient> delegate)
+    : delegate_(std::move(delegate)) {}
+
+dd::Expected<void> TopLevelHeaderHTTPClient::post(
+    const URL& url, HeadersSetter set_headers, std::string body,
+    ResponseHandler on_response, ErrorHandler on_error,
+    std::chrono::steady_clock::time_point deadline) {
+  // Requests other than trace submissions, e.g. remote configuration, are
+  // passed through unmodified.
+  if (!ends_with(url.path, traces_path_suffix)) {
+    return delegate_->post(url, std::move(set_headers), std::move(body),
+                           std::move(on_response), std::move(on_error),
+                           deadline);
+  }
+
+  auto with_top_level_header = [set_headers = std::move(set_headers)](
+                                   dd::DictWriter& headers) {
+    set_headers(headers);
+    headers.set("Datadog-Client-Computed-Top-Level", "yes");
+  };
+
+  return delegate_->post(url, std::move(with_top_level_header),
+                         std::move(body), std::move(on_response),
+                         std::move(on_error), deadline);
+}
+
+void TopLevelHeaderHTTPClient::drain(
+    std::chrono::steady_clock::time_point deadline) {
+  delegate_->drain(deadline);
+}
+
+nlohmann::json TopLevelHeaderHTTPClient::config_json() const {
+  return nlohmann::json::object({{"type", "TopLevelHeaderHTTPClient"},
+                                 {"delegate", delegate_->config_json()}});
+}
+
+}  // namespace nginx
+}  // namespace datadog
@@ -0,0 +1,41 @@
+#pragma once
+
+// This component provides a `class`, `TopLevelHeaderHTTPClient`, that
+// decorates another `dd::HTTPClient`. The tracer marks which spans are
+// "top-level" (the local root span, and spans whose service differs from that
+// of their parent) with the "_dd.top_level" metric. When a trace submission
+// carries the "Datadog-Client-Computed-Top-Level" header, the Datadog Agent
+// uses those marks rather than computing top-level spans itself.
+// `TopLevelHeaderHTTPClient` adds that header to trace submissions.
+
+#include <datadog/http_client.h>
+
+#include <chrono>
+#include <memory>
+
+#include "dd.h"
+
+namespace datadog {
+namespace nginx {
+
+class TopLevelHeaderHTTPClient : public dd::HTTPClient {
+  std::shared_ptr<dd::HTTPClient> delegate_;
+
+ public:
+  // Send requests using the specified `delegate`, adding the
+  // "Datadog-Client-Computed-Top-Level" header to trace submissions.
+  explicit TopLevelHeaderHTTPClient(std::shared_ptr<dd::HTTPClient> delegate);
+
+  dd::Expected<void> post(const URL& url, HeadersSetter set_headers,
+                          std::string body, ResponseHandler on_response,
+                          ErrorHandler on_error,
+                          std::chrono::steady_clock::time_point deadline)
+      override;
+
+  void drain(std::chrono::steady_clock::time_point deadline) override;
+
+  nlohmann::json config_json() const override;
+};
+
+}  // namespace nginx
+}  // namespace datadog
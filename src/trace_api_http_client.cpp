@@ -0,0 +1,80 @@
+#include "trace_api_http_client.h"
+
+#include <datadog/dict_reader.h>
+
+#include <datadog/json.hpp>
+#include <utility>
+
+#include "string_util.h"
+
+namespace datadog {
+namespace nginx {
+namespace {
+
+// This is the path to which the tracer sends traces.
+constexpr std::string_view default_traces_path = "/v0.4/traces";
+
+}  // namespace
+
+TraceAPIHTTPClient::TraceAPIHTTPClient(
+    std::shared_ptr<dd::HTTPClient> delegate,
+    std::shared_ptr<dd::Logger> logger, std::string_view api_version)
+    : delegate_(std::move(delegate)), logger_(std::move(logger)) {
+  traces_path_ += '/';
+  traces_path_.append(api_version.data(), api_version.size());
+  traces_path_ += "/traces";
+}
+
+dd::Expected<void> TraceAPIHTTPClient::post(
+    const URL& url, HeadersSetter set_headers, std::string body,
+    ResponseHandler on_response, ErrorHandler on_error,
+    std::chrono::steady_clock::time_point deadline) {
+  // Requests other than trace submissions, e.g. remote configuration, are
+  // passed through unmodified. So are trace submissions if the configured
+  // version is the default.
+  if (!ends_with(url.path, default_traces_path) ||
+      traces_path_ == default_traces_path) {
+    return delegate_->post(url, std::move(set_headers), std::move(body),
+                           std::move(on_response), std::move(on_error),
+                           deadline);
+  }
+
+  URL redirected = url;
+  redirected.path.replace(url.path.size() - default_traces_path.size(),
+                          default_traces_path.size(), traces_path_);
+
+  auto adapted_on_response = [on_response = std::move(on_response),
+                              logger = logger_, path = traces_path_](
+                                 int status, const dd::DictReader& headers,
+                                 std::string response_body) {
+    if (status == 404) {
+      logger->log_error([&](std::ostream& log) {
+        log << "The Datadog Agent does not support the " << path
+            << " endpoint. Check the datadog_trace_api_version directive.";
+      });
+    } else if (status >= 200 && status < 300) {
+      // Older trace API versions respond with "OK" rather than with a JSON
+      // object of sample rates. The tracer expects the latter, so give it an
+      // empty object.
+      response_body = "{}";
+    }
+    on_response(status, headers, std::move(response_body));
+  };
+
+  return delegate_->post(redirected, std::move(set_headers), std::move(body),
+                         std::move(adapted_on_response), std::move(on_error),
+                         deadline);
+}
+
+void TraceAPIHTTPClient::drain(std::chrono::steady_clock::time_point deadline) {
+  delegate_->drain(deadline);
+}
+
+nlohmann::json TraceAPIHTTPClient::config_json() const {
+  return nlohmann::json::object({{"type", "TraceAPIHTTPClient"},
+                                 {"traces_path", traces_path_},
+                                 {"delegate", delegate_->config_json()}});
+}
+
+}  // namespace nginx
+}  // namespace datadog
@@ -0,0 +1,49 @@
+#pragma once
+
+// This component provides a `class`, `TraceAPIHTTPClient`, that decorates
+// another `dd::HTTPClient`. The tracer sends traces to the Datadog Agent's
+// "/v0.4/traces" endpoint. Older agents accept only "/v0.3/traces", whose
+// request payload has the same shape, but whose response does not contain
+// per-service sample rates. `TraceAPIHTTPClient` redirects trace submissions
+// to the configured endpoint, and adapts the agent's responses accordingly.
+
+#include <datadog/http_client.h>
+#include <datadog/logger.h>
+
+#include <chrono>
+#include <memory>
+#include <string>
+
+#include "dd.h"
+
+namespace datadog {
+namespace nginx {
+
+class TraceAPIHTTPClient : public dd::HTTPClient {
+  std::shared_ptr<dd::HTTPClient> delegate_;
+  std::shared_ptr<dd::Logger> logger_;
+  // `traces_path` is the path of the agent's traces endpoint that we send
+  // traces to, e.g. "/v0.3/traces".
+  std::string traces_path_;
+
+ public:
+  // Send trace submissions to the traces endpoint of the specified
+  // `api_version`, e.g. "v0.3", using the specified `delegate`. Log problems
+  // to the specified `logger`.
+  TraceAPIHTTPClient(std::shared_ptr<dd::HTTPClient> delegate,
+                     std::shared_ptr<dd::Logger> logger,
+                     std::string_view api_version);
+
+  dd::Expected<void> post(const URL& url, HeadersSetter set_headers,
+                          std::string body, ResponseHandler on_response,
+                          ErrorHandler on_error,
+                          std::chrono::steady_clock::time_point deadline)
+      override;
+
+  void drain(std::chrono::steady_clock::time_point deadline) override;
+
+  nlohmann::json config_json() const override;
+};
+
+}  // namespace nginx
+}  // namespace datadog
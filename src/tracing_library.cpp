@@ -1,33 +1,156 @@
 #include "tracing_library.h"
 
+#include <datadog/clock.h>
+#include <datadog/datadog_agent_config.h>
+#include <datadog/default_http_client.h>
 #include <datadog/dict_writer.h>
 #include <datadog/environment.h>
 #include <datadog/error.h>
 #include <datadog/expected.h>
+#include <datadog/http_client.h>
+#include <datadog/null_collector.h>
 #include <datadog/span.h>
 #include <datadog/tracer.h>
 #include <datadog/tracer_config.h>
 
 #include <algorithm>
 #include <cassert>
+#include <chrono>
 #include <datadog/json.hpp>
 #include <iterator>
 #include <ostream>
+#include <variant>
 
+#include "agent_headers_http_client.h"
+#include "agent_tls_http_client.h"
 #include "datadog_conf.h"
 #include "dd.h"
 #include "ngx_event_scheduler.h"
 #include "ngx_logger.h"
+#include "min_trace_duration_http_client.h"
+#include "rejected_spans_http_client.h"
 #include "string_util.h"
+#include "top_level_header_http_client.h"
+#include "trace_api_http_client.h"
+#include "zipkin_http_client.h"
 
 namespace datadog {
 namespace nginx {
 
+namespace {
+
+// `UnusedHTTPClient` is the HTTP client of a tracer that never sends traces.
+// Without it, the tracer would create its default HTTP client, which runs a
+// thread of its own.
+class UnusedHTTPClient : public dd::HTTPClient {
+ public:
+  dd::Expected<void> post(const URL &, HeadersSetter, std::string,
+                          ResponseHandler, ErrorHandler,
+                          std::chrono::steady_clock::time_point) override {
+    return dd::Error{dd::Error::OTHER,
+                     "The non-reporting tracer does not send requests."};
+  }
+
+  void drain(std::chrono::steady_clock::time_point) override {}
+
+  nlohmann::json config_json() const override {
+    return nlohmann::json::object({{"type", "UnusedHTTPClient"}});
+  }
+};
+
+// Set the HTTP client in the specified `config` to the one that the
+// specified `nginx_conf` calls for, decorating the tracer's default client as
+// needed. Return an error if `nginx_conf` is invalid.
+dd::Expected<void> configure_http_client(const datadog_main_conf_t &nginx_conf,
+                                         dd::TracerConfig &config) {
+  const bool has_agent_tls_settings =
+      nginx_conf.agent_ca_file.len != 0 ||
+      nginx_conf.agent_certificate.len != 0 ||
+      nginx_conf.agent_certificate_key.len != 0 ||
+      nginx_conf.agent_verify_certificate != NGX_CONF_UNSET;
+  if (nginx_conf.zipkin_endpoint) {
+    auto url =
+        dd::DatadogAgentConfig::parse_url(nginx_conf.zipkin_endpoint->value);
+    if (!url) {
+      return url.error();
+    }
+    config.agent.http_client = std::make_shared<ZipkinHTTPClient>(
+        dd::default_http_client(config.logger, dd::default_clock),
+        config.logger, *url);
+    // There is no Datadog Agent to poll for remote configuration or to
+    // receive telemetry.
+    config.agent.remote_configuration_enabled = false;
+    config.report_telemetry = false;
+  } else if (nginx_conf.trace_api_version.len != 0 ||
+             nginx_conf.client_computed_top_level != 0 ||
+             !nginx_conf.agent_headers.empty() || has_agent_tls_settings) {
+    std::shared_ptr<dd::HTTPClient> http_client;
+    if (has_agent_tls_settings) {
+      AgentTLSSettings tls;
+      tls.ca_file = str(nginx_conf.agent_ca_file);
+      tls.certificate = str(nginx_conf.agent_certificate);
+      tls.certificate_key = str(nginx_conf.agent_certificate_key);
+      // `NGX_CONF_UNSET` is nonzero, so verification is on by default.
+      tls.verify = nginx_conf.agent_verify_certificate != 0;
+      http_client = std::make_shared<AgentTLSHTTPClient>(
+          config.logger, dd::default_clock, tls);
+    } else {
+      http_client = dd::default_http_client(config.logger, dd::default_clock);
+    }
+    if (!nginx_conf.agent_headers.empty()) {
+      http_client = std::make_shared<AgentHeadersHTTPClient>(
+          std::move(http_client), nginx_conf.agent_headers);
+    }
+    if (nginx_conf.trace_api_version.len != 0) {
+      http_client = std::make_shared<TraceAPIHTTPClient>(
+          std::move(http_client), config.logger,
+          str(nginx_conf.trace_api_version));
+    }
+    // `NGX_CONF_UNSET` is nonzero, so this is on by default.
+    if (nginx_conf.client_computed_top_level != 0) {
+      http_client =
+          std::make_shared<TopLevelHeaderHTTPClient>(std::move(http_client));
+    }
+    config.agent.http_client = std::move(http_client);
+  }
+  if (!nginx_conf.zipkin_endpoint) {
+    // Count the spans that the Datadog Agent rejects, whichever client sends
+    // them.
+    auto http_client = config.agent.http_client;
+    if (!http_client) {
+      http_client = dd::default_http_client(config.logger, dd::default_clock);
+    }
+    config.agent.http_client = std::make_shared<RejectedSpansHTTPClient>(
+        std::move(http_client), config.logger);
+  }
+  // Withhold the traces of requests that were shorter than their
+  // `datadog_min_trace_duration`. This comes first, so that the other clients
+  // see only the traces that are sent. An HTTP client was chosen above in
+  // every case.
+  config.agent.http_client = std::make_shared<MinTraceDurationHTTPClient>(
+      std::move(config.agent.http_client));
+  return {};
+}
+
+}  // namespace
+
 dd::Expected<dd::Tracer> TracingLibrary::make_tracer(
-    const datadog_main_conf_t &nginx_conf, std::shared_ptr<dd::Logger> logger) {
+    const datadog_main_conf_t &nginx_conf, std::shared_ptr<dd::Logger> logger,
+    bool report_traces) {
   dd::TracerConfig config;
   config.logger = std::move(logger);
-  config.agent.event_scheduler = std::make_shared<NgxEventScheduler>();
+  config.report_traces = report_traces;
+  if (!report_traces) {
+    // The reporting tracer already sends telemetry for this worker.
+    config.report_telemetry = false;
+  }
+  std::chrono::milliseconds flush_jitter{0};
+  if (nginx_conf.flush_jitter_ms != NGX_CONF_UNSET_MSEC) {
+    flush_jitter = std::chrono::milliseconds(nginx_conf.flush_jitter_ms);
+  }
+  const auto event_scheduler =
+      std::make_shared<NgxEventScheduler>(flush_jitter);
+  config.agent.event_scheduler = event_scheduler;
   config.integration_name = "nginx";
   config.integration_version = NGINX_VERSION;
 
@@ -36,6 +159,10 @@ dd::Expected<dd::Tracer> TracingLibrary::make_tracer(
         nginx_conf.propagation_styles;
   }
 
+  if (nginx_conf.tags_header_max_size != NGX_CONF_UNSET_SIZE) {
+    config.tags_header_size = nginx_conf.tags_header_max_size;
+  }
+
   if (nginx_conf.service_name) {
     config.service = nginx_conf.service_name->value;
   } else {
@@ -50,6 +177,22 @@ dd::Expected<dd::Tracer> TracingLibrary::make_tracer(
     config.agent.url = nginx_conf.agent_url->value;
   }
 
+  if (report_traces) {
+    auto configured = configure_http_client(nginx_conf, config);
+    if (auto *error = configured.if_error()) {
+      return std::move(*error);
+    }
+  } else {
+    // A non-reporting tracer never sends anything, so it needs neither the
+    // HTTP clients above nor the thread of the tracer's default client.
+    config.agent.http_client = std::make_shared<UnusedHTTPClient>();
+  }
+
+  if (nginx_conf.shutdown_flush_timeout_ms != NGX_CONF_UNSET_MSEC) {
+    config.agent.shutdown_timeout_milliseconds =
+        int(nginx_conf.shutdown_flush_timeout_ms);
+  }
+
   // Set sampling rules based on any `datadog_sample_rate` directives.
   std::vector<sampling_rule_t> rules = nginx_conf.sampling_rules;
   // Sort by descending depth, so that rules in a `location` block come before
@@ -76,6 +219,27 @@ dd::Expected<dd::Tracer> TracingLibrary::make_tracer(
     return final_config.error();
   }
 
+  if (!report_traces) {
+    // `DD_TRACE_ENABLED` in the environment overrides `config.report_traces`,
+    // but a non-reporting tracer must never send traces.
+    final_config->collector = std::make_shared<dd::NullCollector>();
+  }
+
+  // The version read from `datadog_version_file` takes precedence over
+  // `DD_VERSION`, which would otherwise override `config.version`.
+  if (nginx_conf.version) {
+    final_config->defaults.version = *nginx_conf.version;
+  }
+
+  // Only the flushing of traces is jittered, not the tracer's other recurring
+  // events, e.g. polling for remote configuration. The flush interval is known
+  // once the configuration is finalized, and the flush event is scheduled
+  // when the tracer is created.
+  if (const auto *agent_config = std::get_if<dd::FinalizedDatadogAgentConfig>(
+          &final_config->collector)) {
+    event_scheduler->jitter_interval(agent_config->flush_interval);
+  }
+
   return dd::Tracer(*final_config);
 }
 
@@ -95,6 +259,14 @@ std::string_view TracingLibrary::proxy_directive_variable_name() {
   return "datadog_proxy_directive";
 }
 
+std::string_view TracingLibrary::host_variable_name() {
+  return "datadog_host";
+}
+
+std::string_view TracingLibrary::agent_rejected_spans_variable_name() {
+  return "datadog_agent_rejected_spans";
+}
+
 namespace {
 
 class SpanContextJSONWriter : public dd::DictWriter {
@@ -158,13 +330,28 @@ TracingLibrary::default_tags() {
       {"peer.address", "$remote_addr:$remote_port"},
       {"upstream.address", "$upstream_addr"},
       {"http.method", "$request_method"},
-      {"http.url", "$scheme://$http_host$request_uri"},
-      {"http.host", "$http_host"},
+      {"http.url", "$scheme://$datadog_host$request_uri"},
+      {"http.host", "$datadog_host"},
       // added by nginx-datadog
       // See
       // https://docs.datadoghq.com/logs/log_configuration/attributes_naming_convention/#common-attributes
       {"http.useragent", "$http_user_agent"},
-      {"nginx.location", "$datadog_location"}};
+      {"nginx.location", "$datadog_location"},
+      {"host.name", "$hostname"}};
+}
+
+std::string TracingLibrary::default_service_name(
+    const datadog_main_conf_t &conf) {
+  // `DD_SERVICE` takes precedence over the configured service name when the
+  // tracer's configuration is finalized.
+  if (auto env_service = dd::environment::lookup(dd::environment::DD_SERVICE);
+      env_service && !env_service->empty()) {
+    return std::string{*env_service};
+  }
+  if (conf.service_name) {
+    return conf.service_name->value;
+  }
+  return "nginx";
 }
 
 std::string_view TracingLibrary::default_resource_name_pattern() {
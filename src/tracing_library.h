@@ -37,10 +37,12 @@ struct NginxVariableFamily {
 
 struct TracingLibrary {
   // Return a `Tracer` created with the specified `configuration`. If
-  // `configuration` is empty, use a default configuration.  If an error
-  // occurs, return a `dd::Error`.
+  // `configuration` is empty, use a default configuration.  If
+  // `report_traces` is false, then the returned tracer never sends traces to
+  // the Datadog Agent.  If an error occurs, return a `dd::Error`.
   static dd::Expected<dd::Tracer> make_tracer(
-      const datadog_main_conf_t& conf, std::shared_ptr<dd::Logger> logger);
+      const datadog_main_conf_t& conf, std::shared_ptr<dd::Logger> logger,
+      bool report_traces = true);
 
   // Return the common prefix of all variable names that map to nginx worker
   // process environment variables.  The portion of the variable name after
@@ -53,6 +55,11 @@ struct TracingLibrary {
   // integration tests.
   static std::string_view environment_variable_name_prefix();
 
+  // Return the service name of spans created by the tracer made from the
+  // specified `conf`: the `DD_SERVICE` environment variable if it is set,
+  // otherwise `datadog_service_name`, otherwise "nginx".
+  static std::string default_service_name(const datadog_main_conf_t& conf);
+
   // Return a family of nginx variables that will be used to fetch string
   // values from the active span.  For example, to allow the nginx
   // configuration to access the active span's ID, include an entry for
@@ -81,6 +88,16 @@ struct TracingLibrary {
   // configuration directive used to proxy requests through a location.
   static std::string_view proxy_directive_variable_name();
 
+  // Return the name of the nginx variable that expands to the host of the
+  // current request, falling back to `datadog_default_host` if the request
+  // has no host.
+  static std::string_view host_variable_name();
+
+  // Return the name of the nginx variable that expands to a JSON object of the
+  // number of spans that the Datadog Agent rejected in the current worker
+  // process, keyed by response status.
+  static std::string_view agent_rejected_spans_variable_name();
+
   // Return the pattern of an nginx variable script that will be used for the
   // operation name of request spans that do not have an operation name defined
   // in the nginx configuration.  Note that the storage to which the returned
@@ -0,0 +1,92 @@
+#include "user_agent.h"
+
+#include <string>
+#include <unordered_map>
+#include <utility>
+
+namespace datadog {
+namespace nginx {
+namespace {
+
+struct Rule {
+  // The rule matches a user agent that contains `pattern`.
+  std::string_view pattern;
+  std::string_view name;
+};
+
+// Rules are tried in order, and the first match wins. Order matters because
+// user agents mention the browsers that they are compatible with, e.g.
+// Chrome's user agent contains "Safari/", and Edge's contains "Chrome/".
+const Rule browser_rules[] = {
+    {"bot", "Bot"},
+    {"Bot", "Bot"},
+    {"spider", "Bot"},
+    {"crawler", "Bot"},
+    {"Edg/", "Edge"},
+    {"EdgA/", "Edge"},
+    {"EdgiOS/", "Edge"},
+    {"Edge/", "Edge"},
+    {"OPR/", "Opera"},
+    {"Opera", "Opera"},
+    {"SamsungBrowser/", "Samsung Internet"},
+    {"Firefox/", "Firefox"},
+    {"FxiOS/", "Firefox"},
+    {"Chrome/", "Chrome"},
+    {"CriOS/", "Chrome"},
+    {"Chromium/", "Chrome"},
+    {"Safari/", "Safari"},
+    {"MSIE ", "Internet Explorer"},
+    {"Trident/", "Internet Explorer"},
+    {"curl/", "curl"},
+    {"Wget/", "Wget"},
+};
+
+const Rule os_rules[] = {
+    {"Windows", "Windows"},
+    {"Android", "Android"},
+    {"iPhone", "iOS"},
+    {"iPad", "iOS"},
+    {"iPod", "iOS"},
+    {"CrOS", "Chrome OS"},
+    {"Macintosh", "macOS"},
+    {"Mac OS X", "macOS"},
+    {"Linux", "Linux"},
+};
+
+// Each worker process caches at most this many user agents. When the cache is
+// full, it is cleared.
+constexpr std::size_t max_cache_size = 1024;
+
+template <std::size_t N>
+std::string_view classify(std::string_view user_agent, const Rule (&rules)[N]) {
+  for (const Rule &rule : rules) {
+    if (user_agent.find(rule.pattern) != std::string_view::npos) {
+      return rule.name;
+    }
+  }
+  return {};
+}
+
+}  // namespace
+
+UserAgentInfo parse_user_agent(std::string_view user_agent) {
+  // nginx handles requests on one thread per worker process, so the cache
+  // needs no synchronization.
+  static std::unordered_map<std::string, UserAgentInfo> cache;
+
+  std::string key{user_agent};
+  if (auto found = cache.find(key); found != cache.end()) {
+    return found->second;
+  }
+
+  UserAgentInfo info{.browser = classify(user_agent, browser_rules),
+                     .os = classify(user_agent, os_rules)};
+  if (cache.size() >= max_cache_size) {
+    cache.clear();
+  }
+  cache.emplace(std::move(key), info);
+  return info;
+}
+
+}  // namespace nginx
+}  // namespace datadog
@@ -0,0 +1,31 @@
+#pragma once
+
+// This component provides a function, `parse_user_agent`, that classifies a
+// "User-Agent" request header value into a coarse browser family and operating
+// system, e.g. "Chrome" and "Android". It is used by the
+// `datadog_user_agent_tags` directive to tag request spans.
+//
+// The classification uses a small built-in set of substring rules. It is not a
+// complete user agent parser, but it is cheap, and recognizes the most common
+// browsers and operating systems.
+
+#include <string_view>
+
+namespace datadog {
+namespace nginx {
+
+struct UserAgentInfo {
+  // The browser family, e.g. "Firefox", or empty if not recognized.
+  std::string_view browser;
+  // The operating system, e.g. "Windows", or empty if not recognized.
+  std::string_view os;
+};
+
+// Return the browser family and operating system indicated by the specified
+// `user_agent`. Results are cached by `user_agent`, so that classifying a
+// frequently seen user agent is a lookup. The returned views refer to static
+// strings.
+UserAgentInfo parse_user_agent(std::string_view user_agent);
+
+}  // namespace nginx
+}  // namespace datadog
@@ -0,0 +1,8 @@
+#pragma once
+
+// Constants defined in the "version.cpp" generated from "version.cpp.in".
+
+extern "C" {
+// The version of this module, e.g. "1.2.1".
+extern const char datadog_nginx_mod_version[];
+}
@@ -0,0 +1,222 @@
+#include "zipkin_http_client.h"
+
+#include <datadog/dict_writer.h>
+
+#include <algorithm>
+#include <cinttypes>
+#include <cstdint>
+#include <cstdio>
+#include <cstdlib>
+#include <datadog/json.hpp>
+#include <string>
+#include <string_view>
+#include <unordered_set>
+#include <utility>
+
+#include "string_util.h"
+
+namespace datadog {
+namespace nginx {
+namespace {
+
+// This is the path to which the tracer sends traces.
+constexpr std::string_view default_traces_path = "/v0.4/traces";
+
+// This is the path, relative to the collector's URL, to which Zipkin v2 spans
+// are sent.
+constexpr std::string_view zipkin_spans_path = "/api/v2/spans";
+
+std::string hex(std::uint64_t value) {
+  char buffer[17];
+  std::snprintf(buffer, sizeof buffer, "%016" PRIx64, value);
+  return buffer;
+}
+
+// `JSONHeaders` passes through to another `dd::DictWriter` the headers that
+// the tracer sets on trace submissions, except for those that describe the
+// MessagePack payload to the Datadog Agent. It then sets the "Content-Type"
+// of a Zipkin JSON payload.
+class JSONHeaders : public dd::DictWriter {
+  dd::DictWriter& headers_;
+
+ public:
+  explicit JSONHeaders(dd::DictWriter& headers) : headers_(headers) {
+    headers_.set("Content-Type", "application/json");
+  }
+
+  void set(std::string_view key, std::string_view value) override {
+    std::string name{key};
+    std::transform(name.begin(), name.end(), name.begin(), to_lower);
+    if (name == "content-type" || starts_with(name, "datadog-") ||
+        starts_with(name, "x-datadog-")) {
+      return;
+    }
+    headers_.set(key, value);
+  }
+};
+
+// Return the Zipkin v2 span corresponding to the specified Datadog `span`,
+// whose trace ID has the specified `trace_id_high` bits. The specified
+// `is_local_root` is whether the span's parent is outside of nginx.
+nlohmann::json to_zipkin_span(const nlohmann::json& span,
+                              std::uint64_t trace_id_high, bool is_local_root) {
+  const auto trace_id_low = span.value("trace_id", std::uint64_t(0));
+  const auto parent_id = span.value("parent_id", std::uint64_t(0));
+  const auto start = span.value("start", std::int64_t(0));
+  const auto duration = span.value("duration", std::int64_t(0));
+
+  auto tags = nlohmann::json::object();
+  const auto meta = span.value("meta", nlohmann::json::object());
+  for (const auto& [key, value] : meta.items()) {
+    // The high bits of the trace ID are part of "traceId", below.
+    if (key != "_dd.p.tid" && value.is_string()) {
+      tags[key] = value;
+    }
+  }
+  const auto metrics = span.value("metrics", nlohmann::json::object());
+  for (const auto& [key, value] : metrics.items()) {
+    tags[key] = value.dump();
+  }
+  tags["resource.name"] = span.value("resource", "");
+  if (const auto type = span.value("type", ""); !type.empty()) {
+    tags["span.type"] = type;
+  }
+  // Zipkin marks errors with an "error" tag, whose value is the message.
+  if (span.value("error", 0) != 0) {
+    tags["error"] = meta.value("error.message", "true");
+  }
+
+  auto result = nlohmann::json::object({
+      {"traceId", (trace_id_high ? hex(trace_id_high) : std::string{}) +
+                      hex(trace_id_low)},
+      {"id", hex(span.value("span_id", std::uint64_t(0)))},
+      {"name", span.value("name", "")},
+      // Zipkin measures time in microseconds.
+      {"timestamp", start / 1000},
+      {"duration", std::max(duration / 1000, std::int64_t(1))},
+      {"localEndpoint", {{"serviceName", span.value("service", "")}}},
+      {"tags", std::move(tags)},
+  });
+  if (parent_id != 0) {
+    result["parentId"] = hex(parent_id);
+  }
+  if (is_local_root) {
+    result["kind"] = "SERVER";
+  }
+  return result;
+}
+
+// Return the Zipkin v2 spans corresponding to the specified trace `chunks`,
+// which are the payload of a Datadog Agent "/v0.4/traces" request. Traces that
+// the tracer decided to drop are omitted, since Zipkin would keep them.
+nlohmann::json to_zipkin_spans(const nlohmann::json& chunks) {
+  auto result = nlohmann::json::array();
+  for (const auto& chunk : chunks) {
+    if (!chunk.is_array()) {
+      continue;
+    }
+
+    bool dropped = false;
+    std::uint64_t trace_id_high = 0;
+    std::unordered_set<std::uint64_t> span_ids;
+    for (const auto& span : chunk) {
+      span_ids.insert(span.value("span_id", std::uint64_t(0)));
+      const auto metrics = span.value("metrics", nlohmann::json::object());
+      if (metrics.value("_sampling_priority_v1", 1.0) <= 0) {
+        dropped = true;
+      }
+      const auto meta = span.value("meta", nlohmann::json::object());
+      if (meta.contains("_dd.p.tid")) {
+        trace_id_high = std::strtoull(
+            meta.value("_dd.p.tid", "").c_str(), nullptr, 16);
+      }
+    }
+    if (dropped) {
+      continue;
+    }
+
+    for (const auto& span : chunk) {
+      const bool is_local_root =
+          span_ids.count(span.value("parent_id", std::uint64_t(0))) == 0;
+      result.push_back(to_zipkin_span(span, trace_id_high, is_local_root));
+    }
+  }
+  return result;
+}
+
+}  // namespace
+
+ZipkinHTTPClient::ZipkinHTTPClient(std::shared_ptr<dd::HTTPClient> delegate,
+                                   std::shared_ptr<dd::Logger> logger,
+                                   const URL& collector_url)
+    : delegate_(std::move(delegate)),
+      logger_(std::move(logger)),
+      spans_url_(collector_url) {
+  while (ends_with(spans_url_.path, "/")) {
+    spans_url_.path.pop_back();
+  }
+  spans_url_.path += zipkin_spans_path;
+}
+
+dd::Expected<void> ZipkinHTTPClient::post(
+    const URL& url, HeadersSetter set_headers, std::string body,
+    ResponseHandler on_response, ErrorHandler on_error,
+    std::chrono::steady_clock::time_point deadline) {
+  // Requests other than trace submissions are passed through unmodified.
+  if (!ends_with(url.path, default_traces_path)) {
+    return delegate_->post(url, std::move(set_headers), std::move(body),
+                           std::move(on_response), std::move(on_error),
+                           deadline);
+  }
+
+  const auto chunks = nlohmann::json::from_msgpack(
+      body, /*strict=*/true, /*allow_exceptions=*/false);
+  if (chunks.is_discarded() || !chunks.is_array()) {
+    return dd::Error{dd::Error::OTHER,
+                     "Unable to decode traces for the Zipkin collector."};
+  }
+
+  auto json_headers = [set_headers =
+                           std::move(set_headers)](dd::DictWriter& headers) {
+    JSONHeaders writer{headers};
+    set_headers(writer);
+  };
+
+  auto adapted_on_response = [on_response = std::move(on_response),
+                              logger = logger_, spans_url = spans_url_](
+                                 int status, const dd::DictReader& headers,
+                                 std::string response_body) {
+    if (status >= 200 && status < 300) {
+      // Zipkin responds without a body. The tracer expects a JSON object of
+      // sample rates, so give it an empty object.
+      response_body = "{}";
+    } else {
+      logger->log_error([&](std::ostream& log) {
+        log << "The Zipkin collector at " << spans_url.scheme << "://"
+            << spans_url.authority << spans_url.path
+            << " responded with status " << status << ": " << response_body;
+      });
+    }
+    on_response(status, headers, std::move(response_body));
+  };
+
+  return delegate_->post(spans_url_, std::move(json_headers),
+                         to_zipkin_spans(chunks).dump(),
+                         std::move(adapted_on_response), std::move(on_error),
+                         deadline);
+}
+
+void ZipkinHTTPClient::drain(std::chrono::steady_clock::time_point deadline) {
+  delegate_->drain(deadline);
+}
+
+nlohmann::json ZipkinHTTPClient::config_json() const {
+  return nlohmann::json::object(
+      {{"type", "ZipkinHTTPClient"},
+       {"url", spans_url_.scheme + "://" + spans_url_.authority +
+                   spans_url_.path},
+       {"delegate", delegate_->config_json()}});
+}
+
+}  // namespace nginx
+}  // namespace datadog
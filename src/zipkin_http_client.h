@@ -0,0 +1,46 @@
+#pragma once
+
+// This component provides a `class`, `ZipkinHTTPClient`, that decorates
+// another `dd::HTTPClient`. The tracer sends traces to the Datadog Agent's
+// "/v0.4/traces" endpoint as MessagePack. `ZipkinHTTPClient` instead sends
+// them to a Zipkin collector's "/api/v2/spans" endpoint, as Zipkin v2 JSON, and
+// adapts the collector's responses to what the tracer expects of the agent.
+
+#include <datadog/http_client.h>
+#include <datadog/logger.h>
+
+#include <chrono>
+#include <memory>
+
+#include "dd.h"
+
+namespace datadog {
+namespace nginx {
+
+class ZipkinHTTPClient : public dd::HTTPClient {
+  std::shared_ptr<dd::HTTPClient> delegate_;
+  std::shared_ptr<dd::Logger> logger_;
+  // `spans_url_` is the URL of the collector's "/api/v2/spans" endpoint.
+  URL spans_url_;
+
+ public:
+  // Send trace submissions to the Zipkin collector at the specified
+  // `collector_url`, e.g. "http://zipkin:9411", using the specified
+  // `delegate`. Log problems to the specified `logger`.
+  ZipkinHTTPClient(std::shared_ptr<dd::HTTPClient> delegate,
+                   std::shared_ptr<dd::Logger> logger,
+                   const URL& collector_url);
+
+  dd::Expected<void> post(const URL& url, HeadersSetter set_headers,
+                          std::string body, ResponseHandler on_response,
+                          ErrorHandler on_error,
+                          std::chrono::steady_clock::time_point deadline)
+      override;
+
+  void drain(std::chrono::steady_clock::time_point deadline) override;
+
+  nlohmann::json config_json() const override;
+};
+
+}  // namespace nginx
+}  // namespace datadog
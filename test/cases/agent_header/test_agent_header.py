@@ -0,0 +1,41 @@
+from .. import case
+
+import json
+from pathlib import Path
+
+
+class TestAgentHeader(case.TestCase):
+
+    def test_headers_on_flush(self):
+        self.orch.sync_service('agent')
+
+        nginx_conf = (Path(__file__).parent / 'conf' /
+                      'nginx.conf').read_text()
+        extra_env = {'AGENT_PROXY_TOKEN': 'sekrit'}
+        with self.orch.custom_nginx(nginx_conf,
+                                    extra_env,
+                                    healthcheck_port=8080):
+            status, _, body = self.orch.send_nginx_http_request('/', 8080)
+            self.assertEqual(200, status, body)
+
+        # Stopping the custom nginx flushes its traces.
+        log_lines = self.orch.sync_service('agent')
+        prefix = 'Traces request headers: '
+        requests = [
+            json.loads(line[len(prefix):]) for line in log_lines
+            if line.startswith(prefix)
+        ]
+        self.assertNotEqual([], requests, log_lines)
+        for headers in requests:
+            self.assertEqual('static-value',
+                             headers.get('x-datadog-test-static'), headers)
+            self.assertEqual('Bearer sekrit',
+                             headers.get('x-datadog-test-token'), headers)
+
+    def test_unset_environment_variable(self):
+        conf_path = Path(__file__).parent / 'conf' / 'nginx.conf'
+        status, log_lines = self.orch.nginx_test_config(
+            conf_path.read_text(), conf_path.name)
+        self.assertNotEqual(0, status, log_lines)
+        self.assertTrue(
+            any('AGENT_PROXY_TOKEN' in line for line in log_lines), log_lines)
@@ -0,0 +1,29 @@
+from .. import case
+
+
+class TestAgentIPv6(case.TestCase):
+
+    def send_request_and_get_spans(self, conf_name):
+        conf_text = self.conf_path(conf_name).read_text()
+        status, log_lines = self.orch.nginx_replace_config(
+            conf_text, conf_name)
+        if status != 0 and any('[::1]:8126' in line for line in log_lines):
+            self.skipTest('IPv6 loopback is not available in the nginx container')
+        self.assertEqual(0, status, log_lines)
+
+        # Consume any previous logging from the agent.
+        self.orch.sync_service('agent')
+
+        status, _, body = self.orch.send_nginx_http_request('/http')
+        self.assertEqual(200, status, body)
+        return self.flush_spans()
+
+    def test_ipv6_agent_url(self):
+        spans = self.send_request_and_get_spans('http.conf')
+        self.assertEqual(1, len(spans), spans)
+
+    def test_agent_hostname_resolving_to_ipv6(self):
+        # "ip6-localhost" resolves to ::1 in the nginx container's /etc/hosts,
+        # and only [::1]:8126 is relayed to the agent.
+        spans = self.send_request_and_get_spans('hostname.conf')
+        self.assertEqual(1, len(spans), spans)
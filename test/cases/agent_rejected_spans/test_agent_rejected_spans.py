@@ -0,0 +1,54 @@
+from .. import case
+
+import json
+import time
+
+
+class TestAgentRejectedSpans(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def tearDown(self):
+        self.orch.setup_traces_status(None)
+        super().tearDown()
+
+    def rejected_spans(self):
+        status, _, body = self.orch.send_nginx_http_request('/rejected')
+        self.assertEqual(200, status, body)
+        return json.loads(body)
+
+    def wait_for_rejected_spans(self, expected, timeout_secs=5):
+        """Poll `$datadog_agent_rejected_spans` until it is `expected`, which
+        can take a moment after the agent responds.  Return the last value.
+        """
+        deadline = time.monotonic() + timeout_secs
+        while True:
+            rejected = self.rejected_spans()
+            if rejected == expected or time.monotonic() > deadline:
+                return rejected
+            time.sleep(0.1)
+
+    def send_rejected_span(self):
+        status, _, _ = self.orch.send_nginx_http_request('/http')
+        self.assertEqual(200, status)
+        self.orch.wait_for_log_message('agent',
+                                       '^Traces response status: 413',
+                                       timeout_secs=10)
+
+    def test_nothing_rejected(self):
+        self.assertEqual({}, self.rejected_spans())
+
+    def test_payload_too_large(self):
+        status, _, _ = self.orch.setup_traces_status(413)
+        self.assertEqual(200, status)
+        self.orch.sync_service('agent')
+        self.orch.sync_service('nginx')
+
+        self.send_rejected_span()
+        self.assertEqual({'413': 1}, self.wait_for_rejected_spans({'413': 1}))
+        message = self.orch.wait_for_log_message(
+            'nginx', 'The Datadog Agent rejected 1 span', timeout_secs=5)
+        self.assertIn('status 413', message)
+
+        # The counter increments with each rejection.
+        self.send_rejected_span()
+        self.assertEqual({'413': 2}, self.wait_for_rejected_spans({'413': 2}))
@@ -0,0 +1,67 @@
+from .. import case
+
+import json
+
+
+class TestAgentSampling(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def tearDown(self):
+        self.orch.setup_traces_response('')
+        super().tearDown()
+
+    def run_rate_by_service_test(self, rate_by_service, expected_rate,
+                                 expected_priority, expected_dm):
+        status, _, _ = self.orch.setup_traces_response(
+            json.dumps({'rate_by_service': rate_by_service}))
+        self.assertEqual(200, status)
+        self.orch.sync_service('agent')
+
+        # The first trace is sampled using the default rate, because nginx has
+        # not heard from the agent yet.  When nginx flushes the trace, the
+        # agent responds with `rate_by_service`.
+        status, _, _ = self.orch.send_nginx_http_request('/http/first')
+        self.assertEqual(200, status)
+        self.orch.wait_for_log_message('agent',
+                                       'GET /http/first',
+                                       timeout_secs=10)
+        self.orch.wait_for_log_message('agent',
+                                       '^Traces response: ',
+                                       timeout_secs=1)
+
+        # The second trace is sampled using the rate from the agent.
+        status, _, _ = self.orch.send_nginx_http_request('/http/second')
+        self.assertEqual(200, status)
+
+        spans = {span['resource']: span for span in self.flush_spans()}
+        self.assertIn('GET /http/second', spans, spans)
+        span = spans['GET /http/second']
+        self.assertEqual(expected_rate, span['metrics'].get('_dd.agent_psr'),
+                         span)
+        self.assertEqual(expected_priority,
+                         span['metrics'].get('_sampling_priority_v1'), span)
+        # The "decision maker" is present only for kept traces.
+        self.assertEqual(expected_dm, span['meta'].get('_dd.p.dm'), span)
+
+    def test_service_is_listed(self):
+        # A rate of zero means drop (AUTO-REJECT, priority 0).
+        self.run_rate_by_service_test(
+            rate_by_service={
+                'service:,env:': 1.0,
+                'service:nginx,env:': 0.0
+            },
+            expected_rate=0.0,
+            expected_priority=0,
+            expected_dm=None)
+
+    def test_service_is_not_listed(self):
+        # The agent's default rate applies to services that it doesn't list.
+        self.run_rate_by_service_test(
+            rate_by_service={
+                'service:,env:': 1.0,
+                'service:other,env:': 0.0
+            },
+            expected_rate=1.0,
+            expected_priority=1,
+            # Sampling mechanism "1" is "agent rate".
+            expected_dm='-1')
@@ -0,0 +1,34 @@
+from .. import case
+
+from pathlib import Path
+
+
+class TestAgentTLS(case.TestCase):
+
+    def setUp(self):
+        super().setUp()
+        cert_dir = Path(__file__).parent.parent / 'tls' / 'conf'
+        for name in ('nginx.crt', 'nginx.key'):
+            self.orch.nginx_replace_file(f'/tmp/datadog-tests-{name}',
+                                         (cert_dir / name).read_text())
+
+        self.replace_nginx_config('tls_proxy.conf')
+
+    def send_request_and_get_spans(self, conf_name, service):
+        nginx_conf = self.conf_path(conf_name).read_text()
+        with self.orch.custom_nginx(nginx_conf, healthcheck_port=8080):
+            status, _, body = self.orch.send_nginx_http_request('/', 8080)
+            self.assertEqual(200, status, body)
+
+        # Stopping the custom nginx flushes its traces through the proxy.
+        return self.spans_in(self.orch.sync_service('agent'), service)
+
+    def test_verified_certificate(self):
+        spans = self.send_request_and_get_spans('verified.conf',
+                                                'nginx-agent-tls-verified')
+        self.assertEqual(1, len(spans), spans)
+
+    def test_verification_disabled(self):
+        spans = self.send_request_and_get_spans('unverified.conf',
+                                                'nginx-agent-tls-unverified')
+        self.assertEqual(1, len(spans), spans)
@@ -53,6 +53,87 @@ class TestHTTP(case.TestCase):
             headers["x-datadog-parent-id"],
         )
 
+    def test_client_headers_are_preserved(self):
+        conf_path = Path(__file__).parent / "./conf/http_auto.conf"
+        conf_text = conf_path.read_text()
+        status, log_lines = self.orch.nginx_replace_config(
+            conf_text, conf_path.name)
+        self.assertEqual(status, 0, log_lines)
+
+        # Injecting trace context must only add headers. It must not drop or
+        # modify any unrelated client headers, including those that sort or
+        # hash near the injected headers.
+        client_headers = {
+            "sec-browsing-topics": "();p=P0000000000000000000000000000000",
+            "sec-fetch-dest": "document",
+            "sec-fetch-mode": "navigate",
+            "sec-fetch-site": "same-origin",
+            "sec-fetch-user": "?1",
+            "sec-ch-ua": '"Chromium";v="124", "Not-A.Brand";v="99"',
+            "sec-ch-ua-mobile": "?0",
+            "sec-ch-ua-platform": '"Linux"',
+            "sec-gpc": "1",
+            "priority": "u=0, i",
+            "x-datadog-unrelated": "not-a-propagation-header",
+            "x-datadog-trace": "also-not-a-propagation-header",
+        }
+        for i in range(64):
+            client_headers[f"x-arbitrary-{i}"] = f"value-{i}"
+
+        status, _, body = self.orch.send_nginx_http_request(
+            "/http", headers=client_headers)
+        self.assertEqual(status, 200)
+        response = json.loads(body)
+        self.assertEqual(response["service"], "http")
+        headers = response["headers"]
+
+        for name, value in client_headers.items():
+            self.assertIn(name, headers)
+            self.assertEqual(value, headers[name], name)
+
+        self.assertIn("x-datadog-trace-id", headers)
+        self.assertIn("x-datadog-parent-id", headers)
+        self.assertIn("x-datadog-sampling-priority", headers)
+
+    def run_injection_order_test(self, conf_relative_path, first, second):
+        conf_path = Path(__file__).parent / conf_relative_path
+        conf_text = conf_path.read_text()
+        status, log_lines = self.orch.nginx_replace_config(
+            conf_text, conf_path.name)
+        self.assertEqual(status, 0, log_lines)
+
+        # The client sends trace context headers in the opposite order from
+        # that of the configured styles.
+        client_headers = [
+            ("traceparent",
+             "00-0000000000000000298cb24be9d6b82b-5897268091b7dcb9-01"),
+            ("x-datadog-trace-id", "2993963891409991723"),
+            ("x-datadog-parent-id", "6383613330463382713"),
+        ]
+        if first == "traceparent":
+            client_headers.reverse()
+
+        status, _, body = self.orch.send_nginx_http_request(
+            "/http", headers=client_headers)
+        self.assertEqual(status, 200)
+        names = list(json.loads(body)["headers"])
+
+        self.assertIn(first, names)
+        self.assertIn(second, names)
+        self.assertLess(names.index(first), names.index(second), names)
+
+    def test_injection_order_datadog_first(self):
+        return self.run_injection_order_test(
+            "./conf/http_styles_datadog_tracecontext.conf",
+            first="x-datadog-trace-id",
+            second="traceparent")
+
+    def test_injection_order_tracecontext_first(self):
+        return self.run_injection_order_test(
+            "./conf/http_styles_tracecontext_datadog.conf",
+            first="traceparent",
+            second="x-datadog-trace-id")
+
     def test_disabled_at_location(self):
         return self.run_test("./conf/http_disabled_at_location.conf",
                              should_propagate=False)
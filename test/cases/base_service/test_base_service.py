@@ -0,0 +1,34 @@
+from .. import case
+
+
+class TestBaseService(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def send_request_and_get_span(self, path, headers={}):
+        status, _, body = self.orch.send_nginx_http_request(path,
+                                                            headers=headers)
+        self.assertEqual(200, status, body)
+
+        spans = [
+            span for span in self.flush_spans(service=None)
+            if span['service'] != 'http'
+        ]
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]
+
+    def test_per_host_override(self):
+        span = self.send_request_and_get_span(
+            '/http', headers={'Host': 'tenant.example.com'})
+        self.assertEqual('tenant.example.com', span['service'], span)
+        self.assertEqual('global-service', span['meta']['_dd.base_service'],
+                         span)
+
+    def test_no_override(self):
+        span = self.send_request_and_get_span('/http')
+        self.assertEqual('global-service', span['service'], span)
+        self.assertNotIn('_dd.base_service', span['meta'])
+
+    def test_override_same_as_base(self):
+        span = self.send_request_and_get_span('/same')
+        self.assertEqual('global-service', span['service'], span)
+        self.assertNotIn('_dd.base_service', span['meta'])
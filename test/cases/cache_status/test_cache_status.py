@@ -0,0 +1,30 @@
+from .. import case
+
+import uuid
+
+
+class TestCacheStatus(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def send_requests_and_get_spans(self, paths):
+        for path in paths:
+            status, _, body = self.orch.send_nginx_http_request(path)
+            self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(len(paths), len(spans), spans)
+        return sorted(spans, key=lambda span: span['start'])
+
+    def test_cached(self):
+        # A path unique to this test run, so that the first request misses
+        # regardless of what earlier runs left in the cache.
+        path = f'/cached/{uuid.uuid4()}'
+        miss, hit = self.send_requests_and_get_spans([path, path])
+        self.assertEqual('MISS', miss['meta'].get('nginx.cache.status'),
+                         miss['meta'])
+        self.assertEqual('HIT', hit['meta'].get('nginx.cache.status'),
+                         hit['meta'])
+
+    def test_uncached(self):
+        span, = self.send_requests_and_get_spans(['/http'])
+        self.assertNotIn('nginx.cache.status', span['meta'], span['meta'])
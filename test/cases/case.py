@@ -1,8 +1,11 @@
 """Boilerplate for test cases"""
 
+from . import formats
 from . import orchestration
 
 import os
+from pathlib import Path
+import re
 import sys
 import time
 import unittest
@@ -16,6 +19,10 @@ class TestCase(unittest.TestCase):
     indent test cases in a `with orchestration.singleton() as orch:` block.
     """
     durations_seconds = {}
+    # If a derived class sets `nginx_conf_file` to the name of a file in the
+    # "conf" directory next to its module, then `setUp` configures nginx with
+    # that file before each test.
+    nginx_conf_file = None
 
     def __init__(self, *args, **kwargs):
         super().__init__(*args, **kwargs)
@@ -29,16 +36,108 @@ class TestCase(unittest.TestCase):
         waf_value = os.environ.get('WAF', 'OFF')
         cls.waf_disabled = waf_value == 'OFF' or waf_value == 'FALSE' or waf_value == '0' or waf_value == 'N' or \
                            waf_value == 'n' or waf_value == 'No' or waf_value == 'NO' or waf_value == ''
+        stream_value = os.environ.get('STREAM', 'OFF')
+        cls.stream_disabled = stream_value.upper() in ('OFF', 'FALSE', '0',
+                                                       'N', 'NO', '')
 
     def setUp(self):
         if type(self).waf_disabled and hasattr(
                 type(self), 'requires_waf') and type(self).requires_waf:
             self.skipTest("WAF is disabled")
+        if type(self).stream_disabled and getattr(type(self),
+                                                  'requires_stream', False):
+            self.skipTest("stream tracing is disabled")
 
         context = self.orch_context = orchestration.singleton()
         self.orch = context.__enter__()
         self.begin = time.monotonic()
 
+        if self.nginx_conf_file is not None:
+            self.replace_nginx_config(self.nginx_conf_file)
+
+    def conf_path(self, file_name):
+        """Return the path of the specified `file_name` in the "conf"
+        directory next to the module of this test case.
+        """
+        module = sys.modules[type(self).__module__]
+        return Path(module.__file__).parent / 'conf' / file_name
+
+    def replace_nginx_config(self, file_name):
+        """Configure nginx with the specified `file_name` from this test
+        case's "conf" directory, and consume any previous logging from the
+        agent.  Return the text of the configuration.
+        """
+        conf_text = self.conf_path(file_name).read_text()
+        status, log_lines = self.orch.nginx_replace_config(
+            conf_text, file_name)
+        self.assertEqual(0, status, log_lines)
+
+        # Consume any previous logging from the agent.
+        self.orch.sync_service('agent')
+        return conf_text
+
+    def flush_agent_log(self):
+        """Reload nginx, so that its workers flush their traces, and return
+        the lines that the agent logged since its logging was last consumed.
+        """
+        self.orch.reload_nginx()
+        return self.orch.sync_service('agent')
+
+    @staticmethod
+    def spans_in(log_lines, service='nginx'):
+        """Return the spans in the specified agent `log_lines` whose service
+        is the specified `service`, or all of the spans if `service` is
+        `None`.
+        """
+        return [
+            span for span in formats.parse_spans(log_lines)
+            if service is None or span['service'] == service
+        ]
+
+    def flush_spans(self, service='nginx'):
+        """Flush traces as `flush_agent_log` does, and return the spans of
+        the specified `service` that the agent received.
+        """
+        return self.spans_in(self.flush_agent_log(), service)
+
+    def assertTraceContinuity(self, upstream_headers, spans):
+        """Assert that the trace context received by an upstream, whose
+        request headers are the specified `upstream_headers`, refers to one of
+        the specified `spans` sent to the agent, and return that span.
+
+        The upstream's "traceparent" must name the trace and span of the
+        parent span, and any Datadog headers must agree with it.
+        """
+        traceparent = upstream_headers.get('traceparent')
+        self.assertIsNotNone(traceparent, upstream_headers)
+        match = re.fullmatch(
+            r'00-(?P<trace_id>[0-9a-f]{32})-(?P<span_id>[0-9a-f]{16})-[0-9a-f]{2}',
+            traceparent)
+        self.assertIsNotNone(match, traceparent)
+        trace_id = int(match['trace_id'], 16)
+        span_id = int(match['span_id'], 16)
+
+        parent = next(
+            (span for span in spans if span['span_id'] == span_id), None)
+        self.assertIsNotNone(
+            parent, f'no span has the ID {span_id} propagated upstream')
+
+        # The agent receives the lower 64 bits of the trace ID, and the upper
+        # 64 bits are in the "_dd.p.tid" tag, if they're not zero.
+        self.assertEqual(trace_id & (2**64 - 1), parent['trace_id'], parent)
+        upper = parent['meta'].get('_dd.p.tid', '0')
+        self.assertEqual(trace_id >> 64, int(upper, 16), parent)
+
+        if 'x-datadog-trace-id' in upstream_headers:
+            self.assertEqual(str(parent['trace_id']),
+                             upstream_headers['x-datadog-trace-id'],
+                             upstream_headers)
+            self.assertEqual(str(span_id),
+                             upstream_headers.get('x-datadog-parent-id'),
+                             upstream_headers)
+
+        return parent
+
     def tearDown(self):
         end = time.monotonic()
         self.durations_seconds[self.id()] = end - self.begin
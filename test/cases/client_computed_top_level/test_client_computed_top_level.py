@@ -0,0 +1,40 @@
+from .. import case
+
+import json
+
+
+class TestClientComputedTopLevel(case.TestCase):
+
+    def flush_traces(self, conf_name):
+        """Load the nginx configuration in the specified `conf_name` file,
+        send a request, and flush its trace. Return the values of the
+        "Datadog-Client-Computed-Top-Level" header on trace submissions (`None`
+        if absent), and the nginx spans.
+        """
+        self.replace_nginx_config(conf_name)
+
+        status, _, _ = self.orch.send_nginx_http_request('/http')
+        self.assertEqual(200, status)
+
+        log_lines = self.flush_agent_log()
+        prefix = 'Traces request Datadog-Client-Computed-Top-Level: '
+        values = [
+            json.loads(line[len(prefix):]) for line in log_lines
+            if line.startswith(prefix)
+        ]
+        spans = self.spans_in(log_lines)
+        return values, spans
+
+    def test_on(self):
+        values, spans = self.flush_traces('on.conf')
+        self.assertNotEqual([], values)
+        self.assertEqual({'yes'}, set(values))
+
+        # The header is only truthful if the tracer marks top-level spans.
+        self.assertEqual(1, len(spans), spans)
+        self.assertEqual(1, spans[0]['metrics'].get('_dd.top_level'), spans)
+
+    def test_off(self):
+        values, _ = self.flush_traces('off.conf')
+        self.assertNotEqual([], values)
+        self.assertEqual({None}, set(values))
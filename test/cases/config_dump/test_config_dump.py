@@ -0,0 +1,48 @@
+from .. import case
+
+import json
+
+
+class TestConfigDump(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def get_dump(self):
+        status, headers, body = self.orch.send_nginx_http_request(
+            '/datadog-config')
+        self.assertEqual(200, status, body)
+        headers = {name.lower(): value for name, value in headers}
+        self.assertEqual('application/json', headers.get('content-type'))
+        return body, json.loads(body)
+
+    def test_dump_contains_expected_keys(self):
+        _, dump = self.get_dump()
+        self.assertIn('tracer', dump)
+        self.assertIn('locations', dump)
+
+        tracer = json.dumps(dump['tracer'])
+        self.assertIn('config-dump-test', tracer)
+
+        locations = {entry['location']: entry for entry in dump['locations']}
+        self.assertIn('/datadog-config', locations, locations)
+        self.assertIn('/http', locations, locations)
+
+        http = locations['/http']
+        self.assertEqual('localhost', http['server'])
+        self.assertTrue(http['tracing'])
+        self.assertTrue(http['trace_locations'])
+        self.assertEqual(1, len(http['sample_rates']), http)
+        for key in ('operation_name', 'resource_name',
+                    'service_name_override', 'peer_service'):
+            self.assertIn(key, http)
+
+        self.assertFalse(locations['/datadog-config']['tracing'])
+
+    def test_credentials_are_masked(self):
+        body, _ = self.get_dump()
+        self.assertNotIn('hunter2', body)
+        self.assertIn('****@agent:8126', body)
+
+    def test_method_not_allowed(self):
+        status, _, _ = self.orch.send_nginx_http_request('/datadog-config',
+                                                         method='POST')
+        self.assertEqual(405, status)
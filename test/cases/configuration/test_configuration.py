@@ -1,6 +1,7 @@
 from .. import case
 
 import json
+import re
 from pathlib import Path
 
 
@@ -190,3 +191,58 @@ class TestConfiguration(case.TestCase):
     def test_error_in_server_propagation_styles(self):
         return self.run_wrong_block_test(
             "./conf/error_in_server/propagation_styles.conf")
+
+    def run_invalid_directive_test(self, conf_relative_path, line, prefix,
+                                   suffix):
+        """Verify that `nginx -t` rejects the configuration at the specified
+        `conf_relative_path`, and that it logs a diagnostic that begins with
+        the specified `prefix`, names the file and the specified `line`, and
+        is followed by the specified `suffix`.
+        """
+        conf_path = Path(__file__).parent / conf_relative_path
+        conf_text = conf_path.read_text()
+
+        status, log_lines = self.orch.nginx_test_config(
+            conf_text, conf_path.name)
+        self.assertNotEqual(0, status)
+        # `nginx_test_config` prepends one line to the configuration.
+        where = f'{conf_path.name}:{line + 1}'
+        self.assertTrue(
+            any(
+                re.search(
+                    re.escape(prefix) + r'/\S*' + re.escape(where) +
+                    re.escape(suffix), log_line) for log_line in log_lines),
+            log_lines)
+
+    def test_invalid_propagation_style(self):
+        self.run_invalid_directive_test(
+            conf_relative_path='./conf/invalid/propagation_style.conf',
+            line=11,
+            prefix=
+            'Invalid propagation style "Zipkin" to datadog_propagation_styles directive at ',
+            suffix=
+            '.  Acceptable values are "Datadog", "B3", and "tracecontext" (case-insensitive).'
+        )
+
+    def test_invalid_agent_url(self):
+        self.run_invalid_directive_test(
+            conf_relative_path='./conf/invalid/agent_url.conf',
+            line=11,
+            prefix=
+            'Invalid argument "ftp://agent:8126" to datadog_agent_url directive at ',
+            suffix='.  [error code ')
+
+    def test_invalid_resource_name(self):
+        self.run_invalid_directive_test(
+            conf_relative_path='./conf/invalid/resource_name.conf',
+            line=18,
+            prefix=
+            'Invalid argument "${uri" to datadog_resource_name directive at ',
+            suffix='.  Expected a string that may contain $-variables.')
+
+    def test_invalid_opentracing_toggle(self):
+        self.run_invalid_directive_test(
+            conf_relative_path='./conf/invalid/opentracing.conf',
+            line=18,
+            prefix='Invalid argument "maybe" to opentracing directive at ',
+            suffix='.  Use "on" or "off".')
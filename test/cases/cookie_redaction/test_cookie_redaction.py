@@ -0,0 +1,21 @@
+from .. import case
+
+
+class TestCookieRedaction(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def test_cookie_values_redacted(self):
+        headers = {'Cookie': 'session=request-secret; theme=dark; id=42'}
+        status, _, body = self.orch.send_nginx_http_request('/http',
+                                                            headers=headers)
+        self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        meta = spans[0]['meta']
+
+        self.assertEqual('session=<redacted>; theme=dark; id=<redacted>',
+                         meta.get('http.request.headers.cookie'), meta)
+        self.assertEqual('session=<redacted>; Path=/; HttpOnly',
+                         meta.get('http.response.headers.set-cookie'), meta)
+        self.assertNotIn('secret', str(meta))
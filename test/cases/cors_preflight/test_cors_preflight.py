@@ -0,0 +1,42 @@
+from .. import case
+
+
+PREFLIGHT_HEADERS = {
+    'Origin': 'http://example.com',
+    'Access-Control-Request-Method': 'PUT',
+}
+
+
+class TestCORSPreflight(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def send_request_and_get_spans(self, path, **kwargs):
+        status, _, body = self.orch.send_nginx_http_request(path, **kwargs)
+        self.assertEqual(200, status, body)
+
+        return self.flush_spans()
+
+    def test_preflight_is_tagged(self):
+        spans = self.send_request_and_get_spans('/traced',
+                                                method='OPTIONS',
+                                                headers=PREFLIGHT_HEADERS)
+        self.assertEqual(1, len(spans), spans)
+        self.assertEqual('true', spans[0]['meta'].get('http.cors_preflight'),
+                         spans[0]['meta'])
+
+    def test_options_without_preflight_headers_is_not_tagged(self):
+        spans = self.send_request_and_get_spans('/traced', method='OPTIONS')
+        self.assertEqual(1, len(spans), spans)
+        self.assertNotIn('http.cors_preflight', spans[0]['meta'])
+
+    def test_preflight_is_not_traced(self):
+        spans = self.send_request_and_get_spans('/untraced',
+                                                method='OPTIONS',
+                                                headers=PREFLIGHT_HEADERS)
+        self.assertEqual([], spans)
+
+    def test_other_requests_are_traced(self):
+        spans = self.send_request_and_get_spans('/untraced',
+                                                headers=PREFLIGHT_HEADERS)
+        self.assertEqual(1, len(spans), spans)
+        self.assertNotIn('http.cors_preflight', spans[0]['meta'])
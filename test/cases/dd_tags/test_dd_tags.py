@@ -0,0 +1,40 @@
+from .. import case
+
+from pathlib import Path
+
+
+class TestDDTags(case.TestCase):
+
+    def run_dd_tags_test(self, path):
+        """Send a request for `path` to a custom nginx launched with `DD_TAGS`
+        in its environment, and return the resulting span.
+        """
+        self.orch.sync_service('agent')
+
+        nginx_conf = (Path(__file__).parent / 'conf' /
+                      'nginx.conf').read_text()
+        # "bogus" is not of the form "key:value", and so must be skipped
+        # rather than prevent the tracer from starting.
+        extra_env = {'DD_TAGS': 'team:edge,tier:1 bogus'}
+        with self.orch.custom_nginx(nginx_conf,
+                                    extra_env,
+                                    healthcheck_port=8080):
+            status, _, body = self.orch.send_nginx_http_request(path, 8080)
+            self.assertEqual(200, status, body)
+
+        # Stopping the custom nginx flushes its traces.
+        log_lines = self.orch.sync_service('agent')
+        spans = self.spans_in(log_lines, 'nginx-dd-tags')
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]
+
+    def test_env_tags_on_span(self):
+        span = self.run_dd_tags_test('/')
+        self.assertEqual('edge', span['meta'].get('team'), span)
+        self.assertEqual('1', span['meta'].get('tier'), span)
+        self.assertNotIn('bogus', span['meta'], span)
+
+    def test_datadog_tag_overrides_env_tag(self):
+        span = self.run_dd_tags_test('/override')
+        self.assertEqual('overridden', span['meta'].get('team'), span)
+        self.assertEqual('1', span['meta'].get('tier'), span)
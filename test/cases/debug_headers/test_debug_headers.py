@@ -0,0 +1,35 @@
+from .. import case
+
+import re
+
+
+class TestDebugHeaders(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def nginx_trace_ids(self):
+        return [span['trace_id'] for span in self.flush_spans()]
+
+    def test_trailer_in_chunked_response(self):
+        # "--raw" disables curl's decoding of the chunked body, so that the
+        # trailer section appears at the end of the response body.
+        status, headers, body = self.orch.send_nginx_http_request(
+            '/http/chunked', extra_args=['--raw'])
+        self.assertEqual(200, status, body)
+
+        headers = {name.lower(): value for name, value in headers}
+        self.assertEqual('X-Trace-Id', headers.get('trailer'), headers)
+        self.assertEqual('chunked', headers.get('transfer-encoding'), headers)
+
+        match = re.search(r'^X-Trace-Id: ([0-9]+)\r?$', body, re.MULTILINE)
+        self.assertIsNotNone(match, body)
+
+        trace_ids = self.nginx_trace_ids()
+        self.assertEqual([int(match.group(1))], trace_ids)
+
+    def test_no_trailer_when_length_is_known(self):
+        status, headers, body = self.orch.send_nginx_http_request('/http')
+        self.assertEqual(200, status, body)
+
+        names = [name.lower() for name, _ in headers]
+        self.assertNotIn('trailer', names, headers)
+        self.assertNotIn('X-Trace-Id', body)
@@ -0,0 +1,46 @@
+from .. import case
+
+import json
+
+
+class TestDecisionMaker(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def run_test(self, path, expected_dm, headers={}):
+        status, _, body = self.orch.send_nginx_http_request(path,
+                                                            headers=headers)
+        self.assertEqual(200, status, body)
+        upstream_headers = json.loads(body)['headers']
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        span = spans[0]
+        self.assertEqual(expected_dm, span['meta'].get('_dd.p.dm'), span)
+
+        # The decision maker is propagated in both the Datadog and the W3C
+        # trace context headers.
+        self.assertIn(f'_dd.p.dm={expected_dm}',
+                      upstream_headers.get('x-datadog-tags', ''),
+                      upstream_headers)
+        self.assertIn(f't.dm:{expected_dm}',
+                      upstream_headers.get('tracestate', ''),
+                      upstream_headers)
+
+    def test_default(self):
+        # Sampling mechanism "0" is "default".
+        self.run_test('/default', '-0')
+
+    def test_rule(self):
+        # Sampling mechanism "3" is "rule", as configured by
+        # `datadog_sample_rate`.
+        self.run_test('/rule', '-3')
+
+    def test_manual(self):
+        # Sampling mechanism "4" is "manual", as applied by
+        # `datadog_sampling_priority_override`.
+        headers = {
+            'x-datadog-trace-id': '123',
+            'x-datadog-parent-id': '456',
+            'x-datadog-sampling-priority': '2',
+        }
+        self.run_test('/manual', '-4', headers)
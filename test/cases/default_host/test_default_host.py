@@ -0,0 +1,46 @@
+from .. import case
+
+
+class TestDefaultHost(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def send_request_and_get_span(self, path, hostless):
+        if hostless:
+            # An empty header value makes curl omit the header. HTTP/1.0
+            # requests do not require one.
+            headers = {'Host': ''}
+            extra_args = ['--http1.0']
+        else:
+            headers = {}
+            extra_args = []
+        status, _, body = self.orch.send_nginx_http_request(
+            path, headers=headers, extra_args=extra_args)
+        self.assertEqual(200, status, body)
+
+        spans = [
+            span for span in self.flush_spans(service=None)
+            if span['service'] != 'http'
+        ]
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]
+
+    def test_hostless_request_uses_default_host(self):
+        span = self.send_request_and_get_span('/http', hostless=True)
+        self.assertEqual('fallback.example.com', span['meta']['http.host'],
+                         span)
+        self.assertEqual('http://fallback.example.com/http',
+                         span['meta']['http.url'], span)
+        self.assertEqual('fallback.example.com', span['service'], span)
+
+    def test_hostless_request_without_default_host(self):
+        # There is no host at all. The request is still traced, with the
+        # tracer's service name.
+        span = self.send_request_and_get_span('/no-default', hostless=True)
+        self.assertEqual('', span['meta'].get('http.host', ''), span)
+        self.assertEqual('global-service', span['service'], span)
+        self.assertNotIn('_dd.base_service', span['meta'])
+
+    def test_host_header_takes_precedence(self):
+        span = self.send_request_and_get_span('/http', hostless=False)
+        self.assertEqual('nginx', span['meta']['http.host'], span)
+        self.assertEqual('nginx', span['service'], span)
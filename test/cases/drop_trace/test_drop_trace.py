@@ -0,0 +1,46 @@
+from .. import case
+
+import json
+
+
+class TestDropTrace(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def send_request(self, user_agent):
+        """Send a request with the specified `user_agent` to nginx, and return
+        the headers received by the upstream.
+        """
+        status, _, body = self.orch.send_nginx_http_request(
+            '/http', headers={'User-Agent': user_agent})
+        self.assertEqual(200, status, body)
+        return json.loads(body)['headers']
+
+    def nginx_spans(self):
+        """Flush nginx's traces and return the nginx spans received by the
+        agent.
+        """
+        return self.flush_spans()
+
+    def test_probe_is_dropped_but_propagated(self):
+        upstream_headers = self.send_request('kube-probe/1.29')
+
+        # Trace context is still forwarded, marked as dropped.
+        self.assertIn('x-datadog-trace-id', upstream_headers, upstream_headers)
+        self.assertIn('x-datadog-parent-id', upstream_headers,
+                      upstream_headers)
+        self.assertEqual('-1',
+                         upstream_headers.get('x-datadog-sampling-priority'),
+                         upstream_headers)
+
+        # No span is sent to the agent.
+        spans = self.nginx_spans()
+        self.assertEqual([], spans)
+
+    def test_other_requests_are_reported(self):
+        upstream_headers = self.send_request('curl/8.4.0')
+        self.assertIn('x-datadog-trace-id', upstream_headers, upstream_headers)
+
+        spans = self.nginx_spans()
+        self.assertEqual(1, len(spans), spans)
+        self.assertEqual(int(upstream_headers['x-datadog-trace-id']),
+                         spans[0]['trace_id'])
@@ -0,0 +1,30 @@
+from .. import case
+
+
+class TestErrorOn404(case.TestCase):
+    nginx_conf_file = 'error_on_404.conf'
+
+    def nginx_span_error(self, path, expected_status):
+        """Send a request to the specified `path`, and return the "error"
+        property of the resulting nginx span.
+        """
+        status, _, body = self.orch.send_nginx_http_request(path)
+        self.assertEqual(expected_status, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]['error']
+
+    def test_default(self):
+        """Verify that a 404 is not an error by default."""
+        self.assertEqual(0, self.nginx_span_error('/http/status/404', 404))
+
+    def test_on(self):
+        self.assertEqual(1, self.nginx_span_error('/on/http/status/404', 404))
+        # Other 4xx statuses are unaffected.
+        self.assertEqual(0, self.nginx_span_error('/on/http/status/403', 403))
+        self.assertEqual(1, self.nginx_span_error('/on/http/status/500', 500))
+
+    def test_off(self):
+        self.assertEqual(0, self.nginx_span_error('/off/http/status/404', 404))
+        self.assertEqual(1, self.nginx_span_error('/off/http/status/500', 500))
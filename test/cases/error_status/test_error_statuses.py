@@ -0,0 +1,41 @@
+from .. import case
+
+
+class TestErrorStatuses(case.TestCase):
+    nginx_conf_file = 'error_statuses.conf'
+
+    def nginx_span_error(self, path, expected_status):
+        """Send a request to the specified `path`, and return the "error"
+        property of the resulting nginx span.
+        """
+        status, _, body = self.orch.send_nginx_http_request(path)
+        self.assertEqual(expected_status, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]['error']
+
+    def test_configured_statuses(self):
+        """Verify that `datadog_error_statuses` replaces the default set of
+        error statuses.
+        """
+        self.assertEqual(0, self.nginx_span_error('/http/status/404', 404))
+        self.assertEqual(1, self.nginx_span_error('/http/status/403', 403))
+        self.assertEqual(1, self.nginx_span_error('/http/status/503', 503))
+        self.assertEqual(0, self.nginx_span_error('/http', 200))
+
+    def test_error_header(self):
+        """Verify that `datadog_error_on_header` marks a successful response as
+        an error when the response has the configured header.
+        """
+        self.assertEqual(1, self.nginx_span_error('/header', 200))
+
+    def test_invalid_status(self):
+        conf_text = self.conf_path('error_statuses.conf').read_text().replace(
+            '403 500-599', '403 599-500')
+        status, log_lines = self.orch.nginx_test_config(
+            conf_text, 'invalid_error_statuses.conf')
+        self.assertNotEqual(0, status, log_lines)
+        self.assertTrue(
+            any('Invalid argument "599-500" to datadog_error_statuses' in line
+                for line in log_lines), log_lines)
@@ -0,0 +1,73 @@
+from .. import case
+
+# Sending `UPLOAD_BYTES` at `UPLOAD_RATE` bytes per second takes about two
+# seconds.
+UPLOAD_BYTES = 40_000
+UPLOAD_RATE = 20_000
+
+
+class TestExpectContinue(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def send_request_and_get_span(self,
+                                  headers,
+                                  path='/http',
+                                  slow_upload=False):
+        # If nginx did not respond with "100 Continue", then curl would wait
+        # this many seconds before sending the body anyway.
+        expect_timeout_secs = 5
+        extra_args = ['--expect100-timeout', str(expect_timeout_secs)]
+        if slow_upload:
+            req_body = 'x' * UPLOAD_BYTES
+            extra_args += ['--limit-rate', str(UPLOAD_RATE)]
+        else:
+            req_body = 'x' * 4096
+        status, _, body = self.orch.send_nginx_http_request(
+            path,
+            method='POST',
+            headers=headers,
+            req_body=req_body,
+            extra_args=extra_args)
+        self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        span = spans[0]
+
+        duration_secs = span['duration'] / 1e9
+        self.assertLess(duration_secs, expect_timeout_secs, span)
+        return span
+
+    def test_expect_continue(self):
+        span = self.send_request_and_get_span({'Expect': '100-continue'})
+        self.assertEqual('true',
+                         span['meta'].get('http.request.expect_continue'),
+                         span)
+
+    def test_without_expect_continue(self):
+        # Disable curl's default "Expect" header.
+        span = self.send_request_and_get_span({'Expect': ''})
+        self.assertNotIn('http.request.expect_continue', span['meta'], span)
+
+    def test_slow_body_after_continue(self):
+        # The client sends the body slowly once it receives "100 Continue".
+        span = self.send_request_and_get_span({'Expect': '100-continue'},
+                                              slow_upload=True)
+        self.assertGreaterEqual(span['duration'], 1_500_000_000, span)
+        self.assertEqual('true',
+                         span['meta'].get('http.request.expect_continue'),
+                         span)
+
+    def test_slow_body_after_continue_after_body(self):
+        # With `datadog_span_start after-body`, the span begins once the body
+        # is received, so its duration excludes the slow upload.
+        span = self.send_request_and_get_span({'Expect': '100-continue'},
+                                              path='/after-body',
+                                              slow_upload=True)
+        self.assertLess(span['duration'], 1_000_000_000, span)
+        self.assertEqual('true',
+                         span['meta'].get('http.request.expect_continue'),
+                         span)
+        receive_ms = span['meta'].get('nginx.request.body.receive_ms')
+        self.assertIsNotNone(receive_ms, span['meta'])
+        self.assertGreaterEqual(int(receive_ms), 1500, span['meta'])
@@ -0,0 +1,62 @@
+from .. import case
+
+import json
+import threading
+import time
+
+
+def find_key(value, key):
+    """Return the value of the first property named `key` found anywhere in
+    the JSON `value`, or `None` if there isn't one.
+    """
+    if isinstance(value, dict):
+        if key in value:
+            return value[key]
+        value = list(value.values())
+    if isinstance(value, list):
+        for child in value:
+            found = find_key(child, key)
+            if found is not None:
+                return found
+    return None
+
+
+class TestFlushJitter(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def test_config_shows_jitter(self):
+        status, _, body = self.orch.send_nginx_http_request('/config')
+        self.assertEqual(200, status, body)
+        config = json.loads(body)
+        self.assertEqual(1500, find_key(config, 'jitter_milliseconds'),
+                         config)
+
+    def test_jitter_spreads_flushes(self):
+        done = threading.Event()
+
+        def send_requests():
+            while not done.is_set():
+                self.orch.send_nginx_http_request('/http')
+                time.sleep(0.1)
+
+        sender = threading.Thread(target=send_requests)
+        sender.start()
+        try:
+            flush_times = []
+            for _ in range(7):
+                self.orch.wait_for_log_message('agent',
+                                               '^Traces request to ',
+                                               timeout_secs=10)
+                flush_times.append(time.monotonic())
+        finally:
+            done.set()
+            sender.join()
+
+        # Without jitter, nginx would flush every 2 seconds.  With up to 1.5
+        # seconds of jitter, the times between flushes vary from 0.5 to 3.5
+        # seconds.
+        intervals = [
+            later - earlier
+            for earlier, later in zip(flush_times, flush_times[1:])
+        ]
+        self.assertGreater(max(intervals) - min(intervals), 0.3, intervals)
@@ -118,9 +118,89 @@ def parse_docker_compose_down_line(line):
     return ('other', {'payload': line})
 
 
+class MalformedTraceError(Exception):
+    """A trace received by the agent does not conform to the v0.4 schema."""
+
+
+# Fields that every span in a v0.4 trace payload must have, and their types.
+REQUIRED_SPAN_FIELDS = {
+    'trace_id': int,
+    'span_id': int,
+    'name': str,
+    'resource': str,
+    'start': int,
+    'duration': int,
+}
+
+# Fields that a span in a v0.4 trace payload may have, and their types.
+OPTIONAL_SPAN_FIELDS = {
+    'parent_id': int,
+    'service': str,
+    'type': str,
+    'error': int,
+    'meta': dict,
+    'metrics': dict,
+}
+
+
+def validate_trace(trace):
+    """Raise a `MalformedTraceError` describing the first problem found in the
+    specified `trace` (list of list of dict), if it does not conform to the v0.4
+    trace payload schema.
+    """
+    if not isinstance(trace, list):
+        raise MalformedTraceError(
+            f'payload is a {type(trace).__name__}, not a list of chunks')
+
+    for i, chunk in enumerate(trace):
+        if not isinstance(chunk, list):
+            raise MalformedTraceError(
+                f'chunk {i} is a {type(chunk).__name__}, not a list of spans')
+        for j, span in enumerate(chunk):
+            where = f'span {j} of chunk {i}'
+            if not isinstance(span, dict):
+                raise MalformedTraceError(
+                    f'{where} is a {type(span).__name__}, not a map')
+
+            for field in REQUIRED_SPAN_FIELDS:
+                if field not in span:
+                    raise MalformedTraceError(
+                        f'{where} is missing required field "{field}": {span}')
+            for field, expected in {
+                    **REQUIRED_SPAN_FIELDS,
+                    **OPTIONAL_SPAN_FIELDS
+            }.items():
+                # `bool` is a subclass of `int`, but is not an integer here.
+                value = span.get(field)
+                if field in span and (not isinstance(value, expected) or
+                                      isinstance(value, bool)):
+                    raise MalformedTraceError(
+                        f'{where} has field "{field}" of type '
+                        f'{type(value).__name__}, expected {expected.__name__}: '
+                        f'{span}')
+
+            if span['duration'] < 0:
+                raise MalformedTraceError(
+                    f'{where} has negative "duration" {span["duration"]}: {span}'
+                )
+            for tag, value in span.get('meta', {}).items():
+                if not isinstance(value, str):
+                    raise MalformedTraceError(
+                        f'{where} has meta "{tag}" of type '
+                        f'{type(value).__name__}, expected str: {span}')
+            for metric, value in span.get('metrics', {}).items():
+                if not isinstance(value, (int, float)) or isinstance(
+                        value, bool):
+                    raise MalformedTraceError(
+                        f'{where} has metric "{metric}" of type '
+                        f'{type(value).__name__}, expected a number: {span}')
+
+
 def parse_trace(log_line):
     """Return a trace (list of list of dict) parsed from the specified
-    `log_line`, or return `None` if `log_line` is not a trace.
+    `log_line`, or return `None` if `log_line` is not a trace.  Raise a
+    `MalformedTraceError` if `log_line` is a trace that does not conform to the
+    v0.4 schema.
     """
     try:
         trace = json.loads(log_line)
@@ -130,6 +210,7 @@ def parse_trace(log_line):
     if not isinstance(trace, list):
         return None
 
+    validate_trace(trace)
     return trace
 
 
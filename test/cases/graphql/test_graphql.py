@@ -0,0 +1,69 @@
+from .. import case
+
+import json
+
+
+class TestGraphQL(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def send_request_and_get_span(self, path, request):
+        status, _, body = self.orch.send_nginx_http_request(
+            path,
+            method='POST',
+            headers={'Content-Type': 'application/json'},
+            req_body=json.dumps(request))
+        self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]
+
+    def test_named_query(self):
+        span = self.send_request_and_get_span(
+            '/http/graphql', {
+                'query':
+                'query GetUser($id: ID!) { user(id: $id) { name } }',
+                'variables': {
+                    'id': '42'
+                },
+            })
+        self.assertEqual('query GetUser', span['resource'], span)
+        self.assertEqual('query', span['meta'].get('graphql.operation.type'),
+                         span)
+        self.assertEqual('GetUser',
+                         span['meta'].get('graphql.operation.name'), span)
+
+    def test_operation_name_selects_operation(self):
+        span = self.send_request_and_get_span(
+            '/http/graphql', {
+                'query': 'query A { a } mutation B { b }',
+                'operationName': 'B',
+            })
+        self.assertEqual('mutation B', span['resource'], span)
+
+    def test_anonymous_query(self):
+        span = self.send_request_and_get_span('/http/graphql',
+                                              {'query': '{ user { name } }'})
+        self.assertEqual('query anonymous', span['resource'], span)
+        self.assertNotIn('graphql.operation.name', span['meta'])
+
+    def test_disabled(self):
+        span = self.send_request_and_get_span(
+            '/http', {'query': 'query GetUser { user { name } }'})
+        self.assertEqual('POST /http', span['resource'], span)
+        self.assertNotIn('graphql.operation.type', span['meta'])
+
+    def test_max_length_applies(self):
+        span = self.send_request_and_get_span(
+            '/http/graphql-short', {'query': 'query GetUser { user { name } }'})
+        self.assertEqual('query G...', span['resource'], span)
+        self.assertEqual('true', span['meta'].get('_dd.resource.truncated'),
+                         span)
+
+    def test_configured_resource_name_takes_precedence(self):
+        span = self.send_request_and_get_span(
+            '/http/graphql-named', {'query': 'query GetUser { user { name } }'})
+        self.assertEqual('graphql endpoint', span['resource'], span)
+        # The operation is still tagged.
+        self.assertEqual('GetUser',
+                         span['meta'].get('graphql.operation.name'), span)
@@ -0,0 +1,75 @@
+from .. import case
+from ..orchestration import child_env, docker_compose_command
+
+import json
+from pathlib import Path
+import subprocess
+
+
+def client_supports_http3():
+    """Return whether curl in the client service supports HTTP/3."""
+    command = docker_compose_command('exec', '-T', '--', 'client', 'curl',
+                                     '--version')
+    result = subprocess.run(command,
+                            stdin=subprocess.DEVNULL,
+                            stdout=subprocess.PIPE,
+                            stderr=subprocess.DEVNULL,
+                            encoding='utf8',
+                            env=child_env())
+    return result.returncode == 0 and 'HTTP3' in result.stdout.split()
+
+
+class TestHTTP3(case.TestCase):
+
+    def setUp(self):
+        super().setUp()
+        if not client_supports_http3():
+            self.skipTest('curl in the client does not support HTTP/3')
+
+        # Use the same self-signed certificate as the TLS tests.
+        cert_dir = Path(__file__).parent.parent / 'tls' / 'conf'
+        for name in ('nginx.crt', 'nginx.key'):
+            self.orch.nginx_replace_file(f'/tmp/datadog-tests-{name}',
+                                         (cert_dir / name).read_text())
+
+        conf_text = self.conf_path('http3.conf').read_text()
+        status, log_lines = self.orch.nginx_replace_config(
+            conf_text, 'http3.conf')
+        if status != 0 and any('"quic"' in line for line in log_lines):
+            self.skipTest('nginx is not built with the HTTP/3 module')
+        self.assertEqual(0, status, log_lines)
+
+        # Consume any previous logging from the agent.
+        self.orch.sync_service('agent')
+
+    def test_extraction_and_injection(self):
+        trace_id = 1234
+        parent_id = 5678
+        # The certificate is self-signed, so don't verify it.
+        status, headers, body = self.orch.send_nginx_http_request(
+            '/http',
+            port=443,
+            scheme='https',
+            headers={
+                'x-datadog-trace-id': str(trace_id),
+                'x-datadog-parent-id': str(parent_id),
+            },
+            extra_args=['--insecure', '--http3-only'])
+        self.assertEqual(200, status, body)
+
+        headers = {name.lower(): value for name, value in headers}
+        self.assertEqual(str(trace_id),
+                         headers.get('x-datadog-test-trace-id'), headers)
+
+        upstream_headers = json.loads(body)['headers']
+        self.assertEqual(str(trace_id),
+                         upstream_headers.get('x-datadog-trace-id'),
+                         upstream_headers)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        span = spans[0]
+        self.assertEqual(trace_id, span['trace_id'])
+        self.assertEqual(parent_id, span['parent_id'])
+        self.assertEqual(upstream_headers.get('x-datadog-parent-id'),
+                         str(span['span_id']))
@@ -0,0 +1,28 @@
+from .. import case
+
+import json
+
+
+class TestLogCorrelationHeader(case.TestCase):
+
+    def test_header_contains_trace_id(self):
+        self.replace_nginx_config('http.conf')
+
+        status, _, body = self.orch.send_nginx_http_request('/http')
+        self.assertEqual(200, status, body)
+        headers = json.loads(body)['headers']
+
+        # The correlation header coexists with the propagation headers.
+        self.assertIn('x-request-trace', headers, headers)
+        self.assertIn('x-datadog-trace-id', headers, headers)
+        self.assertIn('x-datadog-parent-id', headers, headers)
+
+        hex_trace_id = headers['x-request-trace']
+        self.assertRegex(hex_trace_id, r'^[0-9a-f]{32}$')
+        # "x-datadog-trace-id" contains the lower 64 bits of the trace ID.
+        lower_64_bits = int(hex_trace_id, 16) & (2**64 - 1)
+        self.assertEqual(int(headers['x-datadog-trace-id']), lower_64_bits)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        self.assertEqual(spans[0]['trace_id'], lower_64_bits)
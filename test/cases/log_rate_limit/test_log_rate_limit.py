@@ -0,0 +1,63 @@
+from .. import case
+
+from pathlib import Path
+import re
+import time
+
+LOG_FILE = '/tmp/datadog-tests-log-rate-limit.log'
+
+
+class TestLogRateLimit(case.TestCase):
+
+    def flush_failures(self, conf_name, requests=4, quiet_secs=0):
+        """Run nginx with the configuration at `conf_name` long enough for
+        `requests` flushes of traces to fail, then for `quiet_secs` more
+        seconds without requests, and return the lines of its error log that
+        come from the tracer.
+        """
+        self.orch.nginx_replace_file(LOG_FILE, '')
+        nginx_conf = (Path(__file__).parent / 'conf' / conf_name).read_text()
+        with self.orch.custom_nginx(nginx_conf, healthcheck_port=8080):
+            # The tracer flushes every two seconds, if it has traces to send.
+            for _ in range(requests):
+                status, _, body = self.orch.send_nginx_http_request('/', 8080)
+                self.assertEqual(200, status, body)
+                time.sleep(2.5)
+            time.sleep(quiet_secs)
+
+        log_lines = self.orch.nginx_read_file(LOG_FILE).split('\n')
+        return [line for line in log_lines if 'datadog: ' in line]
+
+    def test_repeated_errors_are_collapsed(self):
+        # The limit is long, so each distinct error is logged once.
+        lines = self.flush_failures('limited.conf')
+        messages = [re.sub(r'^.*?datadog: ', '', line) for line in lines]
+        self.assertNotEqual([], messages)
+        self.assertEqual(len(set(messages)), len(messages), lines)
+
+    def test_repeated_errors_without_limit(self):
+        lines = self.flush_failures('unlimited.conf')
+        messages = [re.sub(r'^.*?datadog: ', '', line) for line in lines]
+        self.assertLess(len(set(messages)), len(messages), lines)
+
+    def test_summary_without_repetition(self):
+        """Verify that the count of suppressed repetitions is logged once the
+        limit's interval has passed, even though the error does not occur
+        again, because there are no more traces to flush.
+        """
+        # Two failed flushes, then more than the 5 second limit without any.
+        lines = self.flush_failures('short_limit.conf',
+                                    requests=2,
+                                    quiet_secs=8)
+        summaries = [
+            (index, match.group(1)) for index, line in enumerate(lines)
+            for match in [re.search(r'suppressed \d+ repetitions of "(.*)"$',
+                                    line)] if match
+        ]
+        self.assertNotEqual([], summaries, lines)
+        for index, message in summaries:
+            later = [
+                line for line in lines[index + 1:]
+                if line.endswith('datadog: ' + message)
+            ]
+            self.assertEqual([], later, lines)
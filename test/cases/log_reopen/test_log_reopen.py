@@ -0,0 +1,63 @@
+from .. import case
+
+import time
+
+LOG_FILE = '/tmp/datadog-tests-log-reopen.log'
+
+
+class TestLogReopen(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def tearDown(self):
+        self.orch.setup_traces_status(None)
+        super().tearDown()
+
+    def test_reopen_while_flushing(self):
+        # The tracer flushes every couple of seconds.  Reopen the logs after
+        # each request, spread over several flushes, so that some reopens
+        # happen while traces are queued and some while they are being sent.
+        num_requests = 20
+        for _ in range(num_requests):
+            status, _, body = self.orch.send_nginx_http_request('/http')
+            self.assertEqual(200, status, body)
+            self.orch.reopen_nginx_logs()
+            time.sleep(0.25)
+
+        spans = self.flush_spans()
+        self.assertEqual(num_requests, len(spans), spans)
+
+    def test_reopen_while_agent_fails(self):
+        """Verify that errors logged by the tracer's HTTP client thread while
+        the logs are being reopened reach the reopened log, and that spans sent
+        before then are not lost.
+        """
+        self.orch.nginx_replace_file(LOG_FILE, '')
+
+        num_requests = 5
+        for _ in range(num_requests):
+            status, _, body = self.orch.send_nginx_http_request('/http/before')
+            self.assertEqual(200, status, body)
+            self.orch.reopen_nginx_logs()
+        # Wait for the tracer's next flush, which the agent accepts.
+        time.sleep(3)
+        spans = [
+            span for span in self.spans_in(self.orch.sync_service('agent'))
+            if span['resource'] == 'GET /http/before'
+        ]
+        self.assertEqual(num_requests, len(spans), spans)
+
+        # Now the agent rejects traces. The rejection is logged by the HTTP
+        # client's thread, so it must wait for the worker's main thread, which
+        # is reopening the logs.
+        status, _, body = self.orch.setup_traces_status(500)
+        self.assertEqual(200, status, body)
+        status, _, body = self.orch.send_nginx_http_request('/http/during')
+        self.assertEqual(200, status, body)
+        deadline = time.monotonic() + 4
+        while time.monotonic() < deadline:
+            self.orch.reopen_nginx_logs()
+        # Pending messages are written about once per second.
+        time.sleep(1.5)
+
+        log = self.orch.nginx_read_file(LOG_FILE)
+        self.assertIn('The Datadog Agent rejected 1 span', log)
@@ -0,0 +1,40 @@
+from .. import case
+
+import json
+import threading
+
+
+class TestMaxActiveSpans(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def test_shed_beyond_limit(self):
+        # Six requests that each take two seconds are in progress at once, but
+        # only two of them fit within the limit.
+        results = []
+
+        def send_slow_request():
+            results.append(
+                self.orch.send_nginx_http_request('/http/delay/2000'))
+
+        threads = [threading.Thread(target=send_slow_request) for _ in range(6)]
+        for thread in threads:
+            thread.start()
+        for thread in threads:
+            thread.join()
+
+        self.assertEqual(6, len(results))
+        for status, _, body in results:
+            self.assertEqual(200, status, body)
+            # Trace context is propagated even for shed requests.
+            upstream_headers = json.loads(body)['headers']
+            self.assertIn('traceparent', upstream_headers, upstream_headers)
+
+        # Once the slow requests are finished, a new request is traced again.
+        status, _, body = self.orch.send_nginx_http_request('/http')
+        self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(3, len(spans), spans)
+
+        last = max(spans, key=lambda span: span['start'])
+        self.assertEqual(4, last['metrics'].get('_dd.spans_shed'), last)
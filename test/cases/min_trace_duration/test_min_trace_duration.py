@@ -0,0 +1,28 @@
+from .. import case
+
+
+class TestMinTraceDuration(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def send_request_and_get_spans(self, path):
+        status, _, body = self.orch.send_nginx_http_request(path)
+        self.assertEqual(200, status, body)
+
+        return self.flush_spans()
+
+    def send_request_and_get_span(self, path):
+        spans = self.send_request_and_get_spans(path)
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]
+
+    def test_fast_request_is_not_submitted(self):
+        # A static response takes well under a millisecond.
+        spans = self.send_request_and_get_spans('/static')
+        self.assertEqual([], spans)
+
+    def test_slow_request_is_kept(self):
+        # The upstream waits 10 milliseconds before responding.
+        span = self.send_request_and_get_span('/http/delay/10')
+        self.assertGreaterEqual(span['duration'], 10_000_000, span)
+        self.assertGreater(span['metrics']['_sampling_priority_v1'], 0, span)
+        self.assertNotIn('_dd.nginx.below_min_duration', span['metrics'])
@@ -0,0 +1,48 @@
+from .. import case
+
+
+class TestMTLS(case.TestCase):
+
+    def setUp(self):
+        super().setUp()
+        for name in ('nginx.crt', 'nginx.key', 'ca.crt'):
+            self.orch.nginx_replace_file(f'/tmp/datadog-tests-{name}',
+                                         self.conf_path(name).read_text())
+        for name in ('client.crt', 'client.key'):
+            self.orch.client_replace_file(f'/tmp/datadog-tests-{name}',
+                                          self.conf_path(name).read_text())
+
+        self.replace_nginx_config('mtls.conf')
+
+    def send_request_and_get_span(self, extra_args=()):
+        # The server certificate is self-signed, so don't verify it.
+        status, _, body = self.orch.send_nginx_http_request(
+            '/http',
+            port=443,
+            scheme='https',
+            extra_args=['--insecure', *extra_args])
+        self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]
+
+    def test_client_certificate(self):
+        span = self.send_request_and_get_span(extra_args=[
+            '--cert', '/tmp/datadog-tests-client.crt', '--key',
+            '/tmp/datadog-tests-client.key'
+        ])
+        meta = span['meta']
+        self.assertEqual('CN=client,O=Datadog Tests',
+                         meta.get('tls.client.subject'), meta)
+        self.assertEqual('CN=Test CA,O=Datadog Tests',
+                         meta.get('tls.client.issuer'), meta)
+        self.assertEqual('1234ABCD', meta.get('tls.client.serial'), meta)
+
+    def test_no_client_certificate(self):
+        span = self.send_request_and_get_span()
+        meta = span['meta']
+        self.assertIn('tls.version', meta, meta)
+        for tag in ('tls.client.subject', 'tls.client.issuer',
+                    'tls.client.serial'):
+            self.assertNotIn(tag, meta, meta)
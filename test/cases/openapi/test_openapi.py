@@ -0,0 +1,57 @@
+from .. import case
+
+
+class TestOpenAPI(case.TestCase):
+
+    def setUp(self):
+        super().setUp()
+        self.orch.nginx_replace_file('/tmp/datadog-tests-openapi.json',
+                                     self.conf_path('spec.json').read_text())
+        self.replace_nginx_config('http.conf')
+
+    def send_request_and_get_span(self, path, method='GET'):
+        status, _, body = self.orch.send_nginx_http_request(path,
+                                                            method=method)
+        self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]
+
+    def test_path_parameter(self):
+        span = self.send_request_and_get_span('/pets/42')
+        self.assertEqual('GET /pets/{petId}', span['resource'], span)
+        self.assertEqual('/pets/{petId}', span['meta'].get('http.route'),
+                         span)
+
+    def test_literal_takes_precedence(self):
+        span = self.send_request_and_get_span('/pets/mine')
+        self.assertEqual('GET /pets/mine', span['resource'], span)
+
+    def test_method_not_in_spec(self):
+        # "/pets/{petId}" has no "post" operation.
+        span = self.send_request_and_get_span('/pets/42', method='POST')
+        self.assertEqual('POST /pets/{id}', span['resource'], span)
+        self.assertNotIn('http.route', span['meta'])
+
+    def test_unmatched(self):
+        span = self.send_request_and_get_span('/http/orders/7')
+        self.assertEqual('GET /http/orders/{id}', span['resource'], span)
+        self.assertNotIn('http.route', span['meta'])
+
+    def test_configured_resource_name_takes_precedence(self):
+        span = self.send_request_and_get_span('/pets/7')
+        self.assertEqual('pet lookup', span['resource'], span)
+        # The route is still tagged.
+        self.assertEqual('/pets/{petId}', span['meta'].get('http.route'),
+                         span)
+
+    def test_invalid_spec(self):
+        self.orch.nginx_replace_file('/tmp/datadog-tests-openapi.json',
+                                     '{"openapi": "3.0.0"}')
+        status, log_lines = self.orch.nginx_test_config(
+            self.conf_path('http.conf').read_text(), 'invalid_spec.conf')
+        self.assertNotEqual(0, status, log_lines)
+        self.assertTrue(
+            any('does not have a "paths" object' in line
+                for line in log_lines), log_lines)
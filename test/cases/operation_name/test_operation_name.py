@@ -162,3 +162,48 @@ class TestOperationName(case.TestCase):
                 on_chunk(chunk)
 
         self.assertTrue(found_nginx_trace)
+
+    def test_per_location(self):
+        """Verify that `datadog_operation_name` directives in different
+        `location` blocks produce request spans having different operation
+        names.
+        """
+        conf_path = Path(__file__).parent / "./conf/per_location.conf"
+        conf_text = conf_path.read_text()
+        status, log_lines = self.orch.nginx_replace_config(
+            conf_text, conf_path.name)
+        self.assertEqual(0, status, log_lines)
+
+        # Clear any outstanding logs from the agent.
+        self.orch.sync_service("agent")
+
+        for path in ("/proxy", "/static"):
+            status, _, _ = self.orch.send_nginx_http_request(path)
+            self.assertEqual(200, status, path)
+
+        # Reload nginx to force it to send its traces.
+        self.orch.reload_nginx()
+
+        log_lines = self.orch.sync_service("agent")
+        names_by_resource = {
+            span["resource"]: span["name"]
+            for span in formats.parse_spans(log_lines)
+            if span["service"] == "nginx"
+        }
+        self.assertEqual(
+            {
+                "GET /proxy": "nginx.proxy",
+                "GET /static": "nginx.static"
+            }, names_by_resource)
+
+    def test_empty_is_rejected(self):
+        """Verify that an empty `datadog_operation_name` is a configuration
+        error.
+        """
+        conf_path = Path(__file__).parent / "./conf/empty.conf"
+        conf_text = conf_path.read_text()
+        status, log_lines = self.orch.nginx_test_config(
+            conf_text, conf_path.name)
+        self.assertNotEqual(0, status, log_lines)
+        excerpt = 'Invalid argument "" to datadog_operation_name directive'
+        self.assertTrue(any(excerpt in line for line in log_lines), log_lines)
@@ -307,7 +307,7 @@ def docker_compose_services():
     return result.stdout.split()
 
 
-def curl(url, headers, stderr=None, method='GET', body=None):
+def curl(url, headers, stderr=None, method='GET', body=None, extra_args=()):
 
     def header_args():
         if isinstance(headers, dict):
@@ -336,7 +336,8 @@ def curl(url, headers, stderr=None, method='GET', body=None):
     # color.
     command = docker_compose_command('exec', '-T', '--', 'client',
                                      'curljson.sh', f'-X{method}',
-                                     *header_args(), *body_args, url)
+                                     *header_args(), *body_args,
+                                     *extra_args, url)
     result = subprocess.run(command,
                             input=body if body is not None else '',
                             stdout=subprocess.PIPE,
@@ -494,17 +495,23 @@ class Orchestration:
                                 port=80,
                                 headers={},
                                 method='GET',
-                                req_body=None):
+                                req_body=None,
+                                extra_args=(),
+                                scheme='http'):
         """Send a "GET <path>" request to nginx, and return the resulting HTTP
         status code and response body as a tuple `(status, body)`.
+        Additional command line arguments to curl, e.g. `['--raw']`, can be
+        specified as `extra_args`.  Specify `scheme='https'` to connect to a
+        TLS listener.
         """
-        url = f'http://nginx:{port}{path}'
+        url = f'{scheme}://nginx:{port}{path}'
         print('fetching', url, file=self.verbose, flush=True)
         fields, headers, body = curl(url,
                                      headers,
                                      body=req_body,
                                      stderr=self.verbose,
-                                     method=method)
+                                     method=method,
+                                     extra_args=extra_args)
         return fields['response_code'], headers, body
 
     def setup_remote_config_payload(self, payload):
@@ -517,6 +524,32 @@ class Orchestration:
                                      method='POST')
         return fields['response_code'], headers, body
 
+    def setup_traces_response(self, payload):
+        """Sets up the response that the agent sends to subsequent trace
+        submissions, e.g. to configure `rate_by_service`.  An empty `payload`
+        restores the default response, `{}`.
+        """
+        url = f'http://agent:8126/save_traces_resp'
+        print('posting', url, file=self.verbose, flush=True)
+        fields, headers, body = curl(url, {},
+                                     body=payload,
+                                     stderr=self.verbose,
+                                     method='POST')
+        return fields['response_code'], headers, body
+
+    def setup_traces_status(self, status):
+        """Sets up the status of the response that the agent sends to
+        subsequent trace submissions, e.g. 413.  A `status` of `None` restores
+        the default status, 200.
+        """
+        url = f'http://agent:8126/save_traces_status'
+        print('posting', url, file=self.verbose, flush=True)
+        fields, headers, body = curl(url, {},
+                                     body='' if status is None else str(status),
+                                     stderr=self.verbose,
+                                     method='POST')
+        return fields['response_code'], headers, body
+
     def send_nginx_grpc_request(self, symbol, port=1337):
         """Send an empty gRPC request to the nginx endpoint at "/", where
         the gRPC request is named by `symbol`, which has the form
@@ -706,6 +739,21 @@ exit "$rcode"
                     )
                 time.sleep(poll_period_seconds)
 
+    def reopen_nginx_logs(self):
+        """Send a "reopen" signal to nginx, as log rotation does."""
+        # "-T" means "don't allocate a TTY".  This is necessary to avoid the
+        # error "the input device is not a TTY".
+        command = docker_compose_command('exec', '-T', '--', 'nginx', 'nginx',
+                                         '-s', 'reopen')
+        with print_duration('Sending the reopen signal to nginx',
+                            self.verbose):
+            subprocess.run(command,
+                           stdin=subprocess.DEVNULL,
+                           stdout=self.verbose,
+                           stderr=self.verbose,
+                           env=child_env(),
+                           check=True)
+
     def nginx_replace_config(self, nginx_conf_text, file_name):
         """Replace nginx's config and reload nginx.
 
@@ -759,6 +807,55 @@ END_CONF
                        check=True,
                        encoding='utf8')
 
+    def client_replace_file(self, file, content):
+        """Replaces the contents of an arbitrary file in the client container,
+        e.g. a certificate for curl to present to nginx."""
+
+        script = f"""
+>{file} cat <<'END_CONF'
+{content}
+END_CONF
+"""
+        # "-T" means "don't allocate a TTY".  This is necessary to avoid the
+        # error "the input device is not a TTY".
+        command = docker_compose_command('exec', '-T', '--', 'client',
+                                         '/bin/sh')
+        subprocess.run(command,
+                       input=script,
+                       stdout=self.verbose,
+                       stderr=self.verbose,
+                       env=child_env(),
+                       check=True,
+                       encoding='utf8')
+
+    def nginx_read_file(self, file):
+        """Return the contents of an arbitrary file in the nginx container."""
+        # "-T" means "don't allocate a TTY".  This is necessary to avoid the
+        # error "the input device is not a TTY".
+        command = docker_compose_command('exec', '-T', '--', 'nginx', 'cat',
+                                         file)
+        result = subprocess.run(command,
+                                stdin=subprocess.DEVNULL,
+                                stdout=subprocess.PIPE,
+                                stderr=self.verbose,
+                                env=child_env(),
+                                check=True,
+                                encoding='utf8')
+        return result.stdout
+
+    def nginx_file_exists(self, file):
+        """Return whether an arbitrary file exists in the nginx container."""
+        # "-T" means "don't allocate a TTY".  This is necessary to avoid the
+        # error "the input device is not a TTY".
+        command = docker_compose_command('exec', '-T', '--', 'nginx', 'test',
+                                         '-e', file)
+        result = subprocess.run(command,
+                                stdin=subprocess.DEVNULL,
+                                stdout=self.verbose,
+                                stderr=self.verbose,
+                                env=child_env())
+        return result.returncode == 0
+
     @contextlib.contextmanager
     def custom_nginx(self, nginx_conf, extra_env=None, healthcheck_port=None):
         """Yield a managed `Popen` object referring to a new instance of nginx
@@ -0,0 +1,35 @@
+from .. import case
+
+
+class TestPeerService(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def send_request_and_get_meta(self, path):
+        status, _, body = self.orch.send_nginx_http_request(path)
+        self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]['meta']
+
+    def test_proxy_host(self):
+        meta = self.send_request_and_get_meta('/http')
+        self.assertEqual('http', meta.get('peer.service'), meta)
+        self.assertEqual('upstream.name', meta.get('_dd.peer.service.source'),
+                         meta)
+
+    def test_upstream_block(self):
+        meta = self.send_request_and_get_meta('/payments')
+        self.assertEqual('payments', meta.get('peer.service'), meta)
+        self.assertEqual(meta.get('upstream.name'), meta.get('peer.service'),
+                         meta)
+
+    def test_override(self):
+        meta = self.send_request_and_get_meta('/override')
+        self.assertEqual('billing-GET', meta.get('peer.service'), meta)
+        self.assertEqual('peer.service', meta.get('_dd.peer.service.source'),
+                         meta)
+
+    def test_not_proxied(self):
+        meta = self.send_request_and_get_meta('/static')
+        self.assertNotIn('peer.service', meta)
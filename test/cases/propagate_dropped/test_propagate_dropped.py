@@ -0,0 +1,43 @@
+from .. import case
+
+import json
+
+# Headers that carry a trace ID, one for each configured propagation style.
+TRACE_ID_HEADERS = ('traceparent', 'x-datadog-trace-id', 'x-b3-traceid')
+
+
+class TestPropagateDropped(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def upstream_headers(self, path):
+        status, _, body = self.orch.send_nginx_http_request(path)
+        self.assertEqual(200, status, body)
+        return json.loads(body)['headers']
+
+    def test_dropped_trace_propagated_by_default(self):
+        headers = self.upstream_headers('/http')
+        for name in TRACE_ID_HEADERS:
+            self.assertIn(name, headers, headers)
+        self.assertEqual('-1', headers.get('x-datadog-sampling-priority'),
+                         headers)
+        self.assertTrue(headers['traceparent'].endswith('-00'), headers)
+
+    def test_dropped_trace_not_propagated(self):
+        headers = self.upstream_headers('/http/off')
+        for name in TRACE_ID_HEADERS:
+            self.assertNotIn(name, headers, headers)
+        self.assertNotIn('x-datadog-sampling-priority', headers, headers)
+        self.assertNotIn('x-b3-sampled', headers, headers)
+
+    def test_dropped_trace_flag_only(self):
+        headers = self.upstream_headers('/http/flag-only')
+        for name in TRACE_ID_HEADERS:
+            self.assertNotIn(name, headers, headers)
+        self.assertEqual('-1', headers.get('x-datadog-sampling-priority'),
+                         headers)
+        self.assertEqual('0', headers.get('x-b3-sampled'), headers)
+
+    def test_kept_trace_propagated(self):
+        headers = self.upstream_headers('/http/kept')
+        for name in TRACE_ID_HEADERS:
+            self.assertIn(name, headers, headers)
@@ -0,0 +1,65 @@
+from .. import case
+
+import json
+
+
+class TestPropagatedTags(case.TestCase):
+
+    def send_request(self, conf_name, tags):
+        self.replace_nginx_config(conf_name)
+
+        headers = {
+            'X-Datadog-Trace-Id': '1234567890',
+            'X-Datadog-Parent-Id': '987654321',
+            'X-Datadog-Tags': tags,
+        }
+        status, _, body = self.orch.send_nginx_http_request('/http',
+                                                            headers=headers)
+        self.assertEqual(200, status, body)
+        upstream_headers = json.loads(body)['headers']
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        return upstream_headers, spans[0]
+
+    def propagated_tags(self, upstream_headers):
+        header = upstream_headers.get('x-datadog-tags', '')
+        return dict(tag.split('=', 1) for tag in header.split(',') if tag)
+
+    def test_round_trip(self):
+        upstream_headers, span = self.send_request(
+            'default.conf', '_dd.p.dm=-4,_dd.p.team=edge')
+
+        self.assertEqual('edge', span['meta'].get('_dd.p.team'), span['meta'])
+        self.assertNotIn('_dd.propagation_error', span['meta'], span['meta'])
+
+        tags = self.propagated_tags(upstream_headers)
+        self.assertEqual('edge', tags.get('_dd.p.team'), upstream_headers)
+        self.assertIn('_dd.p.dm', tags, upstream_headers)
+
+    def test_extract_over_limit(self):
+        # The header is larger than the default limit of 512 bytes.
+        tags = '_dd.p.team=edge,_dd.p.padding=' + 'x' * 512
+        upstream_headers, span = self.send_request('default.conf', tags)
+
+        self.assertEqual('extract_max_size',
+                         span['meta'].get('_dd.propagation_error'),
+                         span['meta'])
+        self.assertNotIn('_dd.p.team', span['meta'], span['meta'])
+
+        tags = self.propagated_tags(upstream_headers)
+        self.assertNotIn('_dd.p.team', tags, upstream_headers)
+        self.assertNotIn('_dd.p.padding', tags, upstream_headers)
+
+    def test_inject_over_limit(self):
+        # The extracted header fits within the configured limit of 64 bytes,
+        # but the sampling decision made by nginx adds "_dd.p.dm", and the
+        # resulting header does not.
+        tags = '_dd.p.padding=' + 'x' * 40
+        self.assertLessEqual(len(tags), 64)
+        upstream_headers, span = self.send_request('small.conf', tags)
+
+        self.assertEqual('inject_max_size',
+                         span['meta'].get('_dd.propagation_error'),
+                         span['meta'])
+        self.assertNotIn('x-datadog-tags', upstream_headers, upstream_headers)
@@ -0,0 +1,32 @@
+from .. import case
+
+
+class TestProxyProtocol(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def send_request_and_get_meta(self, port, extra_args=(), path='/http'):
+        status, _, body = self.orch.send_nginx_http_request(
+            path, port, extra_args=extra_args)
+        self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]['meta']
+
+    def test_proxy_protocol_client_ip(self):
+        meta = self.send_request_and_get_meta(
+            8090, extra_args=['--haproxy-clientip', '203.0.113.7'])
+        self.assertEqual('203.0.113.7', meta.get('http.client_ip'), meta)
+
+    def test_peer_client_ip(self):
+        meta = self.send_request_and_get_meta(80)
+        # Without a PROXY protocol header, the client is the peer.
+        peer_ip = meta['peer.address'].rsplit(':', 1)[0]
+        self.assertEqual(peer_ip, meta.get('http.client_ip'), meta)
+
+    def test_client_ip_tag_off(self):
+        meta = self.send_request_and_get_meta(
+            8090,
+            extra_args=['--haproxy-clientip', '203.0.113.7'],
+            path='/untagged')
+        self.assertNotIn('http.client_ip', meta)
@@ -0,0 +1,34 @@
+from .. import case
+
+
+class TestRequestBodyBytes(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def send_request_and_get_span(self, **kwargs):
+        status, _, body = self.orch.send_nginx_http_request('/http', **kwargs)
+        self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]
+
+    def test_chunked_body(self):
+        req_body = 'x' * 10000
+        span = self.send_request_and_get_span(
+            method='POST',
+            headers={'Transfer-Encoding': 'chunked'},
+            req_body=req_body)
+        self.assertEqual(str(len(req_body)),
+                         span['meta'].get('http.request.body.bytes'),
+                         span['meta'])
+
+    def test_body_with_content_length(self):
+        req_body = 'hello, world'
+        span = self.send_request_and_get_span(method='POST', req_body=req_body)
+        self.assertEqual(str(len(req_body)),
+                         span['meta'].get('http.request.body.bytes'),
+                         span['meta'])
+
+    def test_no_body(self):
+        span = self.send_request_and_get_span()
+        self.assertNotIn('http.request.body.bytes', span['meta'])
@@ -127,7 +127,90 @@ class TestResourceName(case.TestCase):
         return self.run_resource_name_test(
             './conf/manual_in_location_at_http.conf', on_chunk)
 
-    def run_resource_name_test(self, conf_relative_path, on_chunk):
+    def test_long_uri_is_truncated(self):
+        """Verify that a resource name longer than the default maximum length
+        is truncated, while the "http.url" tag is capped separately.
+        """
+        path = '/foo/' + 'a' * 6000
+
+        def on_chunk(chunk):
+            first, *rest = chunk
+            self.assertEqual(0, len(rest), chunk)
+            resource = first['resource']
+            self.assertEqual(5000, len(resource))
+            self.assertEqual('GET ' + path[:4993] + '...', resource)
+            self.assertEqual('true',
+                             first['meta'].get('_dd.resource.truncated'))
+            # 8192 is the default maximum length of "http.url".
+            self.assertEqual('http://nginx' + path,
+                             first['meta'].get('http.url'))
+
+        return self.run_resource_name_test('./conf/default_in_request.conf',
+                                           on_chunk,
+                                           path=path)
+
+    def test_configured_max_lengths(self):
+        path = '/foo/' + 'a' * 6000
+
+        def on_chunk(chunk):
+            first, *rest = chunk
+            self.assertEqual(0, len(rest), chunk)
+            self.assertEqual('GET /foo/aaaaaaaa...', first['resource'])
+            self.assertEqual('true',
+                             first['meta'].get('_dd.resource.truncated'))
+            self.assertEqual('http://nginx/foo/aaaaaaaaaaaa...',
+                             first['meta'].get('http.url'))
+
+        return self.run_resource_name_test('./conf/max_length.conf',
+                                           on_chunk,
+                                           path=path)
+
+    def test_short_uri_is_not_truncated(self):
+
+        def on_chunk(chunk):
+            first, *rest = chunk
+            self.assertEqual('GET /foo', first['resource'], chunk)
+            self.assertNotIn('_dd.resource.truncated', first['meta'])
+
+        return self.run_resource_name_test('./conf/max_length.conf', on_chunk)
+
+    def test_max_length_too_short(self):
+        """Verify that a maximum length with no room for the "..." that ends
+        a truncated value is rejected.
+        """
+        for directive in ('datadog_resource_max_length 20;',
+                          'datadog_url_max_length 32;'):
+            name = directive.split()[0]
+            conf_text = Path(__file__).parent.joinpath(
+                './conf/max_length.conf').read_text().replace(
+                    directive, f'{name} 2;')
+            status, log_lines = self.orch.nginx_test_config(
+                conf_text, 'max_length_too_short.conf')
+            self.assertNotEqual(0, status, log_lines)
+            self.assertTrue(
+                any('value must be equal to or greater than 3' in line
+                    for line in log_lines), log_lines)
+
+    def test_normalize_pattern(self):
+        """Verify that `datadog_resource_normalize_pattern` directives replace
+        matching parts of the resource name, but not of the "http.url" tag.
+        """
+        path = '/v1/tenants/0b6a97f4-2c1d-4e8a-9f3b-5d7c8e9f0a1b/orders/42'
+
+        def on_chunk(chunk):
+            first, *rest = chunk
+            self.assertEqual(0, len(rest), chunk)
+            self.assertEqual('GET /v1/tenants/{uuid}/orders/{id}',
+                             first['resource'], chunk)
+            self.assertEqual('http://nginx' + path,
+                             first['meta'].get('http.url'))
+
+        return self.run_resource_name_test('./conf/normalize_pattern.conf',
+                                           on_chunk,
+                                           path=path)
+
+    def run_resource_name_test(self, conf_relative_path, on_chunk,
+                               path='/foo'):
         conf_path = Path(__file__).parent / conf_relative_path
         conf_text = conf_path.read_text()
         status, log_lines = self.orch.nginx_replace_config(
@@ -137,7 +220,7 @@ class TestResourceName(case.TestCase):
         # Clear any outstanding logs from the agent.
         self.orch.sync_service('agent')
 
-        status, _, _ = self.orch.send_nginx_http_request('/foo')
+        status, _, _ = self.orch.send_nginx_http_request(path)
         self.assertEqual(200, status)
 
         # Reload nginx to force it to send its traces.
@@ -1,6 +1,7 @@
 from .. import case
 from .. import formats
 
+import json
 from pathlib import Path
 
 
@@ -244,3 +245,94 @@ class TestSampleRate(case.TestCase):
                 expected_rate=expected_rate,
                 expected_line=expected_line,
                 expected_dupe=expected_dupe)
+
+    def run_manual_keep_test(self, path, expected_priority):
+        conf_path = Path(__file__).parent / './conf/manual_keep.conf'
+        conf_text = conf_path.read_text()
+        status, log_lines = self.orch.nginx_replace_config(
+            conf_text, conf_path.name)
+        self.assertEqual(0, status, log_lines)
+
+        self.orch.sync_service('agent')
+
+        headers = {'x-datadog-sampling-priority': '2'}
+        status, _, body = self.orch.send_nginx_http_request(path,
+                                                            headers=headers)
+        self.assertEqual(200, status)
+        upstream_headers = json.loads(body)['headers']
+        self.assertEqual(str(expected_priority),
+                         upstream_headers.get('x-datadog-sampling-priority'))
+
+        self.orch.reload_nginx()
+        agent_log_lines = self.orch.sync_service('agent')
+        spans = [
+            span for span in formats.parse_spans(agent_log_lines)
+            if span['service'] == 'nginx'
+        ]
+        self.assertEqual(1, len(spans), spans)
+        self.assertEqual(expected_priority,
+                         spans[0]['metrics'].get('_sampling_priority_v1'))
+
+    def test_manual_keep_from_trusted_peer(self):
+        self.run_manual_keep_test('/http/trusted', expected_priority=2)
+
+    def test_manual_keep_from_untrusted_peer(self):
+        # The sample rate is zero, so the trace is dropped (USER-REJECT).
+        self.run_manual_keep_test('/http/untrusted', expected_priority=-1)
+
+    def test_consistent_sampling(self):
+        # A trace is kept if and only if
+        #
+        #     (trace_id * 1111111111111111111) mod 2**64 < rate * (2**64 - 1)
+        #
+        # which is the same computation that every Datadog tracer performs, so
+        # that nginx and the services behind it make the same sampling decision
+        # for the same trace.  The rate below is 0.5.
+        table = [
+            # (trace ID, kept?)
+            (1, True),  # hash / 2**64 ~= 0.060
+            (8, True),  # ~= 0.482
+            (1234567890123456789, True),  # ~= 0.410
+            (9, False),  # ~= 0.542
+            (10, False),  # ~= 0.602
+            (12078589664685934330, False),  # ~= 0.772
+            (18446744073709551615, False),  # ~= 0.940
+        ]
+
+        conf_path = Path(__file__).parent / './conf/consistent.conf'
+        conf_text = conf_path.read_text()
+        status, log_lines = self.orch.nginx_replace_config(
+            conf_text, conf_path.name)
+        self.assertEqual(0, status, log_lines)
+
+        self.orch.sync_service('agent')
+
+        for trace_id, kept in table:
+            # The incoming trace context has no sampling priority, so nginx
+            # makes the sampling decision.
+            headers = {
+                'x-datadog-trace-id': str(trace_id),
+                'x-datadog-parent-id': '123'
+            }
+            status, _, body = self.orch.send_nginx_http_request(
+                '/http', headers=headers)
+            self.assertEqual(200, status)
+            upstream_headers = json.loads(body)['headers']
+            expected_priority = '2' if kept else '-1'
+            self.assertEqual(
+                expected_priority,
+                upstream_headers.get('x-datadog-sampling-priority'),
+                (trace_id, upstream_headers))
+
+        self.orch.reload_nginx()
+        agent_log_lines = self.orch.sync_service('agent')
+        priority_by_trace_id = {
+            span['trace_id']: span['metrics'].get('_sampling_priority_v1')
+            for span in formats.parse_spans(agent_log_lines)
+            if span['service'] == 'nginx'
+        }
+        expected = {
+            trace_id: 2 if kept else -1
+            for trace_id, kept in table
+        }
+        self.assertEqual(expected, priority_by_trace_id)
@@ -339,6 +339,29 @@ class TestSecAddresses(case.TestCase):
             result['triggers'][0]['rule_matches'][0]['parameters'][0]['value'],
             'fe80::1')
 
+    def test_client_ip_14(self):
+        result = self.do_request_headers(
+            {'x-forwarded-for': '[::1]:8080, [2001:db8::1]:443'})
+        self.assertEqual(
+            result['triggers'][0]['rule_matches'][0]['parameters'][0]['value'],
+            '2001:db8::1')
+
+    def test_client_ip_15(self):
+        result = self.do_request_headers({
+            'x-forwarded':
+            'for="[::1]:1234", for="[2001:db8:cafe::17]:4711"'
+        })
+        self.assertEqual(
+            result['triggers'][0]['rule_matches'][0]['parameters'][0]['value'],
+            '2001:db8:cafe::17')
+
+    def test_client_ip_16(self):
+        result = self.do_request_headers(
+            {'x-forwarded-for': 'fd12:3456::1, 2001:db8::2'})
+        self.assertEqual(
+            result['triggers'][0]['rule_matches'][0]['parameters'][0]['value'],
+            '2001:db8::2')
+
     def test_client_ip_prio_1(self):
         result = self.do_request_headers({
             'x-real-ip': '8.8.8.8',
@@ -420,6 +443,28 @@ class TestSecAddresses(case.TestCase):
             result['triggers'][0]['rule_matches'][0]['parameters'][0]['value'],
             '1.2.3.4')
 
+    def test_client_ip_proxy_protocol(self):
+        # The client address in the PROXY protocol header takes the place of
+        # the connection's peer address.
+        status, _, _ = self.orch.send_nginx_http_request(
+            '/http', 8091, extra_args=['--haproxy-clientip', '10.1.2.3'])
+        self.assertEqual(status, 200)
+        result = self.do_request_common()
+        self.assertEqual(
+            result['triggers'][0]['rule_matches'][0]['parameters'][0]['value'],
+            '10.1.2.3')
+
+    def test_client_ip_public_connection_address(self):
+        # Without client IP headers, a public connection address is the client
+        # address, just as a private one is.
+        status, _, _ = self.orch.send_nginx_http_request(
+            '/http', 8091, extra_args=['--haproxy-clientip', '1.2.3.4'])
+        self.assertEqual(status, 200)
+        result = self.do_request_common()
+        self.assertEqual(
+            result['triggers'][0]['rule_matches'][0]['parameters'][0]['value'],
+            '1.2.3.4')
+
     def test_obfuscation_default(self):
         result = self.do_request_query('password=matched+value')
         self.assertEqual(
@@ -428,3 +473,21 @@ class TestSecAddresses(case.TestCase):
         self.assertEqual(
             result['triggers'][0]['rule_matches'][0]['parameters'][0]
             ['highlight'][0], '<Redacted>')
+
+    def test_path_params(self):
+        status, _, _ = self.orch.send_nginx_http_request(
+            '/users/42/orders/matched%20path%20param', 80)
+        self.assertEqual(status, 200)
+        result = self.do_request_common()
+        match = result['triggers'][0]['rule_matches'][0]['parameters'][0]
+        self.assertEqual(match['address'], 'server.request.path_params')
+        self.assertEqual(match['key_path'], ['order'])
+        self.assertEqual(match['value'], 'matched path param')
+
+    def test_path_params_route_mismatch(self):
+        # The path has an extra segment, so it doesn't match the route, and no
+        # path parameters are extracted.
+        status, _, _ = self.orch.send_nginx_http_request(
+            '/users/42/orders/matched%20path%20param/extra', 80)
+        self.assertEqual(status, 200)
+        self.assertIsNone(self.orch.find_first_appsec_report())
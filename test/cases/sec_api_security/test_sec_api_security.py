@@ -0,0 +1,131 @@
+import base64
+import gzip
+import json
+
+from .. import case, formats
+
+
+def decode_schema(tag_value):
+    """Return the schema in the specified `_dd.appsec.s.*` tag value, which is
+    base64-encoded gzip-compressed JSON."""
+    return json.loads(gzip.decompress(base64.b64decode(tag_value)))
+
+
+class TestSecApiSecurity(case.TestCase):
+    requires_waf = True
+    nginx_conf_file = 'http.conf'
+
+    def get_root_span_meta(self):
+        for line in self.flush_agent_log():
+            trace = formats.parse_trace(line)
+            if trace is None:
+                continue
+            for chunk in trace:
+                for span in chunk:
+                    if span['service'] == 'nginx' and span.get(
+                            'parent_id') in (None, 0):
+                        return span.get('meta', {})
+        self.fail('No nginx root span found in traces')
+
+    def test_json_body_schema(self):
+        body = json.dumps({
+            'user': 'secret-value',
+            'id': 42,
+            'tags': ['a', 'b']
+        })
+        headers = {'Content-Type': 'application/json'}
+        status, _, _ = self.orch.send_nginx_http_request('/http/api',
+                                                         80,
+                                                         headers,
+                                                         method='POST',
+                                                         req_body=body)
+        self.assertEqual(status, 200)
+
+        meta = self.get_root_span_meta()
+        self.assertIn('_dd.appsec.s.req.body', meta)
+        schema = decode_schema(meta['_dd.appsec.s.req.body'])
+        self.assertEqual(schema, [{
+            'user': [8],
+            'id': [4],
+            'tags': [[[8]], {
+                'len': 2
+            }]
+        }])
+        # The schema describes the body's structure, never its values.
+        for value in meta.values():
+            self.assertNotIn('secret-value', value)
+        for key, value in meta.items():
+            if key.startswith('_dd.appsec.s.'):
+                self.assertNotIn('secret-value',
+                                 json.dumps(decode_schema(value)))
+
+    def test_response_schemas(self):
+        status, _, _ = self.orch.send_nginx_http_request('/json')
+        self.assertEqual(status, 200)
+
+        meta = self.get_root_span_meta()
+        self.assertIn('_dd.appsec.s.res.body', meta)
+        self.assertEqual(decode_schema(meta['_dd.appsec.s.res.body']), [{
+            'id': [4],
+            'name': [8],
+            'roles': [[[8]], {
+                'len': 1
+            }]
+        }])
+        self.assertIn('_dd.appsec.s.res.headers', meta)
+        headers = decode_schema(meta['_dd.appsec.s.res.headers'])[0]
+        self.assertEqual(headers.get('content-type'), [8], headers)
+        self.assertEqual(headers.get('x-example'), [8], headers)
+        self.assertNotIn('example-value', json.dumps(headers))
+        response_body_schema = decode_schema(meta['_dd.appsec.s.res.body'])
+        self.assertNotIn('secret-value', json.dumps(response_body_schema))
+
+    def test_redacted_keys(self):
+        body = json.dumps({
+            'user': {
+                'name': 'alice',
+                'Password': {
+                    'hash': 'secret-hash',
+                    'salt': 'secret-salt'
+                }
+            },
+            'records': [{
+                'ssn': ['secret-ssn']
+            }]
+        })
+        headers = {'Content-Type': 'application/json'}
+        status, _, _ = self.orch.send_nginx_http_request('/http/redact',
+                                                         80,
+                                                         headers,
+                                                         method='POST',
+                                                         req_body=body)
+        self.assertEqual(status, 200)
+
+        meta = self.get_root_span_meta()
+        schema = json.loads(meta['_dd.appsec.s.req.body'])
+        # Redacted values, matched case-insensitively at any depth, appear as
+        # strings, hiding their structure.
+        self.assertEqual(schema, [{
+            'user': [{
+                'name': [8],
+                'Password': [8]
+            }],
+            'records': [[[{
+                'ssn': [8]
+            }]], {
+                'len': 1
+            }]
+        }])
+        self.assertNotIn('hash', meta['_dd.appsec.s.req.body'])
+
+    def test_non_json_body_has_no_schema(self):
+        headers = {'Content-Type': 'text/plain'}
+        status, _, _ = self.orch.send_nginx_http_request('/http/api',
+                                                         80,
+                                                         headers,
+                                                         method='POST',
+                                                         req_body='hello')
+        self.assertEqual(status, 200)
+
+        meta = self.get_root_span_meta()
+        self.assertNotIn('_dd.appsec.s.req.body', meta)
@@ -29,10 +29,15 @@ class TestSecBlocking(case.TestCase):
         # Consume any previous logging from the agent.
         self.orch.sync_service('agent')
 
-    def run_with_ua(self, user_agent, accept):
-        headers = {'User-Agent': user_agent, 'Accept': accept}
+    def run_with_ua(self,
+                    user_agent,
+                    accept,
+                    path='/http',
+                    method='GET',
+                    extra_headers={}):
+        headers = {'User-Agent': user_agent, 'Accept': accept, **extra_headers}
         status, headers, body = self.orch.send_nginx_http_request(
-            '/http', 80, headers)
+            path, 80, headers, method=method)
 
         self.orch.reload_nginx()
         log_lines = self.orch.sync_service('agent')
@@ -64,6 +69,33 @@ class TestSecBlocking(case.TestCase):
         self.assertEqual(appsec_rep['triggers'][0]['rule']['on_match'][0],
                          'block')
 
+    def test_blocked_span_event(self):
+        status, _, _, log_lines = self.run_with_ua('block_default', '*/*')
+        self.assertEqual(status, 403)
+
+        traces = [
+            json.loads(line) for line in log_lines if line.startswith('[[{')
+        ]
+        span = next((trace[0][0] for trace in traces
+                     if trace[0][0]['meta'].get('appsec.blocked') == 'true'),
+                    None)
+        if span is None:
+            self.fail('No trace found with appsec.blocked=true')
+
+        events = json.loads(span['meta']['events'])
+        self.assertEqual(1, len(events), events)
+        event = events[0]
+        self.assertEqual('appsec.blocked', event['name'])
+        self.assertEqual(
+            {
+                'rule_id': 'block_default',
+                'action': 'block_request'
+            }, event['attributes'])
+        # The event happened during the span.
+        self.assertGreaterEqual(event['time_unix_nano'], span['start'])
+        self.assertLessEqual(event['time_unix_nano'],
+                             span['start'] + span['duration'])
+
     def test_default_action_html(self):
         status, headers, body, _ = self.run_with_ua('block_default',
                                                     'text/html')
@@ -118,3 +150,74 @@ class TestSecBlocking(case.TestCase):
         status, headers, _, _ = self.run_with_ua('redirect_bad_status', '*/*')
         self.assertEqual(status, 303)
         self.assertEqual(headers['location'], 'https://www.cloudflare.com')
+
+    def test_block_without_tracing(self):
+        status, _, body, log_lines = self.run_with_ua('block_default',
+                                                      '*/*',
+                                                      path='/untraced')
+        self.assertEqual(status, 403)
+        self.assertRegex(body, r'"title":"You\'ve been blocked')
+
+        # No trace is sent to the agent.
+        traces = [
+            json.loads(line) for line in log_lines if line.startswith('[[{')
+        ]
+        nginx_spans = [
+            span for trace in traces for chunk in trace for span in chunk
+            if span['service'] == 'nginx'
+        ]
+        self.assertEqual([], nginx_spans)
+
+    def test_no_block_without_tracing(self):
+        status, _, _, _ = self.run_with_ua('Mozilla/5.0',
+                                           '*/*',
+                                           path='/untraced')
+        self.assertEqual(status, 200)
+
+    def test_no_block_when_monitoring(self):
+        status, _, _, log_lines = self.run_with_ua('block_default',
+                                                   '*/*',
+                                                   path='/monitored')
+        self.assertEqual(status, 200)
+
+        # The attack is reported, but the request is not marked as blocked.
+        traces = [
+            json.loads(line) for line in log_lines if line.startswith('[[{')
+        ]
+        span = next((trace[0][0] for trace in traces
+                     if '_dd.appsec.json' in trace[0][0]['meta']), None)
+        if span is None:
+            self.fail('No trace found with an appsec report')
+        self.assertNotIn('appsec.blocked', span['meta'])
+
+        appsec_rep = json.loads(span['meta']['_dd.appsec.json'])
+        self.assertEqual(appsec_rep['triggers'][0]['rule']['on_match'][0],
+                         'block')
+
+        # Outside of the monitored location, the same attack is blocked.
+        status, _, _, _ = self.run_with_ua('block_default', '*/*')
+        self.assertEqual(status, 403)
+
+    def test_block_untraced_cors_preflight(self):
+        # A CORS preflight request that is not traced is still protected.
+        status, _, body, log_lines = self.run_with_ua(
+            'block_default',
+            '*/*',
+            path='/untraced_preflight',
+            method='OPTIONS',
+            extra_headers={
+                'Origin': 'http://example.com',
+                'Access-Control-Request-Method': 'PUT'
+            })
+        self.assertEqual(status, 403)
+        self.assertRegex(body, r'"title":"You\'ve been blocked')
+
+        # No trace is sent to the agent.
+        traces = [
+            json.loads(line) for line in log_lines if line.startswith('[[{')
+        ]
+        nginx_spans = [
+            span for trace in traces for chunk in trace for span in chunk
+            if span['service'] == 'nginx'
+        ]
+        self.assertEqual([], nginx_spans)
@@ -0,0 +1,54 @@
+from .. import case
+
+
+class TestSecNamedRuleset(case.TestCase):
+    config_setup_done = False
+    requires_waf = True
+
+    def setUp(self):
+        super().setUp()
+        # avoid reconfiguration (cuts time almost in half)
+        if not TestSecNamedRuleset.config_setup_done:
+            for name in ('login', 'api'):
+                self.orch.nginx_replace_file(
+                    f'/tmp/sec-named-ruleset-{name}.json',
+                    self.conf_path(f'{name}.json').read_text())
+
+            self.replace_nginx_config('http.conf')
+            TestSecNamedRuleset.config_setup_done = True
+
+    def status_with_ua(self, path, user_agent):
+        headers = {'User-Agent': user_agent, 'Accept': '*/*'}
+        status, _, _ = self.orch.send_nginx_http_request(path, 80, headers)
+        return status
+
+    def test_login_rules(self):
+        self.assertEqual(403, self.status_with_ua('/login', 'login_attack'))
+        # The API rules are not evaluated for this location.
+        self.assertEqual(200, self.status_with_ua('/login', 'api_attack'))
+
+    def test_api_rules(self):
+        self.assertEqual(403, self.status_with_ua('/api', 'api_attack'))
+        # The login rules are not evaluated for this location.
+        self.assertEqual(200, self.status_with_ua('/api', 'login_attack'))
+
+    def test_undefined_ruleset(self):
+        conf_text = '''
+load_module /datadog-tests/ngx_http_datadog_module.so;
+events {}
+http {
+    datadog_appsec_enabled on;
+    server {
+        listen 80;
+        location / {
+            datadog_appsec_ruleset no-such-rules;
+        }
+    }
+}
+'''
+        status, log_lines = self.orch.nginx_test_config(
+            conf_text, 'undefined_ruleset.conf')
+        self.assertNotEqual(0, status, log_lines)
+        self.assertTrue(
+            any('no ruleset named "no-such-rules"' in line
+                for line in log_lines), log_lines)
@@ -0,0 +1,52 @@
+from .. import case
+
+
+class TestSecOverload(case.TestCase):
+    requires_waf = True
+
+    def test_breaker_skips_evaluation(self):
+        """Verify that once WAF runs exceed the overload threshold, requests
+        are not evaluated by the WAF until the cooldown elapses, and that such
+        requests are tagged and are not blocked.
+        """
+        nginx_conf = self.conf_path('nginx.conf').read_text()
+        # Every WAF run takes longer than one microsecond, so the first run
+        # trips the breaker.
+        extra_env = {'DD_APPSEC_WAF_OVERLOAD_THRESHOLD': '1'}
+        self.run_breaker_test(nginx_conf, extra_env)
+
+    def test_directive_in_microseconds(self):
+        """Verify that `datadog_appsec_waf_overload_threshold`, like
+        `DD_APPSEC_WAF_OVERLOAD_THRESHOLD`, is in microseconds.
+        """
+        nginx_conf = self.conf_path('nginx.conf').read_text().replace(
+            'datadog_appsec_waf_overload_cooldown 60s;',
+            'datadog_appsec_waf_overload_cooldown 60s;\n'
+            '    datadog_appsec_waf_overload_threshold 1;')
+        self.run_breaker_test(nginx_conf, {})
+
+    def run_breaker_test(self, nginx_conf, extra_env):
+        self.orch.sync_service('agent')
+
+        with self.orch.custom_nginx(nginx_conf,
+                                    extra_env,
+                                    healthcheck_port=8080):
+            status, _, body = self.orch.send_nginx_http_request('/', 8080)
+            self.assertEqual(200, status, body)
+            # This would be blocked, were the WAF to evaluate it.
+            headers = {'User-Agent': 'dd-test-scanner-log-block'}
+            status, _, body = self.orch.send_nginx_http_request(
+                '/', 8080, headers)
+            self.assertEqual(200, status, body)
+
+        # Stopping the custom nginx flushes its traces.
+        log_lines = self.orch.sync_service('agent')
+        spans = self.spans_in(log_lines, 'nginx-sec-overload')
+        self.assertEqual(2, len(spans), spans)
+        attack = next(
+            span for span in spans if span['meta'].get('http.useragent') ==
+            'dd-test-scanner-log-block')
+        self.assertEqual('overload', attack['meta'].get('_dd.appsec.skipped'),
+                         attack)
+        self.assertNotIn('_dd.appsec.json', attack['meta'])
+        self.assertNotIn('_dd.appsec.enabled', attack.get('metrics', {}))
@@ -0,0 +1,20 @@
+from .. import case
+
+from pathlib import Path
+
+
+class TestSelfTest(case.TestCase):
+
+    def test_self_test_passes(self):
+        conf_path = Path(__file__).parent / './conf/self_test.conf'
+        conf_text = conf_path.read_text()
+        status, log_lines = self.orch.nginx_test_config(
+            conf_text, conf_path.name)
+        self.assertEqual(0, status, log_lines)
+
+        self.assertTrue(
+            any('datadog self-test: PASS' in line for line in log_lines),
+            log_lines)
+        self.assertFalse(
+            any('datadog self-test: FAIL' in line for line in log_lines),
+            log_lines)
@@ -0,0 +1,39 @@
+from .. import case
+
+import json
+import re
+
+
+class TestServerTiming(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def test_header_contains_traceparent(self):
+        status, headers, body = self.orch.send_nginx_http_request('/http')
+        self.assertEqual(200, status, body)
+
+        headers = {name.lower(): value for name, value in headers}
+        self.assertIn('server-timing', headers, headers)
+        match = re.fullmatch(
+            r'traceparent;desc="00-([0-9a-f]{32})-([0-9a-f]{16})-(0[01])"',
+            headers['server-timing'])
+        self.assertIsNotNone(match, headers['server-timing'])
+        trace_id, span_id, flags = match.groups()
+        self.assertEqual('01', flags)
+
+        # The trace context is the same as that propagated to the upstream.
+        upstream_headers = json.loads(body)['headers']
+        self.assertEqual(upstream_headers['traceparent'],
+                         f'00-{trace_id}-{span_id}-{flags}')
+
+        # The IDs are the same as those of the span sent to the agent.
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        self.assertEqual(spans[0]['trace_id'], int(trace_id[16:], 16))
+        self.assertEqual(spans[0]['span_id'], int(span_id, 16))
+
+    def test_no_header_when_off(self):
+        status, headers, body = self.orch.send_nginx_http_request('/http/off')
+        self.assertEqual(200, status, body)
+
+        names = [name.lower() for name, _ in headers]
+        self.assertNotIn('server-timing', names, headers)
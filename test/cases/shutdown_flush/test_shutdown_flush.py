@@ -0,0 +1,22 @@
+from .. import case
+
+
+class TestShutdownFlush(case.TestCase):
+
+    def test_flush_on_reload(self):
+        self.replace_nginx_config('http.conf')
+
+        status, _, _ = self.orch.send_nginx_http_request('/http')
+        self.assertEqual(200, status)
+        # Reload immediately, so that the trace is still queued in the worker
+        # when it begins to exit.  The worker flushes the trace on its way out.
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+
+    def test_invalid_timeout(self):
+        conf_text = self.conf_path('invalid.conf').read_text()
+        status, log_lines = self.orch.nginx_test_config(
+            conf_text, 'invalid.conf')
+        self.assertNotEqual(0, status, log_lines)
+        excerpt = '"datadog_shutdown_flush_timeout" directive invalid value'
+        self.assertTrue(any(excerpt in line for line in log_lines), log_lines)
@@ -0,0 +1,57 @@
+from .. import case
+
+# Sending `UPLOAD_BYTES` at `UPLOAD_RATE` bytes per second takes about two
+# seconds.
+UPLOAD_BYTES = 40_000
+UPLOAD_RATE = 20_000
+
+
+class TestSpanStart(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def send_request_and_get_span(self,
+                                  path,
+                                  slow_upload=True,
+                                  expected_status=200):
+        if slow_upload:
+            kwargs = dict(method='POST',
+                          req_body='x' * UPLOAD_BYTES,
+                          extra_args=['--limit-rate', str(UPLOAD_RATE)])
+        else:
+            kwargs = {}
+        status, _, body = self.orch.send_nginx_http_request(path, **kwargs)
+        self.assertEqual(expected_status, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]
+
+    def test_default_includes_upload(self):
+        span = self.send_request_and_get_span('/headers')
+        self.assertGreaterEqual(span['duration'], 1_500_000_000, span)
+        self.assertNotIn('nginx.request.body.receive_ms', span['meta'])
+
+    def test_after_body_excludes_upload(self):
+        span = self.send_request_and_get_span('/after-body')
+        self.assertLess(span['duration'], 1_000_000_000, span)
+        receive_ms = span['meta'].get('nginx.request.body.receive_ms')
+        self.assertIsNotNone(receive_ms, span['meta'])
+        self.assertGreaterEqual(int(receive_ms), 1500, span['meta'])
+        # The body was still counted, although it was received before the
+        # request was traced.
+        self.assertEqual(str(UPLOAD_BYTES),
+                         span['meta'].get('http.request.body.bytes'),
+                         span['meta'])
+
+    def test_after_body_without_body(self):
+        span = self.send_request_and_get_span('/after-body',
+                                              slow_upload=False)
+        self.assertNotIn('nginx.request.body.receive_ms', span['meta'])
+
+    def test_after_body_rejected_body_is_traced(self):
+        # The body is rejected, so the request is traced from its usual start.
+        span = self.send_request_and_get_span('/after-body-too-large',
+                                              expected_status=413)
+        self.assertEqual('413', span['meta'].get('http.status_code'),
+                         span['meta'])
+        self.assertNotIn('nginx.request.body.receive_ms', span['meta'])
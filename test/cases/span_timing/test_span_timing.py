@@ -0,0 +1,69 @@
+from .. import case
+
+import threading
+import time
+
+FAKETIME_LIBRARY = '/usr/local/lib/libfaketime.so.1'
+FAKETIME_FILE = '/tmp/datadog-tests-faketime'
+
+
+class TestSpanTiming(case.TestCase):
+
+    def setUp(self):
+        super().setUp()
+        if not self.orch.nginx_file_exists(FAKETIME_LIBRARY):
+            self.skipTest('libfaketime is not installed in the nginx image')
+
+    def request_during_clock_step(self, conf_name):
+        """Send a request that takes two seconds to a custom nginx using the
+        configuration at `conf_name`, and step the system clock of nginx back
+        by an hour while the request is in progress.  Return the request span.
+        """
+        # No offset, to begin with.
+        self.orch.nginx_replace_file(FAKETIME_FILE, '+0')
+        extra_env = {
+            'LD_PRELOAD': FAKETIME_LIBRARY,
+            'FAKETIME_TIMESTAMP_FILE': FAKETIME_FILE,
+            # Read the offset on every call, so that it can change.
+            'FAKETIME_NO_CACHE': '1',
+            # Only the system clock steps.
+            'DONT_FAKE_MONOTONIC': '1',
+        }
+        nginx_conf = self.conf_path(conf_name).read_text()
+
+        # Consume any previous logging from the agent.
+        self.orch.sync_service('agent')
+
+        with self.orch.custom_nginx(nginx_conf,
+                                    extra_env=extra_env,
+                                    healthcheck_port=8080):
+            results = []
+            request = threading.Thread(target=lambda: results.append(
+                self.orch.send_nginx_http_request('/http/delay/2000', 8080)))
+            request.start()
+            time.sleep(1)
+            self.orch.nginx_replace_file(FAKETIME_FILE, '-3600')
+            request.join()
+
+            status, _, body = results[0]
+            self.assertEqual(200, status, body)
+
+        # Stopping nginx flushes its traces.
+        spans = self.spans_in(self.orch.sync_service('agent'))
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]
+
+    def test_monotonic(self):
+        span = self.request_during_clock_step('monotonic.conf')
+        # The duration is measured with the monotonic clock, which did not
+        # step.
+        self.assertGreaterEqual(span['duration'], 2_000_000_000, span)
+        self.assertLess(span['duration'], 60_000_000_000, span)
+        self.assertNotIn('_dd.clock_anomaly', span['meta'], span['meta'])
+
+    def test_wall(self):
+        span = self.request_during_clock_step('wall.conf')
+        # By the system clock, the request finished before it began.
+        self.assertEqual(0, span['duration'], span)
+        self.assertEqual('backward', span['meta'].get('_dd.clock_anomaly'),
+                         span['meta'])
@@ -0,0 +1,47 @@
+from .. import case
+
+
+class TestStream(case.TestCase):
+    requires_stream = True
+    nginx_conf_file = 'nginx.conf'
+
+    def send_through_proxy(self, port):
+        """Send an HTTP request through the stream proxy listening on the
+        specified `port`, and return the spans sent to the agent.
+        """
+        status, _, body = self.orch.send_nginx_http_request('/', port)
+        self.assertEqual(200, status, body)
+
+        return self.flush_spans()
+
+    def test_connection_span(self):
+        spans = self.send_through_proxy(8081)
+        self.assertEqual(1, len(spans), spans)
+        span = spans[0]
+        meta = span['meta']
+
+        self.assertEqual('nginx.stream', span['name'])
+        self.assertRegex(span['resource'], r'^tcp .*:8081$')
+        self.assertEqual(0, span['error'], span)
+        self.assertEqual('tcp', meta['network.protocol'])
+        self.assertIn('network.client.ip', meta)
+        self.assertIn('network.client.port', meta)
+        self.assertRegex(meta['nginx.upstream.address'], r':8080$')
+        self.assertEqual('200', meta['nginx.stream.status'])
+        # The request went one way and the response the other.
+        self.assertGreater(int(meta['nginx.stream.bytes_received']), 0, meta)
+        self.assertGreater(int(meta['nginx.stream.bytes_sent']), 0, meta)
+
+    def test_tracing_disabled(self):
+        spans = self.send_through_proxy(8082)
+        self.assertEqual([], spans)
+
+    def test_stream_only_config(self):
+        # Without an `http` block there is no tracer, which nginx warns about,
+        # but the configuration is still valid.
+        status, log_lines = self.orch.nginx_test_config(
+            self.conf_path('stream_only.conf').read_text(), 'stream_only.conf')
+        self.assertEqual(0, status, log_lines)
+        self.assertTrue(
+            any('there is no "http" block' in line for line in log_lines),
+            log_lines)
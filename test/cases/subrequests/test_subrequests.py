@@ -0,0 +1,59 @@
+from .. import case
+
+import json
+
+
+class TestSubrequests(case.TestCase):
+
+    def test_auth_request(self):
+        """Verify that an `auth_request` subrequest produces a child span of
+        the request span, and that the subrequest's trace context does not
+        replace the context propagated to the main request's upstream.
+        """
+        self.replace_nginx_config('auth_request.conf')
+
+        status, _, body = self.orch.send_nginx_http_request('/http')
+        self.assertEqual(200, status, body)
+        upstream_headers = json.loads(body)['headers']
+
+        spans = self.flush_spans()
+        self.assertEqual(2, len(spans), spans)
+
+        subrequests = [span for span in spans if 'nginx.subrequest' in span['meta']]
+        self.assertEqual(1, len(subrequests), spans)
+        subrequest = subrequests[0]
+        main, = [span for span in spans if span is not subrequest]
+
+        self.assertEqual('auth_request', subrequest['meta']['nginx.subrequest'])
+        self.assertEqual(main['trace_id'], subrequest['trace_id'], spans)
+        self.assertEqual(main['span_id'], subrequest['parent_id'], spans)
+
+        # The main request's upstream sees the main request's span as its
+        # parent, not the auth subrequest's span.
+        self.assertEqual(str(main['span_id']),
+                         upstream_headers.get('x-datadog-parent-id'),
+                         upstream_headers)
+
+    def test_mirror(self):
+        """Verify that a `mirror` subrequest produces its own span, a child of
+        the request span, that is tagged as mirrored traffic.
+        """
+        self.replace_nginx_config('mirror.conf')
+
+        status, _, body = self.orch.send_nginx_http_request('/http')
+        self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(2, len(spans), spans)
+
+        mirrors = [span for span in spans if 'nginx.mirror' in span['meta']]
+        self.assertEqual(1, len(mirrors), spans)
+        mirror = mirrors[0]
+        main, = [span for span in spans if span is not mirror]
+
+        self.assertEqual('true', mirror['meta']['nginx.mirror'])
+        self.assertEqual('mirror', mirror['meta']['nginx.subrequest'])
+        self.assertEqual('/mirror', mirror['meta']['nginx.mirror.uri'])
+        self.assertEqual(main['trace_id'], mirror['trace_id'], spans)
+        self.assertEqual(main['span_id'], mirror['parent_id'], spans)
+        self.assertNotIn('nginx.mirror', main['meta'])
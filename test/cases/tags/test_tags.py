@@ -127,3 +127,37 @@ class TestTags(case.TestCase):
 
                     self.assertIn('nginx.location', tags)
                     self.assertEqual(tags['nginx.location'], '/http')
+
+    def test_worker_tags(self):
+        # Spans are tagged with the PID of the worker process that handled the
+        # request and with the hostname of the machine running nginx. The
+        # configuration echoes the same values back as response headers, so
+        # that we can check that the tags refer to the worker, not the master.
+        conf_path = Path(__file__).parent / './conf/worker.conf'
+        conf_text = conf_path.read_text()
+        status, log_lines = self.orch.nginx_replace_config(
+            conf_text, conf_path.name)
+        self.assertEqual(0, status, log_lines)
+
+        # Consume any previous logging from the agent.
+        self.orch.sync_service('agent')
+
+        status, headers, _ = self.orch.send_nginx_http_request('/http')
+        self.assertEqual(status, 200)
+        headers = {name.lower(): value for name, value in headers}
+
+        self.orch.reload_nginx()
+        log_lines = self.orch.sync_service('agent')
+
+        spans = [
+            span for span in formats.parse_spans(log_lines)
+            if span['service'] == 'nginx'
+        ]
+        self.assertEqual(1, len(spans), spans)
+        tags = spans[0]['meta']
+
+        self.assertIn('nginx.worker_pid', tags)
+        self.assertEqual(tags['nginx.worker_pid'], headers['x-worker-pid'])
+
+        self.assertIn('host.name', tags)
+        self.assertEqual(tags['host.name'], headers['x-hostname'])
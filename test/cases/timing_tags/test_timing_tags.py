@@ -0,0 +1,35 @@
+from .. import case
+
+
+class TestTimingTags(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def send_request_and_get_span(self, path):
+        status, _, body = self.orch.send_nginx_http_request(path)
+        self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        return spans[0], body
+
+    def test_slow_upstream(self):
+        # The upstream waits this long before responding.
+        delay_ms = 500
+        span, body = self.send_request_and_get_span(f'/http/delay/{delay_ms}')
+        meta = span['meta']
+
+        ttfb_ms = int(meta['nginx.upstream.ttfb_ms'])
+        response_time_ms = int(meta['nginx.upstream.response_time_ms'])
+        self.assertGreaterEqual(ttfb_ms, delay_ms, meta)
+        self.assertGreaterEqual(response_time_ms, ttfb_ms, meta)
+        self.assertEqual(len(body.encode('utf8')),
+                         int(meta['nginx.response.bytes']), meta)
+
+    def test_static(self):
+        span, body = self.send_request_and_get_span('/static')
+        meta = span['meta']
+
+        self.assertNotIn('nginx.upstream.ttfb_ms', meta)
+        self.assertNotIn('nginx.upstream.response_time_ms', meta)
+        self.assertEqual(len('static response\n'),
+                         int(meta['nginx.response.bytes']), meta)
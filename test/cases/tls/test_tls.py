@@ -0,0 +1,37 @@
+from .. import case
+
+
+class TestTLS(case.TestCase):
+
+    def setUp(self):
+        super().setUp()
+        for name in ('nginx.crt', 'nginx.key'):
+            self.orch.nginx_replace_file(f'/tmp/datadog-tests-{name}',
+                                         self.conf_path(name).read_text())
+
+        self.replace_nginx_config('https.conf')
+
+    def send_request_and_get_span(self, **kwargs):
+        status, _, body = self.orch.send_nginx_http_request('/http', **kwargs)
+        self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]
+
+    def test_https(self):
+        # The certificate is self-signed, so don't verify it.
+        span = self.send_request_and_get_span(port=443,
+                                              scheme='https',
+                                              extra_args=['--insecure'])
+        meta = span['meta']
+        self.assertEqual('TLSv1.2', meta.get('tls.version'), meta)
+        self.assertEqual('ECDHE-RSA-AES128-GCM-SHA256', meta.get('tls.cipher'),
+                         meta)
+        self.assertEqual('nginx', meta.get('tls.sni'), meta)
+
+    def test_http(self):
+        span = self.send_request_and_get_span(port=80)
+        meta = span['meta']
+        for tag in ('tls.version', 'tls.cipher', 'tls.sni'):
+            self.assertNotIn(tag, meta, meta)
@@ -0,0 +1,37 @@
+from .. import case
+
+
+class TestTraceAPIVersion(case.TestCase):
+
+    def run_version_test(self, conf_name, expected_path):
+        self.replace_nginx_config(conf_name)
+
+        status, _, _ = self.orch.send_nginx_http_request('/http')
+        self.assertEqual(200, status)
+
+        log_lines = self.flush_agent_log()
+
+        prefix = 'Traces request to '
+        paths = set(line[len(prefix):] for line in log_lines
+                    if line.startswith(prefix))
+        self.assertEqual({expected_path}, paths, log_lines)
+
+        # The payload has the same shape in either version: a list of trace
+        # chunks, each a list of spans.
+        spans = self.spans_in(log_lines)
+        self.assertEqual(1, len(spans), log_lines)
+        self.assertEqual('GET /http', spans[0]['resource'])
+
+    def test_v0_3(self):
+        self.run_version_test('v0.3.conf', '/v0.3/traces')
+
+    def test_v0_4(self):
+        self.run_version_test('v0.4.conf', '/v0.4/traces')
+
+    def test_invalid_version(self):
+        conf_text = self.conf_path('bogus.conf').read_text()
+        status, log_lines = self.orch.nginx_test_config(
+            conf_text, 'bogus.conf')
+        self.assertNotEqual(0, status, log_lines)
+        excerpt = 'Invalid argument "bogus" to datadog_trace_api_version directive'
+        self.assertTrue(any(excerpt in line for line in log_lines), log_lines)
@@ -0,0 +1,43 @@
+from .. import case
+
+import json
+
+
+class TestTraceContextHeader(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def test_header_contains_trace_context(self):
+        status, headers, body = self.orch.send_nginx_http_request('/http')
+        self.assertEqual(200, status, body)
+
+        headers = {name.lower(): value for name, value in headers}
+        self.assertIn('x-datadog-trace', headers, headers)
+        self.assertEqual('X-Datadog-Trace',
+                         headers.get('access-control-expose-headers'), headers)
+
+        context = json.loads(headers['x-datadog-trace'])
+        self.assertEqual({'trace_id', 'span_id', 'sampled'}, set(context))
+        self.assertIsInstance(context['trace_id'], str)
+        self.assertIsInstance(context['span_id'], str)
+        self.assertIs(True, context['sampled'])
+
+        # The IDs are the same as those propagated to the upstream.
+        upstream_headers = json.loads(body)['headers']
+        self.assertEqual(upstream_headers['x-datadog-trace-id'],
+                         context['trace_id'])
+        self.assertEqual(upstream_headers['x-datadog-parent-id'],
+                         context['span_id'])
+
+        # The IDs are the same as those of the span sent to the agent.
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        self.assertEqual(spans[0]['trace_id'], int(context['trace_id']))
+        self.assertEqual(spans[0]['span_id'], int(context['span_id']))
+
+    def test_no_header_when_off(self):
+        status, headers, body = self.orch.send_nginx_http_request('/http/off')
+        self.assertEqual(200, status, body)
+
+        names = [name.lower() for name, _ in headers]
+        self.assertNotIn('x-datadog-trace', names, headers)
+        self.assertNotIn('access-control-expose-headers', names, headers)
@@ -0,0 +1,94 @@
+from .. import case
+
+import base64
+import hashlib
+import hmac
+import json
+import time
+
+KEY = 'partner-shared-secret'
+KEY_FILE = '/tmp/datadog-tests-jwt.key'
+
+
+def base64url(data):
+    return base64.urlsafe_b64encode(data).rstrip(b'=').decode()
+
+
+def make_token(claims, key=KEY, alg='HS256'):
+    header = base64url(json.dumps({'alg': alg, 'typ': 'JWT'}).encode())
+    payload = base64url(json.dumps(claims).encode())
+    signed = f'{header}.{payload}'
+    signature = hmac.new(key.encode(), signed.encode(),
+                         hashlib.sha256).digest()
+    return f'{signed}.{base64url(signature)}'
+
+
+TRACE_CONTEXT = {
+    'dd_trace': {
+        'x-datadog-trace-id': '1234567890',
+        'x-datadog-parent-id': '987654321',
+        'x-datadog-sampling-priority': '1',
+    }
+}
+
+
+class TestTraceContextJWT(case.TestCase):
+
+    def setUp(self):
+        super().setUp()
+        self.orch.nginx_replace_file(KEY_FILE, KEY)
+        self.replace_nginx_config('http.conf')
+
+    def request_span(self, path, token, headers=None):
+        headers = dict(headers or {})
+        headers['X-Partner-Token'] = token
+        status, _, body = self.orch.send_nginx_http_request(path,
+                                                            headers=headers)
+        self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]
+
+    def assertExtracted(self, span):
+        self.assertEqual(1234567890, span['trace_id'], span)
+        self.assertEqual(987654321, span['parent_id'], span)
+
+    def assertNotExtracted(self, span):
+        self.assertNotEqual(1234567890, span['trace_id'], span)
+        self.assertEqual(0, span.get('parent_id', 0), span)
+
+    def test_verified_token(self):
+        span = self.request_span('/http', make_token(TRACE_CONTEXT))
+        self.assertExtracted(span)
+
+    def test_verified_token_overrides_untrusted_headers(self):
+        headers = {
+            'X-Datadog-Trace-Id': '1111',
+            'X-Datadog-Parent-Id': '2222',
+        }
+        span = self.request_span('/untrusted', make_token(TRACE_CONTEXT),
+                                 headers)
+        self.assertExtracted(span)
+
+    def test_wrong_key(self):
+        span = self.request_span('/http',
+                                 make_token(TRACE_CONTEXT, key='not-the-key'))
+        self.assertNotExtracted(span)
+
+    def test_unsigned_token(self):
+        token = make_token(TRACE_CONTEXT, alg='none')
+        # An "alg: none" token has an empty signature.
+        token = token[:token.rindex('.') + 1]
+        span = self.request_span('/http', token)
+        self.assertNotExtracted(span)
+
+    def test_expired_token(self):
+        claims = dict(TRACE_CONTEXT, exp=int(time.time()) - 60)
+        span = self.request_span('/http', make_token(claims))
+        self.assertNotExtracted(span)
+
+    def test_non_string_alg(self):
+        # The request is still traced, without the token's trace context.
+        span = self.request_span('/http', make_token(TRACE_CONTEXT, alg=1))
+        self.assertNotExtracted(span)
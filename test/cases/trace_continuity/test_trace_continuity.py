@@ -0,0 +1,44 @@
+from .. import case
+
+import json
+
+
+class TestTraceContinuity(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def request_and_get_spans(self, path, headers={}):
+        status, _, body = self.orch.send_nginx_http_request(path,
+                                                            headers=headers)
+        self.assertEqual(200, status, body)
+        upstream_headers = json.loads(body)['headers']
+
+        spans = self.flush_spans()
+        return upstream_headers, spans
+
+    def test_request_span_is_parent(self):
+        upstream_headers, spans = self.request_and_get_spans('/http')
+        self.assertEqual(1, len(spans), spans)
+        parent = self.assertTraceContinuity(upstream_headers, spans)
+        self.assertEqual('nginx.request', parent['name'], parent)
+
+    def test_location_span_is_parent(self):
+        upstream_headers, spans = self.request_and_get_spans('/http/locations')
+        self.assertEqual(2, len(spans), spans)
+        parent = self.assertTraceContinuity(upstream_headers, spans)
+        self.assertEqual('nginx.proxy_pass', parent['name'], parent)
+        # The location span is the child of the request span.
+        request_span = next(span for span in spans
+                            if span['span_id'] == parent.get('parent_id'))
+        self.assertEqual(request_span['trace_id'], parent['trace_id'])
+
+    def test_extracted_trace_continues(self):
+        # The client's trace continues through nginx to the upstream.
+        trace_id = '4bf92f3577b34da6a3ce929d0e0e4736'
+        headers = {
+            'traceparent': f'00-{trace_id}-00f067aa0ba902b7-01',
+        }
+        upstream_headers, spans = self.request_and_get_spans('/http', headers)
+        parent = self.assertTraceContinuity(upstream_headers, spans)
+        self.assertEqual(int('00f067aa0ba902b7', 16), parent.get('parent_id'),
+                         parent)
+        self.assertIn(trace_id, upstream_headers['traceparent'])
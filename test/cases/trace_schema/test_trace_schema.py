@@ -0,0 +1,101 @@
+from .. import formats
+
+import copy
+import json
+import unittest
+
+
+def valid_span():
+    return {
+        'trace_id': 5208512171318403364,
+        'span_id': 5208512171318403364,
+        'parent_id': 0,
+        'name': 'nginx.request',
+        'resource': 'GET /http',
+        'service': 'nginx',
+        'type': 'web',
+        'start': 1700000000000000000,
+        'duration': 1234567,
+        'error': 0,
+        'meta': {
+            'http.method': 'GET'
+        },
+        'metrics': {
+            '_sampling_priority_v1': 1.0
+        },
+    }
+
+
+class TestTraceSchema(unittest.TestCase):
+
+    def assert_malformed(self, trace, message_regex):
+        with self.assertRaisesRegex(formats.MalformedTraceError,
+                                    message_regex):
+            formats.parse_trace(json.dumps(trace))
+
+    def test_valid(self):
+        trace = [[valid_span(), valid_span()]]
+        self.assertEqual(trace, formats.parse_trace(json.dumps(trace)))
+
+    def test_valid_without_optional_fields(self):
+        span = valid_span()
+        for field in formats.OPTIONAL_SPAN_FIELDS:
+            del span[field]
+        trace = [[span]]
+        self.assertEqual(trace, formats.parse_trace(json.dumps(trace)))
+
+    def test_not_a_trace(self):
+        self.assertIsNone(formats.parse_trace('Traces request to /v0.4/traces'))
+        self.assertIsNone(formats.parse_trace('{"not": "a trace"}'))
+
+    def test_missing_required_field(self):
+        for field in formats.REQUIRED_SPAN_FIELDS:
+            span = valid_span()
+            del span[field]
+            with self.subTest(field=field):
+                self.assert_malformed(
+                    [[valid_span(), span]],
+                    f'span 1 of chunk 0 is missing required field "{field}"')
+
+    def test_wrong_field_type(self):
+        cases = [
+            ('trace_id', '5208512171318403364', 'str, expected int'),
+            ('span_id', 1.5, 'float, expected int'),
+            ('name', 42, 'int, expected str'),
+            ('resource', None, 'NoneType, expected str'),
+            ('start', True, 'bool, expected int'),
+            ('meta', [], 'list, expected dict'),
+        ]
+        for field, value, message in cases:
+            span = valid_span()
+            span[field] = value
+            with self.subTest(field=field):
+                self.assert_malformed(
+                    [[span]], f'span 0 of chunk 0 has field "{field}" of type '
+                    f'{message}')
+
+    def test_negative_duration(self):
+        span = valid_span()
+        span['duration'] = -1
+        self.assert_malformed([[span]], 'negative "duration" -1')
+
+    def test_wrong_tag_types(self):
+        span = valid_span()
+        span['meta']['http.status_code'] = 200
+        self.assert_malformed([[span]],
+                              'meta "http.status_code" of type int')
+
+        span = valid_span()
+        span['metrics']['_dd.top_level'] = '1'
+        self.assert_malformed([[span]], 'metric "_dd.top_level" of type str')
+
+    def test_malformed_structure(self):
+        self.assert_malformed([valid_span()],
+                              'chunk 0 is a dict, not a list of spans')
+        self.assert_malformed([[[]]], 'span 0 of chunk 0 is a list, not a map')
+
+    def test_does_not_modify_input(self):
+        trace = [[valid_span()]]
+        original = copy.deepcopy(trace)
+        formats.validate_trace(trace)
+        self.assertEqual(original, trace)
@@ -0,0 +1,28 @@
+from .. import case
+
+
+class TestUpstreamError(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def send_request_and_get_span(self, path, expected_status):
+        status, _, body = self.orch.send_nginx_http_request(path)
+        self.assertEqual(expected_status, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]
+
+    def test_unreachable_upstream(self):
+        span = self.send_request_and_get_span('/unreachable', 502)
+        meta = span['meta']
+
+        self.assertEqual('connect', meta.get('nginx.upstream.error'), meta)
+        self.assertEqual('502', meta.get('http.status_code'), meta)
+        self.assertEqual(1, span.get('error'), span)
+
+    def test_reachable_upstream(self):
+        span = self.send_request_and_get_span('/http', 200)
+        meta = span['meta']
+
+        self.assertNotIn('nginx.upstream.error', meta)
+        self.assertEqual('200', meta.get('http.status_code'), meta)
@@ -0,0 +1,46 @@
+from .. import case
+
+
+class TestUpstreamSampleRate(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def send_requests_and_get_spans(self, path, count=10, headers={}):
+        for _ in range(count):
+            status, _, body = self.orch.send_nginx_http_request(
+                path, headers=headers)
+            self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(count, len(spans), spans)
+        return spans
+
+    def test_kept_upstream(self):
+        for span in self.send_requests_and_get_spans('/payments'):
+            self.assertEqual(2, span['metrics'].get('_sampling_priority_v1'),
+                             span)
+            self.assertEqual('1.000000',
+                             span['meta'].get('nginx.upstream_sample_rate'),
+                             span['meta'])
+
+    def test_dropped_upstream(self):
+        for span in self.send_requests_and_get_spans('/catalog'):
+            self.assertEqual(-1, span['metrics'].get('_sampling_priority_v1'),
+                             span)
+
+    def test_manual_keep_is_not_overridden(self):
+        # The trace is kept by the client, so the 0.0 rate does not apply.
+        spans = self.send_requests_and_get_spans(
+            '/catalog-manual-keep',
+            headers={'x-datadog-sampling-priority': '2'})
+        for span in spans:
+            self.assertEqual(2, span['metrics'].get('_sampling_priority_v1'),
+                             span)
+            self.assertNotIn('nginx.upstream_sample_rate', span['meta'],
+                             span['meta'])
+
+    def test_other_upstream(self):
+        for span in self.send_requests_and_get_spans('/http'):
+            self.assertNotIn('nginx.upstream_sample_rate', span['meta'],
+                             span['meta'])
+            self.assertGreater(span['metrics'].get('_sampling_priority_v1'),
+                               0, span)
@@ -0,0 +1,25 @@
+from .. import case
+
+
+class TestURLQuery(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def send_request_and_get_span(self, path):
+        status, _, body = self.orch.send_nginx_http_request(path)
+        self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]
+
+    def test_query_included_by_default(self):
+        span = self.send_request_and_get_span('/http?token=secret')
+        self.assertEqual('http://nginx/http?token=secret',
+                         span['meta'].get('http.url'), span)
+        self.assertEqual('GET /http?token=secret', span['resource'], span)
+
+    def test_query_removed(self):
+        span = self.send_request_and_get_span('/http/no-query?token=secret')
+        self.assertEqual('http://nginx/http/no-query',
+                         span['meta'].get('http.url'), span)
+        self.assertEqual('GET /http/no-query', span['resource'], span)
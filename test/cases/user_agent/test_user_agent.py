@@ -0,0 +1,56 @@
+from .. import case
+
+
+class TestUserAgent(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def send_request_and_get_meta(self, user_agent, path='/http'):
+        status, _, body = self.orch.send_nginx_http_request(
+            path, headers={'User-Agent': user_agent})
+        self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]['meta']
+
+    def test_known_user_agents(self):
+        cases = [
+            ('Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 '
+             '(KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36', 'Chrome',
+             'Windows'),
+            ('Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 '
+             '(KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 '
+             'Edg/120.0.0.0', 'Edge', 'Windows'),
+            ('Mozilla/5.0 (Macintosh; Intel Mac OS X 14_2) '
+             'AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 '
+             'Safari/605.1.15', 'Safari', 'macOS'),
+            ('Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) '
+             'Gecko/20100101 Firefox/121.0', 'Firefox', 'Linux'),
+            ('Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 '
+             '(KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36',
+             'Chrome', 'Android'),
+            ('Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) '
+             'AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 '
+             'Mobile/15E148 Safari/604.1', 'Safari', 'iOS'),
+            ('Mozilla/5.0 (compatible; Googlebot/2.1; '
+             '+http://www.google.com/bot.html)', 'Bot', None),
+        ]
+        for user_agent, browser, os in cases:
+            with self.subTest(user_agent=user_agent):
+                meta = self.send_request_and_get_meta(user_agent)
+                self.assertEqual(browser, meta.get('http.useragent.browser'),
+                                 meta)
+                self.assertEqual(os, meta.get('http.useragent.os'), meta)
+
+    def test_unknown_user_agent(self):
+        meta = self.send_request_and_get_meta('SomethingElse/1.0')
+        self.assertNotIn('http.useragent.browser', meta)
+        self.assertNotIn('http.useragent.os', meta)
+
+    def test_disabled(self):
+        meta = self.send_request_and_get_meta(
+            'Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) '
+            'Gecko/20100101 Firefox/121.0',
+            path='/untagged')
+        self.assertNotIn('http.useragent.browser', meta)
+        self.assertNotIn('http.useragent.os', meta)
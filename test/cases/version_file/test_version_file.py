@@ -0,0 +1,31 @@
+from .. import case
+
+
+class TestVersionFile(case.TestCase):
+
+    def load_config_and_get_span(self, conf_name):
+        self.replace_nginx_config(conf_name)
+
+        status, _, body = self.orch.send_nginx_http_request('/http')
+        self.assertEqual(200, status, body)
+
+        spans = self.flush_spans()
+        self.assertEqual(1, len(spans), spans)
+        return spans[0]
+
+    def test_version_from_file(self):
+        # Surrounding whitespace, including the trailing newline, is trimmed.
+        self.orch.nginx_replace_file('/tmp/datadog-tests-version',
+                                     '  1.2.3-abc123\n')
+        span = self.load_config_and_get_span('present.conf')
+        self.assertEqual('1.2.3-abc123', span['meta'].get('version'),
+                         span['meta'])
+
+    def test_file_takes_precedence_over_dd_version(self):
+        self.orch.nginx_replace_file('/tmp/datadog-tests-version', '4.5.6\n')
+        span = self.load_config_and_get_span('present_with_dd_version.conf')
+        self.assertEqual('4.5.6', span['meta'].get('version'), span['meta'])
+
+    def test_missing_file(self):
+        span = self.load_config_and_get_span('missing.conf')
+        self.assertNotIn('version', span['meta'], span['meta'])
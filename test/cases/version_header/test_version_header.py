@@ -0,0 +1,33 @@
+from .. import case
+
+from pathlib import Path
+import re
+
+
+def module_version():
+    """Return the version of the module declared in the CMake project."""
+    cmake_path = Path(__file__).parents[3] / 'CMakeLists.txt'
+    match = re.search(r'project\(\S+ VERSION (\S+)\)', cmake_path.read_text())
+    return match.group(1)
+
+
+class TestVersionHeader(case.TestCase):
+    nginx_conf_file = 'http.conf'
+
+    def test_header_contains_versions(self):
+        status, headers, body = self.orch.send_nginx_http_request('/http')
+        self.assertEqual(200, status, body)
+
+        headers = {name.lower(): value for name, value in headers}
+        value = headers.get('x-datadog-module-version')
+        self.assertIsNotNone(value, headers)
+        match = re.fullmatch(r'nginx-datadog/(\S+) dd-trace-cpp/(\S+)', value)
+        self.assertIsNotNone(match, value)
+        self.assertEqual(module_version(), match.group(1), value)
+
+    def test_no_header_by_default(self):
+        status, headers, body = self.orch.send_nginx_http_request('/http/off')
+        self.assertEqual(200, status, body)
+
+        names = [name.lower() for name, _ in headers]
+        self.assertNotIn('x-datadog-module-version', names, headers)
@@ -0,0 +1,71 @@
+from .. import case
+
+import json
+import re
+
+
+class TestZipkin(case.TestCase):
+
+    def test_payload_shape(self):
+        self.replace_nginx_config('http.conf')
+
+        status, _, body = self.orch.send_nginx_http_request('/http')
+        self.assertEqual(200, status, body)
+
+        log_lines = self.flush_agent_log()
+
+        headers_prefix = 'Zipkin spans request headers: '
+        requests = [
+            json.loads(line[len(headers_prefix):]) for line in log_lines
+            if line.startswith(headers_prefix)
+        ]
+        self.assertNotEqual([], requests, log_lines)
+        for headers in requests:
+            self.assertEqual('application/json', headers.get('content-type'),
+                             headers)
+
+        prefix = 'Zipkin spans: '
+        spans = [
+            span for line in log_lines if line.startswith(prefix)
+            for span in json.loads(line[len(prefix):])
+        ]
+        # Nothing is sent to the Datadog Agent's traces endpoint.
+        self.assertFalse(
+            any(line.startswith('Traces request to ') for line in log_lines),
+            log_lines)
+
+        requests = [span for span in spans if span['name'] == 'nginx.request']
+        self.assertEqual(1, len(requests), spans)
+        span = requests[0]
+
+        self.assertRegex(span['traceId'], r'^([0-9a-f]{16}|[0-9a-f]{32})$')
+        self.assertRegex(span['id'], r'^[0-9a-f]{16}$')
+        self.assertNotIn('parentId', span, span)
+        self.assertEqual('SERVER', span.get('kind'), span)
+        self.assertEqual({'serviceName': 'zipkin-test'},
+                         span['localEndpoint'])
+        self.assertIsInstance(span['timestamp'], int)
+        self.assertGreater(span['duration'], 0)
+        self.assertEqual('GET /http', span['tags'].get('resource.name'),
+                         span['tags'])
+        self.assertEqual('200', span['tags'].get('http.status_code'),
+                         span['tags'])
+        for value in span['tags'].values():
+            self.assertIsInstance(value, str)
+
+        # The location span is a child of the request span.
+        children = [
+            child for child in spans if child.get('parentId') == span['id']
+        ]
+        self.assertEqual(1, len(children), spans)
+        self.assertEqual(span['traceId'], children[0]['traceId'])
+        self.assertNotIn('kind', children[0], children[0])
+
+    def test_conflicts_with_agent_url(self):
+        status, log_lines = self.orch.nginx_test_config(
+            self.conf_path('conflict.conf').read_text(), 'conflict.conf')
+        self.assertNotEqual(0, status, log_lines)
+        self.assertTrue(
+            any(
+                re.search(r'datadog_zipkin_endpoint.*datadog_agent_url', line)
+                for line in log_lines), log_lines)
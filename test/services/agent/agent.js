@@ -13,6 +13,13 @@ const requestListener = (() => {
   let next_rem_cfg_resp = undefined;
   let next_rem_cfg_version = -1;
 
+  // The body of the response to requests to the "/traces" endpoints, e.g.
+  // `{"rate_by_service": {"service:nginx,env:": 0.0}}`.
+  let next_traces_resp = JSON.stringify({});
+  // The status of the response to requests to the "/traces" endpoints, e.g.
+  // 413 to simulate a payload that's too large.
+  let next_traces_status = 200;
+
   function version_from_resp(req_body) {
     const req_json = JSON.parse(req_body);
     const req_targets = req_json['targets'];
@@ -34,10 +41,29 @@ const requestListener = (() => {
         body.push(chunk);
       }).on('end', () => {
         body = Buffer.concat(body);
+        console.log("Traces request to " + request.url);
+        const top_level = request.headers['datadog-client-computed-top-level'];
+        console.log("Traces request Datadog-Client-Computed-Top-Level: " +
+                    JSON.stringify(top_level === undefined ? null : top_level));
+        console.log("Traces request headers: " + JSON.stringify(request.headers));
         const trace_segments = msgpack.decode(body);
         handleTraceSegments(trace_segments);
-        response.writeHead(200);
-        response.end(JSON.stringify({}));
+        response.writeHead(next_traces_status);
+        response.end(next_traces_resp);
+        console.log("Traces response status: " + next_traces_status);
+        console.log("Traces response: " + next_traces_resp);
+      });
+    } else if (request.url === '/api/v2/spans') {
+      // Zipkin v2 spans, as sent by `datadog_zipkin_endpoint`.
+      let body = [];
+      request.on('data', chunk => {
+        body.push(chunk);
+      }).on('end', () => {
+        body = Buffer.concat(body).toString();
+        console.log("Zipkin spans request headers: " + JSON.stringify(request.headers));
+        console.log("Zipkin spans: " + body);
+        response.writeHead(202);
+        response.end();
       });
     } else if (request.url == '/v0.7/config') {
       let body = [];
@@ -68,6 +94,28 @@ const requestListener = (() => {
             response.writeHead(200);
             response.end();
         });
+    } else if (request.url === '/save_traces_resp') {
+        let body = [];
+        request.on('data', chunk => {
+            body.push(chunk);
+        }).on('end', () => {
+            body = Buffer.concat(body).toString();
+            next_traces_resp = body === '' ? JSON.stringify({}) : body;
+            console.log("Next traces response: " + next_traces_resp);
+            response.writeHead(200);
+            response.end();
+        });
+    } else if (request.url === '/save_traces_status') {
+        let body = [];
+        request.on('data', chunk => {
+            body.push(chunk);
+        }).on('end', () => {
+            body = Buffer.concat(body).toString();
+            next_traces_status = body === '' ? 200 : parseInt(body, 10);
+            console.log("Next traces status: " + next_traces_status);
+            response.writeHead(200);
+            response.end();
+        });
     } else {
       // The agent also supports telemetry endpoints.
       // But we don't servet those here.
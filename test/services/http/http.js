@@ -27,7 +27,27 @@ const requestListener = function (request, response) {
     const [full, statusString] = match;
     status = Number.parseInt(statusString, 10);
   }
+
+  // "[...]/delay/<ms>" makes us wait <ms> milliseconds before responding.
+  const delayMatch = request.url.match(/.*\/delay\/([0-9]+)$/);
+  if (delayMatch !== null) {
+    const [full, delayString] = delayMatch;
+    setTimeout(() => {
+      response.writeHead(status);
+      response.end(responseBody);
+    }, Number.parseInt(delayString, 10));
+    return;
+  }
+
   response.writeHead(status);
+
+  // "[...]/chunked" makes us respond without a Content-Length, so that the
+  // body is delivered in chunks.
+  if (request.url.endsWith('/chunked')) {
+    response.write(responseBody);
+    response.end('\n');
+    return;
+  }
   response.end(responseBody);
 }
 
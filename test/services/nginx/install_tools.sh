@@ -12,12 +12,23 @@ install_nginx_on_amazon_linux() {
     yum install -y nginx
 }
 
+# libfaketime is used by tests that simulate steps of the system clock. It is
+# not available everywhere, and those tests are skipped where it's missing.
+# The library is linked to a fixed path so that tests can find it.
+link_faketime() {
+    lib=$(find / -name libfaketime.so.1 -not -path '/proc/*' 2>/dev/null | head -n 1)
+    if [ -n "$lib" ]; then
+        ln -sf "$lib" /usr/local/lib/libfaketime.so.1
+    fi
+}
+
 # `procps` contains `kill`, which is used to bring down temporary instances of
 # nginx.
 # Also, if we're on Amazon Linux, nginx won't be installed yet, so install it.
 if command -v apt-get >/dev/null 2>&1; then
     apt-get update
     DEBIAN_FRONTEND=noninteractive apt-get install -y procps gdb
+    DEBIAN_FRONTEND=noninteractive apt-get install -y faketime || true
     if ! command -v nginx >/dev/null 2>&1; then
         >&2 echo 'nginx must already be installed on Debian-flavored base images'
         exit 1
@@ -25,6 +36,7 @@ if command -v apt-get >/dev/null 2>&1; then
 elif command -v apk >/dev/null 2>&1; then
     apk update
     apk add procps gdb
+    apk add libfaketime || true
     if ! command -v nginx >/dev/null 2>&1; then
         >&2 echo 'nginx must already be installed on Alpine-flavored base images'
         exit 1
@@ -39,3 +51,5 @@ else
     >&2 printf 'Did not find a supported package manager.\n'
     exit 1
 fi
+
+link_faketime